@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/qr"
+)
+
+// ---------- Glyph address book ----------
+
+// addressBook is the glyph collection laid out for printing: one section
+// per galaxy or tag, an entry per glyph with its portal glyphs and a QR
+// code. The page is self-contained (the glyph font is inlined) so a saved
+// copy prints the same, or becomes a PDF through the browser's print
+// dialog.
+type addressBook struct {
+	Title     string
+	Group     string
+	Generated time.Time
+	Count     int
+	Sections  []bookSection
+	Font      template.URL
+}
+
+type bookSection struct {
+	Name    string
+	Entries []bookEntry
+}
+
+type bookEntry struct {
+	glyphs.Glyph
+	Portal string // canonical portal code, empty when Symbols is not one
+	Codes  string // portal code, signal-booster coordinates and galaxy
+	QR     template.HTML
+}
+
+// newAddressBook groups items by "galaxy" or "tag"; a glyph with several
+// tags appears under each. With a link base the QR codes open the glyph
+// on that instance, otherwise they hold the portal code.
+func newAddressBook(items []glyphs.Glyph, group, linkBase string) (*addressBook, error) {
+	if group == "" {
+		group = "galaxy"
+	}
+	if group != "galaxy" && group != "tag" {
+		return nil, errors.New("group must be galaxy or tag")
+	}
+	book := &addressBook{
+		Title:     "Portal Address Book",
+		Group:     group,
+		Generated: time.Now(),
+		Count:     len(items),
+		Font:      template.URL("data:font/ttf;base64," + base64.StdEncoding.EncodeToString(glyphFontTTF)),
+	}
+	byName := map[string]*bookSection{}
+	var order []string
+	add := func(name string, e bookEntry) {
+		s, ok := byName[name]
+		if !ok {
+			s = &bookSection{Name: name}
+			byName[name] = s
+			order = append(order, name)
+		}
+		s.Entries = append(s.Entries, e)
+	}
+	for _, g := range items {
+		e := bookEntry{Glyph: g}
+		payload := g.Symbols
+		var codes []string
+		if a, err := glyphs.ParsePortal(g.Symbols); err == nil {
+			e.Portal = a.String()
+			payload = e.Portal
+			codes = append(codes, e.Portal, a.GalacticCoords())
+		}
+		if group != "galaxy" {
+			codes = append(codes, g.Galaxy)
+		}
+		e.Codes = strings.Join(codes, " • ")
+		if linkBase != "" {
+			payload = strings.TrimRight(linkBase, "/") + "/glyphs#" + g.ID
+		}
+		if code, err := qr.Encode([]byte(payload)); err == nil {
+			e.QR = template.HTML(code.SVG())
+		}
+		switch {
+		case group == "galaxy":
+			add(g.Galaxy, e)
+		case len(g.Tags) == 0:
+			add("", e)
+		default:
+			for _, t := range g.Tags {
+				add(t, e)
+			}
+		}
+	}
+	// Sections alphabetically with the untagged last; entries by name.
+	slices.SortFunc(order, func(a, b string) int {
+		if (a == "") != (b == "") {
+			return strings.Compare(b, a)
+		}
+		return strings.Compare(norm.Key(a), norm.Key(b))
+	})
+	for _, name := range order {
+		s := byName[name]
+		if name == "" {
+			s.Name = "Untagged"
+		}
+		slices.SortStableFunc(s.Entries, func(a, b bookEntry) int {
+			return strings.Compare(norm.Key(a.Name), norm.Key(b.Name))
+		})
+		book.Sections = append(book.Sections, *s)
+	}
+	return book, nil
+}
+
+func (b *addressBook) render(w io.Writer) error {
+	return addressBookTmpl.ExecuteTemplate(w, "addressbook", b)
+}
+
+// addressBookHandler serves GET /glyphs/book: ?group=galaxy|tag, ?tag= to
+// print one tag only and ?download=1 to save the page as a file.
+func addressBookHandler(gs *glyphs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		items := gs.List()
+		if tag := q.Get("tag"); tag != "" {
+			items = gs.Tagged(tag)
+		}
+		book, err := newAddressBook(items, q.Get("group"), requestBase(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var buf bytes.Buffer
+		if err := book.render(&buf); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if q.Get("download") == "1" {
+			w.Header().Set("Content-Disposition", `attachment; filename="glyph-address-book.html"`)
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- API versions and errors ----------
+
+// The API is served under /api/v1/, where a failed request is answered
+// with an error envelope a client can act on:
+//
+//	{"error": {"code": "invalid", "message": "name required", "fields": {"name": "name required"}}}
+//
+// The code is a word for the status (not_found, conflict, ...) unless the
+// handler gives a more precise one, and fields name the record fields at
+// fault. The unversioned /api/ paths are the same endpoints, kept for the
+// pages and older scripts, and still answer errors in plain text.
+
+const apiV1Prefix = "/api/v1/"
+
+type apiVersionKey struct{}
+
+// apiV1 reports whether r came in under /api/v1/.
+func apiV1(r *http.Request) bool {
+	v, _ := r.Context().Value(apiVersionKey{}).(bool)
+	return v
+}
+
+// apiError is the error of the envelope.
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+func errorCode(status int) string {
+	if c, ok := errorCodes[status]; ok {
+		return c
+	}
+	if status >= 500 {
+		return "internal"
+	}
+	return "error"
+}
+
+// writeError answers r with an error: the envelope under /api/v1/, and
+// what http.Error writes elsewhere. code "" is the one for status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string, fields map[string]string) {
+	if !apiV1(r) {
+		http.Error(w, msg, status)
+		return
+	}
+	if code == "" {
+		code = errorCode(status)
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": {Code: code, Message: msg, Fields: fields}})
+}
+
+// writeInvalid answers a record that did not validate with 400, naming the
+// field at fault when the error does (see store.FieldError).
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	var fe *store.FieldError
+	if errors.As(err, &fe) {
+		writeError(w, r, http.StatusBadRequest, "invalid", fe.Message, map[string]string{fe.Field: fe.Message})
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, "", err.Error(), nil)
+}
+
+// apiNotFound answers /api/ paths no route matches, which would otherwise
+// get the home page.
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "", "no such API endpoint: "+r.Method+" "+r.URL.Path, nil)
+}
+
+// withAPIVersions serves /api/v1/X as /api/X, turning the plain-text
+// errors of handlers that use http.Error into the envelope.
+func withAPIVersions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, apiV1Prefix)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, true))
+		u := *r.URL
+		u.Path, u.RawPath = "/api/"+rest, ""
+		r.URL = &u
+		ew := &envelopeWriter{ResponseWriter: w, r: r}
+		defer ew.finish()
+		h.ServeHTTP(ew, r)
+	})
+}
+
+// envelopeWriter holds back a plain-text error body to send it on as the
+// envelope once the handler is done.
+type envelopeWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	status  int
+	capture bool
+	buf     bytes.Buffer
+}
+
+func (ew *envelopeWriter) WriteHeader(code int) {
+	if ew.status != 0 {
+		return
+	}
+	ew.status = code
+	if code >= 400 && strings.HasPrefix(ew.Header().Get("Content-Type"), "text/plain") {
+		ew.capture = true
+		return
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.capture {
+		return ew.buf.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter { return ew.ResponseWriter }
+
+func (ew *envelopeWriter) finish() {
+	if ew.capture {
+		writeError(ew.ResponseWriter, ew.r, ew.status, "", strings.TrimSpace(ew.buf.String()), nil)
+	}
+}
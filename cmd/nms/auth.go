@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ---------- Accounts and sessions ----------
+
+// The accounts are the users of -config (see userEntry). A user with a
+// password signs in on /login and gets a session cookie; API clients send
+// their token instead. Sessions are kept in memory, so a restart signs
+// everyone out, and one ends as soon as its user leaves the config.
+
+const (
+	sessionCookie = "session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+type session struct {
+	user    string
+	role    role // of a user signed in with OIDC who is not in the config
+	expires time.Time
+}
+
+// sessions are the signed-in browsers, by the random token in their cookie.
+type sessions struct {
+	mu      sync.Mutex
+	byToken map[string]session
+}
+
+// start signs user in, dropping sessions that have run out; ro is 0 for a
+// user of the config.
+func (s *sessions) start(user string, ro role) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, ss := range s.byToken {
+		if now.After(ss.expires) {
+			delete(s.byToken, t)
+		}
+	}
+	s.byToken[tok] = session{user: user, role: ro, expires: now.Add(sessionTTL)}
+	return tok, nil
+}
+
+func (s *sessions) get(tok string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.byToken[tok]
+	if !ok || time.Now().After(ss.expires) {
+		return session{}, false
+	}
+	return ss, true
+}
+
+func (s *sessions) end(tok string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byToken, tok)
+}
+
+// user returns the user a request signs in as.
+func (lc *liveConfig) user(r *http.Request) (string, bool) {
+	name, _, ok := lc.signedIn(r)
+	return name, ok
+}
+
+// signedIn returns the user a request signs in as, by its bearer token or
+// its session cookie, and their role.
+func (lc *liveConfig) signedIn(r *http.Request) (string, role, bool) {
+	cfg := lc.Load()
+	if name, ok := cfg.bearerUser(r); ok {
+		return name, cfg.Users[name].role(), true
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", 0, false
+	}
+	ss, ok := lc.sessions.get(c.Value)
+	if !ok {
+		return "", 0, false
+	}
+	if ss.role != 0 {
+		// Signed in with OIDC and not linked to a user of the config.
+		return ss.user, ss.role, lc.oidc != nil
+	}
+	if u, known := cfg.Users[ss.user]; known {
+		return ss.user, u.role(), true
+	}
+	return "", 0, false
+}
+
+// startSession signs the browser in as user.
+func (lc *liveConfig) startSession(w http.ResponseWriter, r *http.Request, user string, ro role) error {
+	tok, err := lc.sessions.start(user, ro)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, newSessionCookie(r, tok, int(sessionTTL/time.Second)))
+	log.Printf("%s signed in from %s", user, requestActor(nil, r).Addr)
+	return nil
+}
+
+// newSessionCookie is the session cookie set on sign in, or with maxAge -1
+// the one clearing it; browsers only drop a cookie set with the same
+// attributes.
+func newSessionCookie(r *http.Request, tok string, maxAge int) *http.Cookie {
+	return &http.Cookie{Name: sessionCookie, Value: tok, Path: "/", MaxAge: maxAge, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode}
+}
+
+// ---------- Login and logout pages ----------
+
+type loginPage struct {
+	Next   string // where to go once signed in
+	SSO    bool   // offer signing in with OIDC
+	Name   string
+	Error  string
+	User   string // signed in already
+	Logout bool
+}
+
+// localPath keeps a ?next= redirect on this site.
+func localPath(s string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return "/"
+	}
+	return s
+}
+
+// slowHash is compared against when the name is unknown, so a wrong name
+// takes as long to turn away as a wrong password.
+var slowHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("nms"), bcrypt.DefaultCost)
+	return h
+})
+
+// loginHandler serves GET /login, the sign-in form, and POST /login, which
+// checks the password and starts a session.
+func loginHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := loginPage{Next: localPath(r.FormValue("next")), SSO: lc.oidc != nil}
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			p.Name = strings.TrimSpace(r.PostFormValue("name"))
+			password := []byte(r.PostFormValue("password"))
+			u, ok := lc.Load().Users[p.Name]
+			hash := []byte(u.Password)
+			if !ok || u.Password == "" {
+				hash = slowHash()
+			}
+			if err := bcrypt.CompareHashAndPassword(hash, password); err == nil && ok && u.Password != "" {
+				if err := lc.startSession(w, r, p.Name, 0); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(w, r, p.Next, http.StatusSeeOther)
+				return
+			}
+			p.Error = "Wrong name or password."
+			status = http.StatusUnauthorized
+		}
+		p.User, _ = lc.user(r)
+		writeLoginPage(w, r, "Sign in", p, status)
+	}
+}
+
+// logoutHandler serves GET /logout, which asks to confirm, and POST
+// /logout, which ends the session.
+func logoutHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if c, err := r.Cookie(sessionCookie); err == nil {
+				lc.sessions.end(c.Value)
+			}
+			http.SetCookie(w, newSessionCookie(r, "", -1))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		p := loginPage{Logout: true}
+		p.User, _ = lc.user(r)
+		writeLoginPage(w, r, "Sign out", p, http.StatusOK)
+	}
+}
+
+func writeLoginPage(w http.ResponseWriter, r *http.Request, title string, p loginPage, status int) {
+	var buf bytes.Buffer
+	data := pageData{Title: title, Heading: title, BgDark2: "#0e312b", Big: bigMode(w, r), Item: p}
+	if err := loginTmpl.ExecuteTemplate(&buf, "login", data); err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+	}
+}
+
+// meHandler serves GET /api/me: who the request signs in as with which
+// role, and whether anyone can sign in on /login at all.
+func meHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := lc.Load()
+		login := lc.oidc != nil
+		for _, u := range cfg.Users {
+			login = login || u.Password != ""
+		}
+		name, _ := lc.user(r)
+		role, _ := lc.role(r)
+		writeJSON(w, map[string]any{"user": name, "role": role.String(), "login": login, "require_login": cfg.RequireLogin})
+	}
+}
+
+// ---------- Command line: passwd ----------
+
+const passwdUsage = `usage: nms passwd [flags]
+
+Reads a password from standard input and prints its bcrypt hash, for a
+user of the serve -config file:
+
+  users:
+    alice:
+      password: <hash>
+      admin: true
+
+`
+
+func passwdCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, passwdUsage); fs.PrintDefaults() }
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost (each step doubles the time a sign-in takes)")
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if f, ok := stdin.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(stderr, "Password: ")
+		}
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintf(stderr, "passwd: %v\n", err)
+		return 1
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		fmt.Fprintln(stderr, "passwd: empty password")
+		return 1
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		fmt.Fprintf(stderr, "passwd: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, string(hash))
+	return 0
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() { log.SetOutput(io.Discard) }
+
+// testConfig is a liveConfig holding cfg, as newLiveConfig would load it.
+func testConfig(t *testing.T, cfg *runtimeConfig) *liveConfig {
+	t.Helper()
+	lc := &liveConfig{sessions: sessions{byToken: map[string]session{}}}
+	lc.cur.Store(cfg)
+	return lc
+}
+
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func sessionCookieOf(res *http.Response) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLogin(t *testing.T) {
+	cfg := defaultRuntimeConfig()
+	cfg.Users = map[string]userEntry{
+		"ana": {Password: testHash(t, "secret")},
+		"bot": {Token: "0123456789abcdef0123456789"},
+	}
+	lc := testConfig(t, cfg)
+	tests := []struct {
+		name, user, password string
+		tls                  bool
+		status               int
+	}{
+		{"right password", "ana", "secret", false, http.StatusSeeOther},
+		{"right password over tls", "ana", "secret", true, http.StatusSeeOther},
+		{"wrong password", "ana", "guess", false, http.StatusUnauthorized},
+		{"unknown user", "eve", "secret", false, http.StatusUnauthorized},
+		{"no password set", "bot", "", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"name": {tt.user}, "password": {tt.password}, "next": {"/glyphs"}}
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rr := httptest.NewRecorder()
+			loginHandler(lc)(rr, req)
+			res := rr.Result()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			c := sessionCookieOf(res)
+			if tt.status != http.StatusSeeOther {
+				if c != nil {
+					t.Errorf("session cookie set on a failed sign-in")
+				}
+				return
+			}
+			if loc := res.Header.Get("Location"); loc != "/glyphs" {
+				t.Errorf("redirect to %q, want /glyphs", loc)
+			}
+			if c == nil {
+				t.Fatal("no session cookie")
+			}
+			if !c.HttpOnly || c.Secure != tt.tls || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.MaxAge <= 0 {
+				t.Errorf("cookie = %+v", c)
+			}
+			check := httptest.NewRequest(http.MethodGet, "/", nil)
+			check.AddCookie(c)
+			if name, ro, ok := lc.signedIn(check); !ok || name != tt.user || ro != roleEditor {
+				t.Errorf("signed in as %q (%v, %v), want %q as editor", name, ro, ok, tt.user)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	cfg := defaultRuntimeConfig()
+	cfg.Users = map[string]userEntry{"ana": {Password: testHash(t, "secret")}}
+	lc := testConfig(t, cfg)
+	for _, secure := range []bool{false, true} {
+		tok, err := lc.sessions.start("ana", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tok})
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rr := httptest.NewRecorder()
+		logoutHandler(lc)(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("secure=%v: status = %d, want %d", secure, rr.Code, http.StatusSeeOther)
+		}
+		if _, ok := lc.sessions.get(tok); ok {
+			t.Errorf("secure=%v: session still valid after logout", secure)
+		}
+		// The clearing cookie must match the one set at sign in, or some
+		// browsers keep the original.
+		c := sessionCookieOf(rr.Result())
+		want := newSessionCookie(req, "", -1)
+		if c == nil || c.MaxAge >= 0 || c.Path != want.Path || c.Secure != secure || !c.HttpOnly || c.SameSite != want.SameSite {
+			t.Errorf("secure=%v: clearing cookie = %+v", secure, c)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	cfg := defaultRuntimeConfig()
+	cfg.Users = map[string]userEntry{"ana": {Password: testHash(t, "secret")}}
+	lc := testConfig(t, cfg)
+	tests := []struct {
+		name    string
+		age     time.Duration
+		user    string
+		removed bool // from the config after sign in
+		ok      bool
+	}{
+		{"fresh", 0, "ana", false, true},
+		{"almost expired", sessionTTL - time.Minute, "ana", false, true},
+		{"expired", sessionTTL + time.Minute, "ana", false, false},
+		{"user removed", 0, "gone", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := lc.sessions.start(tt.user, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lc.sessions.mu.Lock()
+			ss := lc.sessions.byToken[tok]
+			ss.expires = ss.expires.Add(-tt.age)
+			lc.sessions.byToken[tok] = ss
+			lc.sessions.mu.Unlock()
+
+			if _, _, ok := lc.signedIn(newCookieRequest(tok)); ok != tt.ok {
+				t.Errorf("signed in = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+
+	// Starting a session sweeps out the ones that have run out.
+	lc.sessions.start("ana", 0)
+	lc.sessions.mu.Lock()
+	defer lc.sessions.mu.Unlock()
+	for tok, ss := range lc.sessions.byToken {
+		if time.Now().After(ss.expires) {
+			t.Errorf("expired session %s kept", tok)
+		}
+	}
+}
+
+func newCookieRequest(tok string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tok})
+	return req
+}
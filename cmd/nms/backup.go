@@ -0,0 +1,575 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Command line: backup ----------
+
+const backupUsage = `usage: nms backup <command> [flags] FILE
+
+commands:
+  create   [path flags] [FILE]          snapshot datasets, stores and photos
+  restore  [path flags] [-force] FILE   put a snapshot's files back
+
+FILE is a .tar.zst, .tar.gz (.tgz) or .tar archive; create without a FILE
+writes nms-<time>.tar.zst into -backups (DIR/backups/ with -data-dir). The path flags are the
+server's (-csv, -refiner, -tech, -glyphs, -bases, -creatures, -portals,
+-systems, -loadouts, -custom-recipes): create reads those files and restore writes them, so a
+snapshot can be restored into a different layout. Restart a running server
+after a restore so it reloads the datasets.
+`
+
+// snapshotIndex is the first file of every snapshot, snapshot.json.
+type snapshotIndex struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []snapshotEntry `json:"files"`
+}
+
+type snapshotEntry struct {
+	Name   string `json:"name"` // path inside the archive
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+const snapshotIndexName = "snapshot.json"
+
+// backupItem ties a name inside the archive to a file of the instance.
+type backupItem struct {
+	Name  string // e.g. stores/glyphs.json
+	Path  string
+	Store bool // read and written under the store file lock
+}
+
+type instanceStore struct {
+	name, path, photoDir string
+}
+
+func (in *instance) stores() []instanceStore {
+	c := in.catalogues()
+	return []instanceStore{
+		{"glyphs", in.Glyphs, c.Glyphs.PhotoDir()},
+		{"bases", in.Bases, c.Bases.PhotoDir()},
+		{"creatures", in.Creatures, c.Creatures.PhotoDir()},
+		{"portals", in.Portals, c.Portals.PhotoDir()},
+		{"systems", in.Systems, c.Systems.PhotoDir()},
+		{"loadouts", in.Loadouts, c.Loadouts.PhotoDir()},
+		{"custom_recipes", in.CustomRecipes, c.CustomRecipes.PhotoDir()},
+	}
+}
+
+// backupItems lists what a snapshot of the instance holds: the datasets,
+// every store file, the photos uploaded to each store, the glyph history
+// and the item icons.
+func (in *instance) backupItems() ([]backupItem, error) {
+	items := []backupItem{
+		{Name: "datasets/food.csv", Path: in.Food},
+		{Name: "datasets/refiner.csv", Path: in.Refiner},
+		{Name: "datasets/technologies.csv", Path: in.Tech},
+		{Name: "datasets/values.csv", Path: in.Values},
+		{Name: "datasets/aliases.csv", Path: in.Aliases},
+	}
+	for _, s := range in.stores() {
+		items = append(items, backupItem{Name: "stores/" + s.name + ".json", Path: s.path, Store: true})
+		photos, err := os.ReadDir(s.photoDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range photos {
+			if e.Type().IsRegular() {
+				items = append(items, backupItem{Name: "photos/" + s.name + "/" + e.Name(), Path: filepath.Join(s.photoDir, e.Name())})
+			}
+		}
+	}
+	if p := historyPath(in.Glyphs); p != "" {
+		items = append(items, backupItem{Name: "history/glyphs.jsonl", Path: p})
+	}
+	icons, err := os.ReadDir(in.iconDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range icons {
+		if e.Type().IsRegular() {
+			items = append(items, backupItem{Name: "icons/" + e.Name(), Path: filepath.Join(in.iconDir(), e.Name())})
+		}
+	}
+	return items, nil
+}
+
+// target maps an archive name back to where it goes in this instance.
+func (in *instance) target(name string) (backupItem, bool) {
+	if !filepath.IsLocal(name) || path.Clean(name) != name {
+		return backupItem{}, false
+	}
+	switch name {
+	case "datasets/food.csv":
+		return backupItem{Name: name, Path: in.Food}, true
+	case "datasets/refiner.csv":
+		return backupItem{Name: name, Path: in.Refiner}, true
+	case "datasets/technologies.csv":
+		return backupItem{Name: name, Path: in.Tech}, true
+	case "datasets/values.csv":
+		return backupItem{Name: name, Path: in.Values}, true
+	case "datasets/aliases.csv":
+		return backupItem{Name: name, Path: in.Aliases}, true
+	case "history/glyphs.jsonl":
+		return backupItem{Name: name, Path: historyPath(in.Glyphs)}, historyPath(in.Glyphs) != ""
+	}
+	if dir, file := path.Split(name); dir == "icons/" && file != "" {
+		return backupItem{Name: name, Path: filepath.Join(in.iconDir(), file)}, true
+	}
+	for _, s := range in.stores() {
+		if name == "stores/"+s.name+".json" {
+			return backupItem{Name: name, Path: s.path, Store: true}, true
+		}
+		if dir, file := path.Split(name); dir == "photos/"+s.name+"/" && file != "" {
+			return backupItem{Name: name, Path: filepath.Join(s.photoDir, file)}, true
+		}
+	}
+	return backupItem{}, false
+}
+
+func backupCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(stderr, backupUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet("backup "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var in instance
+	in.register(fs)
+	var force bool
+	switch cmd {
+	case "create":
+	case "restore":
+		fs.BoolVar(&force, "force", false, "Overwrite files that already exist")
+	default:
+		fmt.Fprintf(stderr, "unknown backup command %q\n\n%s", cmd, backupUsage)
+		return 2
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 || fs.NArg() == 0 && cmd == "restore" {
+		fmt.Fprint(stderr, backupUsage)
+		return 2
+	}
+	if err := in.resolve(); err != nil {
+		fmt.Fprintf(stderr, "backup %s: %v\n", cmd, err)
+		return 1
+	}
+
+	file := fs.Arg(0)
+	if cmd == "create" {
+		if file == "" {
+			var err error
+			if file, err = in.newSnapshotPath(); err != nil {
+				fmt.Fprintf(stderr, "backup create: %v\n", err)
+				return 1
+			}
+		}
+		idx, err := createSnapshot(file, &in)
+		if err != nil {
+			fmt.Fprintf(stderr, "backup create: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "wrote %s: %d files\n", file, len(idx.Files))
+		return 0
+	}
+	idx, err := restoreSnapshot(file, &in, force)
+	if err != nil {
+		fmt.Fprintf(stderr, "backup restore: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "restored %d files from %s (snapshot of %s)\n", len(idx.Files), file, idx.CreatedAt.Format(time.RFC3339))
+	return 0
+}
+
+// newSnapshotPath names a new snapshot in -backups, creating it.
+func (in *instance) newSnapshotPath() (string, error) {
+	if err := os.MkdirAll(in.Backups, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(in.Backups, "nms-"+time.Now().UTC().Format("20060102-150405")+".tar.zst"), nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// createSnapshot reads every file first so the index checksums describe
+// exactly what is archived; missing datasets and stores are left out.
+func createSnapshot(file string, in *instance) (snapshotIndex, error) {
+	idx := snapshotIndex{CreatedAt: time.Now().UTC()}
+	items, err := in.backupItems()
+	if err != nil {
+		return idx, err
+	}
+	var data [][]byte
+	for _, it := range items {
+		var b []byte
+		if it.Store {
+			b, err = store.ReadFile(it.Path)
+		} else if b, err = os.ReadFile(it.Path); errors.Is(err, os.ErrNotExist) {
+			b, err = nil, nil
+		}
+		if err != nil {
+			return idx, err
+		}
+		if b == nil {
+			continue
+		}
+		idx.Files = append(idx.Files, snapshotEntry{Name: it.Name, SHA256: sha256Hex(b), Bytes: int64(len(b))})
+		data = append(data, b)
+	}
+	head, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return idx, err
+	}
+
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return idx, err
+	}
+	defer os.Remove(tmp)
+	zw, err := compressor(file, f)
+	if err != nil {
+		f.Close()
+		return idx, err
+	}
+	tw := tar.NewWriter(zw)
+	put := func(name string, b []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: idx.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	err = put(snapshotIndexName, head)
+	for i := 0; err == nil && i < len(data); i++ {
+		err = put(idx.Files[i].Name, data[i])
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return idx, err
+	}
+	return idx, os.Rename(tmp, file)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressor(name string, w io.Writer) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return zstd.NewWriter(w)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return gzip.NewWriter(w), nil
+	case strings.HasSuffix(name, ".tar"):
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("%s: want a .tar.zst, .tar.gz or .tar file", name)
+}
+
+// isSnapshotName reports whether name has an extension compressor takes.
+func isSnapshotName(name string) bool {
+	for _, ext := range []string{".tar.zst", ".tzst", ".tar.gz", ".tgz", ".tar"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// decompressor picks the codec from the stream's magic bytes, so restore
+// does not depend on the file name.
+func decompressor(r *bufio.Reader) (io.Reader, func(), error) {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, d.Close, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		g, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return g, func() { g.Close() }, nil
+	}
+	return r, func() {}, nil
+}
+
+// restoreSnapshot unpacks into a staging directory and checks every file
+// against the index before anything in the instance is replaced.
+func restoreSnapshot(file string, in *instance, force bool) (snapshotIndex, error) {
+	var idx snapshotIndex
+	f, err := os.Open(file)
+	if err != nil {
+		return idx, err
+	}
+	defer f.Close()
+	r, closeR, err := decompressor(bufio.NewReader(f))
+	if err != nil {
+		return idx, err
+	}
+	defer closeR()
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != snapshotIndexName {
+		return idx, fmt.Errorf("%s: not a snapshot (no %s)", file, snapshotIndexName)
+	}
+	if err := json.NewDecoder(tr).Decode(&idx); err != nil {
+		return idx, fmt.Errorf("%s: %w", snapshotIndexName, err)
+	}
+
+	want := map[string]int{}
+	targets := make([]backupItem, len(idx.Files))
+	var conflicts []string
+	for i, e := range idx.Files {
+		t, ok := in.target(e.Name)
+		if !ok {
+			return idx, fmt.Errorf("snapshot has unexpected file %q", e.Name)
+		}
+		if _, err := os.Stat(t.Path); err == nil && !force {
+			conflicts = append(conflicts, t.Path)
+		}
+		want[e.Name], targets[i] = i, t
+	}
+	if len(conflicts) > 0 {
+		return idx, fmt.Errorf("would overwrite %d existing files (first %s); use -force", len(conflicts), conflicts[0])
+	}
+
+	stage, err := os.MkdirTemp(filepath.Dir(in.Glyphs), ".restore-")
+	if err != nil {
+		return idx, err
+	}
+	defer os.RemoveAll(stage)
+	staged := make([]bool, len(idx.Files))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return idx, err
+		}
+		i, ok := want[hdr.Name]
+		if !ok || staged[i] || hdr.Typeflag != tar.TypeReg {
+			return idx, fmt.Errorf("snapshot has unexpected entry %q", hdr.Name)
+		}
+		if err := stageFile(filepath.Join(stage, fmt.Sprint(i)), tr, idx.Files[i]); err != nil {
+			return idx, err
+		}
+		staged[i] = true
+	}
+	for i, ok := range staged {
+		if !ok {
+			return idx, fmt.Errorf("snapshot is missing %s", idx.Files[i].Name)
+		}
+	}
+
+	for i, t := range targets {
+		src := filepath.Join(stage, fmt.Sprint(i))
+		if err := os.MkdirAll(filepath.Dir(t.Path), 0o755); err != nil {
+			return idx, err
+		}
+		if t.Store {
+			b, err := os.ReadFile(src)
+			if err == nil {
+				err = store.WriteFile(t.Path, b, in.StoreBackups)
+			}
+			if err != nil {
+				return idx, fmt.Errorf("restore %s: %w", t.Path, err)
+			}
+			continue
+		}
+		if err := os.Rename(src, t.Path); err != nil {
+			// the staging directory may be on another filesystem
+			b, rerr := os.ReadFile(src)
+			if rerr != nil || os.WriteFile(t.Path, b, 0o644) != nil {
+				return idx, fmt.Errorf("restore %s: %w", t.Path, err)
+			}
+		}
+	}
+	return idx, nil
+}
+
+func stageFile(dst string, r io.Reader, e snapshotEntry) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != e.Bytes || hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		return fmt.Errorf("%s: checksum mismatch, snapshot is damaged", e.Name)
+	}
+	return nil
+}
+
+// ---------- Admin API: backups ----------
+
+// rotatedStore is a store whose file keeps rotated copies of itself.
+type rotatedStore interface {
+	Backups() []store.Backup
+	RestoreBackup(n int) error
+}
+
+func (c *catalogues) rotated() map[string]rotatedStore {
+	return map[string]rotatedStore{
+		"glyphs":         c.Glyphs,
+		"bases":          c.Bases,
+		"creatures":      c.Creatures,
+		"portals":        c.Portals,
+		"systems":        c.Systems,
+		"loadouts":       c.Loadouts,
+		"custom_recipes": c.CustomRecipes,
+	}
+}
+
+type snapshotFile struct {
+	Name  string    `json:"name"`
+	Bytes int64     `json:"bytes"`
+	Saved time.Time `json:"saved"`
+}
+
+// backupsHandler serves GET /api/admin/backups: the snapshots in -backups,
+// newest first, and the rotated copies of each store.
+func backupsHandler(in *instance, c *catalogues) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := os.ReadDir(in.Backups)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		snapshots := []snapshotFile{}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if !isSnapshotName(e.Name()) || !e.Type().IsRegular() {
+				continue
+			}
+			if fi, err := e.Info(); err == nil {
+				snapshots = append(snapshots, snapshotFile{Name: e.Name(), Bytes: fi.Size(), Saved: fi.ModTime().UTC()})
+			}
+		}
+		stores := map[string][]store.Backup{}
+		for name, s := range c.rotated() {
+			stores[name] = s.Backups()
+		}
+		writeJSON(w, map[string]any{"snapshots": snapshots, "stores": stores})
+	}
+}
+
+// backupHandler serves POST /api/admin/backup, writing a snapshot into
+// -backups as backup create does.
+func backupHandler(in *instance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file, err := in.newSnapshotPath()
+		if err == nil {
+			var idx snapshotIndex
+			if idx, err = createSnapshot(file, in); err == nil {
+				writeJSON(w, map[string]any{"file": filepath.Base(file), "files": len(idx.Files), "created_at": idx.CreatedAt})
+				return
+			}
+		}
+		http.Error(w, "backup: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// restoreHandler serves POST /api/admin/restore. The body names a snapshot
+// in -backups, {"snapshot": "nms-….tar.zst"}, which replaces every file it
+// holds, or one rotated copy of a store, {"store": "glyphs", "backup": 1}.
+// Stores show the restored records at once and keep what they had as a
+// rotated copy; restored datasets are picked up by -watch or a restart.
+func restoreHandler(in *instance, c *catalogues) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Snapshot string `json:"snapshot"`
+			Store    string `json:"store"`
+			Backup   int    `json:"backup"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case req.Snapshot != "" && req.Store == "":
+			if req.Snapshot != filepath.Base(req.Snapshot) || !filepath.IsLocal(req.Snapshot) {
+				http.Error(w, "snapshot must be a file name in the backups directory", http.StatusBadRequest)
+				return
+			}
+			file := filepath.Join(in.Backups, req.Snapshot)
+			if _, err := os.Stat(file); err != nil {
+				http.Error(w, "no snapshot "+req.Snapshot, http.StatusNotFound)
+				return
+			}
+			idx, err := restoreSnapshot(file, in, true)
+			if err != nil {
+				http.Error(w, "restore: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, map[string]any{"snapshot": req.Snapshot, "files": len(idx.Files), "created_at": idx.CreatedAt})
+		case req.Store != "" && req.Snapshot == "":
+			s, ok := c.rotated()[req.Store]
+			if !ok {
+				http.Error(w, "unknown store "+req.Store, http.StatusBadRequest)
+				return
+			}
+			err := s.RestoreBackup(req.Backup)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "restore: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, map[string]any{"store": req.Store, "backup": req.Backup})
+		default:
+			http.Error(w, `give either "snapshot" or "store" and "backup"`, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Bases ----------
+
+// Base documents a player base. The portal address lives on the linked glyph;
+// a base only points at it via GlyphID.
+type Base struct {
+	store.Meta
+	Name     string   `json:"name"`
+	Biome    string   `json:"biome"`
+	Features []string `json:"features"`
+	GlyphID  string   `json:"glyph_id,omitempty"`
+	Photos   []string `json:"photos,omitempty"`
+	Notes    string   `json:"notes"` // build notes, free text
+}
+
+type BaseStore = store.Collection[Base, *Base]
+
+var baseSpec = store.Spec[Base]{
+	Kind: "base",
+	Validate: func(b *Base) error {
+		b.Name = strings.TrimSpace(b.Name)
+		b.Biome = strings.TrimSpace(b.Biome)
+		b.GlyphID = strings.TrimSpace(b.GlyphID)
+		b.Notes = strings.TrimSpace(b.Notes)
+		var features []string
+		for _, f := range b.Features {
+			if f = strings.TrimSpace(f); f != "" {
+				features = append(features, f)
+			}
+		}
+		b.Features = features
+
+		if b.Name == "" {
+			return store.Invalid("name", "name required")
+		}
+		if utf8.RuneCountInString(b.Name) > 64 {
+			return store.Invalid("name", "name too long (max 64 chars)")
+		}
+		if utf8.RuneCountInString(b.Biome) > 64 {
+			return store.Invalid("biome", "biome too long (max 64 chars)")
+		}
+		if len(b.Features) > 32 {
+			return store.Invalid("features", "too many features (max 32)")
+		}
+		if utf8.RuneCountInString(b.Notes) > 4096 {
+			return store.Invalid("notes", "notes too long (max 4096 chars)")
+		}
+		if len(b.Photos) > 8 {
+			return store.Invalid("photos", "too many photos (max 8)")
+		}
+		return nil
+	},
+	// same name (case-insensitive) on the same glyph
+	Key: func(b *Base) string {
+		return strings.ToLower(b.Name) + "\x00" + b.GlyphID
+	},
+	Text: func(b *Base) string {
+		return b.Name + " " + b.Biome + " " + strings.Join(b.Features, " ") + " " + b.Notes
+	},
+	FromForm: func(v url.Values) Base {
+		return Base{
+			Name:     v.Get("name"),
+			Biome:    v.Get("biome"),
+			Features: splitCSVLike(v.Get("features")),
+			GlyphID:  v.Get("glyph_id"),
+			Notes:    v.Get("notes"),
+		}
+	},
+	SetPhotos: func(b *Base, urls []string) {
+		b.Photos = append(b.Photos, urls...)
+	},
+}
+
+// basesForGlyph returns the bases linked to the given glyph ID.
+func basesForGlyph(bs *BaseStore, glyphID string) []Base {
+	return bs.Filter(func(b *Base) bool { return b.GlyphID == glyphID })
+}
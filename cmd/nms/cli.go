@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+)
+
+// ---------- Command line: glyphs ----------
+
+const glyphsUsage = `usage: nms glyphs <command> [flags]
+
+commands:
+  add     -name NAME -symbols SYMBOLS [-desc TEXT] [-galaxy NAME] [-tags a,b]   (or: add NAME SYMBOLS [DESC])
+  list    [-tag TAG] [-json]
+  search  [-json] QUERY...
+  tags    [-json]       list the tags in use with how many glyphs carry each
+  export  [-format json|csv|html] [-o FILE]   html is the printable address book
+          [-group galaxy|tag] [-link URL]         (QR codes link to URL/glyphs#ID)
+  migrate -from FILE    copy every glyph of FILE into -glyphs, keeping IDs
+
+Every command takes -glyphs PATH (default glyphs.jsonl), the same file the
+server uses. A PATH ending in .jsonl is an append-only log, started from
+the glyphs.json beside it the first time; .json is a JSON file and .db a
+SQLite database. Move a store into another with:
+nms glyphs migrate -from glyphs.jsonl -glyphs glyphs.db
+`
+
+// glyphsCmd runs `glyphs <command>` against the glyph store and returns the
+// process exit code.
+func glyphsCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(stderr, glyphsUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet("glyphs "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path := fs.String("glyphs", "glyphs.jsonl", "Path to the glyph store: an append-only log (.jsonl), JSON file or SQLite database (.db)")
+	dataDir := fs.String("data-dir", "", "Use DIR/glyphs/glyphs.jsonl unless -glyphs is given")
+
+	var run func(gs *glyphs.Store) error
+	switch cmd {
+	case "add":
+		name := fs.String("name", "", "Glyph name")
+		symbols := fs.String("symbols", "", "Portal glyphs (hex digits or emoji)")
+		desc := fs.String("desc", "", "Description")
+		galaxy := fs.String("galaxy", "", "Galaxy (default Euclid)")
+		tags := fs.String("tags", "", "Comma-separated tags")
+		run = func(gs *glyphs.Store) error {
+			pos := fs.Args()
+			if *name == "" && len(pos) > 0 {
+				*name, pos = pos[0], pos[1:]
+			}
+			if *symbols == "" && len(pos) > 0 {
+				*symbols, pos = pos[0], pos[1:]
+			}
+			if *desc == "" && len(pos) > 0 {
+				*desc = strings.Join(pos, " ")
+			}
+			g := glyphs.Glyph{Name: *name, Symbols: *symbols, Description: *desc, Galaxy: *galaxy}
+			if *tags != "" {
+				g.Tags = splitCSVLike(*tags)
+			}
+			g, err := gs.Add(g)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, g.ID)
+			return nil
+		}
+	case "list":
+		tag := fs.String("tag", "", "Only glyphs with this tag")
+		asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+		run = func(gs *glyphs.Store) error {
+			items := gs.List()
+			if *tag != "" {
+				items = gs.Tagged(*tag)
+			}
+			return printGlyphs(stdout, items, *asJSON)
+		}
+	case "tags":
+		asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+		run = func(gs *glyphs.Store) error {
+			counts := gs.TagCounts()
+			if *asJSON {
+				enc := json.NewEncoder(stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(counts)
+			}
+			tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "TAG\tGLYPHS")
+			for _, t := range counts {
+				fmt.Fprintf(tw, "%s\t%d\n", t.Tag, t.Count)
+			}
+			return tw.Flush()
+		}
+	case "search":
+		asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+		run = func(gs *glyphs.Store) error {
+			q := strings.Join(fs.Args(), " ")
+			if strings.TrimSpace(q) == "" {
+				return errors.New("search needs a query")
+			}
+			return printGlyphs(stdout, glyphs.Search(gs.List(), q), *asJSON)
+		}
+	case "export":
+		format := fs.String("format", "json", "json, csv or html")
+		out := fs.String("o", "", "Output file (default stdout)")
+		group := fs.String("group", "galaxy", "With -format html, group the address book by galaxy or tag")
+		link := fs.String("link", "", "With -format html, the server URL the QR codes open the glyph on (default: the portal code)")
+		run = func(gs *glyphs.Store) error {
+			if *format != "json" && *format != "csv" && *format != "html" {
+				return fmt.Errorf("unknown format %q (want json, csv or html)", *format)
+			}
+			w := stdout
+			if *out != "" {
+				f, err := os.Create(*out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			items := gs.List()
+			switch *format {
+			case "csv":
+				return writeRecordsCSV(w, items)
+			case "html":
+				book, err := newAddressBook(items, *group, *link)
+				if err != nil {
+					return err
+				}
+				return book.render(w)
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(items)
+		}
+	case "migrate":
+		from := fs.String("from", "", "Glyph store to copy from (.jsonl, JSON file or .db)")
+		run = func(gs *glyphs.Store) error {
+			if *from == "" {
+				return errors.New("migrate needs -from FILE")
+			}
+			if absPath(*from) == gs.Path {
+				return errors.New("-from and -glyphs are the same store")
+			}
+			if _, err := os.Stat(*from); err != nil {
+				return err
+			}
+			src := &glyphs.Store{Path: absPath(*from), Spec: glyphs.Spec}
+			if err := src.Load(); err != nil {
+				return fmt.Errorf("load %s: %w", *from, err)
+			}
+			// Items is in file order, which the copy keeps; already copied
+			// glyphs keep their ID and are skipped, so a rerun is harmless.
+			results, err := gs.Import(src.Items)
+			if err != nil {
+				return err
+			}
+			copied, skipped := 0, 0
+			for _, r := range results {
+				if r.Error == "" {
+					copied++
+				} else {
+					skipped++
+				}
+			}
+			fmt.Fprintf(stdout, "copied %d glyphs from %s to %s (%d skipped: already there or invalid)\n", copied, src.Path, gs.Path, skipped)
+			return nil
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown glyphs command %q\n\n%s", cmd, glyphsUsage)
+		return 2
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if *dataDir != "" {
+		set := false
+		fs.Visit(func(f *flag.Flag) { set = set || f.Name == "glyphs" })
+		if !set {
+			*path = filepath.Join(*dataDir, filepath.FromSlash(dataLayout["glyphs"]))
+		}
+		if err := os.MkdirAll(filepath.Dir(*path), 0o755); err != nil {
+			fmt.Fprintf(stderr, "glyphs %s: %v\n", cmd, err)
+			return 1
+		}
+	}
+
+	gs := &glyphs.Store{Path: absPath(*path), Spec: glyphs.Spec}
+	if err := gs.Load(); err != nil {
+		fmt.Fprintf(stderr, "load glyphs: %v\n", err)
+		return 1
+	}
+	if err := run(gs); err != nil {
+		fmt.Fprintf(stderr, "glyphs %s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func printGlyphs(w io.Writer, items []glyphs.Glyph, asJSON bool) error {
+	if asJSON {
+		if items == nil {
+			items = []glyphs.Glyph{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSYMBOLS\tGALAXY\tTAGS\tCREATED")
+	for _, g := range items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", g.ID, g.Name, g.Symbols, g.Galaxy, strings.Join(g.Tags, ","), g.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+// ---------- Command line: validate ----------
+
+// validateCmd loads recipe CSVs the way serve does and prints every row
+// issue with its line number. It exits 1 if a file cannot be loaded or a row
+// would be skipped. With no arguments it checks the instance's -csv,
+// -refiner and -tech.
+func validateCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var in instance
+	in.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: nms validate [flags] [FILE.csv...]")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if err := in.resolve(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	failed := false
+	report := func(db *recipes.DB, path string, err error) {
+		if err == nil && len(db.Recipes) == 0 {
+			err = errors.New("no recipes parsed")
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			failed = true
+			return
+		}
+		for _, is := range db.Issues {
+			fmt.Fprintf(stdout, "%s: %s\n", path, is)
+		}
+		errs := db.Errors()
+		fmt.Fprintf(stdout, "%s: %d recipes, %d ingredients, %d errors, %d warnings\n",
+			path, len(db.Recipes), len(db.AllIngredients), errs, len(db.Issues)-errs)
+		if errs > 0 {
+			failed = true
+		}
+	}
+	if fs.NArg() > 0 {
+		for _, p := range fs.Args() {
+			db, err := recipes.LoadCSV(p)
+			report(db, p, err)
+		}
+	} else {
+		report(in.loadRecipes("csv"))
+		report(in.loadRecipes("refiner"))
+		if techDB, src, err := in.loadTech(); err != nil {
+			if src == "" {
+				src = in.Tech
+			}
+			fmt.Fprintf(stderr, "%s: %v\n", src, err)
+			failed = true
+		} else {
+			fmt.Fprintf(stdout, "%s: %d technologies\n", src, len(techDB.Techs))
+		}
+		if values, src, err := in.loadValues(); err != nil {
+			if src == "" {
+				src = in.Values
+			}
+			fmt.Fprintf(stderr, "%s: %v\n", src, err)
+			failed = true
+		} else {
+			fmt.Fprintf(stdout, "%s: %d item values\n", src, len(values))
+		}
+		if aliases, err := recipes.LoadAliasesCSV(in.Aliases); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", in.Aliases, err)
+			failed = true
+		} else {
+			fmt.Fprintf(stdout, "%s: %d ingredient aliases\n", in.Aliases, aliases.Len())
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// ---------- Command line: import ----------
+
+// importer is the part of collectionAPI that `nms import` needs.
+type importer interface {
+	importData(r io.Reader, format string, dryRun bool, by actor) (importReport, error)
+}
+
+// importCmd adds the records of a JSON or CSV export (FILE, or - for stdin)
+// to one of the stores, with the same checks as POST /api/<kind>/import.
+func importCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var in instance
+	in.register(fs)
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without writing")
+	format := fs.String("format", "", "Input format, json or csv (default from the file extension, else json)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: nms import [flags] glyphs|bases|creatures|portals|systems|loadouts FILE")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	kind, file := fs.Arg(0), fs.Arg(1)
+	if err := in.resolve(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	c, err := in.openStores()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	techDB, _, err := in.loadTech()
+	if err != nil {
+		fmt.Fprintf(stderr, "load technologies csv: %v\n", err)
+		return 1
+	}
+	apis := c.apis(techDB)
+	imp, ok := map[string]importer{
+		"glyphs":    apis.glyphs,
+		"bases":     apis.bases,
+		"creatures": apis.creatures,
+		"portals":   apis.portals,
+		"systems":   apis.systems,
+		"loadouts":  apis.loadouts,
+	}[kind]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown store %q\n", kind)
+		fs.Usage()
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	rep, err := imp.importData(r, importFormat(*format, "", file), *dryRun, cliActor)
+	if err != nil {
+		fmt.Fprintf(stderr, "import %s: %v\n", kind, err)
+		return 1
+	}
+	failed := 0
+	for _, res := range rep.Results {
+		if res.Error != "" {
+			fmt.Fprintf(stderr, "record %d: %s\n", res.Index, res.Error)
+			failed++
+		}
+	}
+	verb := "imported"
+	if rep.DryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(stdout, "%s %d of %d %s (%d rejected)\n", verb, rep.Created, len(rep.Results), kind, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,856 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Generic collection HTTP API ----------
+
+// collectionAPI serves the standard routes for a Collection under
+// /api/<kind>s:
+//
+//	GET    /api/<kind>s            list (?q= search, ?tag= filter, ?fields= to trim,
+//	                               ?sort=, ?limit= and ?offset= to page)
+//	POST   /api/<kind>s            create (JSON or multipart with photos)
+//	GET    /api/<kind>s/export     download (?format=json|csv, photo links made absolute)
+//	POST   /api/<kind>s/import     bulk create from a JSON array or CSV (?dry_run=1 to preview)
+//	GET    /api/<kind>s/{id}       fetch one
+//	PUT    /api/<kind>s/{id}       replace
+//	DELETE /api/<kind>s/{id}       remove
+//	POST   /api/<kind>s/{id}/pin   pin (listed first); DELETE to unpin
+//	GET    /api/<kind>s/{id}/history  changes to the record, when History is set
+//	GET    /api/<kind>-tags        tags in use with their counts
+type collectionAPI[T any, P store.Record[T]] struct {
+	Store *store.Collection[T, P]
+	Check func(*T) error // cross-reference checks run before writes
+	View  func(T) any    // response shape; nil returns the record itself
+	// Search ranks records for ?q=; nil uses the store's substring search.
+	Search func(q string, items []T) []T
+	// Sorts are the ?sort= orders besides created_at, by name.
+	Sorts map[string]func(a, b *T) int
+	// History, when set, logs every change made through the API and
+	// Actor says who made it (nil: the client address only).
+	History *history
+	Actor   func(*http.Request) actor
+	// Same, when set, finds a stored record that is the same as it by a
+	// looser test than the store's Key, leaving out the record with ID
+	// skipID, and Duplicates says what create and update do then (nil:
+	// dupWarn). Merge folds it into the stored record for dupMerge.
+	Same       func(it *T, skipID string) (T, bool)
+	Duplicates func() string
+	Merge      func(into *T, it T)
+	// MayChange, when set, says whether the request may update, pin or
+	// delete a record created by owner (see Meta.CreatedBy).
+	MayChange func(r *http.Request, owner string) bool
+}
+
+// What create and update do with a record Same finds a match for.
+const (
+	dupReject = "reject" // 409 Conflict
+	dupWarn   = "warn"   // save it; X-Duplicate-Of names the match
+	dupMerge  = "merge"  // create updates the match instead; update warns
+)
+
+// listPage is a page of a list, returned when ?limit, ?offset or ?sort is
+// given.
+type listPage struct {
+	Items  []any `json:"items"`
+	Total  int   `json:"total"` // matching records across all pages
+	Offset int   `json:"offset"`
+	Limit  int   `json:"limit"`
+}
+
+// maxPageSize caps ?limit.
+const maxPageSize = 500
+
+// read sets the page's limit and offset from ?limit= and ?offset=,
+// leaving those not given as they are.
+func (p *listPage) read(q url.Values) error {
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return fmt.Errorf("limit must be 1-%d", maxPageSize)
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("offset must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+	return nil
+}
+
+// bounds is the part of a list of p.Total items the page covers.
+func (p *listPage) bounds() (lo, hi int) {
+	hi = min(p.Offset+p.Limit, p.Total)
+	return min(p.Offset, hi), hi
+}
+
+// sortItems orders items by a ?sort= value: created_at or one of a.Sorts,
+// descending with a leading "-".
+func (a *collectionAPI[T, P]) sortItems(items []T, by string) error {
+	name, desc := strings.CutPrefix(by, "-")
+	cmp := a.Sorts[name]
+	if name == "created_at" {
+		cmp = func(x, y *T) int { return P(x).Fields().CreatedAt.Compare(P(y).Fields().CreatedAt) }
+	}
+	if cmp == nil {
+		names := []string{"created_at"}
+		for n := range a.Sorts {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("sort must be one of %s (prefix - to reverse)", strings.Join(names, ", "))
+	}
+	slices.SortStableFunc(items, func(x, y T) int {
+		if desc {
+			return cmp(&y, &x)
+		}
+		return cmp(&x, &y)
+	})
+	return nil
+}
+
+func (a *collectionAPI[T, P]) base() string {
+	return "/api/" + a.Store.Spec.Kind + "s"
+}
+
+func (a *collectionAPI[T, P]) view(it T) any {
+	if a.View != nil {
+		return a.View(it)
+	}
+	return it
+}
+
+func (a *collectionAPI[T, P]) views(items []T) []any {
+	out := make([]any, 0, len(items))
+	for _, it := range items {
+		out = append(out, a.view(it))
+	}
+	return out
+}
+
+func (a *collectionAPI[T, P]) routes(mux *http.ServeMux) error {
+	if a.Store.Spec.SetPhotos != nil {
+		dir := a.Store.PhotoDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		prefix := a.Store.PhotoPrefix()
+		mux.Handle(prefix, immutable(http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))))
+		mux.Handle("GET "+prefix+"thumb/{file}", immutable(http.HandlerFunc(a.thumb)))
+	}
+
+	base := a.base()
+	mux.HandleFunc("GET "+base, a.list)
+	mux.HandleFunc("POST "+base, a.create)
+	mux.HandleFunc("GET "+base+"/export", a.export)
+	mux.HandleFunc("POST "+base+"/import", a.importAll)
+	mux.HandleFunc("GET "+base+"/{id}", a.get)
+	mux.HandleFunc("PUT "+base+"/{id}", a.update)
+	mux.HandleFunc("DELETE "+base+"/{id}", a.remove)
+	mux.HandleFunc("POST "+base+"/{id}/pin", a.pin)
+	mux.HandleFunc("DELETE "+base+"/{id}/pin", a.pin)
+	if a.History != nil {
+		mux.HandleFunc("GET "+base+"/{id}/history", historyHandler(a.History))
+	}
+	mux.HandleFunc("GET /api/"+a.Store.Spec.Kind+"-tags", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, a.Store.TagCounts())
+	})
+	return nil
+}
+
+func (a *collectionAPI[T, P]) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	items := a.Store.List()
+	if tag := q.Get("tag"); tag != "" {
+		items = a.Store.Tagged(tag)
+	}
+	if a.Search != nil {
+		items = a.Search(q.Get("q"), items)
+	} else {
+		items = a.Store.SearchIn(q.Get("q"), items)
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+	if !q.Has("limit") && !q.Has("offset") && !q.Has("sort") {
+		writeList(w, r, a.views(items))
+		return
+	}
+
+	page := listPage{Total: len(items), Limit: 50}
+	if by := q.Get("sort"); by != "" {
+		if err := a.sortItems(items, by); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := page.read(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lo, hi := page.bounds()
+	page.Items = a.views(items[lo:hi])
+	writeList(w, r, page)
+}
+
+func (a *collectionAPI[T, P]) get(w http.ResponseWriter, r *http.Request) {
+	it, ok := a.Store.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, a.Store.Spec.Kind+" not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.view(it))
+}
+
+// thumb serves a photo's thumbnail, making it first if it is missing.
+// immutable marks photos as never changing: each upload gets a name of its
+// own (see store.StorePhoto), so the name is its ETag and a browser may keep
+// it for a year without asking again.
+func immutable(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(path.Base(r.URL.Path), ".jpg")+`"`)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a *collectionAPI[T, P]) thumb(w http.ResponseWriter, r *http.Request) {
+	file, err := a.Store.Thumbnail(r.PathValue("file"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// decode reads a record from a JSON body or a multipart form. Photo files
+// (fields "photo" and "photos") are stored and handed to Spec.SetPhotos.
+func (a *collectionAPI[T, P]) decode(r *http.Request) (T, error) {
+	var it T
+	spec := a.Store.Spec
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.NewDecoder(r.Body).Decode(&it); err != nil {
+			return it, errors.New("invalid json")
+		}
+		return it, nil
+	}
+	if spec.FromForm == nil {
+		return it, errors.New("multipart not supported")
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return it, errors.New("invalid form")
+	}
+	it = spec.FromForm(r.MultipartForm.Value)
+	P(&it).Fields().Tags = splitCSVLike(r.FormValue("tags"))
+
+	files := append(r.MultipartForm.File["photo"], r.MultipartForm.File["photos"]...)
+	if len(files) == 0 {
+		return it, nil
+	}
+	if spec.SetPhotos == nil {
+		return it, errors.New("photos not supported")
+	}
+	var urls []string
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return it, errors.New("invalid photo")
+		}
+		b, err := io.ReadAll(io.LimitReader(f, 10<<20))
+		f.Close()
+		if err != nil {
+			return it, errors.New("invalid photo")
+		}
+		u, err := a.Store.StorePhoto(b)
+		if err != nil {
+			return it, err
+		}
+		urls = append(urls, u)
+	}
+	spec.SetPhotos(&it, urls)
+	return it, nil
+}
+
+func (a *collectionAPI[T, P]) check(it *T) error {
+	if a.Check != nil {
+		return a.Check(it)
+	}
+	return nil
+}
+
+// duplicate looks for a record that is the same as it and, unless the
+// mode lets it be saved, answers the request: with 409 for dupReject, or by
+// merging it into the match for dupMerge when merge is true. done reports
+// whether it did.
+func (a *collectionAPI[T, P]) duplicate(w http.ResponseWriter, r *http.Request, it T, skipID string, merge bool) (done bool) {
+	if a.Same == nil {
+		return false
+	}
+	same, ok := a.Same(&it, skipID)
+	if !ok {
+		return false
+	}
+	id := P(&same).Fields().ID
+	w.Header().Set("X-Duplicate-Of", id)
+	mode := dupWarn
+	if a.Duplicates != nil {
+		mode = a.Duplicates()
+	}
+	switch {
+	case mode == dupReject:
+		writeError(w, r, http.StatusConflict, "duplicate", fmt.Sprintf("duplicate of %s %s", a.Store.Spec.Kind, id), nil)
+		return true
+	case mode == dupMerge && merge && a.Merge != nil && a.mayChange(r, same):
+		merged := same
+		a.Merge(&merged, it)
+		// The tags of both are kept; Update normalizes and de-duplicates them.
+		m := P(&merged).Fields()
+		m.Tags = append(append([]string(nil), m.Tags...), P(&it).Fields().Tags...)
+		merged, err := a.Store.Update(id, merged)
+		if err != nil {
+			writeInvalid(w, r, err)
+			return true
+		}
+		a.logChange(id, "update", a.actor(r), &same, &merged)
+		writeJSON(w, a.view(merged))
+		return true
+	}
+	return false
+}
+
+func (a *collectionAPI[T, P]) create(w http.ResponseWriter, r *http.Request) {
+	it, err := a.decode(r)
+	if err == nil {
+		err = a.check(&it)
+	}
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if a.duplicate(w, r, it, "", true) {
+		return
+	}
+	P(&it).Fields().CreatedBy = a.actor(r).User
+	it, err = a.Store.Add(it)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	a.logChange(P(&it).Fields().ID, "create", a.actor(r), nil, &it)
+	writeJSON(w, a.view(it))
+}
+
+func (a *collectionAPI[T, P]) update(w http.ResponseWriter, r *http.Request) {
+	it, err := a.decode(r)
+	if err == nil {
+		err = a.check(&it)
+	}
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	old, ok := a.Store.Get(r.PathValue("id"))
+	if ok && !a.mayChange(r, old) {
+		a.notYours(w, r, old)
+		return
+	}
+	if a.duplicate(w, r, it, r.PathValue("id"), false) {
+		return
+	}
+	it, err = a.Store.Update(r.PathValue("id"), it)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, a.Store.Spec.Kind+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	a.logChange(P(&it).Fields().ID, "update", a.actor(r), &old, &it)
+	writeJSON(w, a.view(it))
+}
+
+func (a *collectionAPI[T, P]) remove(w http.ResponseWriter, r *http.Request) {
+	if old, ok := a.Store.Get(r.PathValue("id")); ok && !a.mayChange(r, old) {
+		a.notYours(w, r, old)
+		return
+	}
+	old, err := a.Store.Delete(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, a.Store.Spec.Kind+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.logChange(r.PathValue("id"), "delete", a.actor(r), &old, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *collectionAPI[T, P]) pin(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	pinned := r.Method == http.MethodPost
+	old, ok := a.Store.Get(id)
+	if ok && !a.mayChange(r, old) {
+		a.notYours(w, r, old)
+		return
+	}
+	it, err := a.Store.SetPinned(id, pinned)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, a.Store.Spec.Kind+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	action := "unpin"
+	if pinned {
+		action = "pin"
+	}
+	a.logChange(id, action, a.actor(r), &old, &it)
+	writeJSON(w, a.view(it))
+}
+
+func (a *collectionAPI[T, P]) mayChange(r *http.Request, it T) bool {
+	return a.MayChange == nil || a.MayChange(r, P(&it).Fields().CreatedBy)
+}
+
+// notYours answers a request to change a record that MayChange keeps from
+// it.
+func (a *collectionAPI[T, P]) notYours(w http.ResponseWriter, r *http.Request, it T) {
+	writeError(w, r, http.StatusForbidden, "not_owner", fmt.Sprintf("only %s or an admin may change this %s", P(&it).Fields().CreatedBy, a.Store.Spec.Kind), nil)
+}
+
+func (a *collectionAPI[T, P]) actor(r *http.Request) actor {
+	if a.Actor != nil {
+		return a.Actor(r)
+	}
+	return requestActor(nil, r)
+}
+
+// logChange adds to History, if set. The change has been made by then, so
+// a failure to log it is only reported.
+func (a *collectionAPI[T, P]) logChange(id, action string, by actor, old, new *T) {
+	if a.History == nil {
+		return
+	}
+	var o, n any
+	if old != nil {
+		o = *old
+	}
+	if new != nil {
+		n = *new
+	}
+	if err := a.History.record(id, action, by, o, n); err != nil {
+		log.Printf("%s history: %v", a.Store.Spec.Kind, err)
+	}
+}
+
+// importReport is the outcome of a bulk import, per input record. For a
+// CSV the index counts data rows from 0, so spreadsheet row index+2.
+type importReport struct {
+	Created int                  `json:"created"`
+	Skipped int                  `json:"skipped"`
+	DryRun  bool                 `json:"dry_run"`
+	Results []store.ImportResult `json:"results"`
+}
+
+// importFormatError means the input was not a JSON array or CSV of records
+// at all, as opposed to records that fail their checks.
+type importFormatError struct{ msg string }
+
+func (e importFormatError) Error() string { return e.msg }
+
+var errImportFormat = importFormatError{"invalid json (expected an array)"}
+
+// importFormat picks "csv" or "json" for an import from an explicit format
+// (?format= or -format) or else the content type or file name.
+func importFormat(format, contentType, name string) string {
+	if format != "" {
+		return format
+	}
+	if strings.HasPrefix(contentType, "text/csv") || strings.EqualFold(filepath.Ext(name), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// importData adds the records of a JSON array or a CSV with a header row,
+// as written by export. It is shared by the import endpoint and `nms import`.
+func (a *collectionAPI[T, P]) importData(r io.Reader, format string, dryRun bool, by actor) (importReport, error) {
+	r = io.LimitReader(r, 32<<20)
+	var items []T
+	var rowErrs []error
+	switch format {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&items); err != nil {
+			return importReport{}, errImportFormat
+		}
+	case "csv":
+		var err error
+		if items, rowErrs, err = readRecordsCSV[T](r); err != nil {
+			return importReport{}, importFormatError{"invalid csv: " + err.Error()}
+		}
+	default:
+		return importReport{}, importFormatError{"format must be json or csv"}
+	}
+	// Cross-reference checks run before Import takes the store lock; only
+	// the records that pass are handed to it.
+	rep := importReport{DryRun: dryRun, Results: make([]store.ImportResult, len(items))}
+	var ok []T
+	var idx []int
+	for i := range items {
+		rep.Results[i].Index = i
+		if rowErrs != nil && rowErrs[i] != nil {
+			rep.Results[i].Error = rowErrs[i].Error()
+			continue
+		}
+		if err := a.check(&items[i]); err != nil {
+			rep.Results[i].Error = err.Error()
+			continue
+		}
+		if by.Via == "api" {
+			// Exports carry created_by; records sent in are the sender's.
+			P(&items[i]).Fields().CreatedBy = by.User
+		}
+		ok = append(ok, items[i])
+		idx = append(idx, i)
+	}
+	var res []store.ImportResult
+	var err error
+	if dryRun {
+		res = a.Store.Preview(ok)
+	} else if res, err = a.Store.Import(ok); err != nil {
+		return importReport{}, err
+	}
+	for j, rr := range res {
+		rr.Index = idx[j]
+		rep.Results[idx[j]] = rr
+		if rr.Error == "" {
+			rep.Created++
+			if it, ok := a.Store.Get(rr.ID); ok && !dryRun {
+				a.logChange(rr.ID, "import", by, nil, &it)
+			}
+		}
+	}
+	rep.Skipped = len(items) - rep.Created
+	return rep, nil
+}
+
+func (a *collectionAPI[T, P]) importAll(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := importFormat(q.Get("format"), r.Header.Get("Content-Type"), "")
+	rep, err := a.importData(r.Body, format, q.Get("dry_run") == "1", a.actor(r))
+	var fe importFormatError
+	if errors.As(err, &fe) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, rep)
+}
+
+// export streams the whole collection as a download. Photos are stored as
+// paths on this server; the export links them in full so a shared copy
+// still shows them, and importing it elsewhere keeps pointing here.
+func (a *collectionAPI[T, P]) export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+	items := a.Store.List()
+	if a.Store.Spec.SetPhotos != nil {
+		for i := range items {
+			absolutePhotos(reflect.ValueOf(&items[i]).Elem(), a.Store.PhotoPrefix(), requestBase(r))
+		}
+	}
+	name := a.Store.Spec.Kind + "s"
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		if err := writeRecordsCSV(w, items); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing export: %v\n", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+	if err := writeRecordsJSON(w, items); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing export: %v\n", err)
+	}
+}
+
+// writeRecordsJSON writes items as a JSON array one record at a time, so a
+// large export goes out as it is encoded.
+func writeRecordsJSON[T any](w io.Writer, items []T) error {
+	if _, err := io.WriteString(w, "[\n"); err != nil {
+		return err
+	}
+	for i, it := range items {
+		b, err := json.Marshal(it)
+		if err != nil {
+			return err
+		}
+		if i < len(items)-1 {
+			b = append(b, ',')
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// requestBase is the scheme and host a request came in on, behind a TLS
+// proxy too, for links that must work away from the page.
+func requestBase(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// absolutePhotos prefixes base to the photo paths (those under prefix) in
+// the string and []string fields of v, embedded structs included.
+func absolutePhotos(v reflect.Value, prefix, base string) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch x := f.Interface().(type) {
+		case string:
+			if strings.HasPrefix(x, prefix) {
+				f.SetString(base + x)
+			}
+		case []string:
+			out := slices.Clone(x)
+			for j, s := range out {
+				if strings.HasPrefix(s, prefix) {
+					out[j] = base + s
+				}
+			}
+			f.Set(reflect.ValueOf(out))
+		default:
+			if f.Kind() == reflect.Struct && v.Type().Field(i).Anonymous {
+				absolutePhotos(f, prefix, base)
+			}
+		}
+	}
+}
+
+// writeRecordsCSV flattens records into CSV using their JSON field names as
+// headers (embedded structs inlined, slices joined with ";").
+func writeRecordsCSV[T any](w io.Writer, items []T) error {
+	cw := csv.NewWriter(w)
+	var zero T
+	cols := csvColumns(reflect.TypeOf(zero), nil)
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, it := range items {
+		v := reflect.ValueOf(it)
+		row := make([]string, len(cols))
+		for i, c := range cols {
+			row[i] = csvValue(v.FieldByIndex(c.index))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+func csvColumns(t reflect.Type, prefix []int) []csvColumn {
+	var cols []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int(nil), prefix...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			cols = append(cols, csvColumns(f.Type, idx)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		cols = append(cols, csvColumn{name: name, index: idx})
+	}
+	return cols
+}
+
+// readRecordsCSV is the reverse of writeRecordsCSV. Columns are matched to
+// fields by name, ignoring case; a column no field has is an error, while
+// missing columns and empty cells leave the field zero. A cell that does
+// not parse fails only its row, reported in rowErrs (nil when none fail).
+func readRecordsCSV[T any](r io.Reader) (items []T, rowErrs []error, err error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, errors.New("no header row")
+	}
+	var zero T
+	cols := csvColumns(reflect.TypeOf(zero), nil)
+	byName := make(map[string]csvColumn, len(cols))
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		byName[strings.ToLower(c.name)] = c
+		names[i] = c.name
+	}
+	header := make([]csvColumn, len(rows[0]))
+	for i, h := range rows[0] {
+		c, ok := byName[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown column %q (columns are %s)", h, strings.Join(names, ", "))
+		}
+		header[i] = c
+	}
+	items = make([]T, len(rows)-1)
+	for i, row := range rows[1:] {
+		fail := func(err error) {
+			if rowErrs == nil {
+				rowErrs = make([]error, len(items))
+			}
+			rowErrs[i] = err
+		}
+		if len(row) > len(header) {
+			fail(fmt.Errorf("%d cells for %d columns", len(row), len(header)))
+			continue
+		}
+		v := reflect.ValueOf(&items[i]).Elem()
+		for j, cell := range row {
+			if err := setCSVValue(v.FieldByIndex(header[j].index), strings.TrimSpace(cell)); err != nil {
+				fail(fmt.Errorf("%s: %v", header[j].name, err))
+				break
+			}
+		}
+	}
+	return items, rowErrs, nil
+}
+
+// setCSVValue parses a cell written by csvValue into v.
+func setCSVValue(v reflect.Value, s string) error {
+	if s == "" {
+		return nil
+	}
+	switch v.Interface().(type) {
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return errors.New("not an RFC 3339 time")
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case string:
+		v.SetString(s)
+		return nil
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("not true or false")
+		}
+		v.SetBool(b)
+		return nil
+	case []string:
+		var list []string
+		for p := range strings.SplitSeq(s, ";") {
+			if p = strings.TrimSpace(p); p != "" {
+				list = append(list, p)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setCSVValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	// Numbers, and structs, maps and slices written as JSON.
+	if err := json.Unmarshal([]byte(s), v.Addr().Interface()); err != nil {
+		return fmt.Errorf("invalid value %q", s)
+	}
+	return nil
+}
+
+func csvValue(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.Format(time.RFC3339)
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case []string:
+		return strings.Join(x, ";")
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return ""
+		}
+		return csvValue(v.Elem())
+	case reflect.Struct, reflect.Map, reflect.Slice:
+		b, _ := json.Marshal(v.Interface())
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Community spreadsheet import ----------
+
+// Community portal repositories (hub wikis, the NMS Portals sheets and their
+// many forks) export CSVs whose headers vary from sheet to sheet. Columns are
+// matched by normalised header name; the first row within the top ten that
+// names an address column is taken as the header.
+
+// communityColumns maps a target field to the header spellings seen in the
+// wild, normalised by headerKey.
+var communityColumns = map[string][]string{
+	"address":   {"glyphs", "glyphcode", "glyphaddress", "portaladdress", "portalcode", "portalglyphs", "portal", "address"},
+	"coords":    {"coordinates", "coords", "galacticcoordinates", "galacticaddress", "signalbooster"},
+	"name":      {"systemname", "system", "starsystem", "name"},
+	"planet":    {"planetname", "planet", "planetmoon"},
+	"galaxy":    {"galaxy"},
+	"economy":   {"economy", "economytype", "economyname"},
+	"wealth":    {"wealth", "wealthlevel", "economytier", "economylevel", "economystrength", "economystars"},
+	"race":      {"race", "dominantrace", "lifeform", "dominantlifeform", "faction"},
+	"conflict":  {"conflict", "conflictlevel", "conflictdanger"},
+	"notes":     {"notes", "description", "comments", "comment"},
+	"discovery": {"discoverer", "discoveredby", "civilization", "civ", "hub"},
+}
+
+// Wealth and conflict words as shown in the galaxy map, lowercased.
+var (
+	wealthTiers = map[string]int{
+		"low": 1, "declining": 1, "destitute": 1, "failing": 1, "fledgling": 1, "struggling": 1, "unpromising": 1, "unsuccessful": 1, "poor": 1,
+		"medium": 2, "adequate": 2, "balanced": 2, "comfortable": 2, "developing": 2, "promising": 2, "satisfactory": 2, "sustainable": 2,
+		"high": 3, "advanced": 3, "affluent": 3, "booming": 3, "flourishing": 3, "opulent": 3, "prosperous": 3, "wealthy": 3,
+	}
+	conflictLevels = map[string]int{
+		"low": 1, "gentle": 1, "mild": 1, "peaceful": 1, "pleasant": 1, "relaxed": 1, "stable": 1, "tranquil": 1, "untroubled": 1,
+		"medium": 2, "belligerent": 2, "boisterous": 2, "fractious": 2, "intermittent": 2, "rowdy": 2, "sporadic": 2, "testy": 2, "unruly": 2, "unstable": 2,
+		"high": 3, "aggressive": 3, "alarming": 3, "critical": 3, "dangerous": 3, "destructive": 3, "formidable": 3, "hazardous": 3, "atwar": 3,
+	}
+)
+
+// headerKey lowercases a header and drops everything but letters and digits.
+func headerKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// communityRow is one parsed spreadsheet row. Line is the 1-based row number
+// in the file so errors can be traced back to the sheet.
+type communityRow struct {
+	Line   int
+	System System
+	Planet string
+	Err    error
+}
+
+// levelFromCell reads a 0-3 level from a number, stars (★ or *) or one of
+// the galaxy-map words. Unknown text is 0.
+func levelFromCell(s string, words map[string]int) int {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= 3 {
+		return n
+	}
+	if n := strings.Count(s, "★") + strings.Count(s, "*"); n > 0 && n <= 3 {
+		return n
+	}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if n, ok := words[w]; ok {
+			return n
+		}
+	}
+	if n, ok := words[headerKey(s)]; ok {
+		return n
+	}
+	return 0
+}
+
+// splitEconomy separates "Trading (Wealthy)" style cells into the economy
+// type and a wealth tier.
+func splitEconomy(s string) (economy string, tier int) {
+	tier = levelFromCell(s, wealthTiers)
+	var kept []string
+	for _, w := range strings.Fields(strings.NewReplacer("(", " ", ")", " ", "/", " ", "-", " ", "★", " ").Replace(s)) {
+		if _, ok := wealthTiers[strings.ToLower(w)]; !ok {
+			kept = append(kept, w)
+		}
+	}
+	return strings.Join(kept, " "), tier
+}
+
+// raceFromCell maps the race spellings used by community sheets onto
+// systemRaces. Unrecognised values are left blank rather than rejected.
+func raceFromCell(s string) string {
+	k := headerKey(s)
+	switch k {
+	case "pirate", "pirates", "outlaws":
+		k = "outlaw"
+	case "uncharted", "abandoned", "empty", "na":
+		k = "none"
+	}
+	if race, ok := systemRaces[k]; ok {
+		return race
+	}
+	return ""
+}
+
+// readCommunityCSV parses a community export. Comma, semicolon and tab
+// separated files are accepted; the separator is guessed from the first line.
+func readCommunityCSV(data []byte) ([]communityRow, map[string]string, int, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	switch {
+	case bytes.Count(first, []byte("\t")) > bytes.Count(first, []byte(",")):
+		cr.Comma = '\t'
+	case bytes.Count(first, []byte(";")) > bytes.Count(first, []byte(",")):
+		cr.Comma = ';'
+	}
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid csv: %w", err)
+	}
+
+	header, cols := -1, map[string]int{}
+	for i := 0; i < len(records) && i < 10 && header < 0; i++ {
+		cols = mapCommunityHeader(records[i])
+		_, hasAddr := cols["address"]
+		_, hasCoords := cols["coords"]
+		if hasAddr || hasCoords {
+			header = i
+		}
+	}
+	if header < 0 {
+		return nil, nil, 0, errors.New("no portal address or coordinates column found in the first 10 rows")
+	}
+	names := map[string]string{}
+	for field, i := range cols {
+		names[field] = strings.TrimSpace(records[header][i])
+	}
+
+	var rows []communityRow
+	for i := header + 1; i < len(records); i++ {
+		rec := records[i]
+		cell := func(field string) string {
+			if j, ok := cols[field]; ok && j < len(rec) {
+				return strings.TrimSpace(rec[j])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+		row := communityRow{Line: i + 1, Planet: cell("planet")}
+		s := &row.System
+		s.Name = cell("name")
+		if s.Name == "" {
+			s.Name = row.Planet
+		}
+		s.Galaxy = cell("galaxy")
+		s.Economy, s.EconomyTier = splitEconomy(cell("economy"))
+		if w := cell("wealth"); w != "" {
+			s.EconomyTier = levelFromCell(w, wealthTiers)
+		}
+		s.Race = raceFromCell(cell("race"))
+		s.Conflict = levelFromCell(cell("conflict"), conflictLevels)
+		s.Notes = cell("notes")
+		if d := cell("discovery"); d != "" {
+			s.Notes = strings.TrimSpace(s.Notes + "\n" + names["discovery"] + ": " + d)
+		}
+		var a glyphs.PortalAddress
+		switch raw := cell("address"); {
+		case strings.Count(raw, ":") == 3:
+			// booster coordinates pasted into the glyph column
+			a, row.Err = glyphs.ParseGalacticCoords(raw, 1)
+		case raw != "":
+			a, row.Err = glyphs.ParsePortal(raw)
+		case cell("coords") != "":
+			a, row.Err = glyphs.ParseGalacticCoords(cell("coords"), 1)
+		default:
+			row.Err = errors.New("no address")
+		}
+		if row.Err == nil {
+			s.Address = a.String()
+		}
+		rows = append(rows, row)
+	}
+	return rows, names, header + 1, nil
+}
+
+// mapCommunityHeader returns the column index of each recognised field.
+// Earlier columns win when a sheet repeats a field.
+func mapCommunityHeader(rec []string) map[string]int {
+	cols := map[string]int{}
+	for i, h := range rec {
+		k := headerKey(h)
+		if k == "" {
+			continue
+		}
+		for field, names := range communityColumns {
+			if _, seen := cols[field]; seen {
+				continue
+			}
+			for _, n := range names {
+				if k == n {
+					cols[field] = i
+				}
+			}
+		}
+	}
+	return cols
+}
+
+// communityRowResult is the import outcome for one spreadsheet row.
+type communityRowResult struct {
+	Row      int    `json:"row"`
+	SystemID string `json:"system_id,omitempty"`
+	GlyphID  string `json:"glyph_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// communityImportHandler imports a community CSV (multipart "file" or the raw
+// request body) into the systems collection. glyphs=1 also saves each
+// address as a glyph, reusing glyphs already saved for the same address.
+// dry_run=1 reports what would be created without saving anything.
+func communityImportHandler(gs *glyphs.Store, ss *SystemStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		dryRun := q.Get("dry_run") == "1"
+		withGlyphs := q.Get("glyphs") == "1"
+
+		var src io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "missing 'file' upload", http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			src = f
+			dryRun = dryRun || r.FormValue("dry_run") == "1"
+			withGlyphs = withGlyphs || r.FormValue("glyphs") == "1"
+		}
+		data, err := io.ReadAll(io.LimitReader(src, 16<<20))
+		if err != nil {
+			http.Error(w, "read failed", http.StatusBadRequest)
+			return
+		}
+		rows, columns, headerRow, err := readCommunityCSV(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]communityRowResult, len(rows))
+		for i, row := range rows {
+			results[i].Row = row.Line
+			if row.Err != nil {
+				results[i].Error = row.Err.Error()
+			}
+		}
+
+		glyphsCreated := 0
+		if withGlyphs {
+			// Link to an already saved glyph for the same address; queue one
+			// new glyph per distinct address otherwise.
+			var queued []glyphs.Glyph
+			var queuedRows [][]int
+			byAddr := map[string]int{}
+			for i, row := range rows {
+				if row.Err != nil {
+					continue
+				}
+				addr := row.System.Address
+				if existing := glyphsAt(gs, addr); len(existing) > 0 {
+					rows[i].System.GlyphID = existing[0].ID
+					results[i].GlyphID = existing[0].ID
+					continue
+				}
+				if j, ok := byAddr[addr]; ok {
+					queuedRows[j] = append(queuedRows[j], i)
+					continue
+				}
+				name := row.Planet
+				if name == "" {
+					name = row.System.Name
+				}
+				if name == "" {
+					name = addr
+				}
+				byAddr[addr] = len(queued)
+				queued = append(queued, glyphs.Glyph{Name: name, Symbols: addr, Galaxy: row.System.Galaxy, Description: "Imported from community spreadsheet"})
+				queuedRows = append(queuedRows, []int{i})
+			}
+			var res []store.ImportResult
+			if dryRun {
+				res = gs.Preview(queued)
+			} else if res, err = gs.Import(queued); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for j, rr := range res {
+				if rr.Error == "" {
+					glyphsCreated++
+				}
+				for _, i := range queuedRows[j] {
+					rows[i].System.GlyphID = rr.ID
+					results[i].GlyphID = rr.ID
+				}
+			}
+		}
+
+		var systems []System
+		var idx []int
+		for i, row := range rows {
+			if row.Err == nil {
+				systems = append(systems, row.System)
+				idx = append(idx, i)
+			}
+		}
+		var res []store.ImportResult
+		if dryRun {
+			res = ss.Preview(systems)
+		} else if res, err = ss.Import(systems); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		systemsCreated := 0
+		for j, rr := range res {
+			i := idx[j]
+			results[i].SystemID = rr.ID
+			results[i].Error = rr.Error
+			if rr.Error == "" {
+				systemsCreated++
+			}
+		}
+
+		writeJSON(w, map[string]any{
+			"dry_run":    dryRun,
+			"header_row": headerRow,
+			"columns":    columns,
+			"rows":       len(rows),
+			"systems":    systemsCreated,
+			"glyphs":     glyphsCreated,
+			"results":    results,
+		})
+	}
+}
+
+// glyphsAt returns saved glyphs whose symbols decode to addr.
+func glyphsAt(gs *glyphs.Store, addr string) []glyphs.Glyph {
+	return gs.Filter(func(g *glyphs.Glyph) bool {
+		a, err := glyphs.ParsePortal(g.Symbols)
+		return err == nil && a.String() == addr
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ---------- Compression ----------
+
+// compressible are the content types worth compressing; images and the
+// like are compressed already.
+var compressible = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"text/html":              true,
+	"text/plain":             true,
+	"text/css":               true,
+	"text/csv":               true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// acceptedEncoding picks gzip or deflate from Accept-Encoding, "" for
+// neither.
+func acceptedEncoding(r *http.Request) string {
+	var deflate bool
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter compresses the body when its status and Content-Type,
+// known once the header is written, call for it.
+type compressWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	encoding string
+	wrote    bool
+	zw       interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wrote {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wrote = true
+	h := cw.Header()
+	h.Add("Vary", "Accept-Encoding")
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if compressible[ct] && h.Get("Content-Encoding") == "" && cw.r.Method != http.MethodHead &&
+		code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified && code != http.StatusPartialContent {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			// The bytes differ, the content does not: a weak tag, which
+			// If-None-Match still matches.
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			g := gzipWriters.Get().(*gzip.Writer)
+			g.Reset(cw.ResponseWriter)
+			cw.zw = g
+		} else {
+			f := flateWriters.Get().(*flate.Writer)
+			f.Reset(cw.ResponseWriter)
+			cw.zw = f
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wrote {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what is compressed so far, for the event streams.
+func (cw *compressWriter) Flush() {
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressWriter) close() {
+	switch zw := cw.zw.(type) {
+	case *gzip.Writer:
+		zw.Close()
+		gzipWriters.Put(zw)
+	case *flate.Writer:
+		zw.Close()
+		flateWriters.Put(zw)
+	}
+}
+
+// withCompression gzips (or deflates) JSON, HTML and other text responses
+// for clients that accept it.
+func withCompression(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := acceptedEncoding(r)
+		if enc == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, r: r, encoding: enc}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------- Runtime config ----------
+
+// runtimeConfig is what serve reads from -config. Unlike flags, it is read
+// again on SIGHUP or POST /api/admin/config/reload, and the new values apply
+// to the next request without dropping open connections.
+type runtimeConfig struct {
+	// CORSOrigins are the origins allowed to call the API from a browser;
+	// "*" allows any.
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"`
+	// Users are the accounts, by name. Never echoed back by the reload
+	// endpoint; see userEntry.
+	Users map[string]userEntry `yaml:"users" json:"-"`
+	// RequireLogin turns away writes from anyone not signed in: they are
+	// viewers rather than editors (see role).
+	RequireLogin bool `yaml:"require_login" json:"require_login"`
+	// OIDC maps who signs in with -oidc-issuer to a user and role.
+	OIDC oidcMapping `yaml:"oidc" json:"oidc"`
+	// GlyphDuplicates is what saving a glyph does when one with the same
+	// portal address in the same galaxy is saved already: "reject" it,
+	// "warn" and save it anyway, or "merge" it into the saved one.
+	GlyphDuplicates string `yaml:"glyph_duplicates" json:"glyph_duplicates"`
+	// Flags set command-line flags by name, for when passing them is
+	// awkward (containers). Read once at start: see configFlags.
+	Flags map[string]flagValue `yaml:"flags" json:"-"`
+}
+
+// flagValue is the value of a flag in the flags section: a scalar as it
+// would be written on the command line, or a list, joined with commas.
+type flagValue string
+
+func (v *flagValue) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		*v = flagValue(n.Value)
+		return nil
+	case yaml.SequenceNode:
+		var items []string
+		if err := n.Decode(&items); err != nil {
+			return err
+		}
+		*v = flagValue(strings.Join(items, ","))
+		return nil
+	}
+	return fmt.Errorf("line %d: a flag value is a scalar or a list", n.Line)
+}
+
+func defaultRuntimeConfig() *runtimeConfig {
+	return &runtimeConfig{CORSOrigins: []string{"*"}, GlyphDuplicates: dupWarn}
+}
+
+// loadRuntimeConfig reads a YAML config file. Keys left out keep their
+// defaults; unknown keys are an error so a typo does not go unnoticed.
+func loadRuntimeConfig(path string) (*runtimeConfig, error) {
+	if path == "" {
+		return defaultRuntimeConfig(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRuntimeConfig(path, b)
+}
+
+// parseRuntimeConfig reads the contents of the config file at path.
+func parseRuntimeConfig(path string, b []byte) (*runtimeConfig, error) {
+	cfg := defaultRuntimeConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	switch cfg.GlyphDuplicates {
+	case dupReject, dupWarn, dupMerge:
+	default:
+		return nil, fmt.Errorf("%s: glyph_duplicates must be %s, %s or %s", path, dupReject, dupWarn, dupMerge)
+	}
+	if err := cfg.OIDC.check(); err != nil {
+		return nil, fmt.Errorf("%s: oidc: %w", path, err)
+	}
+	seen := map[string]string{}
+	for name, u := range cfg.Users {
+		if strings.HasPrefix(name, oidcUserPrefix) {
+			return nil, fmt.Errorf("%s: user %q: names starting with %q are for OIDC sign-ins", path, name, oidcUserPrefix)
+		}
+		if u.Token == "" && u.Password == "" {
+			return nil, fmt.Errorf("%s: user %q has neither a token nor a password", path, name)
+		}
+		if _, err := parseRole(u.Role); err != nil {
+			return nil, fmt.Errorf("%s: user %q: %w", path, name, err)
+		}
+		if u.Password != "" {
+			if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+				return nil, fmt.Errorf("%s: password of user %q is not a bcrypt hash (make one with nms passwd)", path, name)
+			}
+		}
+		if u.Token == "" {
+			continue
+		}
+		if len(u.Token) < minTokenLen {
+			return nil, fmt.Errorf("%s: token of user %q is shorter than %d characters", path, name, minTokenLen)
+		}
+		if other, ok := seen[u.Token]; ok {
+			return nil, fmt.Errorf("%s: users %q and %q share a token", path, other, name)
+		}
+		seen[u.Token] = name
+	}
+	return cfg, nil
+}
+
+// userEntry is an account. It is written either as just the API token,
+//
+//	alice: 0123456789abcdef0123
+//
+// or with the bcrypt hash of a password to sign in to the pages with
+// (made by nms passwd) and the user's role, viewer, editor (the default)
+// or admin; "admin: true" is short for "role: admin":
+//
+//	bob:
+//	  password: $2a$10$...
+//	  token: fedcba9876543210fedc
+//	  role: admin
+//
+// The token is sent as "Authorization: Bearer TOKEN". Only signed-in users
+// have custom recipes.
+type userEntry struct {
+	Token    string `yaml:"token,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Role     string `yaml:"role,omitempty"`
+	Admin    bool   `yaml:"admin,omitempty"`
+}
+
+func (u *userEntry) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&u.Token)
+	}
+	type plain userEntry
+	return n.Decode((*plain)(u))
+}
+
+// MarshalYAML keeps a user with only a token in the short form.
+func (u userEntry) MarshalYAML() (any, error) {
+	if u.Password == "" && u.Role == "" && !u.Admin {
+		return u.Token, nil
+	}
+	type plain userEntry
+	return plain(u), nil
+}
+
+// liveConfig is the current runtimeConfig, swapped atomically on reload.
+type liveConfig struct {
+	path     string
+	cur      atomic.Pointer[runtimeConfig]
+	sessions sessions    // of the users in cur
+	editMu   sync.Mutex  // held by editUsers
+	oidc     *oidcClient // set by serve with -oidc-issuer
+}
+
+func newLiveConfig(path string) (*liveConfig, error) {
+	lc := &liveConfig{path: path, sessions: sessions{byToken: map[string]session{}}}
+	cfg, err := loadRuntimeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	lc.cur.Store(cfg)
+	return lc, nil
+}
+
+func (lc *liveConfig) Load() *runtimeConfig { return lc.cur.Load() }
+
+// reload re-reads the file; on error the current config stays in place.
+func (lc *liveConfig) reload() (*runtimeConfig, error) {
+	cfg, err := loadRuntimeConfig(lc.path)
+	if err != nil {
+		return nil, err
+	}
+	lc.cur.Store(cfg)
+	return cfg, nil
+}
+
+// watchSIGHUP reloads the config whenever the process gets SIGHUP.
+func (lc *liveConfig) watchSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if _, err := lc.reload(); err != nil {
+				log.Printf("config reload: %v (keeping the current config)", err)
+				continue
+			}
+			log.Printf("config reloaded from %s", lc.path)
+		}
+	}()
+}
+
+// reloadHandler serves POST /api/admin/config/reload.
+func (lc *liveConfig) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if lc.path == "" {
+		http.Error(w, "no -config file to reload", http.StatusConflict)
+		return
+	}
+	cfg, err := lc.reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("config reloaded from %s", lc.path)
+	writeJSON(w, cfg)
+}
+
+// minTokenLen keeps guessable tokens out of the config.
+const minTokenLen = 16
+
+// bearerUser returns the name of the configured user whose token the
+// request carries.
+func (c *runtimeConfig) bearerUser(r *http.Request) (string, bool) {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" {
+		return "", false
+	}
+	for name, u := range c.Users {
+		if u.Token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(tok)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when it is not allowed.
+func (c *runtimeConfig) allowOrigin(origin string) string {
+	if slices.Contains(c.CORSOrigins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(c.CORSOrigins, origin) {
+		return origin
+	}
+	return ""
+}
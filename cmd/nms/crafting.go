@@ -0,0 +1,658 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// ---------- Crafting graph ----------
+
+// craftGraph is the food and refiner datasets as one graph of items, each
+// made by the recipes of either. A request takes it once, so a reload in
+// the middle does not mix datasets.
+type craftGraph struct {
+	*recipeDBs
+}
+
+func (rec *liveRecipes) graph() craftGraph {
+	return craftGraph{rec.cur.Load()}
+}
+
+// difficulty rates the items by the recipes of both datasets, worked out
+// on first use.
+func (g craftGraph) difficulty() *recipes.Difficulties {
+	c := &g.combined
+	c.once.Do(func() { c.d = recipes.NewDifficulties(append(g.food.All(), g.refiner.All()...)) })
+	return c.d
+}
+
+// each calls fn with each dataset, food first.
+func (g craftGraph) each(fn func(source string, db *recipeSet)) {
+	fn("food", g.food)
+	fn("refiner", g.refiner)
+}
+
+// makers returns the recipes of both datasets making item, food first.
+func (g craftGraph) makers(item string) []recipeEntry {
+	var out []recipeEntry
+	g.each(func(source string, db *recipeSet) {
+		for _, rc := range db.ByOutput(item) {
+			out = append(out, recipeEntry{ID: rc.ID(), Source: source, Recipe: rc})
+		}
+	})
+	return out
+}
+
+// items is the info on the named items, from the food dataset for
+// items it knows and else the refiner one.
+func (g craftGraph) items(names []string, icons itemIcons) map[string]recipes.ItemInfo {
+	out := g.refiner.items(names, icons)
+	for name, info := range g.food.items(names, icons) {
+		if g.food.Has(name) {
+			out[name] = info
+		}
+	}
+	return out
+}
+
+// byID finds a recipe by its ID in either dataset, food first.
+func (g craftGraph) byID(id string) (recipeEntry, bool) {
+	var e recipeEntry
+	var found bool
+	g.each(func(source string, db *recipeSet) {
+		if rc, ok := db.ByID(id); ok && !found {
+			e, found = recipeEntry{ID: id, Source: source, Recipe: rc}, true
+		}
+	})
+	return e, found
+}
+
+// ---------- Crafting trees ----------
+
+// treeNode is an input of a recipe in a crafting tree, with the recipes
+// that make it expanded in turn.
+type treeNode struct {
+	Item    string       `json:"item"`
+	Raw     bool         `json:"raw,omitempty"`   // no recipe makes it
+	Cycle   bool         `json:"cycle,omitempty"` // made further up this branch; not expanded again
+	More    bool         `json:"more,omitempty"`  // recipes make it, past the depth asked for
+	Recipes []treeRecipe `json:"recipes,omitempty"`
+}
+
+// treeRecipe is a recipe in a crafting tree, with a node per input.
+type treeRecipe struct {
+	recipeEntry
+	From []treeNode `json:"from"`
+}
+
+const (
+	defaultTreeDepth = 3
+	maxTreeDepth     = 8
+	// maxTreeNodes caps a tree: items made many ways multiply fast, and
+	// nodes past the cap are marked More.
+	maxTreeNodes = 5000
+)
+
+// tree expands e's inputs into the recipes making them, depth recipes
+// deep counting e. An item already being made further up a branch is a
+// cycle and is not expanded again there.
+func (g craftGraph) tree(e recipeEntry, depth int) treeRecipe {
+	nodes := 0
+	path := map[string]bool{}
+	var expand func(e recipeEntry, depth int) treeRecipe
+	expand = func(e recipeEntry, depth int) treeRecipe {
+		path[e.Output] = true
+		defer delete(path, e.Output)
+		t := treeRecipe{recipeEntry: e, From: make([]treeNode, 0, len(e.Inputs))}
+		for _, in := range e.Inputs {
+			n := treeNode{Item: in}
+			nodes++
+			makers := g.makers(in)
+			switch {
+			case len(makers) == 0:
+				n.Raw = true
+			case path[in]:
+				n.Cycle = true
+			case depth <= 1 || nodes >= maxTreeNodes:
+				n.More = true
+			default:
+				for _, m := range makers {
+					n.Recipes = append(n.Recipes, expand(m, depth-1))
+				}
+			}
+			t.From = append(t.From, n)
+		}
+		return t
+	}
+	return expand(e, depth)
+}
+
+// recipeTreeHandler serves GET /api/recipes/{id}/tree?depth=N: the recipe
+// with every input expanded into the food and refiner recipes making it,
+// down to raw materials or N recipes deep (default 3, at most 8).
+func recipeTreeHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depth := defaultTreeDepth
+		if v := r.URL.Query().Get("depth"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxTreeDepth {
+				http.Error(w, "depth must be 1-"+strconv.Itoa(maxTreeDepth), http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+		g := rec.graph()
+		e, ok := g.byID(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "recipe not found", http.StatusNotFound)
+			return
+		}
+		writeJSONCached(w, r, g.tree(e, depth))
+	}
+}
+
+// ---------- Crafting plans ----------
+
+// craftPlan is what it takes to make a quantity of an item, given what is
+// at hand already.
+type craftPlan struct {
+	Target   string         `json:"target"`
+	Quantity int            `json:"quantity"`
+	Steps    []craftStep    `json:"steps"`              // in an order they can be made in
+	Raw      map[string]int `json:"raw"`                // still to gather
+	Used     map[string]int `json:"used"`               // taken from what is owned
+	Leftover map[string]int `json:"leftover,omitempty"` // made beyond what is needed
+	Crafts   int            `json:"crafts"`             // steps' crafts, summed
+	RawTotal int            `json:"raw_total"`          // raw items to gather, summed
+	// Unrecognized are owned names matching no item.
+	Unrecognized []string `json:"unrecognized"`
+}
+
+// craftStep is one recipe of a plan, made Crafts times for Makes items.
+type craftStep struct {
+	recipeEntry
+	Crafts int `json:"crafts"`
+	Makes  int `json:"makes"`
+}
+
+// maxPlanQuantity caps the quantity of a plan.
+const maxPlanQuantity = 1_000_000
+
+// choose picks the recipe an item is made with in a plan: the one on its
+// shallowest route across both datasets, then the one taking fewest raw
+// materials, then the one making most. Items made only through cycles are
+// treated as raw.
+func (g craftGraph) choose(item string) (recipeEntry, bool) {
+	d := g.difficulty()
+	var best recipeEntry
+	var bestD recipes.Difficulty
+	found := false
+	for _, m := range g.makers(item) {
+		md, ok := d.Recipe(m.Recipe)
+		if !ok {
+			continue
+		}
+		if !found || md.Depth < bestD.Depth || md.Depth == bestD.Depth &&
+			(md.Raw < bestD.Raw || md.Raw == bestD.Raw && m.Qty > best.Qty) {
+			best, bestD, found = m, md, true
+		}
+	}
+	return best, found
+}
+
+// plan works out what making qty of target takes: owned items are used
+// first, surplus from a craft is kept for later needs, and the rest is
+// made by the recipe choose picks, down to raw materials. Each craft
+// takes its inputs' quantities (see recipes.Recipe.InQty).
+func (g craftGraph) plan(target string, qty int, owned map[string]int) craftPlan {
+	p := craftPlan{Target: target, Quantity: qty, Steps: []craftStep{},
+		Raw: map[string]int{}, Used: map[string]int{}, Leftover: map[string]int{}, Unrecognized: []string{}}
+	stock := map[string]int{}
+	for k, v := range owned {
+		stock[k] = v
+	}
+	spare := map[string]int{} // made by earlier crafts beyond their need
+	step := map[string]int{}  // output -> index into p.Steps
+	var need func(item string, n int)
+	need = func(item string, n int) {
+		if have := min(stock[item], n); have > 0 {
+			stock[item] -= have
+			p.Used[item] += have
+			n -= have
+		}
+		if have := min(spare[item], n); have > 0 {
+			spare[item] -= have
+			n -= have
+		}
+		if n == 0 {
+			return
+		}
+		e, ok := g.choose(item)
+		if !ok {
+			p.Raw[item] += n
+			return
+		}
+		crafts := (n + e.Qty - 1) / e.Qty
+		spare[item] += crafts*e.Qty - n
+		for j, in := range e.Inputs {
+			need(in, crafts*e.InQty(j))
+		}
+		i, ok := step[item]
+		if !ok {
+			i = len(p.Steps)
+			step[item] = i
+			p.Steps = append(p.Steps, craftStep{recipeEntry: e})
+		}
+		p.Steps[i].Crafts += crafts
+		p.Steps[i].Makes += crafts * e.Qty
+	}
+	need(target, qty)
+	p.Steps = stepOrder(p.Steps)
+	for item, left := range spare {
+		if left > 0 {
+			p.Leftover[item] = left
+		}
+	}
+	for _, s := range p.Steps {
+		p.Crafts += s.Crafts
+	}
+	for _, n := range p.Raw {
+		p.RawTotal += n
+	}
+	return p
+}
+
+// stepOrder puts each step after the steps making its inputs. A step
+// crafted again for a later need keeps the place of its first crafts,
+// which may be ahead of inputs only the later crafts take.
+func stepOrder(steps []craftStep) []craftStep {
+	byOutput := map[string]int{}
+	for i, s := range steps {
+		byOutput[s.Output] = i
+	}
+	out := make([]craftStep, 0, len(steps))
+	placed := make([]bool, len(steps))
+	var place func(i int)
+	place = func(i int) {
+		if placed[i] {
+			return
+		}
+		placed[i] = true // before its inputs, so a cycle ends here
+		for _, in := range steps[i].Inputs {
+			if j, ok := byOutput[in]; ok {
+				place(j)
+			}
+		}
+		out = append(out, steps[i])
+	}
+	for i := range steps {
+		place(i)
+	}
+	return out
+}
+
+// craftPlanHandler serves POST /api/plan with
+// {"target": "Herb-Encrusted Flesh", "quantity": 2, "owned": {"Carbon": 50}}:
+// the steps making the target and the raw materials still needed (see
+// craftGraph.plan). Names are matched as for /api/suggest.
+func craftPlanHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target   string         `json:"target"`
+			Quantity int            `json:"quantity"`
+			Owned    map[string]int `json:"owned"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if body.Quantity == 0 {
+			body.Quantity = 1
+		}
+		if body.Quantity < 1 || body.Quantity > maxPlanQuantity {
+			http.Error(w, "quantity must be 1-"+strconv.Itoa(maxPlanQuantity), http.StatusBadRequest)
+			return
+		}
+		g := rec.graph()
+		var outputs, items []string
+		g.each(func(_ string, db *recipeSet) {
+			outputs = append(outputs, db.Outputs()...)
+			items = append(items, db.Ingredients()...)
+		})
+		items = append(items, outputs...)
+		target, ok := recipes.MapName(strings.TrimSpace(body.Target), outputs)
+		if !ok {
+			http.Error(w, "no recipe makes "+strconv.Quote(body.Target), http.StatusNotFound)
+			return
+		}
+		owned := map[string]int{}
+		var unknown []string
+		for name, n := range body.Owned {
+			if n < 0 {
+				http.Error(w, "owned quantities must not be negative", http.StatusBadRequest)
+				return
+			}
+			if item, ok := recipes.MapName(name, items); ok {
+				owned[item] += n
+			} else {
+				unknown = append(unknown, name)
+			}
+		}
+		p := g.plan(target, body.Quantity, owned)
+		if unknown != nil {
+			slices.Sort(unknown)
+			p.Unrecognized = unknown
+		}
+		writeJSON(w, p)
+	}
+}
+
+// ---------- Refiner chains ----------
+
+// refinerChain is a way to refine one item into another in steps, each
+// taking the item the one before made.
+type refinerChain struct {
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Quantity int         `json:"quantity"` // of To
+	Needs    int         `json:"needs"`    // of From
+	Steps    []chainStep `json:"steps"`
+}
+
+// chainStep is one refining of a chain: Crafts runs of the recipe take
+// Takes of the item before and Also of its other inputs, and make Makes.
+type chainStep struct {
+	recipeEntry
+	Crafts int            `json:"crafts"`
+	Takes  int            `json:"takes"`
+	Makes  int            `json:"makes"`
+	Also   map[string]int `json:"also,omitempty"`
+}
+
+// chainLink is a recipe taking the item before it on a shortest path.
+type chainLink struct {
+	prev string
+	rc   recipeEntry
+}
+
+// refinerChain finds the chains from one item to another with the fewest
+// steps (breadth first over the refiner recipes), and of those the one
+// needing least of from for qty of to, then the fewest other inputs. From
+// an item to itself the chain has no steps.
+func (g craftGraph) refinerChain(from, to string, qty int) (refinerChain, bool) {
+	if from == to {
+		return refinerChain{From: from, To: to, Quantity: qty, Needs: qty, Steps: []chainStep{}}, true
+	}
+	dist := map[string]int{from: 0}
+	links := map[string][]chainLink{} // item -> recipes reaching it on a shortest path
+	for frontier := []string{from}; len(frontier) > 0 && links[to] == nil; {
+		var next []string
+		for _, item := range frontier {
+			for _, rc := range g.refiner.Suggest([]string{item}) {
+				d, seen := dist[rc.Output]
+				if seen && d != dist[item]+1 {
+					continue
+				}
+				if !seen {
+					dist[rc.Output] = dist[item] + 1
+					next = append(next, rc.Output)
+				}
+				links[rc.Output] = append(links[rc.Output], chainLink{prev: item, rc: recipeEntry{ID: rc.ID(), Source: "refiner", Recipe: rc}})
+			}
+		}
+		frontier = next
+	}
+	if links[to] == nil {
+		return refinerChain{}, false
+	}
+	// best makes n of item from `from` along the shortest paths.
+	type result struct {
+		steps       []chainStep
+		needs, also int
+	}
+	var best func(item string, n int) result
+	best = func(item string, n int) result {
+		if item == from {
+			return result{needs: n}
+		}
+		var out result
+		found := false
+		for _, l := range links[item] {
+			crafts := (n + l.rc.Qty - 1) / l.rc.Qty
+			s := chainStep{recipeEntry: l.rc, Crafts: crafts, Makes: crafts * l.rc.Qty}
+			also := 0
+			for j, in := range l.rc.Inputs {
+				n := crafts * l.rc.InQty(j)
+				if in == l.prev {
+					s.Takes += n
+					continue
+				}
+				if s.Also == nil {
+					s.Also = map[string]int{}
+				}
+				s.Also[in] += n
+				also += n
+			}
+			r := best(l.prev, s.Takes)
+			r.steps = append(slices.Clip(r.steps), s)
+			r.also += also
+			if !found || r.needs < out.needs || r.needs == out.needs && r.also < out.also {
+				out, found = r, true
+			}
+		}
+		return out
+	}
+	r := best(to, qty)
+	return refinerChain{From: from, To: to, Quantity: qty, Needs: r.needs, Steps: r.steps}, true
+}
+
+// refinerChainHandler serves GET /api/refiner/chain?from=Carbon&to=...
+// (&quantity=, default 1): the refiner steps turning one item into another
+// (see craftGraph.refinerChain). Names are matched as for /api/suggest.
+func refinerChainHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		qty := 1
+		if v := q.Get("quantity"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxPlanQuantity {
+				http.Error(w, "quantity must be 1-"+strconv.Itoa(maxPlanQuantity), http.StatusBadRequest)
+				return
+			}
+			qty = n
+		}
+		g := rec.graph()
+		from, ok := recipes.MapName(strings.TrimSpace(q.Get("from")), g.refiner.Ingredients())
+		if !ok {
+			http.Error(w, "no refiner recipe takes "+strconv.Quote(q.Get("from")), http.StatusNotFound)
+			return
+		}
+		to, ok := recipes.MapName(strings.TrimSpace(q.Get("to")), append(slices.Clip(g.refiner.Outputs()), from))
+		if !ok {
+			http.Error(w, "no refiner recipe makes "+strconv.Quote(q.Get("to")), http.StatusNotFound)
+			return
+		}
+		c, ok := g.refinerChain(from, to, qty)
+		if !ok {
+			http.Error(w, "no refiner chain from "+from+" to "+to, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, c)
+	}
+}
+
+// ---------- Reachable items ----------
+
+// reachLevel is what becomes makeable at one depth of chaining: depth 1 is
+// made straight from the ingredients given, depth 2 from those and depth 1,
+// and so on.
+type reachLevel struct {
+	Depth int         `json:"depth"`
+	Items []reachItem `json:"items"`
+}
+
+// reachItem is an item first makeable at a level, with the recipes making
+// it from items of the levels before.
+type reachItem struct {
+	Item string        `json:"item"`
+	Via  []recipeEntry `json:"via"`
+}
+
+type reachResp struct {
+	Mapped       []string     `json:"mapped"`
+	Unrecognized []string     `json:"unrecognized"` // by neither dataset
+	Levels       []reachLevel `json:"levels"`
+	Total        int          `json:"total"` // items across the levels
+	// DidYouMean offers names for the unrecognized input.
+	DidYouMean map[string][]recipes.Candidate `json:"did_you_mean,omitempty"`
+}
+
+// maxReachDepth caps the levels of reachable.
+const maxReachDepth = 20
+
+// mapIngredients maps user input in both datasets, as suggest does in
+// one; unknown is what neither knows.
+func (g craftGraph) mapIngredients(parts []string) (mapped, unknown []string) {
+	misses := map[string]int{} // datasets not knowing an input
+	datasets := 0
+	mapped, unknown = []string{}, []string{}
+	g.each(func(_ string, db *recipeSet) {
+		datasets++
+		m, u := db.MapIngredients(parts)
+		for _, n := range m {
+			if !slices.Contains(mapped, n) {
+				mapped = append(mapped, n)
+			}
+		}
+		seen := map[string]bool{} // an input may be sent twice
+		for _, p := range u {
+			if !seen[p] {
+				seen[p] = true
+				misses[p]++
+			}
+		}
+	})
+	for _, p := range parts {
+		if misses[p] == datasets && !slices.Contains(unknown, p) {
+			unknown = append(unknown, p)
+		}
+	}
+	return mapped, unknown
+}
+
+// didYouMean offers the names of either dataset nearest each
+// unrecognized input, as recipeSet.didYouMean does for one.
+func (g craftGraph) didYouMean(unknown []string) map[string][]recipes.Candidate {
+	var out map[string][]recipes.Candidate
+	for _, u := range unknown {
+		var cs []recipes.Candidate
+		g.each(func(_ string, db *recipeSet) {
+			for _, c := range recipes.Closest(db.match, u, db.Ingredients(), didYouMeanLimit) {
+				if !slices.ContainsFunc(cs, func(o recipes.Candidate) bool { return o.Name == c.Name }) {
+					cs = append(cs, c)
+				}
+			}
+		})
+		if len(cs) == 0 {
+			continue
+		}
+		slices.SortStableFunc(cs, func(a, b recipes.Candidate) int { return cmp.Compare(b.Score, a.Score) })
+		if out == nil {
+			out = map[string][]recipes.Candidate{}
+		}
+		out[u] = cs[:min(didYouMeanLimit, len(cs))]
+	}
+	return out
+}
+
+// reachable chains the recipes of both datasets from have: each level
+// holds the items the recipes make from have and the levels before it
+// that no earlier level had, until nothing new is made or depth levels.
+func (g craftGraph) reachable(have []string, depth int) []reachLevel {
+	var all []recipeEntry
+	g.each(func(source string, db *recipeSet) {
+		for _, rc := range db.All() {
+			all = append(all, recipeEntry{ID: rc.ID(), Source: source, Recipe: rc})
+		}
+	})
+	got := map[string]bool{}
+	for _, h := range have {
+		got[h] = true
+	}
+	levels := []reachLevel{}
+	for d := 1; d <= depth; d++ {
+		at := map[string]int{} // item -> index into items
+		var items []reachItem
+		for _, e := range all {
+			if got[e.Output] || !allOf(e.Inputs, got) {
+				continue
+			}
+			i, ok := at[e.Output]
+			if !ok {
+				items = append(items, reachItem{Item: e.Output})
+				i = len(items)
+				at[e.Output] = i
+			}
+			items[i-1].Via = append(items[i-1].Via, e)
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, it := range items {
+			got[it.Item] = true
+		}
+		slices.SortFunc(items, func(a, b reachItem) int { return strings.Compare(a.Item, b.Item) })
+		levels = append(levels, reachLevel{Depth: d, Items: items})
+	}
+	return levels
+}
+
+// allOf reports whether every name is in set.
+func allOf(names []string, set map[string]bool) bool {
+	for _, n := range names {
+		if !set[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// reachableHandler serves GET /api/reachable?have=carbon,oxygen: every
+// item the food and refiner recipes make from the ingredients given,
+// chaining outputs back in as inputs, by level (see craftGraph.reachable).
+// ?max_depth= caps the levels (default and most 20).
+func reachableHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		depth := maxReachDepth
+		if v := q.Get("max_depth"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxReachDepth {
+				http.Error(w, "max_depth must be 1-"+strconv.Itoa(maxReachDepth), http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+		have := strings.TrimSpace(q.Get("have"))
+		if have == "" {
+			http.Error(w, "missing 'have' query param", http.StatusBadRequest)
+			return
+		}
+		g := rec.graph()
+		resp := reachResp{}
+		resp.Mapped, resp.Unrecognized = g.mapIngredients(splitCSVLike(have))
+		resp.DidYouMean = g.didYouMean(resp.Unrecognized)
+		resp.Levels = g.reachable(resp.Mapped, depth)
+		for _, l := range resp.Levels {
+			resp.Total += len(l.Items)
+		}
+		writeList(w, r, resp)
+	}
+}
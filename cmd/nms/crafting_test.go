@@ -0,0 +1,217 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// testGraph builds a craftGraph from food and refiner CSV rows of
+// input1,input1_qty,input2,input2_qty,output,output_qty.
+func testGraph(t *testing.T, food, refiner string) craftGraph {
+	t.Helper()
+	const header = "input1_name,input1_qty,input2_name,input2_qty,input3_name,output_name,output_qty\n"
+	load := func(rows string) recipes.Store {
+		db, err := recipes.ReadCSV(strings.NewReader(header + rows))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	return craftGraph{newRecipeDBs(load(food), load(refiner), recipeOpts{match: norm.DefaultMatcher}, "food", "refiner")}
+}
+
+func TestPlan(t *testing.T) {
+	g := testGraph(t,
+		"Flour,3,,,,Bread,2\n"+
+			"Bread,1,Egg,2,,Pie,1\n"+
+			"Bread,1,,,,Toast,1\n"+
+			"Bread,1,Toast,1,,Sandwich,1\n"+
+			"Y,1,,,,X,1\n"+
+			"X,1,,,,Z,1\n"+
+			"X,1,Z,1,,T,1\n"+
+			"R,1,,,,Y,1\n",
+		"Wheat,1,,,,Flour,1\n")
+	tests := []struct {
+		name     string
+		target   string
+		qty      int
+		owned    map[string]int
+		crafts   map[string]int // by step output
+		raw      map[string]int
+		used     map[string]int
+		leftover map[string]int
+	}{
+		{
+			name: "rounds up crafts", target: "Bread", qty: 3,
+			crafts:   map[string]int{"Bread": 2, "Flour": 6},
+			raw:      map[string]int{"Wheat": 6},
+			used:     map[string]int{},
+			leftover: map[string]int{"Bread": 1},
+		},
+		{
+			name: "partly owned then crafted", target: "Bread", qty: 8,
+			owned:    map[string]int{"Bread": 5},
+			crafts:   map[string]int{"Bread": 2, "Flour": 6},
+			raw:      map[string]int{"Wheat": 6},
+			used:     map[string]int{"Bread": 5},
+			leftover: map[string]int{"Bread": 1},
+		},
+		{
+			name: "owned inputs", target: "Pie", qty: 3,
+			owned:    map[string]int{"Flour": 4, "Egg": 10},
+			crafts:   map[string]int{"Pie": 3, "Bread": 2, "Flour": 2},
+			raw:      map[string]int{"Wheat": 2},
+			used:     map[string]int{"Flour": 4, "Egg": 6},
+			leftover: map[string]int{"Bread": 1},
+		},
+		{
+			name: "surplus used by a later need", target: "Sandwich", qty: 1,
+			crafts:   map[string]int{"Sandwich": 1, "Toast": 1, "Bread": 1, "Flour": 3},
+			raw:      map[string]int{"Wheat": 3},
+			used:     map[string]int{},
+			leftover: map[string]int{},
+		},
+		{
+			name: "crafted again from an input made later", target: "T", qty: 1,
+			owned:    map[string]int{"Y": 1},
+			crafts:   map[string]int{"T": 1, "Z": 1, "X": 2, "Y": 1},
+			raw:      map[string]int{"R": 1},
+			used:     map[string]int{"Y": 1},
+			leftover: map[string]int{},
+		},
+		{
+			name: "all owned", target: "Pie", qty: 3,
+			owned:    map[string]int{"Pie": 7},
+			crafts:   map[string]int{},
+			raw:      map[string]int{},
+			used:     map[string]int{"Pie": 3},
+			leftover: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := g.plan(tt.target, tt.qty, tt.owned)
+			crafts := map[string]int{}
+			for _, s := range p.Steps {
+				crafts[s.Output] = s.Crafts
+				if s.Makes != s.Crafts*s.Qty {
+					t.Errorf("step %s makes %d in %d crafts of %d", s.Output, s.Makes, s.Crafts, s.Qty)
+				}
+			}
+			if !maps.Equal(crafts, tt.crafts) {
+				t.Errorf("crafts = %v, want %v", crafts, tt.crafts)
+			}
+			if !maps.Equal(p.Raw, tt.raw) {
+				t.Errorf("raw = %v, want %v", p.Raw, tt.raw)
+			}
+			if !maps.Equal(p.Used, tt.used) {
+				t.Errorf("used = %v, want %v", p.Used, tt.used)
+			}
+			if !maps.Equal(p.Leftover, tt.leftover) {
+				t.Errorf("leftover = %v, want %v", p.Leftover, tt.leftover)
+			}
+			made := map[string]bool{}
+			for _, s := range p.Steps {
+				for _, in := range s.Inputs {
+					if _, crafted := crafts[in]; crafted && !made[in] {
+						t.Errorf("step %s comes before the step making its input %s", s.Output, in)
+					}
+				}
+				made[s.Output] = true
+			}
+			if len(p.Steps) > 0 && p.Steps[len(p.Steps)-1].Output != tt.target {
+				t.Errorf("last step makes %s, want %s", p.Steps[len(p.Steps)-1].Output, tt.target)
+			}
+		})
+	}
+}
+
+func TestRefinerChain(t *testing.T) {
+	g := testGraph(t, "",
+		"Dust,1,,,,Pure,1\n"+
+			"Pure,2,,,,Magnet,1\n"+
+			"Dust,3,,,,Iron,1\n"+
+			"Dust,1,Salt,1,,Iron,1\n")
+	type step struct {
+		output             string
+		crafts, takes, out int
+		also               map[string]int
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		qty      int
+		ok       bool
+		needs    int
+		steps    []step
+	}{
+		{
+			name: "two steps", from: "Dust", to: "Magnet", qty: 3, ok: true, needs: 6,
+			steps: []step{{"Pure", 6, 6, 6, nil}, {"Magnet", 3, 6, 3, nil}},
+		},
+		{
+			name: "least of from", from: "Dust", to: "Iron", qty: 2, ok: true, needs: 2,
+			steps: []step{{"Iron", 2, 2, 2, map[string]int{"Salt": 2}}},
+		},
+		{name: "same item", from: "Dust", to: "Dust", qty: 4, ok: true, needs: 4, steps: []step{}},
+		{name: "no chain", from: "Magnet", to: "Dust", qty: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := g.refinerChain(tt.from, tt.to, tt.qty)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if c.Needs != tt.needs || c.Quantity != tt.qty {
+				t.Errorf("needs %d for %d, want %d for %d", c.Needs, c.Quantity, tt.needs, tt.qty)
+			}
+			if c.Steps == nil {
+				t.Error("steps = nil, want a list")
+			}
+			if len(c.Steps) != len(tt.steps) {
+				t.Fatalf("%d steps, want %d", len(c.Steps), len(tt.steps))
+			}
+			for i, want := range tt.steps {
+				s := c.Steps[i]
+				got := step{s.Output, s.Crafts, s.Takes, s.Makes, s.Also}
+				if got.output != want.output || got.crafts != want.crafts || got.takes != want.takes ||
+					got.out != want.out || !maps.Equal(got.also, want.also) {
+					t.Errorf("step %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMapIngredients(t *testing.T) {
+	g := testGraph(t,
+		"Carbon,1,Salt,1,,Cake,1\n",
+		"Ferrite Dust,1,,,,Pure Ferrite,1\n")
+	tests := []struct {
+		name            string
+		parts           []string
+		mapped, unknown []string
+	}{
+		{"one in each dataset", []string{"Carbon", "Ferrite Dust"}, []string{"Carbon", "Ferrite Dust"}, []string{}},
+		{"unknown", []string{"Carbon", "qqqq"}, []string{"Carbon"}, []string{"qqqq"}},
+		{"unknown sent twice", []string{"qqqq", "qqqq"}, []string{}, []string{"qqqq"}},
+		{"known sent twice", []string{"Salt", "salt"}, []string{"Salt"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped, unknown := g.mapIngredients(tt.parts)
+			slices.Sort(mapped)
+			if !slices.Equal(mapped, tt.mapped) || !slices.Equal(unknown, tt.unknown) {
+				t.Errorf("mapIngredients = %q, %q; want %q, %q", mapped, unknown, tt.mapped, tt.unknown)
+			}
+		})
+	}
+}
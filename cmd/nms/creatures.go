@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Creatures ----------
+
+// Creature is a tamed companion or an egg waiting to hatch.
+type Creature struct {
+	store.Meta
+	Name        string   `json:"name"`
+	Species     string   `json:"species"`
+	Egg         bool     `json:"egg"`
+	Traits      []string `json:"traits"`
+	HomeGlyphID string   `json:"home_glyph_id,omitempty"` // planet it was tamed on
+	Photo       string   `json:"photo,omitempty"`
+	Notes       string   `json:"notes"`
+}
+
+type CreatureStore = store.Collection[Creature, *Creature]
+
+var creatureSpec = store.Spec[Creature]{
+	Kind: "creature",
+	Validate: func(c *Creature) error {
+		c.Name = strings.TrimSpace(c.Name)
+		c.Species = strings.TrimSpace(c.Species)
+		c.HomeGlyphID = strings.TrimSpace(c.HomeGlyphID)
+		c.Notes = strings.TrimSpace(c.Notes)
+		var traits []string
+		for _, t := range c.Traits {
+			if t = strings.TrimSpace(t); t != "" {
+				traits = append(traits, t)
+			}
+		}
+		c.Traits = traits
+
+		if c.Species == "" {
+			return store.Invalid("species", "species required")
+		}
+		if utf8.RuneCountInString(c.Name) > 64 {
+			return store.Invalid("name", "name too long (max 64 chars)")
+		}
+		if utf8.RuneCountInString(c.Species) > 64 {
+			return store.Invalid("species", "species too long (max 64 chars)")
+		}
+		if len(c.Traits) > 16 {
+			return store.Invalid("traits", "too many traits (max 16)")
+		}
+		if utf8.RuneCountInString(c.Notes) > 1024 {
+			return store.Invalid("notes", "notes too long (max 1024 chars)")
+		}
+		return nil
+	},
+	Text: func(c *Creature) string {
+		return c.Name + " " + c.Species + " " + strings.Join(c.Traits, " ") + " " + c.Notes
+	},
+	FromForm: func(v url.Values) Creature {
+		return Creature{
+			Name:        v.Get("name"),
+			Species:     v.Get("species"),
+			Egg:         v.Get("egg") == "on" || v.Get("egg") == "true",
+			Traits:      splitCSVLike(v.Get("traits")),
+			HomeGlyphID: v.Get("home_glyph_id"),
+			Notes:       v.Get("notes"),
+			Photo:       v.Get("photo_url"),
+		}
+	},
+	SetPhotos: func(c *Creature, urls []string) {
+		c.Photo = urls[0]
+	},
+}
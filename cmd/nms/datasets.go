@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// ---------- Live recipe datasets ----------
+
+// recipeCSVs are the food and refiner tables as loaded from CSV. They are
+// loaded together because the food DB borrows item colours from the refiner
+// DB.
+type recipeCSVs struct {
+	food, refiner       *recipes.DB
+	foodSrc, refinerSrc string
+}
+
+// loadRecipeCSVs loads both recipe CSVs the way serve expects them.
+func (in *instance) loadRecipeCSVs() (*recipeCSVs, error) {
+	var d recipeCSVs
+	var err error
+	if d.food, d.foodSrc, err = in.loadRecipes("csv"); err != nil {
+		return nil, fmt.Errorf("load food csv: %w", err)
+	}
+	if len(d.food.Recipes) == 0 {
+		return nil, fmt.Errorf("no recipes parsed from %s", d.foodSrc)
+	}
+	d.food.FillOutputCategory("cooked")
+	if d.refiner, d.refinerSrc, err = in.loadRecipes("refiner"); err != nil {
+		return nil, fmt.Errorf("load refiner csv: %w", err)
+	}
+	if len(d.refiner.Recipes) == 0 {
+		return nil, fmt.Errorf("no refiner recipes parsed from %s", d.refinerSrc)
+	}
+	d.food.BorrowItems(d.refiner)
+	for _, x := range []struct {
+		db  *recipes.DB
+		src string
+	}{{d.food, d.foodSrc}, {d.refiner, d.refinerSrc}} {
+		if n := len(x.db.Issues); n > 0 {
+			log.Printf("%s: %d rows skipped, %d warnings (see nms validate)", x.src, x.db.Errors(), n-x.db.Errors())
+		}
+	}
+	return &d, nil
+}
+
+// recipeSet is one dataset with the difficulty of its items and which of
+// them are early game, both worked out again whenever it is reloaded, and
+// the recipeOpts of the instance.
+type recipeSet struct {
+	recipes.Store
+	difficulty *recipes.Difficulties
+	early      *recipes.EarlyGame
+	recipeOpts
+}
+
+// recipeOpts are what each recipeSet is given besides its store: the item
+// values of -values, the aliases of -aliases and the name matching of
+// -match.
+type recipeOpts struct {
+	values  recipes.Values
+	aliases *recipes.Aliases
+	match   norm.Matcher
+}
+
+func newRecipeSet(s recipes.Store, o recipeOpts) *recipeSet {
+	all := s.All()
+	return &recipeSet{Store: s, difficulty: recipes.NewDifficulties(all), early: recipes.NewEarlyGame(all), recipeOpts: o}
+}
+
+// MapIngredients maps user input as matchIngredients does.
+func (db *recipeSet) MapIngredients(parts []string) ([]string, []string) {
+	matches, unknown := db.matchIngredients(parts)
+	return recipes.Names(matches), unknown
+}
+
+// matchIngredients maps user input with the instance's matcher, after
+// swapping aliases for the items they stand for. Matches and unknown
+// input keep the alias as typed.
+func (db *recipeSet) matchIngredients(parts []string) ([]recipes.Match, []string) {
+	named := db.aliases.Apply(parts)
+	typed := map[string]string{}
+	for i, p := range parts {
+		if named[i] != p {
+			typed[named[i]] = p
+		}
+	}
+	matches, unknown := db.MatchIngredients(db.match, named)
+	for i, m := range matches {
+		if t, ok := typed[m.Input]; ok {
+			matches[i].Input = t
+		}
+	}
+	for i, u := range unknown {
+		if t, ok := typed[u]; ok {
+			unknown[i] = t
+		}
+	}
+	return matches, unknown
+}
+
+// value is what a recipe is worth, nil when its output has no value.
+func (db *recipeSet) value(r recipes.Recipe) *recipes.RecipeValue {
+	if v, ok := db.values.Recipe(r); ok {
+		return &v
+	}
+	return nil
+}
+
+// recipeDBs are the stores the recipe API answers from: the CSVs in
+// memory, or the SQLite database given with -recipe-db.
+type recipeDBs struct {
+	food, refiner       *recipeSet
+	foodSrc, refinerSrc string
+
+	export struct { // GET /api/export/recipes.json, built on first request
+		once sync.Once
+		body []byte
+		etag string
+		err  error
+	}
+	combined struct { // item difficulty across both datasets, for craftGraph
+		once sync.Once
+		d    *recipes.Difficulties
+	}
+}
+
+func newRecipeDBs(food, refiner recipes.Store, o recipeOpts, foodSrc, refinerSrc string) *recipeDBs {
+	return &recipeDBs{
+		food:    newRecipeSet(food, o),
+		refiner: newRecipeSet(refiner, o),
+		foodSrc: foodSrc, refinerSrc: refinerSrc,
+	}
+}
+
+func (d *recipeDBs) logCounts() {
+	log.Printf("food recipes: %d | ingredients: %d | source: %s", len(d.food.All()), len(d.food.Ingredients()), d.foodSrc)
+	log.Printf("refiner recipes: %d | ingredients: %d | source: %s", len(d.refiner.All()), len(d.refiner.Ingredients()), d.refinerSrc)
+}
+
+// liveRecipes holds the current recipeDBs. A reload swaps the pointer, so a
+// request keeps the DB it started with and none is ever half-built. With
+// SQLite the stores stay the same and a reload replaces their contents in a
+// transaction.
+type liveRecipes struct {
+	in              *instance
+	remote          *remoteDataset // set once mounted; the local CSVs are then ignored
+	sqlFood, sqlRef *recipes.SQLiteStore
+	opts            recipeOpts
+	cur             atomic.Pointer[recipeDBs]
+
+	mu      sync.Mutex
+	changed chan struct{} // closed by the next swap
+}
+
+// newLiveRecipes loads the recipes. A -recipe-db that does not hold them
+// yet is filled from the CSVs (or the built-in datasets) first.
+func newLiveRecipes(in *instance, o recipeOpts) (*liveRecipes, error) {
+	l := &liveRecipes{in: in, opts: o}
+	if in.RecipeDB == "" {
+		return l, l.reload()
+	}
+	var err error
+	if l.sqlFood, err = recipes.OpenSQLite(in.RecipeDB, "food"); err != nil {
+		return nil, fmt.Errorf("open %s: %w", in.RecipeDB, err)
+	}
+	if l.sqlRef, err = recipes.OpenSQLite(in.RecipeDB, "refiner"); err != nil {
+		return nil, fmt.Errorf("open %s: %w", in.RecipeDB, err)
+	}
+	if l.sqlFood.Empty() || l.sqlRef.Empty() {
+		return l, l.reload()
+	}
+	d := newRecipeDBs(l.sqlFood, l.sqlRef, o, in.RecipeDB, in.RecipeDB)
+	l.cur.Store(d)
+	d.logCounts()
+	return l, nil
+}
+
+func (l *liveRecipes) Food() *recipeSet    { return l.cur.Load().food }
+func (l *liveRecipes) Refiner() *recipeSet { return l.cur.Load().refiner }
+
+// reload reads both CSVs again and swaps them in (or writes them to the
+// SQLite database); on error the current recipes stay.
+func (l *liveRecipes) reload() error {
+	csvs, err := l.in.loadRecipeCSVs()
+	if err != nil {
+		return err
+	}
+	return l.swap(csvs)
+}
+
+// swap makes csvs the recipes the API answers from.
+func (l *liveRecipes) swap(csvs *recipeCSVs) error {
+	var d *recipeDBs
+	if l.sqlFood == nil {
+		d = newRecipeDBs(csvs.food, csvs.refiner, l.opts, csvs.foodSrc, csvs.refinerSrc)
+	} else {
+		if err := l.sqlFood.Replace(csvs.food); err != nil {
+			return fmt.Errorf("write food recipes to %s: %w", l.in.RecipeDB, err)
+		}
+		if err := l.sqlRef.Replace(csvs.refiner); err != nil {
+			return fmt.Errorf("write refiner recipes to %s: %w", l.in.RecipeDB, err)
+		}
+		d = newRecipeDBs(l.sqlFood, l.sqlRef, l.opts,
+			l.in.RecipeDB+" (from "+csvs.foodSrc+")",
+			l.in.RecipeDB+" (from "+csvs.refinerSrc+")")
+	}
+	if l.cur.Swap(d) != nil {
+		log.Printf("recipes reloaded")
+	}
+	d.logCounts()
+	l.mu.Lock()
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// changes returns a channel closed when the recipes are next swapped.
+func (l *liveRecipes) changes() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	return l.changed
+}
+
+// reloadDelay lets an editor finish writing (several events per save)
+// before the CSVs are read again.
+const reloadDelay = 250 * time.Millisecond
+
+// watch reloads the recipe CSVs whenever one of them is written, created or
+// renamed into place. A reload that fails keeps the current DBs.
+func (l *liveRecipes) watch() error {
+	in := l.in
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, p := range []string{in.Food, in.Refiner, in.DataPack} {
+		if p == "" {
+			continue
+		}
+		names[filepath.Clean(p)] = true
+		dirs[filepath.Dir(p)] = true
+	}
+	// Watch the directories, not the files: editors and the scraper replace
+	// the file by renaming a new one over it.
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !names[filepath.Clean(ev.Name)] || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					if err := l.reload(); err != nil {
+						log.Printf("recipe reload: %v (keeping the current recipes)", err)
+					}
+				})
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("recipe watcher: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// uploadHandler serves POST /api/recipes/upload: a multipart form with the
+// new CSV in "file" and "dataset" set to food or refiner. The CSV must load
+// with at least one recipe; it then replaces the file at the configured path
+// and the DBs are reloaded. Row issues are returned, as nms validate reports
+// them.
+func (l *liveRecipes) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	var path, flagName string
+	switch r.FormValue("dataset") {
+	case "food":
+		path, flagName = l.in.Food, "csv"
+	case "refiner":
+		path, flagName = l.in.Refiner, "refiner"
+	default:
+		http.Error(w, "dataset must be food or refiner", http.StatusBadRequest)
+		return
+	}
+	if l.in.Demo {
+		http.Error(w, "recipe uploads are disabled in demo mode", http.StatusForbidden)
+		return
+	}
+	if l.remote != nil {
+		http.Error(w, "the recipes are mounted read-only from "+l.remote.url, http.StatusConflict)
+		return
+	}
+	if b, _, _ := l.in.fromPack(flagName); b != nil {
+		http.Error(w, "this dataset is served from "+l.in.DataPack+"; pack a new bundle instead", http.StatusConflict)
+		return
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*.csv")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, f)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	db, err := recipes.LoadCSV(tmp.Name())
+	if err == nil && len(db.Recipes) == 0 {
+		err = errors.New("no recipes parsed")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := l.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	issues := make([]string, len(db.Issues))
+	for i, is := range db.Issues {
+		issues[i] = is.String()
+	}
+	writeJSON(w, map[string]any{
+		"dataset": r.FormValue("dataset"),
+		"recipes": len(db.Recipes),
+		"errors":  db.Errors(),
+		"issues":  issues,
+	})
+}
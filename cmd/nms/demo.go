@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Demo mode ----------
+
+// Demo mode serves sample data nobody can keep: the stores live in memory,
+// uploads land in a temporary directory, the built-in recipes are used
+// whatever the path flags say, and everything is put back every so often.
+// The admin page and API are switched off, since they have no login.
+
+const (
+	demoSeed   = 1  // gen-fixtures' default seed, so the sample never changes
+	demoGlyphs = 60 // sample glyphs
+)
+
+// demo points the instance at memory and a fresh temporary directory, and
+// removes the directory when the server is stopped.
+func (in *instance) demo() error {
+	tmp, err := os.MkdirTemp("", "nms-demo-*")
+	if err != nil {
+		return err
+	}
+	in.Demo = true
+	in.Images = tmp
+	for _, p := range []*string{&in.Glyphs, &in.Bases, &in.Creatures, &in.Portals, &in.Systems, &in.Loadouts, &in.CustomRecipes} {
+		*p = store.InMemory
+	}
+	// Paths that do not exist, so loadRecipes falls back to the built-in
+	// datasets and loadTech and loadValues to none.
+	in.Food, in.Refiner, in.Tech = filepath.Join(tmp, "food.csv"), filepath.Join(tmp, "refiner.csv"), filepath.Join(tmp, "technologies.csv")
+	in.Values, in.Aliases = filepath.Join(tmp, "values.csv"), filepath.Join(tmp, "aliases.csv")
+	in.given["csv"], in.given["refiner"] = false, false
+	in.RecipeDB, in.DataPack, in.ProfilesDir = "", "", ""
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		os.RemoveAll(tmp)
+		os.Exit(0)
+	}()
+	return nil
+}
+
+// resetDemo empties every store but the glyphs, which get the sample back,
+// and deletes uploaded photos and icons.
+func (c *catalogues) resetDemo(images string) error {
+	entries, err := os.ReadDir(images)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(images, e.Name())); err != nil {
+			return err
+		}
+	}
+	if _, err := c.Glyphs.Reset(newFixtureGen(demoSeed).glyphs(demoGlyphs)); err != nil {
+		return err
+	}
+	c.GlyphHistory.reset()
+	for _, reset := range []func() error{
+		func() error { _, err := c.Bases.Reset(nil); return err },
+		func() error { _, err := c.Creatures.Reset(nil); return err },
+		func() error { _, err := c.Portals.Reset(nil); return err },
+		func() error { _, err := c.Systems.Reset(nil); return err },
+		func() error { _, err := c.Loadouts.Reset(nil); return err },
+		func() error { _, err := c.CustomRecipes.Reset(nil); return err },
+	} {
+		if err := reset(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDemo loads the sample and puts it back every interval (never when 0).
+func (c *catalogues) runDemo(images string, every time.Duration) error {
+	if err := c.resetDemo(images); err != nil {
+		return err
+	}
+	if every <= 0 {
+		return nil
+	}
+	go func() {
+		for range time.Tick(every) {
+			if err := c.resetDemo(images); err != nil {
+				log.Printf("demo reset: %v", err)
+				continue
+			}
+			log.Printf("demo data reset")
+		}
+	}()
+	return nil
+}
+
+// withDemo turns away the admin page and API.
+func withDemo(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			http.Error(w, "disabled in demo mode", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+)
+
+// ---------- Live updates ----------
+
+// eventPing is how often an idle event stream sends a comment, so proxies
+// and browsers keep the connection open.
+const eventPing = 25 * time.Second
+
+// eventsHandler serves GET /api/events: a server-sent event stream with a
+// "glyph" event for every glyph created, updated or deleted (or a reset of
+// them all), so open glyph pages can show each other's changes.
+func eventsHandler(gs *glyphs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, stop := gs.Subscribe()
+		defer stop()
+		rc := http.NewResponseController(w)
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		fmt.Fprint(w, "retry: 5000\n\n")
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		ping := time.NewTicker(eventPing)
+		defer ping.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				b, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: glyph\ndata: %s\n\n", b)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// ---------- Command line: gen-fixtures ----------
+
+const fixturesUsage = `usage: nms gen-fixtures [flags]
+
+Generates synthetic recipes and glyphs with game-like names, for
+benchmarks, demos and load tests, and writes them in the -data-dir layout:
+
+  DIR/datasets/food.csv, DIR/datasets/refiner.csv, DIR/glyphs/glyphs.jsonl
+
+so 'nms serve -data-dir DIR' serves them. The same -seed gives the same data.
+`
+
+func genFixturesCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen-fixtures", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, fixturesUsage); fs.PrintDefaults() }
+	out := fs.String("out", "fixtures", "Directory to write to")
+	nFood := fs.Int("recipes", 1000, "Number of food recipes")
+	nRefiner := fs.Int("refiner", -1, "Number of refiner recipes (default a fifth of -recipes)")
+	nGlyphs := fs.Int("glyphs", 100, "Number of glyphs")
+	seed := fs.Uint64("seed", 1, "Random seed")
+	force := fs.Bool("force", false, "Replace files already in -out")
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if *nRefiner < 0 {
+		*nRefiner = *nFood / 5
+	}
+	if *nFood < 0 || *nGlyphs < 0 {
+		fmt.Fprintln(stderr, "gen-fixtures: counts must not be negative")
+		return 2
+	}
+
+	paths := map[string]string{}
+	for _, name := range []string{"csv", "refiner", "glyphs"} {
+		p := filepath.Join(*out, filepath.FromSlash(dataLayout[name]))
+		if _, err := os.Stat(p); err == nil && !*force {
+			fmt.Fprintf(stderr, "gen-fixtures: %s exists (use -force to replace it)\n", p)
+			return 1
+		}
+		paths[name] = p
+	}
+
+	g := newFixtureGen(*seed)
+	food := g.foodRecipes(*nFood)
+	refiner := g.refinerRecipes(*nRefiner)
+	gl := g.glyphs(*nGlyphs)
+
+	err := writeFixtureCSV(paths["csv"], food, false)
+	if err == nil {
+		err = writeFixtureCSV(paths["refiner"], refiner, true)
+	}
+	if err == nil {
+		err = writeFixtureGlyphs(paths["glyphs"], gl)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "gen-fixtures: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "wrote %d food recipes to %s\n", len(food), paths["csv"])
+	fmt.Fprintf(stdout, "wrote %d refiner recipes to %s\n", len(refiner), paths["refiner"])
+	fmt.Fprintf(stdout, "wrote %d glyphs to %s\n", len(gl), paths["glyphs"])
+	return 0
+}
+
+// ---------- Word lists ----------
+
+var (
+	fixtureFoodRaw = []string{
+		"Fireberry", "Frost Crystal", "Solanium", "Heptaploid Wheat", "Sweetroot", "Jade Peas",
+		"Impulse Beans", "Aloe Flesh", "Marrow Bulb", "Grahberry", "Kelp Rice", "Gamma Root",
+		"Cactus Flesh", "Star Bulb", "Pulpy Roots", "Wild Milk", "Giant Egg", "Raw Steak",
+		"Meaty Chunks", "Scaly Meat", "Hexaberry", "Sievert Beans", "Fungal Mould", "Wild Yeast",
+	}
+	fixtureFoodAdj = []string{
+		"Spiced", "Candied", "Glazed", "Smoked", "Baked", "Whipped", "Crystallised", "Fragrant",
+		"Sticky", "Delicious", "Haunted", "Abyssal", "Frozen", "Bitter", "Sweet", "Crimson",
+		"Glowing", "Toasted", "Creamy", "Dreadful", "Wriggling", "Honeyed", "Stellar", "Salty",
+	}
+	fixtureFoodBase = []string{
+		"Fireberry", "Sweetroot", "Grahberry", "Hexaberry", "Marrow", "Star Bulb", "Jade Pea",
+		"Kelp", "Gamma", "Cactus", "Aloe", "Beast", "Egg", "Honey", "Cream", "Custard",
+		"Chocolate", "Nectar", "Bone", "Crab", "Sweetbread", "Leopard-Fruit", "Pilgrim", "Void",
+	}
+	fixtureFoodForm = []string{
+		"Pie", "Tart", "Stew", "Custard", "Jam", "Cake", "Dumplings", "Broth", "Pudding", "Pastry",
+		"Ice Cream", "Souffle", "Roast", "Sandwich", "Preserve", "Syrup", "Dough", "Paste",
+		"Surprise", "Fondue", "Crumble", "Loaf", "Casserole", "Pastille",
+	}
+	fixtureElements = []string{
+		"Ferrite Dust", "Pure Ferrite", "Carbon", "Condensed Carbon", "Oxygen", "Sodium",
+		"Sodium Nitrate", "Cobalt", "Ionised Cobalt", "Copper", "Gold", "Silver", "Chlorine",
+		"Salt", "Tritium", "Di-hydrogen", "Paraffinium", "Pyrite", "Ammonia", "Uranium",
+		"Dioxite", "Phosphorus", "Cactus Flesh", "Star Bulb", "Gamma Root", "Frost Crystal",
+	}
+	fixtureMineralPrefix = []string{"Ionised", "Chromatic", "Activated", "Condensed", "Pure", "Magnetised", "Refined", "Polished"}
+	fixtureStems         = []string{"Ferr", "Cobal", "Sodi", "Trit", "Pyr", "Cadm", "Emer", "Indi", "Rust", "Fung", "Gamm", "Uran", "Silic", "Quar", "Lum", "Nitr"}
+	fixtureMineralSuffix = []string{"ite", "ium", "ine", "on", "ate", "ide", "ene"}
+
+	fixtureSyllables = []string{"Ko", "Vy", "Ex", "Ush", "Ra", "Zel", "Thi", "Nor", "Ek", "Ama", "Ov", "Hes", "Gek", "Tal", "Mor", "Qui", "Sar", "Ul", "Yon", "Bel"}
+	fixtureEndings   = []string{"ion", "ar", "ux", "eth", "ora", "is", "aya", "um", "ensi", "ak", "ov", "ia"}
+	fixtureSuffixes  = []string{"", "", "", " Prime", " Minor", " Major", " IV", " XI", " Beta", " Tau"}
+	fixtureGalaxies  = []string{"Euclid", "Euclid", "Euclid", "Euclid", "Hilbert Dimension", "Calypso", "Hesperius Dimension", "Hyades", "Ickjamatew", "Eissentam"}
+	fixtureTags      = []string{"paradise", "s-class", "hub", "exotic", "trade", "farm", "lush", "toxic", "frozen", "dissonant", "ship", "multitool", "base"}
+	fixtureSights    = []string{
+		"a crashed freighter", "an S-class trading post", "ancient ruins", "a cave of storm crystals",
+		"floating islands", "bioluminescent flora", "a Sentinel pillar", "a gravitino ball farm",
+		"huge hexagonal rocks", "a minor settlement", "a derelict outpost", "rare Hadal Cores",
+	}
+	fixtureWeather = []string{"calm skies", "acid rain", "frequent storms", "no sentinels", "aggressive sentinels", "perfect weather"}
+)
+
+// ---------- Generation ----------
+
+type fixtureGen struct {
+	rng  *rand.Rand
+	used map[string]bool // names handed out, across kinds
+}
+
+func newFixtureGen(seed uint64) *fixtureGen {
+	return &fixtureGen{rng: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)), used: map[string]bool{}}
+}
+
+func (g *fixtureGen) pick(list []string) string { return list[g.rng.IntN(len(list))] }
+
+// unique draws names until an unused one comes up, numbering the last
+// draw once the word lists run dry.
+func (g *fixtureGen) unique(draw func() string) string {
+	var name string
+	for range 50 {
+		if name = draw(); !g.used[name] {
+			g.used[name] = true
+			return name
+		}
+	}
+	for n := 2; ; n++ {
+		if s := name + " " + strconv.Itoa(n); !g.used[s] {
+			g.used[s] = true
+			return s
+		}
+	}
+}
+
+// fixtureRecipe is a row of a generated recipe CSV.
+type fixtureRecipe struct {
+	recipes.Recipe
+	InQty []int
+	Cat   map[string]string // item categories, refiner only
+}
+
+// build makes n recipes. Each turns one to three known items into either a
+// new item, which becomes an input for later recipes, or (one time in four)
+// another recipe for an existing product, as real datasets have.
+func (g *fixtureGen) build(n int, raws []string, newItem func() string, qty func() int) []recipes.Recipe {
+	pool := append([]string(nil), raws...)
+	var made []string
+	seen := map[string]bool{}
+	out := make([]recipes.Recipe, 0, n)
+	for len(out) < n {
+		var output string
+		if len(made) > 0 && g.rng.IntN(4) == 0 {
+			output = made[g.rng.IntN(len(made))]
+		} else {
+			output = newItem()
+		}
+		k := 1 + g.rng.IntN(3)
+		inputs := make([]string, 0, k)
+		for range k * 4 {
+			if len(inputs) == k {
+				break
+			}
+			in := pool[g.rng.IntN(len(pool))]
+			if in != output && !slices.Contains(inputs, in) {
+				inputs = append(inputs, in)
+			}
+		}
+		r := recipes.Recipe{Inputs: inputs, Output: output, Qty: qty()}
+		if len(inputs) == 0 || seen[r.Key()] {
+			continue
+		}
+		seen[r.Key()] = true
+		if !slices.Contains(made, output) {
+			made = append(made, output)
+			pool = append(pool, output)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (g *fixtureGen) foodRecipes(n int) []fixtureRecipe {
+	for _, r := range fixtureFoodRaw {
+		g.used[r] = true
+	}
+	dish := func() string {
+		return g.unique(func() string {
+			if g.rng.IntN(3) == 0 {
+				return g.pick(fixtureFoodBase) + " " + g.pick(fixtureFoodForm)
+			}
+			return g.pick(fixtureFoodAdj) + " " + g.pick(fixtureFoodBase) + " " + g.pick(fixtureFoodForm)
+		})
+	}
+	recs := g.build(n, fixtureFoodRaw, dish, func() int { return 1 })
+	out := make([]fixtureRecipe, len(recs))
+	for i, r := range recs {
+		out[i] = fixtureRecipe{Recipe: r, InQty: ones(len(r.Inputs))}
+	}
+	return out
+}
+
+func ones(n int) []int {
+	q := make([]int, n)
+	for i := range q {
+		q[i] = 1
+	}
+	return q
+}
+
+func (g *fixtureGen) refinerRecipes(n int) []fixtureRecipe {
+	cat := map[string]string{}
+	for _, e := range fixtureElements {
+		cat[e] = "raw"
+		g.used[e] = true
+	}
+	mineral := func() string {
+		name := g.unique(func() string {
+			s := g.pick(fixtureStems) + g.pick(fixtureMineralSuffix)
+			if g.rng.IntN(2) == 0 {
+				s = g.pick(fixtureMineralPrefix) + " " + s
+			}
+			return s
+		})
+		cat[name] = "product"
+		return name
+	}
+	qtys := []int{1, 1, 2, 5, 10, 25, 30, 50, 100, 150, 250}
+	recs := g.build(n, fixtureElements, mineral, func() int { return qtys[g.rng.IntN(len(qtys))] })
+	out := make([]fixtureRecipe, len(recs))
+	for i, r := range recs {
+		in := make([]int, len(r.Inputs))
+		for j := range in {
+			in[j] = qtys[g.rng.IntN(len(qtys))]
+		}
+		out[i] = fixtureRecipe{Recipe: r, InQty: in, Cat: cat}
+	}
+	return out
+}
+
+// glyphs makes n glyphs around a few hub regions, the way a community's
+// addresses cluster, with the odd one far out.
+func (g *fixtureGen) glyphs(n int) []glyphs.Glyph {
+	var hubs []glyphs.PortalAddress
+	for range 1 + n/50 {
+		hubs = append(hubs, glyphs.RandomPortal(g.rng, nil, 0))
+	}
+	out := make([]glyphs.Glyph, n)
+	for i := range out {
+		var a glyphs.PortalAddress
+		if g.rng.IntN(5) == 0 {
+			a = glyphs.RandomPortal(g.rng, nil, 0)
+		} else {
+			a = glyphs.RandomPortal(g.rng, &hubs[g.rng.IntN(len(hubs))], 6)
+		}
+		name := g.unique(func() string {
+			return g.pick(fixtureSyllables) + strings.ToLower(g.pick(fixtureSyllables)) + g.pick(fixtureEndings) + g.pick(fixtureSuffixes)
+		})
+		sight := g.pick(fixtureSights)
+		gl := glyphs.Glyph{
+			Name:        name,
+			Symbols:     a.String(),
+			Galaxy:      g.pick(fixtureGalaxies),
+			Description: fmt.Sprintf("%s%s with %s, %s.", strings.ToUpper(sight[:1]), sight[1:], g.pick(fixtureSights), g.pick(fixtureWeather)),
+		}
+		for range g.rng.IntN(4) {
+			if t := g.pick(fixtureTags); !slices.Contains(gl.Tags, t) {
+				gl.Tags = append(gl.Tags, t)
+			}
+		}
+		out[i] = gl
+	}
+	return out
+}
+
+// ---------- Output ----------
+
+// writeFixtureCSV writes recipes in the scraper's column layout, with
+// *_category columns when categorised.
+func writeFixtureCSV(path string, recs []fixtureRecipe, categories bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	cols := []string{"input1", "input2", "input3", "output"}
+	var header []string
+	for _, c := range cols {
+		header = append(header, c+"_name", c+"_qty")
+		if categories {
+			header = append(header, c+"_category")
+		}
+	}
+	cw.Write(header)
+	for _, r := range recs {
+		var row []string
+		for i := range 3 {
+			name, qty, cat := "", "", ""
+			if i < len(r.Inputs) {
+				name, qty, cat = r.Inputs[i], strconv.Itoa(r.InQty[i]), r.Cat[r.Inputs[i]]
+			}
+			row = append(row, name, qty)
+			if categories {
+				row = append(row, cat)
+			}
+		}
+		row = append(row, r.Output, strconv.Itoa(r.Qty))
+		if categories {
+			row = append(row, r.Cat[r.Output])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	err = cw.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeFixtureGlyphs saves the glyphs through the store, so the file has
+// the format serve expects.
+func writeFixtureGlyphs(path string, items []glyphs.Glyph) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	gs := &glyphs.Store{Path: absPath(path), Spec: glyphs.Spec}
+	if err := gs.Load(); err != nil {
+		return err
+	}
+	res, err := gs.Import(items)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if r.Error != "" {
+			return fmt.Errorf("glyph %d: %s", r.Index, r.Error)
+		}
+	}
+	return nil
+}
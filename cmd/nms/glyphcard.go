@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+)
+
+// ---------- Glyph cards ----------
+
+// A glyph card is a PNG of a glyph's name and its twelve portal glyphs,
+// drawn with the embedded glyph font so it can be pasted into chats whose
+// readers do not have the font installed.
+
+const (
+	cardWidth   = 1000
+	cardHeight  = 320
+	cardPad     = 40
+	cardCell    = 68 // square behind each portal glyph
+	cardCellGap = 8
+)
+
+var (
+	cardBg     = color.RGBA{0x0e, 0x31, 0x2b, 0xff}
+	cardCellBg = color.RGBA{0x11, 0x8b, 0x73, 0xff}
+	cardText   = color.RGBA{0xf2, 0xf7, 0xf5, 0xff}
+	cardMuted  = color.RGBA{0x9f, 0xc9, 0xbe, 0xff}
+)
+
+type cardFaces struct {
+	name, small, glyph font.Face
+}
+
+// loadCardFaces parses the fonts on first use; they never change.
+var loadCardFaces = sync.OnceValues(func() (*cardFaces, error) {
+	face := func(ttf []byte, size float64) (font.Face, error) {
+		f, err := opentype.Parse(ttf)
+		if err != nil {
+			return nil, err
+		}
+		return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+	var (
+		fs  cardFaces
+		err error
+	)
+	if fs.name, err = face(gobold.TTF, 44); err != nil {
+		return nil, err
+	}
+	if fs.small, err = face(goregular.TTF, 22); err != nil {
+		return nil, err
+	}
+	if fs.glyph, err = face(glyphFontTTF, 52); err != nil {
+		return nil, fmt.Errorf("glyph font: %w", err)
+	}
+	return &fs, nil
+})
+
+// glyphCard draws g, whose symbols must be a portal address.
+func glyphCard(g glyphs.Glyph, a glyphs.PortalAddress) ([]byte, error) {
+	fs, err := loadCardFaces()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBg), image.Point{}, draw.Src)
+
+	text := func(face font.Face, c color.Color, x, y int, s string) {
+		d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(fit(face, s, cardWidth-2*cardPad))
+	}
+	text(fs.name, cardText, cardPad, cardPad+44, g.Name)
+	text(fs.small, cardMuted, cardPad, cardPad+84, g.Galaxy+" • "+a.GalacticCoords())
+
+	// The glyph row, centred, each symbol centred in its square.
+	portal := a.String()
+	row := len(portal)*cardCell + (len(portal)-1)*cardCellGap
+	x0, y0 := (cardWidth-row)/2, cardPad+124
+	for i, r := range portal {
+		x := x0 + i*(cardCell+cardCellGap)
+		draw.Draw(img, image.Rect(x, y0, x+cardCell, y0+cardCell), image.NewUniform(cardCellBg), image.Point{}, draw.Src)
+		b, adv := font.BoundString(fs.glyph, string(r))
+		gx := fixed.I(x) + (fixed.I(cardCell)-adv)/2
+		gy := fixed.I(y0) + (fixed.I(cardCell)-(b.Max.Y-b.Min.Y))/2 - b.Min.Y
+		d := font.Drawer{Dst: img, Src: image.NewUniform(cardText), Face: fs.glyph, Dot: fixed.Point26_6{X: gx, Y: gy}}
+		d.DrawString(string(r))
+	}
+	text(fs.small, cardMuted, x0, y0+cardCell+44, portal)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fit shortens s with an ellipsis until it is at most width pixels wide.
+func fit(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Ceil() <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && font.MeasureString(face, string(r)+"…").Ceil() > width {
+		r = r[:len(r)-1]
+	}
+	return strings.TrimSpace(string(r)) + "…"
+}
+
+// glyphImageHandler serves GET /api/glyphs/{id}/image.png. The card only
+// changes with the glyph, so its ETag is a hash of what is drawn.
+func glyphImageHandler(gs *glyphs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := gs.Get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "glyph not found", http.StatusNotFound)
+			return
+		}
+		a, err := glyphs.ParsePortal(g.Symbols)
+		if err != nil {
+			http.Error(w, "glyph symbols are not a portal address: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		etag := `"` + sha256Hex([]byte(g.Name + "\x00" + g.Galaxy + "\x00" + a.String()))[:32] + `"`
+		if notModified(w, r, etag) {
+			return
+		}
+		body, err := glyphCard(g, a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Change history ----------
+
+// history is the append-only log of changes to a store's records, one JSON
+// line per change in a file beside the store (glyphs.jsonl keeps
+// glyphs.history.jsonl). Nothing in it is ever rewritten, so edits and
+// deletions on a shared instance can be traced back.
+type history struct {
+	path string // "" keeps the log in memory, for in-memory stores
+	mu   sync.Mutex
+	mem  []change
+}
+
+// change is one entry: which record, what was done to it, by whom and the
+// fields that changed. A deleted record's last fields are kept in From.
+type change struct {
+	At     time.Time              `json:"at"`
+	ID     string                 `json:"id"`
+	Action string                 `json:"action"` // create, update, delete, import, pin or unpin
+	By     actor                  `json:"by"`
+	Fields map[string]fieldChange `json:"fields,omitempty"`
+}
+
+type fieldChange struct {
+	From json.RawMessage `json:"from,omitempty"`
+	To   json.RawMessage `json:"to,omitempty"`
+}
+
+// actor is who made a change: the signed-in user if any and the client's
+// address for the API, or the command line.
+type actor struct {
+	User string `json:"user,omitempty"`
+	Addr string `json:"addr,omitempty"`
+	Via  string `json:"via"` // api or cli
+}
+
+var cliActor = actor{Via: "cli"}
+
+// historyPath is the log kept for the store at path.
+func historyPath(path string) string {
+	if path == store.InMemory {
+		return ""
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".history.jsonl"
+}
+
+func newHistory(storePath string) *history { return &history{path: historyPath(storePath)} }
+
+// record logs what happened to the record with the given id; old is nil for
+// a new record and new is nil for a deleted one. An update that changed
+// nothing is not logged.
+func (h *history) record(id, action string, by actor, old, new any) error {
+	if h == nil {
+		return nil
+	}
+	c := change{At: time.Now().UTC(), ID: id, Action: action, By: by, Fields: diffFields(old, new)}
+	if action == "update" && len(c.Fields) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.path == "" {
+		h.mem = append(h.mem, c)
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// of returns the changes to the record with the given id, oldest first.
+func (h *history) of(id string) ([]change, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []change
+	if h.path == "" {
+		for _, c := range h.mem {
+			if c.ID == id {
+				out = append(out, c)
+			}
+		}
+		return out, nil
+	}
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	needle := []byte(`"id":` + mustJSON(id))
+	for sc.Scan() {
+		if !bytes.Contains(sc.Bytes(), needle) {
+			continue
+		}
+		var c change
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil || c.ID != id {
+			continue // a line cut short by a crash
+		}
+		out = append(out, c)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out, sc.Err()
+}
+
+// reset forgets an in-memory log (demo mode puts its data back).
+func (h *history) reset() {
+	h.mu.Lock()
+	h.mem = nil
+	h.mu.Unlock()
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// diffFields compares two records by their JSON fields, leaving out the id.
+func diffFields(old, new any) map[string]fieldChange {
+	a, b := jsonFields(old), jsonFields(new)
+	out := map[string]fieldChange{}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			out[k] = fieldChange{From: v, To: b[k]}
+		}
+	}
+	for k, w := range b {
+		if _, ok := a[k]; !ok {
+			out[k] = fieldChange{To: w}
+		}
+	}
+	delete(out, "id")
+	return out
+}
+
+func jsonFields(v any) map[string]json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if json.Unmarshal(b, &m) != nil {
+		return nil
+	}
+	// Re-encode each value so equal values compare equal byte for byte.
+	for k, raw := range m {
+		var x any
+		if json.Unmarshal(raw, &x) == nil {
+			m[k], _ = json.Marshal(x)
+		}
+	}
+	return m
+}
+
+// requestActor names who sent r: the user whose token it carries, if cfg
+// has one, and the client address.
+func requestActor(cfg *liveConfig, r *http.Request) actor {
+	a := actor{Via: "api", Addr: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		a.Addr = host
+	}
+	if cfg != nil {
+		a.User, _ = cfg.user(r)
+	}
+	return a
+}
+
+// historyHandler serves GET /api/<kind>s/{id}/history. A deleted record's
+// history is still there.
+func historyHandler(h *history) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changes, err := h.of(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(changes) == 0 {
+			http.Error(w, "no history for this id", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, changes)
+	}
+}
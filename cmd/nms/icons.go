@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Item icons ----------
+
+// itemIcons are icons uploaded for items, shown instead of (or where there
+// is no) upstream artwork. Each is re-encoded as a PNG named after a hash of
+// the item's normalized name, in item-icons/ beside the glyph photos.
+type itemIcons struct {
+	dir string
+}
+
+const iconPrefix = "/item-icons/"
+
+// iconDir is where an instance keeps item icons.
+func (in *instance) iconDir() string {
+	return filepath.Join(filepath.Dir(in.catalogues().Glyphs.PhotoDir()), "item-icons")
+}
+
+func iconFile(name string) string {
+	return fmt.Sprintf("%016x.png", store.Hash(norm.Key(name)))
+}
+
+// urls maps each of names that has an uploaded icon to the icon's URL.
+func (ic itemIcons) urls(names []string) map[string]string {
+	entries, err := os.ReadDir(ic.dir)
+	if err != nil {
+		return nil
+	}
+	have := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		have[e.Name()] = true
+	}
+	out := map[string]string{}
+	for _, n := range names {
+		if f := iconFile(n); have[f] {
+			out[n] = iconPrefix + f
+		}
+	}
+	return out
+}
+
+func (ic itemIcons) put(name string, data []byte) (string, error) {
+	// The size is checked before decoding: a small file may declare a
+	// huge image.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid icon: %w", err)
+	}
+	if cfg.Width > 512 || cfg.Height > 512 {
+		return "", errors.New("icon too large (max 512x512)")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid icon: %w", err)
+	}
+	if err := os.MkdirAll(ic.dir, 0o755); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	file := iconFile(name)
+	tmp := filepath.Join(ic.dir, file+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(ic.dir, file)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return iconPrefix + file, nil
+}
+
+func (ic itemIcons) remove(name string) error {
+	err := os.Remove(filepath.Join(ic.dir, iconFile(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// routes serves the icons and PUT and DELETE /api/items/{name}/icon. The
+// icon is the "icon" field of a multipart form or the raw request body.
+// known reports whether the name is an item of either dataset.
+func (ic itemIcons) routes(mux *http.ServeMux, known func(name string) bool) error {
+	if err := os.MkdirAll(ic.dir, 0o755); err != nil {
+		return err
+	}
+	mux.Handle(iconPrefix, http.StripPrefix(iconPrefix, http.FileServer(http.Dir(ic.dir))))
+
+	mux.HandleFunc("PUT /api/items/{name}/icon", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !known(name) {
+			http.Error(w, "unknown item", http.StatusNotFound)
+			return
+		}
+		var data []byte
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			f, _, ferr := r.FormFile("icon")
+			if ferr != nil {
+				http.Error(w, "missing icon file", http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			data, err = io.ReadAll(io.LimitReader(f, 4<<20))
+		} else {
+			data, err = io.ReadAll(io.LimitReader(r.Body, 4<<20))
+		}
+		if err != nil {
+			http.Error(w, "read icon: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		u, err := ic.put(name, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]string{"name": name, "icon": u})
+	})
+
+	mux.HandleFunc("DELETE /api/items/{name}/icon", func(w http.ResponseWriter, r *http.Request) {
+		if err := ic.remove(r.PathValue("name")); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "no icon for this item", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return nil
+}
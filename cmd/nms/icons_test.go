@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestIconPut(t *testing.T) {
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  string // in the error; "" for none
+	}{
+		{"fits", encode(64, 64), ""},
+		{"largest", encode(512, 512), ""},
+		{"too wide", encode(513, 10), "too large"},
+		{"declares 30000x30000", pngHeader(30000, 30000), "too large"},
+		{"not an image", []byte("hello"), "invalid icon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ic := itemIcons{dir: t.TempDir()}
+			u, err := ic.put("Carbon", tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one about %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(u, iconPrefix) {
+				t.Errorf("url = %q", u)
+			}
+		})
+	}
+}
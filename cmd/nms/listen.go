@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ---------- Listeners ----------
+
+// listenOptions say where and how serve listens.
+type listenOptions struct {
+	Addr       string
+	SocketMode fs.FileMode // of a unix: socket
+	AccessLog  bool
+	TLS        tlsOptions
+}
+
+// listen opens the socket serve answers on. An address of unix:PATH is a
+// Unix socket, for a reverse proxy on the same machine; mode is given to
+// it so the proxy's user can connect. When systemd passes sockets in
+// (socket activation, LISTEN_FDS) the nth of them is used instead and
+// addr is ignored: the first for -addr, the second for -http-addr (nth
+// is -1 for a socket systemd does not pass in).
+func listen(addr string, nth int, mode fs.FileMode) (net.Listener, error) {
+	if fds := systemdFDs(); nth >= 0 && nth < len(fds) {
+		ln, err := net.FileListener(fds[nth])
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %w", nth+1, err)
+		}
+		log.Printf("listening on %s (from systemd)", ln.Addr())
+		return ln, nil
+	}
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			log.Printf("listening on %s", addr)
+		}
+		return ln, err
+	}
+	// A socket left by a process that did not get to close it would make
+	// the listen fail; anything else at the path is not ours to remove.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s: exists and is not a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: another server is listening there", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	log.Printf("listening on %s", addr)
+	return ln, nil
+}
+
+// systemdFDs returns the sockets systemd passed to this process, taking
+// them out of the environment so commands started from here do not see
+// them as theirs.
+var systemdFDs = sync.OnceValue(func() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	const first = 3 // SD_LISTEN_FDS_START
+	files := make([]*os.File, n)
+	for i := range files {
+		files[i] = os.NewFile(uintptr(first+i), "systemd-socket-"+strconv.Itoa(i+1))
+	}
+	return files
+})
+
+// parseSocketMode reads -socket-mode, an octal permission like 0660.
+func parseSocketMode(s string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, errors.New("-socket-mode: want octal permissions like 0660")
+	}
+	return fs.FileMode(m), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	. "fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ---------- Logging ----------
+
+// setupLogging installs the default slog logger on w. Text output suits a
+// terminal; --log-json gives one object per line for cron and log shippers.
+func setupLogging(w io.Writer, level string, asJSON bool) error {
+	var lv slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lv = slog.LevelDebug
+	case "", "info":
+		lv = slog.LevelInfo
+	case "warn", "warning":
+		lv = slog.LevelWarn
+	case "error":
+		lv = slog.LevelError
+	default:
+		return Errorf("unknown --log-level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if asJSON {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// ---------- Request logging ----------
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestID returns the ID withRequestLog gave r, "" outside it.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID keeps the ID a proxy in front already gave the request, if
+// it looks like one, so its logs and ours can be matched up.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 64 && strings.Trim(id, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_.:") == "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loggedWriter records what was written for the access log.
+type loggedWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (lw *loggedWriter) WriteHeader(code int) {
+	if lw.status == 0 {
+		lw.status = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggedWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController flush the event streams.
+func (lw *loggedWriter) Unwrap() http.ResponseWriter { return lw.ResponseWriter }
+
+// withRequestLog gives each request an ID, sent back in X-Request-ID, and
+// logs it once it is answered (unless access is false). A handler that
+// panics gets a 500 naming the ID, and the stack goes to the log, instead
+// of the connection being dropped.
+func withRequestLog(h http.Handler, access bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := newRequestID(r)
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		lw := &loggedWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				slog.Error("panic serving request", "id", id, "method", r.Method, "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+				if lw.status == 0 {
+					http.Error(lw, Sprintf("internal error (request %s)", id), http.StatusInternalServerError)
+				} else {
+					lw.status = http.StatusInternalServerError // cut short
+				}
+			}
+			if !access {
+				return
+			}
+			addr := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				addr = host
+			}
+			slog.Info("request", "id", id, "method", r.Method, "path", r.URL.Path, "status", max(lw.status, http.StatusOK),
+				"latency", time.Since(start), "bytes", lw.bytes, "addr", addr)
+		}()
+		h.ServeHTTP(lw, r)
+	})
+}
@@ -0,0 +1,531 @@
+// nms is the No Man's Sky toolbox: it scrapes recipe tables, serves the
+// recipe finder and catalogues, and manages the stores from the command line.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// ---------- Main ----------
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3";
+// otherwise the VCS revision Go embeds is shown.
+var version = ""
+
+const usage = `usage: nms <command> [flags]
+
+commands:
+  serve      run the web server (recipes, glyphs, bases, systems...)
+  scrape     fetch a table page and write CSV, TSV or XLSX
+  validate   check recipe CSV files the server would load
+  import     add records from a JSON export to a store
+  glyphs     add, list, search and export saved glyphs
+  backup     create or restore a snapshot of the whole instance
+  pack       bundle the datasets into one file for serve -datapack
+  gen-fixtures  write synthetic recipes and glyphs for benchmarks and demos
+  update     check for a newer release and optionally install it
+  passwd     hash a password for a user of the serve -config file
+  version    print the version
+
+Run 'nms <command> -h' for the command's flags. serve, validate, import and
+backup share the path flags (-csv, -refiner, -tech, -glyphs, -bases, ...).
+-data-dir DIR puts them all under one directory:
+
+  DIR/datasets/   food.csv, refiner.csv, technologies.csv, values.csv, aliases.csv
+  DIR/glyphs/     glyphs.jsonl, bases.json, creatures.json, portals.json, ...
+  DIR/images/     uploaded photos, one directory per kind
+  DIR/backups/    snapshots written by 'nms backup create'
+
+Every flag can also be set with an NMS_* environment variable named after
+it (-data-dir is NMS_DATA_DIR, -addr is NMS_ADDR), or in the flags section
+of a YAML config file: serve's -config, or NMS_CONFIG for every command.
+
+  flags:
+    data-dir: /data
+    addr: :8080
+
+Command-line flags win over the environment, and both over the file.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "serve":
+		serveCmd(args)
+	case "scrape":
+		scrapeCmd(args)
+	case "validate":
+		os.Exit(validateCmd(args, os.Stdout, os.Stderr))
+	case "import":
+		os.Exit(importCmd(args, os.Stdout, os.Stderr))
+	case "glyphs":
+		os.Exit(glyphsCmd(args, os.Stdout, os.Stderr))
+	case "backup":
+		os.Exit(backupCmd(args, os.Stdout, os.Stderr))
+	case "pack":
+		os.Exit(packCmd(args, os.Stdout, os.Stderr))
+	case "gen-fixtures":
+		os.Exit(genFixturesCmd(args, os.Stdout, os.Stderr))
+	case "update":
+		os.Exit(updateCmd(args, os.Stdout, os.Stderr))
+	case "passwd":
+		os.Exit(passwdCmd(args, os.Stdin, os.Stdout, os.Stderr))
+	case "version", "-version", "--version":
+		printVersion(os.Stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+func printVersion(w io.Writer) {
+	v, rev := buildVersion()
+	if rev != "" {
+		v += " (" + rev + ")"
+	}
+	fmt.Fprintln(w, "nms", v)
+}
+
+// buildVersion returns the release version ("devel" for a development
+// build) and the VCS revision it was built from, if known.
+func buildVersion() (v, rev string) {
+	v = version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				rev = s.Value[:12]
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	return v, rev
+}
+
+// envFlags sets each flag of fs that has an NMS_<NAME> environment variable,
+// where NAME is the flag name upper-cased with dashes as underscores. Call it
+// before Parse so the command line still overrides the environment.
+func envFlags(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "NMS_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(name); ok && err == nil {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %w", name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// configFlags sets flags of fs from the flags section of the YAML config
+// file at path:
+//
+//	flags:
+//	  data-dir: /data
+//	  addr: unix:/run/nms/nms.sock
+//	  autocert-domain: [nms.example.com, www.nms.example.com]
+//
+// A name fs does not have is an error when strict (the file is the
+// command's own -config), and skipped otherwise, since one file given in
+// NMS_CONFIG serves every command.
+func configFlags(fs *flag.FlagSet, path string, strict bool) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseRuntimeConfig(path, b)
+	if err != nil {
+		return err
+	}
+	for name, v := range cfg.Flags {
+		switch {
+		case name == "config":
+			return fmt.Errorf("%s: flags: config cannot be set from the config file", path)
+		case fs.Lookup(name) == nil && strict:
+			return fmt.Errorf("%s: flags: %s has no flag -%s", path, fs.Name(), name)
+		case fs.Lookup(name) == nil:
+			continue
+		}
+		if err := fs.Set(name, string(v)); err != nil {
+			return fmt.Errorf("%s: flags: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// configPath returns the config file whose flags section applies to fs:
+// its -config on the command line or in NMS_CONFIG, and whether fs has
+// such a flag at all.
+func configPath(fs *flag.FlagSet, args []string) (string, bool) {
+	own := fs.Lookup("config") != nil
+	if own {
+		for i := 0; i < len(args); i++ {
+			a := args[i]
+			if a == "--" || !strings.HasPrefix(a, "-") {
+				break
+			}
+			name, v, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+			if name != "config" {
+				continue
+			}
+			if !hasValue && i+1 < len(args) {
+				v = args[i+1]
+			}
+			return v, true
+		}
+	}
+	return os.Getenv("NMS_CONFIG"), own
+}
+
+// parseFlags applies the flags section of the config file, then the
+// environment and then args to fs, so the command line wins over the
+// environment, and both over the file.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if path, own := configPath(fs, args); path != "" {
+		if err := configFlags(fs, path, own); err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			return err
+		}
+	}
+	if err := envFlags(fs); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func absPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// instance is where one installation keeps its datasets and stores. Every
+// command that touches them takes the same path flags.
+type instance struct {
+	DataDir, Images, Backups                             string
+	Food, Refiner, Tech, Values, RecipeDB, DataPack      string
+	Glyphs, Bases, Creatures, Portals, Systems, Loadouts string
+	Aliases                                              string
+	CustomRecipes                                        string
+	ProfilesDir                                          string // see profiles
+	StoreBackups                                         int
+	Demo                                                 bool // see demo
+
+	fs    *flag.FlagSet
+	given map[string]bool // flags set on the command line or environment
+}
+
+func (in *instance) register(fs *flag.FlagSet) {
+	in.fs = fs
+	fs.StringVar(&in.DataDir, "data-dir", "", "Keep datasets, stores, images and backups under this directory")
+	fs.StringVar(&in.Images, "images", "", "Directory for uploaded photos (default next to each store file)")
+	fs.StringVar(&in.Backups, "backups", "backups", "Directory for snapshots written by backup create without a FILE")
+	fs.StringVar(&in.Food, "csv", "food.csv", "Path to food.csv (recipe table; the built-in one is used when the default is missing)")
+	fs.StringVar(&in.Refiner, "refiner", "refiner.csv", "Path to refiner.csv (recipe table; the built-in one is used when the default is missing)")
+	fs.StringVar(&in.Glyphs, "glyphs", "glyphs.jsonl", "Path to the glyph store: an append-only log (.jsonl), JSON file or SQLite database (.db)")
+	fs.StringVar(&in.Bases, "bases", "bases.json", "Path to bases JSON file")
+	fs.StringVar(&in.Creatures, "creatures", "creatures.json", "Path to creatures JSON file")
+	fs.StringVar(&in.Portals, "portals", "portals.json", "Path to portal roulette history JSON file")
+	fs.StringVar(&in.Systems, "systems", "systems.json", "Path to star systems JSON file")
+	fs.StringVar(&in.Loadouts, "loadouts", "loadouts.json", "Path to upgrade loadouts JSON file")
+	fs.StringVar(&in.CustomRecipes, "custom-recipes", "custom_recipes.json", "Path to the users' custom recipes JSON file")
+	fs.StringVar(&in.RecipeDB, "recipe-db", "", "Keep the recipes in this SQLite database instead of in memory (filled from -csv/-refiner, rewritten when they change)")
+	fs.StringVar(&in.Tech, "tech", "technologies.csv", "Path to technologies.csv (scraped with --profile technology; optional)")
+	fs.StringVar(&in.Values, "values", "values.csv", "Path to a CSV of item base values (name,value columns, or a scraped item-details table; optional)")
+	fs.StringVar(&in.Aliases, "aliases", "aliases.csv", "Path to a CSV of ingredient aliases (alias,name columns; optional, added to from the admin API)")
+	fs.StringVar(&in.ProfilesDir, "profiles-dir", "", "Keep the stores of each profile other than the default (one per save game or character) in a directory of its own under this one")
+	fs.IntVar(&in.StoreBackups, "store-backups", 3, "Copies of each JSON store kept on every save, as FILE.bak.1 (newest) to FILE.bak.N")
+	fs.StringVar(&in.DataPack, "datapack", "", "Read the datasets from this file written by nms pack (a path flag given explicitly still wins)")
+}
+
+// dataLayout is where each path flag points inside -data-dir.
+var dataLayout = map[string]string{
+	"csv":            "datasets/food.csv",
+	"refiner":        "datasets/refiner.csv",
+	"tech":           "datasets/technologies.csv",
+	"values":         "datasets/values.csv",
+	"aliases":        "datasets/aliases.csv",
+	"glyphs":         "glyphs/glyphs.jsonl",
+	"bases":          "glyphs/bases.json",
+	"creatures":      "glyphs/creatures.json",
+	"portals":        "glyphs/portals.json",
+	"systems":        "glyphs/systems.json",
+	"loadouts":       "glyphs/loadouts.json",
+	"custom-recipes": "glyphs/custom_recipes.json",
+	"images":         "images",
+	"backups":        "backups",
+}
+
+// resolve moves the paths not given explicitly under -data-dir, creating
+// its directories, and makes every path absolute.
+func (in *instance) resolve() error {
+	in.given = map[string]bool{}
+	in.fs.Visit(func(f *flag.Flag) { in.given[f.Name] = true })
+	if in.DataDir != "" {
+		for name, rel := range dataLayout {
+			if !in.given[name] {
+				in.fs.Set(name, filepath.Join(in.DataDir, filepath.FromSlash(rel)))
+			}
+		}
+		for _, dir := range []string{"datasets", "glyphs", "images", "backups"} {
+			if err := os.MkdirAll(filepath.Join(in.DataDir, dir), 0o755); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range []*string{&in.Images, &in.Backups, &in.Food, &in.Refiner, &in.Tech, &in.Values, &in.Aliases, &in.RecipeDB, &in.DataPack, &in.Glyphs, &in.Bases, &in.Creatures, &in.Portals, &in.Systems, &in.Loadouts, &in.CustomRecipes, &in.ProfilesDir} {
+		if *p != "" {
+			*p = absPath(*p)
+		}
+	}
+	return nil
+}
+
+// catalogues are the record stores of an instance.
+type catalogues struct {
+	Glyphs    *glyphs.Store
+	Bases     *BaseStore
+	Creatures *CreatureStore
+	Portals   *PortalStore
+	Systems   *SystemStore
+	Loadouts  *LoadoutStore
+
+	CustomRecipes *CustomRecipeStore
+
+	GlyphHistory *history
+}
+
+// loadRecipes loads the recipe CSV of a path flag ("csv" or "refiner").
+// When the file is missing and the flag was not given, the dataset built
+// into the binary is used; src says which one was loaded.
+func (in *instance) loadRecipes(flagName string) (db *recipes.DB, src string, err error) {
+	path, builtin := in.Food, "food.csv"
+	if flagName == "refiner" {
+		path, builtin = in.Refiner, "refiner.csv"
+	}
+	if b, src, err := in.fromPack(flagName); err != nil || b != nil {
+		if err != nil {
+			return nil, src, err
+		}
+		db, err = recipes.ReadCSV(bytes.NewReader(b))
+		return db, src, err
+	}
+	if _, serr := os.Stat(path); errors.Is(serr, fs.ErrNotExist) && !in.given[flagName] {
+		db, err = recipes.LoadBuiltin(builtin)
+		return db, "built-in " + builtin, err
+	}
+	db, err = recipes.LoadCSV(path)
+	return db, path, err
+}
+
+// catalogues returns the instance's stores without loading them.
+func (in *instance) catalogues() *catalogues {
+	return &catalogues{
+		Glyphs:    &glyphs.Store{Path: in.Glyphs, Spec: glyphs.Spec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+		Bases:     &BaseStore{Path: in.Bases, Spec: baseSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+		Creatures: &CreatureStore{Path: in.Creatures, Spec: creatureSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+		Portals:   &PortalStore{Path: in.Portals, Spec: portalSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+		Systems:   &SystemStore{Path: in.Systems, Spec: systemSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+		Loadouts:  &LoadoutStore{Path: in.Loadouts, Spec: loadoutSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+
+		CustomRecipes: &CustomRecipeStore{Path: in.CustomRecipes, Spec: customRecipeSpec, ImageDir: in.Images, KeepBackups: in.StoreBackups},
+
+		GlyphHistory: newHistory(in.Glyphs),
+	}
+}
+
+// openStores loads every store, migrating old files.
+func (in *instance) openStores() (*catalogues, error) {
+	c := in.catalogues()
+	for _, s := range []struct {
+		name string
+		load func() error
+	}{
+		{"glyphs", c.Glyphs.Load},
+		{"bases", c.Bases.Load},
+		{"creatures", c.Creatures.Load},
+		{"portals", c.Portals.Load},
+		{"systems", c.Systems.Load},
+		{"loadouts", c.Loadouts.Load},
+		{"custom recipes", c.CustomRecipes.Load},
+	} {
+		if err := s.load(); err != nil {
+			return nil, fmt.Errorf("load %s: %w", s.name, err)
+		}
+	}
+	return c, nil
+}
+
+// ---------- Command line: serve ----------
+
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var in instance
+	var lo listenOptions
+	var config, remote, socketMode, pprofAddr string
+	var oidcIssuer, oidcClientID, oidcSecret, oidcRedirect, oidcScopes string
+	var watch, maint, checkUpdates, demo, logJSON bool
+	var logLevel, match string
+	var matchThreshold, matchBonus float64
+	var remoteEvery, demoEvery time.Duration
+	in.register(fs)
+	lo.TLS.register(fs)
+	fs.StringVar(&lo.Addr, "addr", ":8080", "Listen address, or unix:PATH for a Unix socket (:443 unless given, with -tls-cert or -autocert-domain; unused under systemd socket activation)")
+	fs.StringVar(&socketMode, "socket-mode", "0660", "Permissions of the unix: socket of -addr")
+	fs.StringVar(&pprofAddr, "pprof", "", "Serve the Go profiler (/debug/pprof/) on this separate address, such as 127.0.0.1:6060; it has no sign-in")
+	fs.StringVar(&config, "config", "", "YAML file with settings re-read on SIGHUP or POST /api/admin/config/reload (cors_origins, users, require_login, oidc, glyph_duplicates; flags are read at start only)")
+	fs.StringVar(&oidcIssuer, "oidc-issuer", "", "Also sign in with this OpenID Connect provider (https://sso.example.com/realms/main); the oidc section of -config maps its claims to roles")
+	fs.StringVar(&oidcClientID, "oidc-client-id", "", "Client ID registered with -oidc-issuer")
+	fs.StringVar(&oidcSecret, "oidc-client-secret", "", "Client secret for -oidc-client-id, if it has one (better set as NMS_OIDC_CLIENT_SECRET)")
+	fs.StringVar(&oidcRedirect, "oidc-redirect-url", "", "Callback URL registered with the provider (default: /login/oidc/callback on the host the browser asked for)")
+	fs.StringVar(&oidcScopes, "oidc-scopes", "openid profile email", "Scopes to ask -oidc-issuer for")
+	fs.StringVar(&match, "match", "levenshtein", "How typed ingredient names are matched: exact, prefix, substring, levenshtein or jaro-winkler")
+	fs.Float64Var(&matchThreshold, "match-threshold", 0, "Cut-off of -match: largest score for levenshtein (default 2.5), smallest similarity for jaro-winkler (default 0.85)")
+	fs.Float64Var(&matchBonus, "match-bonus", 0, "With -match levenshtein, factor on the score when one name contains the other (default 0.5)")
+	fs.BoolVar(&watch, "watch", true, "Reload the recipe CSVs when they change on disk")
+	fs.BoolVar(&maint, "maintenance", false, "Start in maintenance mode: pages show a status page and writes fail until POST /api/admin/maintenance turns it off")
+	fs.BoolVar(&checkUpdates, "check-updates", false, "Check GitHub for a newer release at start and once a day, shown in /api/version and on /admin")
+	fs.StringVar(&remote, "remote-dataset", "", "Mount the recipes read-only from another instance (https://host/api/export/recipes.json) instead of the local CSVs")
+	fs.DurationVar(&remoteEvery, "remote-refresh", 15*time.Minute, "How often to check -remote-dataset for changes (0 fetches it once)")
+	fs.BoolVar(&demo, "demo", false, "Public demo: sample glyphs and the built-in recipes, kept in memory only; the admin page and API are off")
+	fs.DurationVar(&demoEvery, "demo-reset", time.Hour, "With -demo, how often to put the sample data back (0 never)")
+	fs.BoolVar(&lo.AccessLog, "access-log", true, "Log each request: method, path, status, latency, bytes and its X-Request-ID")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	fs.BoolVar(&logJSON, "log-json", false, "Write logs to stderr as JSON lines")
+	if err := parseFlags(fs, args); err != nil {
+		os.Exit(2)
+	}
+	if logJSON || logLevel != "info" {
+		if err := setupLogging(os.Stderr, logLevel, logJSON); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := in.resolve(); err != nil {
+		log.Fatal(err)
+	}
+	if err := lo.TLS.check(in.DataDir); err != nil {
+		log.Fatal(err)
+	}
+	if lo.TLS.enabled() && !in.given["addr"] {
+		lo.Addr = ":443"
+	}
+	mode, err := parseSocketMode(socketMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lo.SocketMode = mode
+	if (oidcIssuer == "") != (oidcClientID == "") {
+		log.Fatal("-oidc-issuer and -oidc-client-id go together")
+	}
+	matcher, err := norm.NewMatcher(match, matchThreshold, matchBonus)
+	if err != nil {
+		log.Fatalf("-match: %v", err)
+	}
+	if demo {
+		if err := in.demo(); err != nil {
+			log.Fatal(err)
+		}
+		watch, remote = false, ""
+	}
+
+	values, valuesSrc, err := in.loadValues()
+	if err != nil {
+		log.Fatalf("load values csv: %v", err)
+	}
+	aliases, err := recipes.LoadAliasesCSV(in.Aliases)
+	if err != nil {
+		log.Fatalf("load aliases csv: %v", err)
+	}
+	rec, err := newLiveRecipes(&in, recipeOpts{values: values, aliases: aliases, match: matcher})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	techDB, techSrc, err := in.loadTech()
+	if err != nil {
+		log.Fatalf("load technologies csv: %v", err)
+	}
+
+	c, err := in.openStores()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if in.Demo {
+		if err := c.runDemo(in.Images, demoEvery); err != nil {
+			log.Fatalf("demo: %v", err)
+		}
+		log.Printf("demo mode: nothing is saved; sample data is put back every %v", demoEvery)
+	}
+	cfg, err := newLiveConfig(config)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	if oidcIssuer != "" {
+		cfg.oidc = newOIDCClient(oidcIssuer, oidcClientID, oidcSecret, oidcRedirect, oidcScopes)
+		log.Printf("oidc: signing in with %s as %s", oidcIssuer, oidcClientID)
+	}
+
+	log.Printf("technologies: %d | csv: %s", len(techDB.Techs), techSrc)
+	log.Printf("item values: %d | csv: %s", len(values), valuesSrc)
+	log.Printf("ingredient aliases: %d | csv: %s", aliases.Len(), in.Aliases)
+	log.Printf("glyphs: %d | file: %s", c.Glyphs.Len(), in.Glyphs)
+	log.Printf("bases: %d | file: %s", c.Bases.Len(), in.Bases)
+	log.Printf("creatures: %d | file: %s", c.Creatures.Len(), in.Creatures)
+	log.Printf("portal rolls: %d | file: %s", c.Portals.Len(), in.Portals)
+	log.Printf("systems: %d | file: %s", c.Systems.Len(), in.Systems)
+	log.Printf("loadouts: %d | file: %s", c.Loadouts.Len(), in.Loadouts)
+	log.Printf("custom recipes: %d | file: %s", c.CustomRecipes.Len(), in.CustomRecipes)
+
+	if remote != "" {
+		rec.mount(remote, remoteEvery)
+	} else if watch {
+		if err := rec.watch(); err != nil {
+			log.Printf("recipe watcher disabled: %v", err)
+		}
+	}
+
+	if pprofAddr != "" {
+		servePprof(pprofAddr)
+	}
+
+	var updates *updateChecker
+	if checkUpdates {
+		updates = &updateChecker{}
+		updates.start()
+	}
+
+	if err := serve(rec, techDB, c, itemIcons{dir: in.iconDir()}, cfg, newMaintenance(maint), updates, lo); err != nil {
+		log.Fatal(err)
+	}
+}
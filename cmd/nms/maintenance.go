@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ---------- Maintenance mode ----------
+
+// maintenanceState is whether the server is down for planned work, as
+// POST /api/admin/maintenance sets it.
+type maintenanceState struct {
+	On      bool      `json:"on"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+}
+
+// maintenance is the current state, swapped atomically. While it is on,
+// pages answer with a status page and API writes with 503; reads and the
+// admin API keep working, so imports and migrations can run without users
+// seeing connection errors.
+type maintenance struct {
+	cur atomic.Pointer[maintenanceState]
+}
+
+func newMaintenance(on bool) *maintenance {
+	m := &maintenance{}
+	m.set(on, "")
+	return m
+}
+
+func (m *maintenance) set(on bool, msg string) *maintenanceState {
+	st := &maintenanceState{On: on}
+	if on {
+		st.Message, st.Since = msg, time.Now().UTC()
+	}
+	m.cur.Store(st)
+	return st
+}
+
+// maintenanceRetryAfter is the Retry-After sent with every 503.
+const maintenanceRetryAfter = "120"
+
+// handler serves GET and POST /api/admin/maintenance; POST takes
+// {"on": true, "message": "..."}.
+func (m *maintenance) handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, m.cur.Load())
+		return
+	}
+	var req struct {
+		On      bool   `json:"on"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(req.Message) > 512 {
+		http.Error(w, "message too long (max 512 chars)", http.StatusBadRequest)
+		return
+	}
+	st := m.set(req.On, req.Message)
+	if st.On {
+		log.Printf("maintenance mode on")
+	} else {
+		log.Printf("maintenance mode off")
+	}
+	writeJSON(w, st)
+}
+
+// withMaintenance holds back what maintenance mode stops: writes get 503
+// and browsers asking for a page get the status page. Other GETs (the API,
+// photos, icons, fonts) and the admin page and API go through.
+func withMaintenance(h http.Handler, m *maintenance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := m.cur.Load()
+		if !st.On || r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		page := !strings.HasPrefix(r.URL.Path, "/api/") && strings.Contains(r.Header.Get("Accept"), "text/html")
+		switch {
+		case read && !page:
+			h.ServeHTTP(w, r)
+		case page:
+			var buf bytes.Buffer
+			data := pageData{
+				Title:   "Maintenance",
+				Heading: "Down for maintenance",
+				BgDark2: "#18534a",
+				Big:     bigMode(w, r),
+				Item:    st,
+			}
+			if err := maintenanceTmpl.ExecuteTemplate(&buf, "maintenance", data); err != nil {
+				http.Error(w, "template error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(buf.Bytes())
+		default:
+			msg := "down for maintenance; read-only until it ends"
+			if st.Message != "" {
+				msg += ": " + st.Message
+			}
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			http.Error(w, msg, http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Custom recipes ----------
+
+// CustomRecipe is a recipe a user keeps for themselves: one the scrape
+// missed, or their own take on a shared one. A custom recipe with the same
+// inputs and output as a shared recipe overrides it for that user.
+type CustomRecipe struct {
+	store.Meta
+	Owner    string   `json:"owner"`
+	Dataset  string   `json:"dataset"` // food or refiner
+	Inputs   []string `json:"inputs"`
+	InputQty []int    `json:"input_qty,omitempty"` // per input; 1 each when left out
+	Output   string   `json:"output"`
+	Qty      int      `json:"qty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+type CustomRecipeStore = store.Collection[CustomRecipe, *CustomRecipe]
+
+func (c *CustomRecipe) recipe() recipes.Recipe {
+	return recipes.Recipe{Inputs: c.Inputs, InputQty: c.InputQty, Output: c.Output, Qty: c.Qty}
+}
+
+var customRecipeSpec = store.Spec[CustomRecipe]{
+	Kind: "customrecipe",
+	Validate: func(c *CustomRecipe) error {
+		c.Owner = strings.TrimSpace(c.Owner)
+		c.Dataset = strings.ToLower(strings.TrimSpace(c.Dataset))
+		c.Output = strings.TrimSpace(c.Output)
+		c.Note = strings.TrimSpace(c.Note)
+		var ins []string
+		var qs []int
+		for i, in := range c.Inputs {
+			if in = strings.TrimSpace(in); in != "" {
+				ins = append(ins, in)
+				if i < len(c.InputQty) {
+					qs = append(qs, c.InputQty[i])
+				}
+			}
+		}
+		if len(c.InputQty) > 0 && len(qs) != len(ins) {
+			return store.Invalid("input_qty", "input_qty must give a qty for each input")
+		}
+		c.Inputs, c.InputQty = ins, qs
+		if c.Qty == 0 {
+			c.Qty = 1
+		}
+
+		if c.Owner == "" {
+			return store.Invalid("owner", "owner required")
+		}
+		if c.Dataset != "food" && c.Dataset != "refiner" {
+			return store.Invalid("dataset", "dataset must be food or refiner")
+		}
+		if len(c.Inputs) == 0 || len(c.Inputs) > 3 {
+			return store.Invalid("inputs", "a recipe takes 1 to 3 inputs")
+		}
+		if c.Output == "" {
+			return store.Invalid("output", "output required")
+		}
+		for _, name := range append([]string{c.Output}, c.Inputs...) {
+			if utf8.RuneCountInString(name) > 64 {
+				return errors.New("item name too long (max 64 chars)")
+			}
+		}
+		if c.Qty < 1 || c.Qty > 9999 {
+			return store.Invalid("qty", "qty must be 1-9999")
+		}
+		for _, q := range c.InputQty {
+			if q < 1 || q > 9999 {
+				return store.Invalid("input_qty", "input_qty must be 1-9999")
+			}
+		}
+		if utf8.RuneCountInString(c.Note) > 512 {
+			return store.Invalid("note", "note too long (max 512 chars)")
+		}
+		return nil
+	},
+	// one per owner, dataset and recipe
+	Key: func(c *CustomRecipe) string {
+		return strings.ToLower(c.Owner) + "\x00" + c.Dataset + "\x00" + c.recipe().Key()
+	},
+	Text: func(c *CustomRecipe) string {
+		return strings.Join(c.Inputs, " ") + " " + c.Output + " " + c.Note
+	},
+}
+
+// ---------- Custom recipe overlay ----------
+
+// overlay layers each signed-in user's custom recipes over the shared
+// datasets. Nothing is merged into the datasets themselves: the recipe
+// handlers ask for the requesting user's recipes and combine them per
+// request.
+type overlay struct {
+	store *CustomRecipeStore
+	cfg   *liveConfig
+}
+
+// mine returns the custom recipes of the user the request signs in as, for
+// one dataset ("" for both).
+func (o overlay) mine(r *http.Request, dataset string) []CustomRecipe {
+	user, ok := o.cfg.user(r)
+	if !ok {
+		return nil
+	}
+	return o.store.Filter(func(c *CustomRecipe) bool {
+		return c.Owner == user && (dataset == "" || c.Dataset == dataset)
+	})
+}
+
+// customNames returns every item the custom recipes mention, by normalized
+// name.
+func customNames(mine []CustomRecipe) map[string]string {
+	names := map[string]string{}
+	for _, c := range mine {
+		for _, n := range append([]string{c.Output}, c.Inputs...) {
+			names[norm.Key(n)] = n
+		}
+	}
+	return names
+}
+
+// mergeCustom puts the user's custom recipes that take every one of have
+// in front of the shared suggestions, dropping the shared recipes they
+// override. Each custom one is labelled "custom", or "override" when it
+// replaces a shared recipe.
+func mergeCustom(db recipes.Store, mine []CustomRecipe, have []string, shared []recipes.Recipe) []suggestion {
+	var out []suggestion
+	overridden := map[string]bool{}
+	for _, c := range mine {
+		r := c.recipe()
+		key, source := r.Key(), "custom"
+		for _, s := range db.ByOutput(r.Output) {
+			if s.Key() == key {
+				source = "override"
+				overridden[key] = true
+				break
+			}
+		}
+		if len(have) > 0 && takesAll(r, have) {
+			out = append(out, suggestion{Recipe: r, Source: source, CustomID: c.ID, Note: c.Note})
+		}
+	}
+	for _, s := range shared {
+		if !overridden[s.Key()] {
+			out = append(out, suggestion{ID: s.ID(), Recipe: s})
+		}
+	}
+	return out
+}
+
+func takesAll(r recipes.Recipe, have []string) bool {
+	ins := map[string]bool{}
+	for _, in := range r.Inputs {
+		ins[norm.Key(in)] = true
+	}
+	for _, h := range have {
+		if !ins[norm.Key(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// ingredients adds the user's custom inputs to a dataset's ingredient list.
+func (o overlay) ingredients(r *http.Request, dataset string, shared []string) []string {
+	mine := o.mine(r, dataset)
+	if len(mine) == 0 {
+		return shared
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(shared))
+	for _, n := range shared {
+		seen[norm.Key(n)] = true
+		out = append(out, n)
+	}
+	for _, c := range mine {
+		for _, in := range c.Inputs {
+			if k := norm.Key(in); !seen[k] {
+				seen[k] = true
+				out = append(out, in)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// requireUser answers 401 unless the request signs in as a configured user.
+func (o overlay) requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := o.cfg.user(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "sign in first (on /login, or with Authorization: Bearer TOKEN)", http.StatusUnauthorized)
+	}
+	return user, ok
+}
+
+// routes serves /api/my/recipes: the signed-in user's custom recipes, which
+// no one else can list or change.
+func (o overlay) routes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/my/recipes", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := o.requireUser(w, r); !ok {
+			return
+		}
+		mine := o.mine(r, r.URL.Query().Get("dataset"))
+		if mine == nil {
+			mine = []CustomRecipe{}
+		}
+		writeList(w, r, mine)
+	})
+	mux.HandleFunc("POST /api/my/recipes", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := o.requireUser(w, r)
+		if !ok {
+			return
+		}
+		var c CustomRecipe
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		c.Owner = user
+		c, err := o.store.Add(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, c)
+	})
+	mux.HandleFunc("PUT /api/my/recipes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := o.requireUser(w, r)
+		if !ok || !o.owns(w, user, r.PathValue("id")) {
+			return
+		}
+		var c CustomRecipe
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		c.Owner = user
+		c, err := o.store.Update(r.PathValue("id"), c)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, store.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, c)
+	})
+	mux.HandleFunc("DELETE /api/my/recipes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := o.requireUser(w, r)
+		if !ok || !o.owns(w, user, r.PathValue("id")) {
+			return
+		}
+		if _, err := o.store.Delete(r.PathValue("id")); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "recipe not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// owns answers 404 unless user owns the recipe, so other users' IDs look
+// the same as missing ones.
+func (o overlay) owns(w http.ResponseWriter, user, id string) bool {
+	c, ok := o.store.Get(id)
+	if !ok || c.Owner != user {
+		http.Error(w, "recipe not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
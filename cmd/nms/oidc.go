@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // SHA-384 and SHA-512 for RS384, ES384 and the like
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- OIDC sign-in ----------
+
+// With -oidc-issuer and -oidc-client-id, /login also offers signing in with
+// an OpenID Connect provider: /login/oidc sends the browser there (the
+// authorization code flow with PKCE) and /login/oidc/callback checks the ID
+// token it comes back with and starts a session. The oidc section of
+// -config says who that is (see oidcMapping).
+
+const (
+	oidcLoginTTL = 10 * time.Minute // to come back from the provider
+	oidcKeysMin  = time.Minute      // between fetches of the provider's keys
+	oidcSkew     = time.Minute      // of the provider's clock
+)
+
+type oidcClient struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string // "" for /login/oidc/callback on the host asked
+	scopes       []string
+	http         *http.Client
+
+	mu      sync.Mutex
+	meta    *oidcMeta
+	keys    map[string]crypto.PublicKey // by key ID
+	keysAt  time.Time
+	pending map[string]oidcLogin // by state
+}
+
+// oidcMeta is the part of the provider's discovery document used here.
+type oidcMeta struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a sign-in under way, from /login/oidc to the callback.
+type oidcLogin struct {
+	nonce    string
+	verifier string // PKCE
+	redirect string
+	next     string
+	expires  time.Time
+}
+
+func newOIDCClient(issuer, clientID, clientSecret, redirectURL, scopes string) *oidcClient {
+	return &oidcClient{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       strings.Fields(strings.ReplaceAll(scopes, ",", " ")),
+		http:         &http.Client{Timeout: 15 * time.Second},
+		pending:      map[string]oidcLogin{},
+	}
+}
+
+// discover fetches the provider's discovery document the first time it is
+// needed, so the server starts while the provider is down.
+func (o *oidcClient) discover(ctx context.Context) (*oidcMeta, error) {
+	o.mu.Lock()
+	meta := o.meta
+	o.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	var m oidcMeta
+	if err := o.getJSON(ctx, o.issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(m.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("provider says its issuer is %q, not %q", m.Issuer, o.issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks an endpoint")
+	}
+	o.mu.Lock()
+	o.meta = &m
+	o.mu.Unlock()
+	return &m, nil
+}
+
+func (o *oidcClient) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return o.do(req, v)
+}
+
+func (o *oidcClient) do(req *http.Request, v any) error {
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// startHandler serves GET /login/oidc, sending the browser to the provider.
+func (o *oidcClient) startHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := o.discover(r.Context())
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "the sign-in provider is not answering", http.StatusBadGateway)
+		return
+	}
+	var state, nonce, verifier string
+	for _, p := range []*string{&state, &nonce, &verifier} {
+		if *p, err = randomToken(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	redirect := o.redirectURL
+	if redirect == "" {
+		redirect = requestBase(r) + "/login/oidc/callback"
+	}
+	now := time.Now()
+	o.mu.Lock()
+	for s, l := range o.pending {
+		if now.After(l.expires) {
+			delete(o.pending, s)
+		}
+	}
+	o.pending[state] = oidcLogin{nonce: nonce, verifier: verifier, redirect: redirect, next: localPath(r.FormValue("next")), expires: now.Add(oidcLoginTTL)}
+	o.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.clientID},
+		"redirect_uri":          {redirect},
+		"scope":                 {strings.Join(o.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusSeeOther)
+}
+
+// callbackHandler serves GET /login/oidc/callback, where the provider
+// sends the browser back with a code to trade for the ID token.
+func (o *oidcClient) callbackHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fail := func(msg string, err error) {
+			if err != nil {
+				log.Printf("oidc: %s: %v", msg, err)
+			}
+			writeLoginPage(w, r, "Sign in", loginPage{Next: "/", SSO: true, Error: "Signing in with SSO failed: " + msg + "."}, http.StatusUnauthorized)
+		}
+		q := r.URL.Query()
+		o.mu.Lock()
+		login, ok := o.pending[q.Get("state")]
+		delete(o.pending, q.Get("state"))
+		o.mu.Unlock()
+		switch {
+		case !ok || time.Now().After(login.expires):
+			fail("the sign-in expired or was not started here", nil)
+			return
+		case q.Get("error") != "":
+			fail(strings.TrimSpace(q.Get("error")+" "+q.Get("error_description")), nil)
+			return
+		}
+		claims, err := o.exchange(r.Context(), q.Get("code"), login)
+		if err != nil {
+			fail("the provider's answer did not check out", err)
+			return
+		}
+		cfg := lc.Load()
+		id, err := cfg.OIDC.identify(claims)
+		if err != nil {
+			fail(err.Error(), nil)
+			return
+		}
+		if _, local := cfg.Users[id.user]; id.role == 0 && !local {
+			fail(id.name+" is linked to a user who is gone", nil)
+			return
+		}
+		log.Printf("oidc: %s signs in as %s", id.name, id.user)
+		if err := lc.startSession(w, r, id.user, id.role); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, login.next, http.StatusSeeOther)
+	}
+}
+
+// exchange trades the code for the ID token and returns its claims once
+// it checks out.
+func (o *oidcClient) exchange(ctx context.Context, code string, login oidcLogin) (map[string]any, error) {
+	meta, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.redirect},
+		"code_verifier": {login.verifier},
+	}
+	if o.clientSecret == "" {
+		form.Set("client_id", o.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := o.do(req, &tok); err != nil {
+		return nil, err
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("no id_token in the token response")
+	}
+	claims, err := o.verify(ctx, tok.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return nil, errors.New("nonce does not match")
+	}
+	return claims, nil
+}
+
+// verify checks the signature of an ID token and that it was issued by the
+// provider for this client and has not expired, and returns its claims.
+func (o *oidcClient) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token is not a JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id_token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id_token signature: %w", err)
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id_token claims: %w", err)
+	}
+	meta, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	switch iss, _ := claims["iss"].(string); {
+	case iss != meta.Issuer:
+		return nil, fmt.Errorf("id_token issued by %q", iss)
+	case !slices.Contains(stringsClaim(claims["aud"]), o.clientID):
+		return nil, errors.New("id_token is not for this client")
+	case now.After(time.Unix(int64(exp), 0).Add(oidcSkew)):
+		return nil, errors.New("id_token has expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the provider's signing key with the given ID (any one key
+// when the token names none), fetching the keys again when it is new.
+func (o *oidcClient) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	find := func() crypto.PublicKey {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if k, ok := o.keys[kid]; ok {
+			return k
+		}
+		if kid == "" && len(o.keys) == 1 {
+			for _, k := range o.keys {
+				return k
+			}
+		}
+		return nil
+	}
+	if k := find(); k != nil {
+		return k, nil
+	}
+	o.mu.Lock()
+	recent := time.Since(o.keysAt) < oidcKeysMin
+	o.mu.Unlock()
+	if recent {
+		return nil, fmt.Errorf("no provider key %q", kid)
+	}
+	meta, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Printf("oidc: key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	o.mu.Lock()
+	o.keys, o.keysAt = keys, time.Now()
+	o.mu.Unlock()
+	if k := find(); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no provider key %q", kid)
+}
+
+// jwk is a public key of a JWK set (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		size := map[string]int{"P-256": 32, "P-384": 48}[k.Crv]
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("unsupported EC key on %q", k.Crv)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if size == 32 {
+			return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P384(), point)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWS checks a signature made with one of the asymmetric JWS
+// algorithms (RS, PS and ES with SHA-256, -384 or -512).
+func verifyJWS(alg string, key crypto.PublicKey, input, sig []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	h, ok := hashes[strings.TrimLeft(alg, "RSPE")]
+	if !ok || len(alg) != 5 {
+		return fmt.Errorf("unsupported id_token algorithm %q", alg)
+	}
+	d := h.New()
+	d.Write(input)
+	digest := d.Sum(nil)
+	bad := errors.New("id_token signature does not verify")
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(pub, h, digest, sig)
+		case "PS":
+			err = rsa.VerifyPSS(pub, h, digest, sig, nil)
+		default:
+			return fmt.Errorf("%s with an RSA key", alg)
+		}
+		if err != nil {
+			return bad
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return bad
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return bad
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key %T", key)
+}
+
+// stringsClaim reads a claim that is a string or a list of them.
+func stringsClaim(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case []any:
+		var out []string
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// ---------- OIDC users and roles ----------
+
+// oidcMapping is the oidc section of -config:
+//
+//	oidc:
+//	  user_claim: preferred_username  # the name logged at sign in (default: preferred_username, else a verified email, else sub)
+//	  roles_claim: groups             # claim listing the user's groups or roles (default: groups)
+//	  roles:                          # what a value of it makes them here; the highest wins
+//	    nms-admins: admin
+//	    nms-editors: editor
+//	  default_role: viewer            # for everyone else; leave out to turn them away
+//	  users:                          # provider accounts, by sub, that are users of the config
+//	    "248289761001": alice
+//
+// Someone signing in with the provider is known by its issuer and their
+// sub claim, never by a name they may be able to choose. Only an account
+// listed under users is that user of the config, with that user's role,
+// whatever the claims say.
+type oidcMapping struct {
+	UserClaim   string            `yaml:"user_claim" json:"user_claim,omitempty"`
+	RolesClaim  string            `yaml:"roles_claim" json:"roles_claim,omitempty"`
+	Roles       map[string]string `yaml:"roles" json:"roles,omitempty"`
+	DefaultRole string            `yaml:"default_role" json:"default_role,omitempty"`
+	Users       map[string]string `yaml:"users" json:"-"`
+}
+
+func (m oidcMapping) check() error {
+	for v, name := range m.Roles {
+		if _, err := parseRole(name); err != nil || name == "" {
+			return fmt.Errorf("roles: %q: role must be viewer, editor or admin", v)
+		}
+	}
+	if _, err := parseRole(m.DefaultRole); err != nil {
+		return fmt.Errorf("default_role: %w", err)
+	}
+	for sub, user := range m.Users {
+		if sub == "" || user == "" {
+			return fmt.Errorf("users: %q: %q: both the sub and the user are needed", sub, user)
+		}
+	}
+	return nil
+}
+
+// oidcUserPrefix starts the user name of everyone signing in with OIDC who
+// is not a user of the config, so they never share a name with one.
+const oidcUserPrefix = "oidc:"
+
+// oidcIdentity is who the claims of an ID token say signs in.
+type oidcIdentity struct {
+	user string // the user of the config they are linked to, else oidcUserPrefix+sub@issuer
+	name string // to log
+	role role   // 0 for a user of the config
+}
+
+// identify returns who the claims of an ID token are: a user of the config
+// when their sub is linked to one, else someone with the role their
+// claims give.
+func (m oidcMapping) identify(claims map[string]any) (oidcIdentity, error) {
+	iss, _ := claims["iss"].(string)
+	sub, _ := claims["sub"].(string)
+	if iss == "" || sub == "" {
+		return oidcIdentity{}, errors.New("the provider did not say who you are")
+	}
+	if user, ok := m.Users[sub]; ok {
+		return oidcIdentity{user: user, name: user}, nil
+	}
+	id := oidcIdentity{user: oidcUserPrefix + sub + "@" + strings.TrimPrefix(strings.TrimPrefix(iss, "https://"), "http://")}
+	claimed := []string{"preferred_username", "email", "sub"}
+	if m.UserClaim != "" {
+		claimed = []string{m.UserClaim}
+	}
+	for _, c := range claimed {
+		if c == "email" && !verifiedEmail(claims) {
+			continue
+		}
+		if id.name, _ = claims[c].(string); strings.TrimSpace(id.name) != "" {
+			break
+		}
+	}
+	if id.name = strings.TrimSpace(id.name); id.name == "" {
+		if m.UserClaim == "email" {
+			return oidcIdentity{}, errors.New("the provider has not verified your email")
+		}
+		id.name = sub
+	}
+	rolesClaim := m.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "groups"
+	}
+	for _, v := range stringsClaim(claims[rolesClaim]) {
+		if ro, err := parseRole(m.Roles[v]); err == nil && m.Roles[v] != "" && ro > id.role {
+			id.role = ro
+		}
+	}
+	if id.role == 0 && m.DefaultRole != "" {
+		id.role, _ = parseRole(m.DefaultRole)
+	}
+	if id.role == 0 {
+		return oidcIdentity{}, fmt.Errorf("%s has no role here", id.name)
+	}
+	return id, nil
+}
+
+// verifiedEmail reports whether the claims vouch for their email; some
+// providers send email_verified as a string.
+func verifiedEmail(claims map[string]any) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
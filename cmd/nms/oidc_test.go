@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://sso.example.com/realms/main"
+	testClientID = "nms"
+)
+
+// testOIDCClient is a client whose discovery document and keys are known
+// already, so verify never goes to the network.
+func testOIDCClient(keys map[string]crypto.PublicKey) *oidcClient {
+	o := newOIDCClient(testIssuer, testClientID, "", "", "openid")
+	o.meta = &oidcMeta{Issuer: testIssuer, AuthorizationEndpoint: testIssuer + "/auth", TokenEndpoint: testIssuer + "/token", JWKSURI: testIssuer + "/certs"}
+	o.keys, o.keysAt = keys, time.Now()
+	return o
+}
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signRS256 makes an RS256 JWS of claims with key kid.
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	input := segment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	input := segment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := testOIDCClient(map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey})
+
+	now := time.Now().Unix()
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{"iss": testIssuer, "aud": testClientID, "sub": "1234", "exp": now + 300, "iat": now}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	valid := signRS256(t, rsaKey, "rsa", claims(nil))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		err   string // in the error; "" for none
+	}{
+		{"valid RS256", valid, ""},
+		{"valid ES256", signES256(t, ecKey, "ec", claims(nil)), ""},
+		{"audience list", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { c["aud"] = []string{"other", testClientID} })), ""},
+		{"within clock skew", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { c["exp"] = now - 30 })), ""},
+		{"signed by another key", signRS256(t, otherKey, "rsa", claims(nil)), "does not verify"},
+		{"claims changed after signing", parts[0] + "." + segment(t, claims(func(c map[string]any) { c["sub"] = "admin" })) + "." + parts[2], "does not verify"},
+		{"signature truncated", valid[:len(valid)-8], "does not verify"},
+		{"no signature", parts[0] + "." + parts[1] + ".", "does not verify"},
+		{"alg none", segment(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + parts[1] + ".", "unsupported"},
+		{"alg HS256", segment(t, map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + parts[1] + "." + parts[2], "unsupported"},
+		{"ES256 header on an RSA key", segment(t, map[string]string{"alg": "ES256", "kid": "rsa"}) + "." + parts[1] + "." + parts[2], "ES256"},
+		{"wrong audience", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { c["aud"] = "other" })), "not for this client"},
+		{"no audience", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { delete(c, "aud") })), "not for this client"},
+		{"wrong issuer", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), "issued by"},
+		{"expired", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { c["exp"] = now - 3600 })), "expired"},
+		{"no expiry", signRS256(t, rsaKey, "rsa", claims(func(c map[string]any) { delete(c, "exp") })), "expired"},
+		{"unknown key", signRS256(t, rsaKey, "gone", claims(nil)), "no provider key"},
+		{"not a JWS", "abc.def", "not a JWS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := o.verify(context.Background(), tt.token)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("verify: %v", err)
+			case tt.err == "" && got["sub"] != "1234":
+				t.Errorf("claims = %v", got)
+			case tt.err != "" && err == nil:
+				t.Fatalf("verify succeeded, want an error about %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("error = %q, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOIDCIdentify(t *testing.T) {
+	m := oidcMapping{
+		Roles:       map[string]string{"nms-admins": "admin", "nms-editors": "editor"},
+		DefaultRole: "viewer",
+		Users:       map[string]string{"linked-sub": "alice"},
+	}
+	noDefault := m
+	noDefault.DefaultRole = ""
+	byEmail := m
+	byEmail.UserClaim = "email"
+
+	claims := func(kv ...any) map[string]any {
+		c := map[string]any{"iss": testIssuer, "sub": "1234"}
+		for i := 0; i < len(kv); i += 2 {
+			c[kv[i].(string)] = kv[i+1]
+		}
+		return c
+	}
+	const unlinked = oidcUserPrefix + "1234@sso.example.com/realms/main"
+	tests := []struct {
+		name    string
+		m       oidcMapping
+		claims  map[string]any
+		user    string
+		display string
+		role    role
+		err     string
+	}{
+		{"linked by sub", m, claims("sub", "linked-sub", "groups", []any{"nms-admins"}), "alice", "alice", 0, ""},
+		{"same name as a user is not that user", m, claims("preferred_username", "alice", "groups", []any{"nms-admins"}), unlinked, "alice", roleAdmin, ""},
+		{"verified email as a user's name", m, claims("email", "alice", "email_verified", true), unlinked, "alice", roleViewer, ""},
+		{"highest role wins", m, claims("groups", []any{"nms-editors", "nms-admins", "other"}), unlinked, "1234", roleAdmin, ""},
+		{"default role", m, claims("preferred_username", "bob", "groups", []any{"other"}), unlinked, "bob", roleViewer, ""},
+		{"no role", noDefault, claims("preferred_username", "bob"), "", "", 0, "bob has no role here"},
+		{"roles claim from another group", noDefault, claims("groups", []any{"nms-editors"}), unlinked, "1234", roleEditor, ""},
+		{"unverified email skipped", m, claims("email", "alice@example.com"), unlinked, "1234", roleViewer, ""},
+		{"unverified email string", m, claims("email", "alice@example.com", "email_verified", "false"), unlinked, "1234", roleViewer, ""},
+		{"verified email", m, claims("email", "alice@example.com", "email_verified", true), unlinked, "alice@example.com", roleViewer, ""},
+		{"verified email as a string", m, claims("email", "alice@example.com", "email_verified", "true"), unlinked, "alice@example.com", roleViewer, ""},
+		{"email claim unverified", byEmail, claims("email", "alice@example.com", "email_verified", false), "", "", 0, "not verified"},
+		{"email claim verified", byEmail, claims("email", "alice@example.com", "email_verified", true), unlinked, "alice@example.com", roleViewer, ""},
+		{"no sub", m, map[string]any{"iss": testIssuer, "preferred_username": "bob"}, "", "", 0, "did not say who you are"},
+		{"no issuer", m, map[string]any{"sub": "1234"}, "", "", 0, "did not say who you are"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := tt.m.identify(tt.claims)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one about %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.user != tt.user || id.name != tt.display || id.role != tt.role {
+				t.Errorf("identity = %+v, want user %q, name %q, role %v", id, tt.user, tt.display, tt.role)
+			}
+		})
+	}
+}
+
+// An OIDC session never signs in as the user of the config sharing its
+// name, even when an OIDC user name was crafted to look like one.
+func TestOIDCSessionIsNotLocalUser(t *testing.T) {
+	cfg := defaultRuntimeConfig()
+	cfg.Users = map[string]userEntry{"alice": {Password: testHash(t, "secret"), Admin: true}}
+	lc := testConfig(t, cfg)
+	lc.oidc = testOIDCClient(nil)
+	tok, err := lc.sessions.start("alice", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newCookieRequest(tok)
+	if _, ro, ok := lc.signedIn(req); !ok || ro != roleViewer {
+		t.Errorf("role = %v (%v), want viewer", ro, ok)
+	}
+	lc.oidc = nil
+	if _, _, ok := lc.signedIn(req); ok {
+		t.Error("OIDC session still signed in with OIDC turned off")
+	}
+}
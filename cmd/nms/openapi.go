@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/glyphocr"
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- OpenAPI ----------
+
+// apiOp documents an endpoint in /api/openapi.json. The request and
+// response schemas are made from Go values of the types the handler reads
+// and writes (see schemaGen), so they follow the code.
+type apiOp struct {
+	Method, Path string // as registered, /api/...
+	Tag, Summary string
+	Query        []apiParam
+	Body         any    // JSON request body; nil for none
+	Resp         any    // JSON response; nil for none
+	RespType     string // when the response is not JSON
+	Status       int    // on success, when not 200
+}
+
+type apiParam struct {
+	Name, Type, Desc string
+	Required         bool
+}
+
+var (
+	qParam      = apiParam{Name: "q", Type: "string", Desc: "search text"}
+	fieldsParam = apiParam{Name: "fields", Type: "string", Desc: "comma-separated fields to keep in each listed object"}
+	sourceParam = apiParam{Name: "source", Type: "string", Desc: "food or refiner; both when left out"}
+	limitParam  = apiParam{Name: "limit", Type: "integer", Desc: "page size (default 50)"}
+	offsetParam = apiParam{Name: "offset", Type: "integer", Desc: "items to skip"}
+)
+
+// recipePage is the listPage of recipe entries, as documented.
+type recipePage struct {
+	Items  []recipeEntry `json:"items"`
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+}
+
+// apiOps are the endpoints besides the catalogue collections, which
+// document themselves (collectionAPI.ops).
+func apiOps() []apiOp {
+	have := apiParam{Name: "have", Type: "string", Desc: "comma-separated ingredient names", Required: true}
+	suggestQuery := []apiParam{have,
+		{Name: "max_difficulty", Type: "integer", Desc: "leave out recipes deeper than this"},
+		{Name: "early_game", Type: "boolean", Desc: "1 keeps recipes needing no advanced tech"},
+		{Name: "distinct_outputs", Type: "boolean", Desc: "1 keeps one recipe per output"},
+		{Name: "sort", Type: "string", Desc: "name (A-Z), qty (most first), inputs (fewest first) or value (most added first)"},
+		{Name: "limit", Type: "integer", Desc: "suggestions to return (1-500; default all)"}, offsetParam}
+	infoParam := apiParam{Name: "info", Type: "boolean", Desc: "1 lists {name, category, color, img, href, icon} objects instead of names"}
+	ingredientsQuery := []apiParam{
+		{Name: "since", Type: "string", Desc: "version the client has; answers with what changed since"},
+		{Name: "wait", Type: "integer", Desc: "with since, seconds (0-60) to wait for a change"},
+		infoParam}
+	convertReq := struct {
+		Input  string `json:"input"`
+		Planet *int   `json:"planet,omitempty"`
+	}{}
+	me := struct {
+		User         string `json:"user"`
+		Role         string `json:"role"`
+		Login        bool   `json:"login"`
+		RequireLogin bool   `json:"require_login"`
+	}{}
+	profileList := struct {
+		Enabled  bool     `json:"enabled"`
+		Current  string   `json:"current"`
+		Profiles []string `json:"profiles"`
+	}{}
+	return []apiOp{
+		{Method: "GET", Path: "/api/suggest", Tag: "recipes", Summary: "Food recipes makeable from the ingredients given", Query: suggestQuery, Resp: apiResp{}},
+		{Method: "POST", Path: "/api/suggest", Tag: "recipes", Summary: "Suggestions for named ingredient lists given as JSON", Body: batchRequest{}, Resp: struct {
+			Results map[string]apiResp `json:"results"`
+		}{}},
+		{Method: "GET", Path: "/api/suggest/all", Tag: "recipes", Summary: "Food and refiner recipes makeable from the ingredients given, in one answer", Query: suggestQuery, Resp: allResp{}},
+		{Method: "GET", Path: "/api/ingredients/all", Tag: "recipes", Summary: "Ingredient names of both datasets", Query: []apiParam{infoParam}, Resp: []string{}},
+		{Method: "GET", Path: "/api/refiner/suggest", Tag: "recipes", Summary: "Refiner recipes makeable from the ingredients given", Query: suggestQuery, Resp: apiResp{}},
+		{Method: "GET", Path: "/api/refiner/chain", Tag: "recipes", Summary: "Fewest refiner steps turning one item into another",
+			Query: []apiParam{{Name: "from", Type: "string", Required: true}, {Name: "to", Type: "string", Required: true}, {Name: "quantity", Type: "integer", Desc: "of to (default 1)"}}, Resp: refinerChain{}},
+		{Method: "GET", Path: "/api/ingredients", Tag: "recipes", Summary: "Food ingredient names, or what changed since a version", Query: ingredientsQuery, Resp: ingredientsDelta{}},
+		{Method: "GET", Path: "/api/refiner/ingredients", Tag: "recipes", Summary: "Refiner ingredient names, or what changed since a version", Query: ingredientsQuery, Resp: ingredientsDelta{}},
+		{Method: "GET", Path: "/api/ingredients/{name}/uses", Tag: "recipes", Summary: "Recipes of both datasets taking an ingredient, most processed output first",
+			Query: []apiParam{sourceParam, fieldsParam}, Resp: usesResp{}},
+		{Method: "GET", Path: "/api/items", Tag: "recipes", Summary: "Every food item with its category, difficulty and icon", Resp: itemsResp{}},
+		{Method: "GET", Path: "/api/refiner/items", Tag: "recipes", Summary: "Every refiner item with its category, difficulty and icon", Resp: itemsResp{}},
+		{Method: "GET", Path: "/api/recipes", Tag: "recipes", Summary: "Every recipe of both datasets, a page at a time",
+			Query: []apiParam{sourceParam, {Name: "output", Type: "string", Desc: "keep recipes whose output contains this"},
+				{Name: "ingredient", Type: "string", Desc: "keep recipes with an input containing this"}, limitParam, offsetParam, fieldsParam}, Resp: recipePage{}},
+		{Method: "GET", Path: "/api/recipes/{id}", Tag: "recipes", Summary: "A recipe, with the info on its items", Resp: recipeDetail{}},
+		{Method: "GET", Path: "/api/recipes/{id}/tree", Tag: "recipes", Summary: "A recipe with its inputs expanded into the recipes making them",
+			Query: []apiParam{{Name: "depth", Type: "integer", Desc: "recipe levels to expand, 1-8 (default 3)"}}, Resp: treeRecipe{}},
+		{Method: "POST", Path: "/api/plan", Tag: "recipes", Summary: "Steps and raw materials making a quantity of an item", Body: struct {
+			Target   string         `json:"target"`
+			Quantity int            `json:"quantity,omitempty"`
+			Owned    map[string]int `json:"owned,omitempty"`
+		}{}, Resp: craftPlan{}},
+		{Method: "GET", Path: "/api/reachable", Tag: "recipes", Summary: "Everything the ingredients given make, chaining both datasets, by level",
+			Query: []apiParam{have, {Name: "max_depth", Type: "integer", Desc: "levels to chain, 1-20 (default 20)"}, fieldsParam}, Resp: reachResp{}},
+		{Method: "GET", Path: "/api/recipes/by-output", Tag: "recipes", Summary: "Every recipe making an item, its name matched loosely",
+			Query: []apiParam{{Name: "name", Type: "string", Required: true}, sourceParam, fieldsParam}, Resp: byOutputResp{}},
+		{Method: "GET", Path: "/api/recipes/search", Tag: "recipes", Summary: "Outputs best matching a partial name, with their recipes",
+			Query: []apiParam{{Name: "output", Type: "string", Required: true}, {Name: "dataset", Type: "string", Desc: "food (default) or refiner"}, {Name: "limit", Type: "integer"}, fieldsParam}, Resp: []outputHit{}},
+		{Method: "GET", Path: "/api/export/recipes.json", Tag: "recipes", Summary: "Both datasets, for -remote-dataset", Resp: recipeExport{}},
+		{Method: "GET", Path: "/api/my/recipes", Tag: "recipes", Summary: "The signed-in user's custom recipes", Query: []apiParam{{Name: "dataset", Type: "string"}, fieldsParam}, Resp: []CustomRecipe{}},
+		{Method: "POST", Path: "/api/my/recipes", Tag: "recipes", Summary: "Add a custom recipe", Body: CustomRecipe{}, Resp: CustomRecipe{}},
+		{Method: "PUT", Path: "/api/my/recipes/{id}", Tag: "recipes", Summary: "Change a custom recipe", Body: CustomRecipe{}, Resp: CustomRecipe{}},
+		{Method: "DELETE", Path: "/api/my/recipes/{id}", Tag: "recipes", Summary: "Remove a custom recipe", Status: http.StatusNoContent},
+		{Method: "GET", Path: "/api/technologies", Tag: "technologies", Summary: "Technologies and upgrade modules", Query: []apiParam{qParam, fieldsParam}, Resp: []recipes.Technology{}},
+		{Method: "POST", Path: "/api/technologies/plan", Tag: "technologies", Summary: "Plan the materials for a list of modules", Body: struct {
+			Modules []LoadoutModule `json:"modules"`
+		}{}, Resp: upgradePlan{}},
+		{Method: "GET", Path: "/api/glyphs/random", Tag: "glyphs", Summary: "A random portal address, near a glyph if asked", Resp: randomPortalResp{}},
+		{Method: "GET", Path: "/api/glyphs/duplicates", Tag: "glyphs", Summary: "Glyphs sharing a portal address", Resp: []glyphs.DuplicateGroup{}},
+		{Method: "GET", Path: "/api/glyphs/{id}/coords", Tag: "glyphs", Summary: "Galactic coordinates of a glyph", Resp: glyphs.Coordinates{}},
+		{Method: "GET", Path: "/api/glyphs/{id}/image.png", Tag: "glyphs", Summary: "Shareable card of a glyph", RespType: "image/png"},
+		{Method: "POST", Path: "/api/glyphs/recognize", Tag: "glyphs", Summary: "Read the glyphs in a screenshot (multipart field photo)", Resp: glyphocr.Result{}},
+		{Method: "POST", Path: "/api/convert", Tag: "glyphs", Summary: "Convert a portal code or signal-booster coordinates", Body: convertReq, Resp: glyphs.Coordinates{}},
+		{Method: "GET", Path: "/api/systems/nearest", Tag: "systems", Summary: "Recorded systems nearest a glyph or address",
+			Query: []apiParam{{Name: "from", Type: "string", Desc: "glyph id"}, {Name: "address", Type: "string", Desc: "portal address"}, {Name: "limit", Type: "integer"}, fieldsParam}, Resp: []systemHit{}},
+		{Method: "GET", Path: "/api/loadouts/{id}/plan", Tag: "loadouts", Summary: "Materials for a saved loadout", Query: []apiParam{{Name: "format", Type: "string", Desc: "json (default), csv or md"}}, Resp: upgradePlan{}},
+		{Method: "GET", Path: "/api/events", Tag: "glyphs", Summary: "Server-sent events as glyphs change", RespType: "text/event-stream"},
+		{Method: "GET", Path: "/api/me", Tag: "account", Summary: "Who the request signs in as", Resp: me},
+		{Method: "GET", Path: "/api/profiles", Tag: "account", Summary: "Save-game profiles", Resp: profileList},
+		{Method: "POST", Path: "/api/profiles", Tag: "account", Summary: "Add a profile", Body: struct {
+			Name string `json:"name"`
+		}{}, Resp: profileList},
+		{Method: "GET", Path: "/api/version", Tag: "server", Summary: "Version of the server", Resp: map[string]string{}},
+	}
+}
+
+// ops documents the endpoints of a collection.
+func (a *collectionAPI[T, P]) ops() []apiOp {
+	var zero T
+	kind := a.Store.Spec.Kind
+	base := a.base()
+	view := a.view(zero)
+	list := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(view)), 0, 0).Interface()
+	return []apiOp{
+		{Method: "GET", Path: base, Tag: kind + "s", Summary: "List " + kind + "s", Resp: list,
+			Query: []apiParam{qParam, {Name: "tag", Type: "string"}, {Name: "sort", Type: "string"}, {Name: "limit", Type: "integer"}, {Name: "offset", Type: "integer"}, fieldsParam}},
+		{Method: "POST", Path: base, Tag: kind + "s", Summary: "Add a " + kind, Body: zero, Resp: view},
+		{Method: "GET", Path: base + "/{id}", Tag: kind + "s", Summary: "Get a " + kind, Resp: view},
+		{Method: "PUT", Path: base + "/{id}", Tag: kind + "s", Summary: "Change a " + kind, Body: zero, Resp: view},
+		{Method: "DELETE", Path: base + "/{id}", Tag: kind + "s", Summary: "Remove a " + kind, Status: http.StatusNoContent},
+		{Method: "POST", Path: base + "/{id}/pin", Tag: kind + "s", Summary: "Pin a " + kind, Resp: view},
+		{Method: "DELETE", Path: base + "/{id}/pin", Tag: kind + "s", Summary: "Unpin a " + kind, Resp: view},
+		{Method: "GET", Path: base + "/export", Tag: kind + "s", Summary: "Export every " + kind, Query: []apiParam{{Name: "format", Type: "string", Desc: "json (default) or csv"}}, Resp: []T{}},
+		{Method: "POST", Path: base + "/import", Tag: kind + "s", Summary: "Import " + kind + "s exported before", Body: []T{}},
+	}
+}
+
+func (c catalogueAPIs) ops() []apiOp {
+	var out []apiOp
+	for _, api := range []interface{ ops() []apiOp }{c.glyphs, c.bases, c.creatures, c.portals, c.systems, c.loadouts} {
+		out = append(out, api.ops()...)
+	}
+	return out
+}
+
+var pathParamRe = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// openAPIDoc builds the OpenAPI 3 document of ops, served from /api/v1.
+func openAPIDoc(ops []apiOp) map[string]any {
+	v, _ := buildVersion()
+	g := &schemaGen{defs: map[string]any{}, names: map[reflect.Type]string{}}
+	g.defs["Error"] = g.schema(reflect.TypeOf(struct {
+		Error apiError `json:"error"`
+	}{}))
+	paths := map[string]map[string]any{}
+	for _, op := range ops {
+		p := strings.TrimPrefix(op.Path, "/api")
+		var params []any
+		for _, m := range pathParamRe.FindAllStringSubmatch(p, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "required": q.Required, "description": q.Desc, "schema": map[string]any{"type": q.Type}})
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.RespType != "":
+			ok["content"] = map[string]any{op.RespType: map[string]any{}}
+		case op.Resp != nil:
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.Resp))}}
+		}
+		o := map[string]any{
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"responses": map[string]any{
+				strconv.Itoa(status): ok,
+				"default": map[string]any{"description": "Error", "content": map[string]any{"application/json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/Error"}}}},
+			},
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]any{"required": true, "content": map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.Body))}}}
+		}
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		paths[p][strings.ToLower(op.Method)] = o
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "NMS toolbox API",
+			"version":     v,
+			"description": "Errors are {\"error\": {\"code\", \"message\", \"fields\"}}. The same endpoints are served under /api/ with plain-text errors.",
+		},
+		"servers":    []any{map[string]any{"url": "/api/v1"}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.defs},
+	}
+}
+
+// operationID names an operation for client generators: getGlyphsId,
+// postMyRecipes...
+func operationID(op apiOp) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(op.Path, "/api/"), func(r rune) bool {
+		return r == '/' || r == '-' || r == '_' || r == '.' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// schemaGen turns Go types into JSON schemas the way encoding/json writes
+// them; named structs go in defs, by type name, and are referred to.
+type schemaGen struct {
+	defs  map[string]any
+	names map[reflect.Type]string
+}
+
+var (
+	metaType      = reflect.TypeOf(store.Meta{})
+	serverSet     = map[string]bool{"id": true, "created_at": true, "created_by": true} // fields of Meta
+	timeType      = reflect.TypeOf(time.Time{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawType, t.Kind() == reflect.Interface, t.Implements(marshalerType):
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.defName(t)
+			g.names[t] = name
+			g.defs[name] = map[string]any{} // while its fields refer to it
+			g.defs[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// defName is the type's name, with its package's when another package
+// has a type of that name too.
+func (g *schemaGen) defName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.ToUpper(name[:1]) + name[1:]
+	if _, taken := g.defs[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndexByte(t.PkgPath(), '/')+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+// object is the schema of a struct's JSON object, embedded structs'
+// fields included.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := f.Type
+			if f.Anonymous && name == "" {
+				for ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s := g.schema(ft)
+			if strings.Contains(opts, "string") {
+				s = map[string]any{"type": "string"}
+			}
+			if t == metaType && serverSet[name] {
+				s = map[string]any{"allOf": []any{s}, "readOnly": true}
+			}
+			props[name] = s
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+	return out
+}
+
+// openAPIHandler serves GET /api/openapi.json.
+func openAPIHandler(ops []apiOp) http.HandlerFunc {
+	doc := sync.OnceValues(func() ([]byte, error) { return json.MarshalIndent(openAPIDoc(ops), "", "  ") })
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := doc()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, `"`+sha256Hex(b)[:32]+`"`) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(b)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/poku-e/NMScripts/internal/recipes"
+)
+
+// ---------- Command line: pack ----------
+
+const packUsage = `usage: nms pack [-name NAME] [-out data.pak] DIR
+
+Bundles the datasets in DIR (food.csv, refiner.csv, technologies.csv,
+values.csv; any subset) into one compressed file, after checking that each loads. Serve it
+with 'nms serve -datapack data.pak'; a dataset the pack lacks, or whose
+path flag is given, is read as usual.
+`
+
+// packDatasets are the files a data pack may hold and the path flag each
+// stands in for.
+var packDatasets = []struct{ name, flag string }{
+	{"food.csv", "csv"},
+	{"refiner.csv", "refiner"},
+	{"technologies.csv", "tech"},
+	{"values.csv", "values"},
+}
+
+// packIndexName is the first file of every pack.
+const packIndexName = "pack.json"
+
+type packIndex struct {
+	Name      string          `json:"name,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []snapshotEntry `json:"files"`
+}
+
+func packCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("pack", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, packUsage); fs.PrintDefaults() }
+	out := fs.String("out", "data.pak", "File to write")
+	name := fs.String("name", "", "Name of the bundle, shown when the server loads it")
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	idx, err := writePack(fs.Arg(0), *out, *name)
+	if err != nil {
+		fmt.Fprintf(stderr, "pack: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "wrote %s:", *out)
+	for _, f := range idx.Files {
+		fmt.Fprintf(stdout, " %s (%d bytes)", f.Name, f.Bytes)
+	}
+	fmt.Fprintln(stdout)
+	return 0
+}
+
+// checkDataset loads one dataset the way serve would.
+func checkDataset(name string, b []byte) error {
+	switch name {
+	case "technologies.csv":
+		_, err := recipes.ReadTechCSV(bytes.NewReader(b))
+		return err
+	case "values.csv":
+		_, err := recipes.ReadValuesCSV(bytes.NewReader(b))
+		return err
+	}
+	db, err := recipes.ReadCSV(bytes.NewReader(b))
+	if err == nil && len(db.Recipes) == 0 {
+		err = errors.New("no recipes parsed")
+	}
+	return err
+}
+
+func writePack(dir, file, name string) (packIndex, error) {
+	idx := packIndex{Name: name, CreatedAt: time.Now().UTC()}
+	var data [][]byte
+	for _, d := range packDatasets {
+		b, err := os.ReadFile(filepath.Join(dir, d.name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return idx, err
+		}
+		if err := checkDataset(d.name, b); err != nil {
+			return idx, fmt.Errorf("%s: %w", d.name, err)
+		}
+		idx.Files = append(idx.Files, snapshotEntry{Name: d.name, SHA256: sha256Hex(b), Bytes: int64(len(b))})
+		data = append(data, b)
+	}
+	if len(idx.Files) == 0 {
+		return idx, fmt.Errorf("no datasets in %s", dir)
+	}
+	head, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return idx, err
+	}
+
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return idx, err
+	}
+	defer os.Remove(tmp)
+	zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		f.Close()
+		return idx, err
+	}
+	tw := tar.NewWriter(zw)
+	put := func(name string, b []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: idx.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	err = put(packIndexName, head)
+	for i := 0; err == nil && i < len(data); i++ {
+		err = put(idx.Files[i].Name, data[i])
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return idx, err
+	}
+	return idx, os.Rename(tmp, file)
+}
+
+// dataPack is a pack read into memory.
+type dataPack struct {
+	index packIndex
+	files map[string][]byte
+}
+
+// readPack reads a data pack and checks every file against its index.
+func readPack(path string) (*dataPack, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, closeZ, err := decompressor(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer closeZ()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err != nil || hdr.Name != packIndexName {
+		return nil, fmt.Errorf("%s: not a data pack (no %s)", path, packIndexName)
+	}
+	p := &dataPack{files: map[string][]byte{}}
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&p.index); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", path, packIndexName, err)
+	}
+	want := map[string]snapshotEntry{}
+	for _, e := range p.index.Files {
+		want[e.Name] = e
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		e, ok := want[hdr.Name]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%s: unexpected entry %q", path, hdr.Name)
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, e.Bytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if int64(len(b)) != e.Bytes || sha256Hex(b) != e.SHA256 {
+			return nil, fmt.Errorf("%s: %s: checksum mismatch, pack is damaged", path, e.Name)
+		}
+		p.files[e.Name] = b
+	}
+	for name := range want {
+		if _, ok := p.files[name]; !ok {
+			return nil, fmt.Errorf("%s: missing %s", path, name)
+		}
+	}
+	return p, nil
+}
+
+// fromPack returns the dataset standing in for a path flag, when -datapack
+// is set, holds it and the flag was not given.
+func (in *instance) fromPack(flagName string) (b []byte, src string, err error) {
+	if in.DataPack == "" || in.given[flagName] {
+		return nil, "", nil
+	}
+	p, err := readPack(in.DataPack)
+	if err != nil {
+		return nil, in.DataPack, err
+	}
+	for _, d := range packDatasets {
+		if d.flag == flagName {
+			if b, ok := p.files[d.name]; ok {
+				return b, in.DataPack + ":" + d.name, nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+// loadTech loads the technologies dataset from -datapack or -tech.
+func (in *instance) loadTech() (*recipes.TechDB, string, error) {
+	b, src, err := in.fromPack("tech")
+	if err != nil || b != nil {
+		if err != nil {
+			return nil, src, err
+		}
+		db, err := recipes.ReadTechCSV(bytes.NewReader(b))
+		return db, src, err
+	}
+	db, err := recipes.LoadTechCSV(in.Tech)
+	return db, in.Tech, err
+}
+
+// loadValues loads the item values from -datapack or -values.
+func (in *instance) loadValues() (recipes.Values, string, error) {
+	b, src, err := in.fromPack("values")
+	if err != nil || b != nil {
+		if err != nil {
+			return nil, src, err
+		}
+		v, err := recipes.ReadValuesCSV(bytes.NewReader(b))
+		return v, src, err
+	}
+	v, err := recipes.LoadValuesCSV(in.Values)
+	return v, in.Values, err
+}
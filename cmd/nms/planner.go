@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Upgrade loadouts ----------
+
+// LoadoutModule is one technology in a loadout. An empty Class means every
+// class of that module in the dataset, i.e. the fully upgraded set.
+type LoadoutModule struct {
+	Name  string `json:"name"`
+	Class string `json:"class,omitempty"`
+	Count int    `json:"count"`
+}
+
+// Loadout is a saved set of modules for one platform.
+type Loadout struct {
+	store.Meta
+	Name     string          `json:"name"`
+	Platform string          `json:"platform"` // starship, multitool, exosuit, exocraft, freighter
+	Modules  []LoadoutModule `json:"modules"`
+	Notes    string          `json:"notes"`
+}
+
+type LoadoutStore = store.Collection[Loadout, *Loadout]
+
+var loadoutPlatforms = map[string]string{
+	"": "", "starship": "starship", "ship": "starship", "multitool": "multitool", "multi-tool": "multitool",
+	"exosuit": "exosuit", "suit": "exosuit", "exocraft": "exocraft", "freighter": "freighter",
+}
+
+// loadoutLineRe reads one module per line: "Name [S|A|B|C|X] [xN]".
+var loadoutLineRe = regexp.MustCompile(`(?i)^(.+?)(?:\s+([SABCX]))?(?:\s+x\s*(\d+))?$`)
+
+// parseLoadoutModules reads the textarea form of a module list.
+func parseLoadoutModules(s string) []LoadoutModule {
+	var out []LoadoutModule
+	for _, line := range strings.Split(s, "\n") {
+		m := loadoutLineRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[3])
+		out = append(out, LoadoutModule{Name: m[1], Class: strings.ToUpper(m[2]), Count: n})
+	}
+	return out
+}
+
+var loadoutSpec = store.Spec[Loadout]{
+	Kind: "loadout",
+	Validate: func(l *Loadout) error {
+		l.Name = strings.TrimSpace(l.Name)
+		l.Notes = strings.TrimSpace(l.Notes)
+		platform, ok := loadoutPlatforms[strings.ToLower(strings.TrimSpace(l.Platform))]
+		if !ok {
+			return store.Invalid("platform", "platform must be starship, multitool, exosuit, exocraft or freighter")
+		}
+		l.Platform = platform
+		if l.Name == "" {
+			return store.Invalid("name", "name required")
+		}
+		if utf8.RuneCountInString(l.Name) > 64 {
+			return store.Invalid("name", "name too long (max 64 chars)")
+		}
+		if utf8.RuneCountInString(l.Notes) > 2048 {
+			return store.Invalid("notes", "notes too long (max 2048 chars)")
+		}
+		if len(l.Modules) > 64 {
+			return store.Invalid("modules", "too many modules (max 64)")
+		}
+		var mods []LoadoutModule
+		for _, m := range l.Modules {
+			m.Name = strings.TrimSpace(m.Name)
+			m.Class = strings.ToUpper(strings.TrimSpace(m.Class))
+			if m.Name == "" {
+				continue
+			}
+			if m.Count == 0 {
+				m.Count = 1
+			}
+			if m.Count < 0 || m.Count > 9 {
+				return fmt.Errorf("%s: count must be 1-9", m.Name)
+			}
+			mods = append(mods, m)
+		}
+		l.Modules = mods
+		return nil
+	},
+	Key: func(l *Loadout) string { return strings.ToLower(l.Name) },
+	Text: func(l *Loadout) string {
+		var names []string
+		for _, m := range l.Modules {
+			names = append(names, m.Name)
+		}
+		return l.Name + " " + l.Platform + " " + strings.Join(names, " ") + " " + l.Notes
+	},
+	FromForm: func(v url.Values) Loadout {
+		return Loadout{
+			Name:     v.Get("name"),
+			Platform: v.Get("platform"),
+			Modules:  parseLoadoutModules(v.Get("modules")),
+			Notes:    v.Get("notes"),
+		}
+	},
+}
+
+// ---------- Upgrade planner ----------
+
+// plannedModule is one technology the plan installs, with its total cost.
+type plannedModule struct {
+	Name      string             `json:"name"`
+	Class     string             `json:"class"`
+	Category  string             `json:"category"`
+	Count     int                `json:"count"`
+	Resources []recipes.TechCost `json:"resources"` // already multiplied by Count
+}
+
+// upgradePlan is a loadout resolved against the technologies dataset, with
+// every module's cost rolled up into one shopping list.
+type upgradePlan struct {
+	Modules  []plannedModule    `json:"modules"`
+	Unknown  []string           `json:"unknown"` // modules missing from the dataset
+	Shopping []recipes.TechCost `json:"shopping"`
+}
+
+func planUpgrades(db *recipes.TechDB, mods []LoadoutModule) upgradePlan {
+	p := upgradePlan{Modules: []plannedModule{}, Unknown: []string{}, Shopping: []recipes.TechCost{}}
+	totals := map[string]int{}
+	names := map[string]string{}
+	for _, m := range mods {
+		count := max(m.Count, 1)
+		var techs []recipes.Technology
+		if m.Class != "" {
+			if t, ok := db.Lookup(m.Name, m.Class); ok {
+				techs = append(techs, t)
+			}
+		} else {
+			techs = db.Classes(m.Name)
+		}
+		if len(techs) == 0 {
+			label := m.Name
+			if m.Class != "" {
+				label += " (" + m.Class + ")"
+			}
+			p.Unknown = append(p.Unknown, label)
+			continue
+		}
+		for _, t := range techs {
+			pm := plannedModule{Name: t.Name, Class: t.Class, Category: t.Category, Count: count, Resources: []recipes.TechCost{}}
+			for _, c := range t.Resources {
+				q := c.Qty * count
+				pm.Resources = append(pm.Resources, recipes.TechCost{Name: c.Name, Qty: q})
+				k := norm.Key(c.Name)
+				totals[k] += q
+				if _, ok := names[k]; !ok {
+					names[k] = c.Name
+				}
+			}
+			p.Modules = append(p.Modules, pm)
+		}
+	}
+	for k, q := range totals {
+		p.Shopping = append(p.Shopping, recipes.TechCost{Name: names[k], Qty: q})
+	}
+	sort.Slice(p.Shopping, func(i, j int) bool {
+		if p.Shopping[i].Qty != p.Shopping[j].Qty {
+			return p.Shopping[i].Qty > p.Shopping[j].Qty
+		}
+		return p.Shopping[i].Name < p.Shopping[j].Name
+	})
+	return p
+}
+
+// loadoutView is a loadout with its resolved plan.
+type loadoutView struct {
+	Loadout
+	Plan upgradePlan `json:"plan"`
+}
+
+// writePlan renders a plan as JSON, CSV (one row per shopping item) or a
+// plain-text checklist, as a download named after the loadout.
+func writePlan(w http.ResponseWriter, name, format string, p upgradePlan) {
+	file := strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-shopping.csv"`, file))
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"resource", "qty"})
+		for _, c := range p.Shopping {
+			_ = cw.Write([]string{c.Name, strconv.Itoa(c.Qty)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing plan: %v\n", err)
+		}
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-shopping.txt"`, file))
+		writePlanText(w, name, p)
+	default:
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-plan.json"`, file))
+		writeJSON(w, p)
+	}
+}
+
+func writePlanText(w io.Writer, name string, p upgradePlan) {
+	fmt.Fprintf(w, "%s\n\nModules:\n", name)
+	for _, m := range p.Modules {
+		class := ""
+		if m.Class != "" {
+			class = " [" + m.Class + "]"
+		}
+		fmt.Fprintf(w, "  %dx %s%s\n", m.Count, m.Name, class)
+	}
+	for _, u := range p.Unknown {
+		fmt.Fprintf(w, "  ?? %s (not in technologies dataset)\n", u)
+	}
+	fmt.Fprintf(w, "\nShopping list:\n")
+	for _, c := range p.Shopping {
+		fmt.Fprintf(w, "  [ ] %6d  %s\n", c.Qty, c.Name)
+	}
+}
+
+// planHandler plans an unsaved module list: POST /api/technologies/plan with
+// {"modules": [...]}.
+func planHandler(db *recipes.TechDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Modules []LoadoutModule `json:"modules"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, planUpgrades(db, body.Modules))
+	}
+}
+
+// loadoutPlanHandler exports a saved loadout's plan:
+// GET /api/loadouts/{id}/plan?format=json|csv|txt.
+func loadoutPlanHandler(db *recipes.TechDB, ls *LoadoutStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := ls.Get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "loadout not found", http.StatusNotFound)
+			return
+		}
+		writePlan(w, l.Name, r.URL.Query().Get("format"), planUpgrades(db, l.Modules))
+	}
+}
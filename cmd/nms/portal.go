@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Portal rolls ----------
+
+// Portal is an address produced by the random explorer, kept so players can
+// track which rolls they actually visited.
+type Portal struct {
+	store.Meta
+	Address     string     `json:"address"`
+	NearGlyphID string     `json:"near_glyph_id,omitempty"`
+	Visited     bool       `json:"visited"`
+	VisitedAt   *time.Time `json:"visited_at,omitempty"`
+	Notes       string     `json:"notes"`
+}
+
+type PortalStore = store.Collection[Portal, *Portal]
+
+var portalSpec = store.Spec[Portal]{
+	Kind: "portal",
+	Validate: func(p *Portal) error {
+		a, err := glyphs.ParsePortal(p.Address)
+		if err != nil {
+			return err
+		}
+		p.Address = a.String()
+		p.NearGlyphID = strings.TrimSpace(p.NearGlyphID)
+		p.Notes = strings.TrimSpace(p.Notes)
+		if len(p.Notes) > 1024 {
+			return store.Invalid("notes", "notes too long (max 1024 chars)")
+		}
+		if p.Visited && p.VisitedAt == nil {
+			now := time.Now().UTC()
+			p.VisitedAt = &now
+		}
+		if !p.Visited {
+			p.VisitedAt = nil
+		}
+		return nil
+	},
+	Key: func(p *Portal) string { return p.Address },
+	Text: func(p *Portal) string {
+		return p.Address + " " + p.Notes
+	},
+	FromForm: func(v url.Values) Portal {
+		return Portal{
+			Address:     v.Get("address"),
+			NearGlyphID: v.Get("near_glyph_id"),
+			Visited:     v.Get("visited") == "on" || v.Get("visited") == "true",
+			Notes:       v.Get("notes"),
+		}
+	},
+}
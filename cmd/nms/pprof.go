@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// ---------- Profiling ----------
+
+// servePprof serves the net/http/pprof endpoints on addr, a port of their
+// own so they are never reachable through the public one:
+//
+//	go tool pprof http://127.0.0.1:6060/debug/pprof/profile?seconds=30
+//	go tool pprof http://127.0.0.1:6060/debug/pprof/heap
+//
+// They are not behind a sign-in, so addr should be a loopback or private
+// address (or a unix: socket).
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	ln, err := listen(addr, -1, 0o600)
+	if err != nil {
+		log.Printf("pprof: %v", err)
+		return
+	}
+	go func() {
+		// No write timeout: a CPU profile or trace takes as long as asked.
+		err := (&http.Server{Handler: mux, ReadHeaderTimeout: 30 * time.Second}).Serve(ln)
+		log.Printf("pprof: %v", err)
+	}()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ---------- Profiles ----------
+
+// A profile is one save game or character: its own glyphs (with their pins
+// and history), bases, creatures, portal rolls, systems and loadouts. The
+// instance's stores are the "default" profile; with -profiles-dir every
+// other profile keeps its stores and photos in DIR/NAME/, under the file
+// names the instance uses. Custom recipes belong to the player rather than
+// a save, so every profile shares them.
+//
+// A request picks its profile with an X-Profile header, ?profile=NAME
+// (remembered in a cookie, like ?big=) or that cookie, and is served the
+// catalogue routes of that profile; everything else is the same for all.
+
+const (
+	defaultProfile = "default"
+	profileCookie  = "profile"
+)
+
+var profileNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var (
+	errNoProfile      = errors.New("no such profile")
+	errProfileExists  = errors.New("profile exists")
+	errProfilesOff    = errors.New("profiles are off (start the server with -profiles-dir)")
+	errBadProfileName = errors.New("profile names are 1-32 lowercase letters, digits, - and _")
+)
+
+type profileKey struct{}
+
+// profileOf returns the profile a request was routed to.
+func profileOf(r *http.Request) string {
+	if name, ok := r.Context().Value(profileKey{}).(string); ok {
+		return name
+	}
+	return defaultProfile
+}
+
+// profileQuery is the query string that selects the request's profile in a
+// link meant for someone else, empty for the default profile.
+func profileQuery(r *http.Request) string {
+	if name := profileOf(r); name != defaultProfile {
+		return "?profile=" + url.QueryEscape(name)
+	}
+	return ""
+}
+
+// profiles routes requests to the catalogue routes of their profile,
+// opening a profile's stores the first time it is used.
+type profiles struct {
+	in     *instance
+	routes func(c *catalogues, mux *http.ServeMux) error
+
+	mu    sync.Mutex
+	muxes map[string]*http.ServeMux
+}
+
+// newProfiles registers the routes of c, the default profile.
+func newProfiles(in *instance, c *catalogues, routes func(c *catalogues, mux *http.ServeMux) error) (*profiles, error) {
+	mux := http.NewServeMux()
+	if err := routes(c, mux); err != nil {
+		return nil, err
+	}
+	return &profiles{in: in, routes: routes, muxes: map[string]*http.ServeMux{defaultProfile: mux}}, nil
+}
+
+// list returns the names of the profiles, the default first.
+func (p *profiles) list() ([]string, error) {
+	names := []string{defaultProfile}
+	if p.in.ProfilesDir == "" {
+		return names, nil
+	}
+	entries, err := os.ReadDir(p.in.ProfilesDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != defaultProfile && profileNameRe.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// instance returns the instance with its stores moved into the directory of
+// the named profile.
+func (p *profiles) instance(name string) *instance {
+	in := *p.in
+	dir := filepath.Join(p.in.ProfilesDir, name)
+	for _, s := range []*string{&in.Glyphs, &in.Bases, &in.Creatures, &in.Portals, &in.Systems, &in.Loadouts} {
+		*s = filepath.Join(dir, filepath.Base(*s))
+	}
+	if in.Images != "" {
+		in.Images = filepath.Join(dir, "images")
+	}
+	return &in
+}
+
+// mux returns the routes of the named profile. With create the profile is
+// made, and must not exist yet.
+func (p *profiles) mux(name string, create bool) (*http.ServeMux, error) {
+	if !profileNameRe.MatchString(name) {
+		return nil, errBadProfileName
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if mux, ok := p.muxes[name]; ok {
+		if create {
+			return nil, errProfileExists
+		}
+		return mux, nil
+	}
+	if p.in.ProfilesDir == "" {
+		if create {
+			return nil, errProfilesOff
+		}
+		return nil, errNoProfile
+	}
+	dir := filepath.Join(p.in.ProfilesDir, name)
+	_, err := os.Stat(dir)
+	switch {
+	case err == nil && create:
+		return nil, errProfileExists
+	case errors.Is(err, os.ErrNotExist) && !create:
+		return nil, errNoProfile
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c, err := p.instance(name).openStores()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	mux := http.NewServeMux()
+	if err := p.routes(c, mux); err != nil {
+		return nil, err
+	}
+	p.muxes[name] = mux
+	return mux, nil
+}
+
+// route serves each request from the routes of its profile, and from next
+// when they have none for it. A cookie naming a profile that is gone falls
+// back to the default; a header or query naming one is an error.
+func (p *profiles) route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, query := r.Header.Get("X-Profile"), r.URL.Query().Get("profile")
+		fromCookie := false
+		if name == "" {
+			name = query
+		}
+		if name == "" {
+			name = defaultProfile
+			if c, err := r.Cookie(profileCookie); err == nil && c.Value != "" {
+				name, fromCookie = c.Value, true
+			}
+		}
+		mux, err := p.mux(name, false)
+		if err != nil && fromCookie {
+			http.SetCookie(w, &http.Cookie{Name: profileCookie, Path: "/", MaxAge: -1})
+			name, mux, err = defaultProfile, p.muxes[defaultProfile], nil
+		}
+		switch {
+		case errors.Is(err, errBadProfileName):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, errNoProfile):
+			http.Error(w, fmt.Sprintf("profile %q: %v", name, err), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if query != "" {
+			http.SetCookie(w, &http.Cookie{Name: profileCookie, Value: name, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), profileKey{}, name))
+		// Handler only looks the route up; ServeHTTP also sets PathValue.
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listHandler serves GET /api/profiles: the profiles and the one the
+// request was routed to.
+func (p *profiles) listHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := p.list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"enabled": p.in.ProfilesDir != "", "current": profileOf(r), "profiles": names})
+}
+
+// createHandler serves POST /api/profiles, {"name": "permadeath"}, making
+// a profile with empty stores.
+func (p *profiles) createHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	_, err := p.mux(req.Name, true)
+	switch {
+	case errors.Is(err, errBadProfileName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errProfileExists), errors.Is(err, errProfilesOff):
+		http.Error(w, fmt.Sprintf("profile %q: %v", req.Name, err), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"name": req.Name})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/poku-e/NMScripts/internal/glyphocr"
+)
+
+// ---------- Glyph recognition ----------
+
+// recognizer draws its templates from the embedded glyph font on first use.
+var recognizer = sync.OnceValues(func() (*glyphocr.Recognizer, error) {
+	return glyphocr.New(glyphFontTTF)
+})
+
+// maxRecognizePixels caps the size of a photo to read: a small file may
+// declare a huge image, and reading it takes several full-size copies.
+const maxRecognizePixels = 40_000_000
+
+// recognizeHandler serves POST /api/glyphs/recognize: a screenshot, as the
+// multipart "photo" field the glyph form sends or as the raw body, read
+// into portal symbols with a confidence for the user to check. Nothing is
+// stored.
+func recognizeHandler(w http.ResponseWriter, r *http.Request) {
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("photo")
+		if err != nil {
+			http.Error(w, "missing photo", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+	data, err := io.ReadAll(io.LimitReader(src, 10<<20))
+	if err != nil {
+		http.Error(w, "invalid photo", http.StatusBadRequest)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "invalid photo: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.Width*cfg.Height > maxRecognizePixels {
+		http.Error(w, "photo too large (max 40 megapixels)", http.StatusRequestEntityTooLarge)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "invalid photo: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := recognizer()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := rec.Read(img)
+	if errors.Is(err, glyphocr.ErrNotFound) {
+		http.Error(w, "no portal glyphs found in the photo", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, res)
+}
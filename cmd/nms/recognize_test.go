@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pngHeader is the start of a PNG declaring a w×h image, enough for
+// image.DecodeConfig but not for image.Decode.
+func pngHeader(w, h int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestRecognizeRejectsHugeImages(t *testing.T) {
+	var small bytes.Buffer
+	png.Encode(&small, image.NewGray(image.Rect(0, 0, 4, 4)))
+	tests := []struct {
+		name   string
+		body   []byte
+		status int
+	}{
+		{"declares 30000x30000", pngHeader(30000, 30000), http.StatusRequestEntityTooLarge},
+		{"declares just over the cap", pngHeader(8000, 5001), http.StatusRequestEntityTooLarge},
+		{"not an image", []byte("hello"), http.StatusBadRequest},
+		{"small image without glyphs", small.Bytes(), http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			recognizeHandler(rr, httptest.NewRequest(http.MethodPost, "/api/glyphs/recognize", bytes.NewReader(tt.body)))
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+}
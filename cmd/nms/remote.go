@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"github.com/poku-e/NMScripts/internal/scrape"
+)
+
+// ---------- Remote datasets ----------
+
+// recipeExport is the body of GET /api/export/recipes.json: both datasets,
+// for other instances to mount with -remote-dataset.
+type recipeExport struct {
+	Food    recipes.Dataset `json:"food"`
+	Refiner recipes.Dataset `json:"refiner"`
+}
+
+// exportJSON renders the datasets once per reload, with an ETag that
+// changes only when the recipes do.
+func (d *recipeDBs) exportJSON() ([]byte, string, error) {
+	x := &d.export
+	x.once.Do(func() {
+		x.body, x.err = json.Marshal(recipeExport{
+			Food:    recipes.Export(d.food),
+			Refiner: recipes.Export(d.refiner),
+		})
+		x.etag = `"` + sha256Hex(x.body)[:32] + `"`
+	})
+	return x.body, x.etag, x.err
+}
+
+// recipeExportHandler serves GET /api/export/recipes.json. A request whose
+// If-None-Match holds the current ETag gets 304 and no body.
+func recipeExportHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, etag, err := rec.cur.Load().exportJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(body)
+	}
+}
+
+// remoteDataset is another instance's export, mounted read-only in place
+// of the local CSVs and fetched again every few minutes. The ETag of the
+// recipes in use makes a refresh that finds no change cost one 304.
+type remoteDataset struct {
+	url   string
+	every time.Duration
+	etag  string
+}
+
+var remoteRetry = scrape.RetryPolicy{
+	Retries: 2,
+	Backoff: 2 * time.Second,
+	Timeout: 60 * time.Second,
+	RetryOn: []scrape.StatusMatch{{Code: http.StatusTooManyRequests}, {Class: 5}},
+}
+
+// fetch returns the remote datasets and their ETag, or nil when they have
+// not changed since the ETag kept in rd.
+func (rd *remoteDataset) fetch(ctx context.Context) (*recipeCSVs, string, error) {
+	opts := scrape.FetchOptions{
+		Retry:  remoteRetry,
+		Header: http.Header{"Accept": {"application/json"}},
+	}
+	if rd.etag != "" {
+		opts.Cached = &scrape.CacheEntry{URL: rd.url, ETag: rd.etag}
+	}
+	body, _, val, err := scrape.Fetch(ctx, rd.url, opts)
+	if errors.Is(err, scrape.ErrNotModified) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var exp recipeExport
+	if err := json.Unmarshal([]byte(body), &exp); err != nil {
+		return nil, "", fmt.Errorf("decode %s: %w", rd.url, err)
+	}
+	d := recipeCSVs{foodSrc: rd.url + " (food)", refinerSrc: rd.url + " (refiner)"}
+	if d.food, err = recipes.FromDataset(exp.Food); err != nil {
+		return nil, "", fmt.Errorf("%s: food: %w", rd.url, err)
+	}
+	if d.refiner, err = recipes.FromDataset(exp.Refiner); err != nil {
+		return nil, "", fmt.Errorf("%s: refiner: %w", rd.url, err)
+	}
+	d.food.FillOutputCategory("cooked")
+	d.food.BorrowItems(d.refiner)
+	return &d, val.ETag, nil
+}
+
+// refresh fetches the remote datasets and swaps them in if they changed.
+func (l *liveRecipes) refresh(rd *remoteDataset) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	csvs, etag, err := rd.fetch(ctx)
+	if err != nil || csvs == nil {
+		return err
+	}
+	if err := l.swap(csvs); err != nil {
+		return err
+	}
+	rd.etag = etag
+	return nil
+}
+
+// mount serves the recipes from another instance's export from now on. If
+// the first fetch fails the local recipes stay until a refresh succeeds.
+func (l *liveRecipes) mount(url string, every time.Duration) {
+	rd := &remoteDataset{url: url, every: every}
+	l.remote = rd
+	if err := l.refresh(rd); err != nil {
+		log.Printf("remote dataset: %v (serving the local recipes until it answers)", err)
+	}
+	if every <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(every) {
+			if err := l.refresh(rd); err != nil {
+				log.Printf("remote dataset: %v (keeping the current recipes)", err)
+			}
+		}
+	}()
+}
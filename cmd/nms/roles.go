@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------- Roles ----------
+
+// role is what a request may do; each role may do what the ones before it
+// may.
+type role int
+
+const (
+	roleViewer role = iota + 1 // query recipes, read glyphs and the other catalogues
+	roleEditor                 // add glyphs, change their own and the other catalogues
+	roleAdmin                  // change anyone's glyphs, reload data, manage users
+)
+
+var roleNames = map[role]string{roleViewer: "viewer", roleEditor: "editor", roleAdmin: "admin"}
+
+func (ro role) String() string { return roleNames[ro] }
+
+// parseRole reads the role of a user entry; "" is an editor.
+func parseRole(s string) (role, error) {
+	if s == "" {
+		return roleEditor, nil
+	}
+	for ro, name := range roleNames {
+		if name == s {
+			return ro, nil
+		}
+	}
+	return 0, fmt.Errorf("role must be viewer, editor or admin, not %q", s)
+}
+
+func (u userEntry) role() role {
+	if u.Admin {
+		return roleAdmin
+	}
+	ro, _ := parseRole(u.Role) // checked on load
+	return ro
+}
+
+// role returns the role of the request and whether it signs in. Signed
+// out, it is an admin while nobody can sign in (no users, no OIDC), a
+// viewer with require_login and an editor otherwise.
+func (lc *liveConfig) role(r *http.Request) (role, bool) {
+	cfg := lc.Load()
+	if _, ro, ok := lc.signedIn(r); ok {
+		return ro, true
+	}
+	switch {
+	case len(cfg.Users) == 0 && lc.oidc == nil:
+		return roleAdmin, false
+	case cfg.RequireLogin:
+		return roleViewer, false
+	}
+	return roleEditor, false
+}
+
+// mayChange reports whether the request may edit or delete a record that
+// owner created: only owner and admins may, unless nobody is recorded.
+func (lc *liveConfig) mayChange(r *http.Request, owner string) bool {
+	ro, _ := lc.role(r)
+	if owner == "" || ro == roleAdmin {
+		return ro >= roleEditor
+	}
+	name, ok := lc.user(r)
+	return ok && name == owner && ro >= roleEditor
+}
+
+// policies declare the role each route needs, by ServeMux pattern. A route
+// left out needs a viewer to read it and an editor for anything else.
+var policies = []struct {
+	pattern string
+	role    role
+}{
+	{"/login", roleViewer},
+	{"/logout", roleViewer},
+	{"POST /api/convert", roleViewer},           // saves nothing
+	{"POST /api/glyphs/recognize", roleViewer},  // saves nothing
+	{"POST /api/technologies/plan", roleViewer}, // saves nothing
+	{"POST /api/suggest", roleViewer},           // saves nothing
+	{"POST /api/plan", roleViewer},              // saves nothing
+	{"POST /api/recipes/upload", roleAdmin},     // replaces a dataset
+	{"/admin", roleAdmin},
+	{"/api/admin/", roleAdmin},
+}
+
+var (
+	policyMux   = http.NewServeMux()
+	policyRoles = map[string]role{}
+)
+
+func init() {
+	for _, p := range policies {
+		policyMux.Handle(p.pattern, http.NotFoundHandler())
+		policyRoles[p.pattern] = p.role
+	}
+}
+
+// routeRole returns the role a request needs.
+func routeRole(r *http.Request) role {
+	if _, pattern := policyMux.Handler(r); pattern != "" {
+		return policyRoles[pattern]
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return roleViewer
+	}
+	return roleEditor
+}
+
+// withRoles turns away requests whose role is below what their route
+// needs. A browser asking for a page while signed out is sent to /login.
+func withRoles(h http.Handler, lc *liveConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := routeRole(r)
+		have, signedIn := lc.role(r)
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead
+		switch {
+		case have >= need:
+			h.ServeHTTP(w, r)
+		case !signedIn && read && !strings.HasPrefix(r.URL.Path, "/api/"):
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		case !signedIn:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "sign in first (on /login, or with Authorization: Bearer TOKEN)", http.StatusUnauthorized)
+		default:
+			http.Error(w, fmt.Sprintf("%s role needed", need), http.StatusForbidden)
+		}
+	})
+}
+
+// ---------- Admin API: users ----------
+
+var userNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]{0,63}$`)
+
+type userInfo struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Password bool   `json:"password"` // can sign in on /login
+	Token    bool   `json:"token"`    // can use the API with a token
+}
+
+// usersHandler serves GET /api/admin/users.
+func usersHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := []userInfo{}
+		for name, u := range lc.Load().Users {
+			out = append(out, userInfo{Name: name, Role: u.role().String(), Password: u.Password != "", Token: u.Token != ""})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		writeJSON(w, out)
+	}
+}
+
+// putUserHandler serves PUT /api/admin/users/{name}, adding the user or
+// changing the fields given: {"role": "editor", "password": "…",
+// "new_token": true}. A new token is in the response, and only there.
+func putUserHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		var req struct {
+			Role     string `json:"role"`
+			Password string `json:"password"`
+			NewToken bool   `json:"new_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if !userNameRe.MatchString(name) {
+			http.Error(w, "user names are 1-64 letters, digits, _ . @ and -", http.StatusBadRequest)
+			return
+		}
+		if _, err := parseRole(req.Role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var hash, token string
+		if req.Password != "" {
+			b, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hash = string(b)
+		}
+		if req.NewToken {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			token = hex.EncodeToString(b)
+		}
+		err := lc.editUsers(func(users map[string]userEntry) {
+			u := users[name]
+			if req.Role != "" {
+				u.Role, u.Admin = req.Role, false
+			}
+			if hash != "" {
+				u.Password = hash
+			}
+			if token != "" {
+				u.Token = token
+			}
+			users[name] = u
+		})
+		if err != nil {
+			writeEditUsersError(w, err)
+			return
+		}
+		log.Printf("user %s changed by %s", name, requestActor(lc, r).User)
+		u := lc.Load().Users[name]
+		writeJSON(w, map[string]any{"name": name, "role": u.role().String(), "token": token})
+	}
+}
+
+// deleteUserHandler serves DELETE /api/admin/users/{name}.
+func deleteUserHandler(lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if _, ok := lc.Load().Users[name]; !ok {
+			http.Error(w, "no such user", http.StatusNotFound)
+			return
+		}
+		if err := lc.editUsers(func(users map[string]userEntry) { delete(users, name) }); err != nil {
+			writeEditUsersError(w, err)
+			return
+		}
+		log.Printf("user %s removed by %s", name, requestActor(lc, r).User)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var (
+	errNoConfigFile = errors.New("no -config file to keep users in")
+	errNoAdmin      = errors.New("that would leave no admin to manage the users")
+	errBadUsers     = errors.New("invalid users")
+)
+
+func writeEditUsersError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoConfigFile), errors.Is(err, errNoAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errBadUsers):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// editUsers applies edit to the users of the -config file and puts the
+// result in place. The rest of the file, comments included, is kept as it
+// is; the users are written out afresh. Once there are users, one of them
+// must be an admin, or nobody could reach the admin API again.
+func (lc *liveConfig) editUsers(edit func(users map[string]userEntry)) error {
+	if lc.path == "" {
+		return errNoConfigFile
+	}
+	lc.editMu.Lock()
+	defer lc.editMu.Unlock()
+	b, err := os.ReadFile(lc.path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("parse %s: %w", lc.path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: not a mapping", lc.path)
+	}
+	var usersNode *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "users" {
+			usersNode = root.Content[i+1]
+		}
+	}
+	users := map[string]userEntry{}
+	if usersNode != nil {
+		if err := usersNode.Decode(&users); err != nil {
+			return fmt.Errorf("%s: users: %w", lc.path, err)
+		}
+	}
+	edit(users)
+	if len(users) > 0 && !hasAdmin(users) {
+		return errNoAdmin
+	}
+	var n yaml.Node
+	if err := n.Encode(users); err != nil {
+		return err
+	}
+	if usersNode != nil {
+		*usersNode = n
+	} else {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "users"}, &n)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	cfg, err := parseRuntimeConfig(lc.path, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadUsers, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(lc.path), filepath.Base(lc.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(lc.path); err == nil {
+		tmp.Chmod(fi.Mode().Perm())
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), lc.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	lc.cur.Store(cfg)
+	return nil
+}
+
+func hasAdmin(users map[string]userEntry) bool {
+	for _, u := range users {
+		if u.role() == roleAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteRole(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         role
+	}{
+		{"GET", "/", roleViewer},
+		{"GET", "/api/glyphs", roleViewer},
+		{"HEAD", "/api/glyphs", roleViewer},
+		{"OPTIONS", "/api/glyphs", roleViewer},
+		{"POST", "/api/glyphs", roleEditor},
+		{"PUT", "/api/glyphs/1", roleEditor},
+		{"DELETE", "/api/bases/1", roleEditor},
+		{"POST", "/api/convert", roleViewer},
+		{"POST", "/api/technologies/plan", roleViewer},
+		{"POST", "/api/suggest", roleViewer},
+		{"POST", "/api/plan", roleViewer},
+		{"POST", "/login", roleViewer},
+		{"POST", "/logout", roleViewer},
+		{"POST", "/api/recipes/upload", roleAdmin},
+		{"GET", "/admin", roleAdmin},
+		{"GET", "/api/admin/users", roleAdmin},
+		{"PUT", "/api/admin/aliases/o2", roleAdmin},
+	}
+	for _, tt := range tests {
+		if got := routeRole(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s needs %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWithRoles(t *testing.T) {
+	const (
+		viewerTok = "viewer-token-0123456789"
+		editorTok = "editor-token-0123456789"
+		adminTok  = "admin-token-01234567890"
+	)
+	users := map[string]userEntry{
+		"val": {Token: viewerTok, Role: "viewer"},
+		"eve": {Token: editorTok},
+		"ada": {Token: adminTok, Admin: true},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	type route struct{ method, path string }
+	var (
+		read  = route{"GET", "/api/glyphs"}
+		page  = route{"GET", "/glyphs"}
+		write = route{"POST", "/api/glyphs"}
+		admin = route{"GET", "/api/admin/users"}
+	)
+	tests := []struct {
+		name         string
+		users        map[string]userEntry
+		requireLogin bool
+		token        string
+		route        route
+		status       int
+	}{
+		{"viewer reads", users, false, viewerTok, read, http.StatusNoContent},
+		{"viewer plans", users, false, viewerTok, route{"POST", "/api/plan"}, http.StatusNoContent},
+		{"viewer batch suggests", users, false, viewerTok, route{"POST", "/api/suggest"}, http.StatusNoContent},
+		{"viewer batch suggests on v1", users, false, viewerTok, route{"POST", "/api/v1/suggest"}, http.StatusNoContent},
+		{"signed out batch suggests, login required", users, true, "", route{"POST", "/api/v1/suggest"}, http.StatusNoContent},
+		{"viewer writes", users, false, viewerTok, write, http.StatusForbidden},
+		{"viewer administers", users, false, viewerTok, admin, http.StatusForbidden},
+		{"editor reads", users, false, editorTok, read, http.StatusNoContent},
+		{"editor writes", users, false, editorTok, write, http.StatusNoContent},
+		{"editor administers", users, false, editorTok, admin, http.StatusForbidden},
+		{"admin reads", users, false, adminTok, read, http.StatusNoContent},
+		{"admin writes", users, false, adminTok, write, http.StatusNoContent},
+		{"admin administers", users, false, adminTok, admin, http.StatusNoContent},
+		{"unknown token is signed out", users, false, "not-a-token-0123456789", admin, http.StatusUnauthorized},
+		{"signed out writes", users, false, "", write, http.StatusNoContent},
+		{"signed out writes, login required", users, true, "", write, http.StatusUnauthorized},
+		{"signed out reads, login required", users, true, "", read, http.StatusNoContent},
+		{"signed out administers", users, false, "", admin, http.StatusUnauthorized},
+		{"signed out page", users, false, "", route{"GET", "/admin"}, http.StatusSeeOther},
+		{"signed out page, login required", users, true, "", page, http.StatusNoContent},
+		{"no users: everyone administers", nil, false, "", admin, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultRuntimeConfig()
+			cfg.Users, cfg.RequireLogin = tt.users, tt.requireLogin
+			h := withAPIVersions(withRoles(ok, testConfig(t, cfg)))
+			req := httptest.NewRequest(tt.route.method, tt.route.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rr.Code, tt.status, strings.TrimSpace(rr.Body.String()))
+			}
+			if rr.Code == http.StatusSeeOther && !strings.HasPrefix(rr.Header().Get("Location"), "/login?next=") {
+				t.Errorf("redirect to %q, want /login", rr.Header().Get("Location"))
+			}
+		})
+	}
+}
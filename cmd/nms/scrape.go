@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	. "fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/scrape"
+)
+
+// headerFlag collects repeatable --header 'Key: Value' flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var parts []string
+	for k, vs := range h {
+		for _, v := range vs {
+			parts = append(parts, k+": "+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return Errorf("header must look like 'Key: Value', got %q", s)
+	}
+	http.Header(h).Add(k, strings.TrimSpace(v))
+	return nil
+}
+
+// sheetTarget is one page scraped into its own workbook sheet.
+type sheetTarget struct {
+	Name string
+	URL  string
+}
+
+// sheetFlag collects repeatable --sheet 'Name=URL' flags.
+type sheetFlag []sheetTarget
+
+func (s *sheetFlag) String() string {
+	var parts []string
+	for _, t := range *s {
+		parts = append(parts, t.Name+"="+t.URL)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *sheetFlag) Set(v string) error {
+	name, u, ok := strings.Cut(v, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(u) == "" {
+		return Errorf("sheet must look like 'Name=URL', got %q", v)
+	}
+	*s = append(*s, sheetTarget{Name: name, URL: strings.TrimSpace(u)})
+	return nil
+}
+
+// ---------- Command line: scrape ----------
+
+// scrapeCmd runs `nms scrape`: it fetches a table page (plus any --sheet
+// pages) and writes CSV, TSV or XLSX. Usage examples:
+//
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.xlsx
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --selector "#table"
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --force
+//	nms scrape --url "https://example.com/page" --out out.csv --proxy http://proxy:3128 --header "Accept-Language: en" --cookie "session=abc"
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out all.xlsx --sheet "Refiner=https://app.nmsassistant.com/refiner" --xlsx-images
+//	nms scrape --url "https://app.nmsassistant.com/technology" --out technologies.csv --profile technology
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --delimiter semicolon --bom
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --preview 10
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --map columns.yaml
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --dedupe
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --manifest out.manifest.json
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --log-level debug --log-json 2>>scrape.log
+//	nms scrape --url "https://app.nmsassistant.com/cooking" --out out.csv --retries 6 --backoff 2s --timeout 45s --retry-on 429,502-504
+//
+// Repeated runs send If-None-Match/If-Modified-Since using validators kept in
+// --cache-dir and skip parsing/writing when the page answers 304.
+func scrapeCmd(args []string) {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	var (
+		pageURL     string
+		outPath     string
+		selector    string
+		cacheDir    string
+		force       bool
+		retries     int
+		backoff     time.Duration
+		timeout     time.Duration
+		retryOn     string
+		proxy       string
+		cookie      string
+		headers     = headerFlag{}
+		delay       time.Duration
+		noRobots    bool
+		sheets      sheetFlag
+		images      bool
+		profileName string
+		delimiter   string
+		bom         bool
+		preview     int
+		mapPath     string
+		dedupe      bool
+		logLevel    string
+		logJSON     bool
+		manPath     string
+	)
+	fs.StringVar(&pageURL, "url", "", "Page URL to fetch (required)")
+	fs.StringVar(&outPath, "out", "", "Output file path (.csv, .tsv or .xlsx) (required unless --preview)")
+	fs.StringVar(&selector, "selector", "#table", "CSS selector for the target table")
+	fs.StringVar(&cacheDir, "cache-dir", scrape.DefaultCacheDir(), "Directory for ETag/Last-Modified validators (empty disables caching)")
+	fs.BoolVar(&force, "force", false, "Ignore cached validators and always fetch, parse and write")
+	fs.IntVar(&retries, "retries", 3, "Retry attempts after the first request")
+	fs.DurationVar(&backoff, "backoff", 500*time.Millisecond, "Base retry delay, doubled per attempt with jitter")
+	fs.DurationVar(&timeout, "timeout", 25*time.Second, "Per-request HTTP timeout")
+	fs.StringVar(&retryOn, "retry-on", "429,5xx", "Comma-separated status codes, ranges (500-504) or classes (5xx) to retry")
+	fs.StringVar(&proxy, "proxy", "", "Proxy URL (http://, https:// or socks5://); overrides HTTP(S)_PROXY")
+	fs.Var(headers, "header", "Extra request header 'Key: Value' (repeatable)")
+	fs.StringVar(&cookie, "cookie", "", "Cookie header value, e.g. 'session=abc123'")
+	fs.DurationVar(&delay, "delay", time.Second, "Minimum delay between requests to the same host")
+	fs.BoolVar(&noRobots, "ignore-robots", false, "Do not fetch or honour robots.txt")
+	fs.Var(&sheets, "sheet", "Extra table 'Name=URL' written as its own .xlsx sheet (repeatable)")
+	fs.BoolVar(&images, "xlsx-images", false, "Embed item icons as pictures in .xlsx output")
+	fs.StringVar(&delimiter, "delimiter", "", "CSV field delimiter: comma, tab, semicolon or pipe (default comma; tab for .tsv)")
+	fs.BoolVar(&bom, "bom", false, "Write a UTF-8 byte order mark at the start of CSV output")
+	fs.IntVar(&preview, "preview", 0, "Print the first N parsed rows as a text table instead of writing --out")
+	fs.StringVar(&profileName, "profile", "recipe", "Table schema: recipe (cooking/refiner) or technology (upgrade modules)")
+	fs.BoolVar(&dedupe, "dedupe", false, "Normalize item names and drop repeated rows")
+	fs.StringVar(&mapPath, "map", "", "YAML/JSON file with per-column CSS selectors; replaces --profile")
+	fs.StringVar(&manPath, "manifest", "", "Write a SHA-256 manifest of sources, icons and outputs here (default manifest.json next to --out when embedding icons or writing extra sheets; 'none' disables)")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	fs.BoolVar(&logJSON, "log-json", false, "Write logs to stderr as JSON lines")
+	if err := parseFlags(fs, args); err != nil {
+		os.Exit(2)
+	}
+
+	if err := setupLogging(os.Stderr, logLevel, logJSON); err != nil {
+		fatal(err)
+	}
+	if pageURL == "" || (outPath == "" && preview == 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if preview < 0 {
+		fatal(errors.New("--preview must be >= 0"))
+	}
+	if retries < 0 || backoff < 0 || timeout <= 0 {
+		fatal(errors.New("--retries and --backoff must be >= 0 and --timeout > 0"))
+	}
+	retryCodes, err := scrape.ParseRetryOn(retryOn)
+	if err != nil {
+		fatal(err)
+	}
+	policy := scrape.RetryPolicy{Retries: retries, Backoff: backoff, Timeout: timeout, RetryOn: retryCodes}
+
+	parse, ok := scrape.Profiles[profileName]
+	if !ok {
+		fatal(Errorf("unknown --profile %q (want recipe or technology)", profileName))
+	}
+	if mapPath != "" {
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["profile"] {
+			fatal(errors.New("--map and --profile are mutually exclusive"))
+		}
+		m, err := scrape.LoadTableMap(mapPath)
+		if err != nil {
+			fatal(Errorf("--map: %w", err))
+		}
+		if m.Table != "" && !set["selector"] {
+			selector = m.Table
+		}
+		parse = m.Parse
+		// The mapping decides the output, so editing it must invalidate
+		// cached validators just like switching profiles.
+		b, _ := os.ReadFile(mapPath)
+		profileName = Sprintf("map:%x", sha256.Sum256(b))
+	}
+	// Output flags are checked only when a file will be written; a preview
+	// may still name --out so the same command line works for both.
+	ext := strings.ToLower(filepath.Ext(outPath))
+	isXLSX := ext == ".xlsx"
+	if delimiter == "" && ext == ".tsv" {
+		delimiter = "tab"
+	}
+	comma, err := scrape.ParseDelimiter(delimiter)
+	if err != nil {
+		fatal(err)
+	}
+	if preview == 0 {
+		if !isXLSX && ext != ".csv" && ext != ".tsv" {
+			fatal(errors.New("out must end with .csv, .tsv or .xlsx"))
+		}
+		if isXLSX && (delimiter != "" || bom) {
+			fatal(errors.New("--delimiter and --bom apply to .csv/.tsv output only"))
+		}
+		if len(sheets) > 0 && !isXLSX {
+			fatal(errors.New("--sheet needs an .xlsx output"))
+		}
+		if images && !isXLSX {
+			fatal(errors.New("--xlsx-images needs an .xlsx output"))
+		}
+	}
+	targets := append([]sheetTarget{{Name: scrape.URLSheetName(pageURL), URL: pageURL}}, sheets...)
+	if manPath == "" && (images || len(targets) > 1) {
+		manPath = filepath.Join(filepath.Dir(outPath), "manifest.json")
+	}
+	var man *scrape.Manifest
+	if manPath != "none" && manPath != "" && preview == 0 {
+		man = &scrape.Manifest{Args: scrape.RedactArgs(os.Args[1:])}
+	}
+
+	// Each request gets its own budget so extra sheets and icons do not eat
+	// into the main page's.
+	budget := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), max(60*time.Second, policy.Budget()))
+	}
+
+	cache := &scrape.HTTPCache{Dir: cacheDir}
+	key := scrape.CacheKey(pageURL, outPath, selector, profileName)
+	opts := scrape.FetchOptions{Retry: policy, Header: http.Header(headers), Cookie: cookie, Pacer: &scrape.Pacer{Delay: delay}}
+	if proxy != "" {
+		pu, err := url.Parse(proxy)
+		if err != nil || pu.Scheme == "" || pu.Host == "" {
+			fatal(Errorf("invalid --proxy %q", proxy))
+		}
+		opts.Proxy = pu
+	}
+	var robots *scrape.RobotsCache
+	if !noRobots {
+		robots = &scrape.RobotsCache{Opts: opts}
+	}
+	// The 304 shortcut only covers a single page: with extra sheets any one
+	// of them may have changed, so the workbook is always rebuilt.
+	if _, statErr := os.Stat(outPath); statErr == nil && !force && len(targets) == 1 && preview == 0 {
+		if e, ok := cache.Get(key); ok {
+			opts.Cached = &e
+		}
+	}
+
+	var tables []scrape.XLSXSheet
+	var validators scrape.CacheEntry
+	total := 0
+	for i, t := range targets {
+		tu, err := url.Parse(t.URL)
+		if err != nil {
+			fatal(err)
+		}
+		ctx, cancel := budget()
+		if !robots.Allowed(ctx, tu) {
+			fatal(Errorf("%s is disallowed by robots.txt (use --ignore-robots to override)", t.URL))
+		}
+		topts := opts
+		if i > 0 {
+			topts.Cached = nil
+		}
+		html, base, v, err := scrape.Fetch(ctx, t.URL, topts)
+		cancel()
+		if errors.Is(err, scrape.ErrNotModified) {
+			slog.Info("output up to date (use --force to rewrite)", "url", t.URL, "out", outPath, "since", opts.Cached.FetchedAt.Format(time.RFC3339))
+			return
+		}
+		if err != nil {
+			fatal(err)
+		}
+		parseStart := time.Now()
+		data, err := parse(html, base, selector)
+		if err != nil {
+			fatal(Errorf("%s: %w", t.URL, err))
+		}
+		slog.Info("parsed", "url", t.URL, "sheet", t.Name, "rows", len(data.Records), "duration", time.Since(parseStart))
+		if dedupe {
+			if n := data.Dedupe(); n > 0 {
+				slog.Info("dropped duplicate rows", "url", t.URL, "dropped", n, "rows", len(data.Records))
+			}
+		}
+		if man != nil {
+			man.Sources = append(man.Sources, scrape.ManifestSource{
+				URL: t.URL, FinalURL: base.String(), Sheet: t.Name, FetchedAt: v.FetchedAt,
+				SHA256: scrape.SHA256Hex([]byte(html)), Bytes: len(html), Rows: len(data.Records),
+			})
+		}
+		if len(data.Records) == 0 {
+			fatal(Errorf("%s: parsed 0 rows; check selector or that the page is server-rendered", t.URL))
+		}
+		if i == 0 {
+			validators = v
+		}
+		tables = append(tables, scrape.XLSXSheet{Name: t.Name, Data: data})
+		total += len(data.Records)
+	}
+
+	if preview > 0 {
+		for _, t := range tables {
+			if err := scrape.PrintPreview(os.Stdout, t.Name, t.Data, preview); err != nil {
+				fatal(err)
+			}
+		}
+		return
+	}
+
+	if isXLSX {
+		var xopt scrape.XLSXOptions
+		if images {
+			xopt.Image = func(u string) ([]byte, error) {
+				iu, err := url.Parse(u)
+				if err != nil {
+					return nil, err
+				}
+				ctx, cancel := budget()
+				defer cancel()
+				if !robots.Allowed(ctx, iu) {
+					return nil, errors.New("disallowed by robots.txt")
+				}
+				iopts := opts
+				iopts.Cached = nil
+				body, _, _, err := scrape.Fetch(ctx, u, iopts)
+				man.AddAsset(u, []byte(body), err)
+				return []byte(body), err
+			}
+		}
+		err = scrape.WriteXLSX(outPath, tables, xopt)
+	} else {
+		err = scrape.WriteCSV(outPath, tables[0].Data, scrape.CSVOptions{Comma: comma, BOM: bom})
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if len(targets) == 1 {
+		validators.Key = key
+		if err := cache.Put(validators); err != nil {
+			slog.Warn("cache write failed", "err", err)
+		}
+	}
+
+	slog.Info("wrote output", "out", outPath, "rows", total, "sheets", len(tables))
+
+	if man != nil {
+		if err := man.AddOutput(outPath, total); err != nil {
+			fatal(Errorf("manifest: %w", err))
+		}
+		if err := man.Write(manPath); err != nil {
+			fatal(Errorf("manifest: %w", err))
+		}
+		slog.Info("wrote manifest", "path", manPath, "sources", len(man.Sources), "assets", len(man.Assets))
+	}
+}
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
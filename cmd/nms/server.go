@@ -0,0 +1,1801 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/recipes"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+type apiResp struct {
+	Mapped       []string     `json:"mapped"`
+	Unrecognized []string     `json:"unrecognized"`
+	Suggestions  []suggestion `json:"suggestions"`
+	// Total counts the suggestions before limit and offset.
+	Total int `json:"total"`
+	// Matches say what each piece of input was taken for, and how surely.
+	Matches []recipes.Match `json:"matches,omitempty"`
+	// DidYouMean offers up to didYouMeanLimit names for each unrecognized
+	// input.
+	DidYouMean map[string][]recipes.Candidate `json:"did_you_mean,omitempty"`
+	// NearMisses is only set when there are no suggestions.
+	NearMisses []recipes.NearMiss `json:"near_misses,omitempty"`
+	// MissingOne are recipes the ingredients make but for one more.
+	MissingOne []recipes.MissingOne `json:"missing_one,omitempty"`
+	// Items has the info on every item named above, for icons and links.
+	Items map[string]recipes.ItemInfo `json:"items,omitempty"`
+}
+
+// names are the items the response names.
+func (a apiResp) names() []string {
+	names := slices.Clone(a.Mapped)
+	add := func(r recipes.Recipe) { names = append(append(names, r.Output), r.Inputs...) }
+	for _, s := range a.Suggestions {
+		add(s.Recipe)
+	}
+	for _, nm := range a.NearMisses {
+		add(nm.Recipe)
+	}
+	for _, m := range a.MissingOne {
+		add(m.Recipe)
+	}
+	return names
+}
+
+// suggestion is a recipe with how hard it is to make that way (left out
+// when it can't be rated, e.g. a custom recipe's unknown input). Recipes from
+// the user's overlay say so in Source: "custom", or "override" when they
+// replace a shared recipe; shared ones have the ID of /api/recipes/{id}.
+// Value is set when -values has one for the output.
+type suggestion struct {
+	ID string `json:"id,omitempty"`
+	recipes.Recipe
+	Difficulty *recipes.Difficulty  `json:"difficulty,omitempty"`
+	Value      *recipes.RecipeValue `json:"value,omitempty"`
+	Source     string               `json:"source,omitempty"`
+	CustomID   string               `json:"custom_id,omitempty"`
+	Note       string               `json:"note,omitempty"`
+}
+
+// nearMissLimit caps the near misses returned with an empty suggestion list.
+const nearMissLimit = 5
+
+// missingOneLimit caps the recipes listed as one ingredient short.
+const missingOneLimit = 20
+
+// didYouMeanLimit caps the names offered for an unrecognized ingredient.
+const didYouMeanLimit = 3
+
+// baseView is a base together with the glyph it is linked to, if any, and
+// the recorded star system that glyph points into.
+type baseView struct {
+	Base
+	Glyph  *glyphs.Glyph `json:"glyph,omitempty"`
+	System *System       `json:"system,omitempty"`
+}
+
+type pageData struct {
+	Title   string
+	Heading string
+	Active  string
+	APIBase string
+	BgDark2 string
+	Big     bool
+	Item    any
+}
+
+// The recipe handlers take the DB as a function so a reload swaps it
+// between requests; each request works on the DB it started with. The
+// signed-in user's custom recipes for the dataset are merged in per request.
+
+// suggestOpts are the query options of the suggest endpoints.
+type suggestOpts struct {
+	maxDiff  int // -1 for no cap
+	early    bool
+	distinct bool
+	sortBy   string
+	limit    int // suggestions kept, 0 for all
+	offset   int // suggestions skipped first
+}
+
+// suggestSorts are the sort= orders of suggestions; without one they
+// come in dataset order.
+var suggestSorts = []string{"name", "qty", "inputs", "value"}
+
+var errSuggestSort = errors.New("sort must be name, qty, inputs or value")
+
+// unlimited is o keeping every suggestion, for answers merged before
+// the page is cut.
+func (o suggestOpts) unlimited() suggestOpts {
+	o.limit, o.offset = 0, 0
+	return o
+}
+
+func readSuggestOpts(q url.Values) (suggestOpts, error) {
+	o := suggestOpts{maxDiff: -1, early: q.Get("early_game") == "1", distinct: q.Get("distinct_outputs") == "1", sortBy: q.Get("sort")}
+	if v := q.Get("max_difficulty"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, errors.New("max_difficulty must be a non-negative integer")
+		}
+		o.maxDiff = n
+	}
+	if o.sortBy != "" && !slices.Contains(suggestSorts, o.sortBy) {
+		return o, errSuggestSort
+	}
+	if q.Has("limit") || q.Has("offset") {
+		var page listPage
+		if err := page.read(q); err != nil {
+			return o, err
+		}
+		o.limit, o.offset = page.Limit, page.Offset
+	}
+	return o, nil
+}
+
+// compareSuggestions orders two suggestions by sort=: name A-Z, qty
+// most first, inputs fewest first, value as compareValues, best first.
+// Ties keep their order.
+func compareSuggestions(by string, a, b suggestion) int {
+	switch by {
+	case "name":
+		return cmp.Compare(norm.Key(a.Output), norm.Key(b.Output))
+	case "qty":
+		return cmp.Compare(b.Qty, a.Qty)
+	case "inputs":
+		return cmp.Compare(len(a.Inputs), len(b.Inputs))
+	case "value":
+		return compareValues(b.Value, a.Value)
+	}
+	return 0
+}
+
+// pageOf is the part of s the limit and offset of o keep.
+func pageOf[T any](s []T, o suggestOpts) []T {
+	lo := min(o.offset, len(s))
+	if o.limit > 0 && lo+o.limit < len(s) {
+		return s[lo : lo+o.limit]
+	}
+	return s[lo:]
+}
+
+// keep reports whether the options let a recipe through, with its
+// difficulty when it can be rated. max_difficulty caps the crafting depth;
+// recipes that can't be rated (inputs only a cycle makes) are left out when
+// it is set. early_game=1 keeps recipes needing no advanced tech.
+func (o suggestOpts) keep(db *recipeSet, r recipes.Recipe) (*recipes.Difficulty, bool) {
+	d, ok := db.difficulty.Recipe(r)
+	if o.maxDiff >= 0 && (!ok || d.Depth > o.maxDiff) {
+		return nil, false
+	}
+	if o.early && !db.early.Recipe(r) {
+		return nil, false
+	}
+	if !ok {
+		return nil, true
+	}
+	return &d, true
+}
+
+func suggestHandler(dbFn func() *recipeSet, dataset string, ov overlay, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, err := readSuggestOpts(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		have := strings.TrimSpace(r.URL.Query().Get("have"))
+		if have == "" {
+			http.Error(w, "missing 'have' query param", http.StatusBadRequest)
+			return
+		}
+		db := dbFn()
+		resp := suggest(db, ov.mine(r, dataset), splitCSVLike(have), o)
+		resp.Items = db.items(resp.names(), icons)
+		w.Header().Set("X-Total-Count", strconv.Itoa(resp.Total))
+		writeList(w, r, resp)
+	}
+}
+
+// suggest answers a suggest request on one dataset, with the user's custom
+// recipes for it.
+func suggest(db *recipeSet, mine []CustomRecipe, parts []string, o suggestOpts) apiResp {
+	// Names from the user's own recipes are taken as they are, before
+	// the rest is fuzzy-matched against the dataset.
+	var matches []recipes.Match
+	if names := customNames(mine); len(names) > 0 {
+		rest := parts[:0:0]
+		for _, p := range parts {
+			if n, ok := names[norm.Key(p)]; ok {
+				matches = append(matches, recipes.Match{Input: p, Name: n, Confidence: 1})
+			} else {
+				rest = append(rest, p)
+			}
+		}
+		parts = rest
+	}
+	shared, unknown := db.matchIngredients(parts)
+	matches = append(matches, shared...)
+	mapped := recipes.Names(matches)
+	if unknown == nil {
+		unknown = []string{}
+	}
+	sugs := db.Suggest(mapped)
+	merged := mergeCustom(db, mine, mapped, sugs)
+	if o.distinct {
+		merged = distinctSuggestions(merged)
+	}
+	views := []suggestion{}
+	for _, s := range merged {
+		d, ok := o.keep(db, s.Recipe)
+		if !ok {
+			continue
+		}
+		s.Difficulty = d
+		s.Value = db.value(s.Recipe)
+		views = append(views, s)
+	}
+	// The page is cut after sorting, so it is a page of the whole order.
+	if o.sortBy != "" {
+		slices.SortStableFunc(views, func(a, b suggestion) int { return compareSuggestions(o.sortBy, a, b) })
+	}
+
+	resp := apiResp{
+		Mapped:       mapped,
+		Unrecognized: unknown,
+		Matches:      matches,
+		DidYouMean:   db.didYouMean(unknown),
+		Suggestions:  pageOf(views, o),
+		Total:        len(views),
+	}
+	// The same filters hold for what one more ingredient would make.
+	for _, m := range recipes.MissingOnes(db, mapped, 4*missingOneLimit) {
+		if _, ok := o.keep(db, m.Recipe); ok && len(resp.MissingOne) < missingOneLimit {
+			resp.MissingOne = append(resp.MissingOne, m)
+		}
+	}
+	if len(merged) == 0 {
+		if o.early {
+			// Ask for more so some are left after dropping late-game ones.
+			for _, nm := range db.NearMisses(mapped, 4*nearMissLimit) {
+				if db.early.Recipe(nm.Recipe) && len(resp.NearMisses) < nearMissLimit {
+					resp.NearMisses = append(resp.NearMisses, nm)
+				}
+			}
+		} else {
+			resp.NearMisses = db.NearMisses(mapped, nearMissLimit)
+		}
+	}
+	return resp
+}
+
+// allResp answers /api/suggest/all: the answers of both datasets in one,
+// each recipe tagged with the dataset it is from.
+type allResp struct {
+	Mapped       []string        `json:"mapped"`
+	Unrecognized []string        `json:"unrecognized"` // by neither dataset
+	Matches      []recipes.Match `json:"matches,omitempty"`
+	Suggestions  []allSuggestion `json:"suggestions"`
+	Total        int             `json:"total"` // before limit and offset
+	NearMisses   []allNearMiss   `json:"near_misses,omitempty"`
+	MissingOne   []allMissingOne `json:"missing_one,omitempty"`
+	// DidYouMean offers names from either dataset.
+	DidYouMean map[string][]recipes.Candidate `json:"did_you_mean,omitempty"`
+	// Items has the info on every item named above, from either dataset.
+	Items map[string]recipes.ItemInfo `json:"items,omitempty"`
+}
+
+// names are the items the response names.
+func (a allResp) names() []string {
+	names := slices.Clone(a.Mapped)
+	add := func(r recipes.Recipe) { names = append(append(names, r.Output), r.Inputs...) }
+	for _, s := range a.Suggestions {
+		add(s.Recipe)
+	}
+	for _, nm := range a.NearMisses {
+		add(nm.Recipe)
+	}
+	for _, m := range a.MissingOne {
+		add(m.Recipe)
+	}
+	return names
+}
+
+// allSuggestion is a suggestion with the dataset it is from in Source;
+// the custom or override label of suggestion.Source moves to Origin.
+type allSuggestion struct {
+	suggestion
+	Source string `json:"source"`
+	Origin string `json:"origin,omitempty"`
+}
+
+// allNearMiss and allMissingOne are near misses and recipes one short
+// tagged with their dataset.
+type allNearMiss struct {
+	recipes.NearMiss
+	Source string `json:"source"`
+}
+
+type allMissingOne struct {
+	recipes.MissingOne
+	Source string `json:"source"`
+}
+
+// suggestAllHandler serves GET /api/suggest/all, /api/suggest and
+// /api/refiner/suggest in one call. The ingredients are mapped in each
+// dataset; an ingredient only one of them knows is unrecognized by the
+// other alone, so only those neither knows are listed. sort=value sorts
+// the suggestions of both together.
+func suggestAllHandler(rec *liveRecipes, ov overlay, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, err := readSuggestOpts(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		have := strings.TrimSpace(r.URL.Query().Get("have"))
+		if have == "" {
+			http.Error(w, "missing 'have' query param", http.StatusBadRequest)
+			return
+		}
+		g := rec.graph()
+		resp := suggestAll(g, ov, r, splitCSVLike(have), o)
+		resp.Items = g.items(resp.names(), icons)
+		w.Header().Set("X-Total-Count", strconv.Itoa(resp.Total))
+		writeList(w, r, resp)
+	}
+}
+
+// suggestAll answers a suggest request on both datasets.
+func suggestAll(g craftGraph, ov overlay, r *http.Request, parts []string, o suggestOpts) allResp {
+	resp := allResp{Mapped: []string{}, Unrecognized: []string{}, Suggestions: []allSuggestion{}}
+	g.each(func(source string, db *recipeSet) {
+		one := suggest(db, ov.mine(r, source), parts, o.unlimited())
+		for _, m := range one.Mapped {
+			if !slices.Contains(resp.Mapped, m) {
+				resp.Mapped = append(resp.Mapped, m)
+			}
+		}
+		for _, m := range one.Matches {
+			if !slices.Contains(resp.Matches, m) {
+				resp.Matches = append(resp.Matches, m)
+			}
+		}
+		for _, s := range one.Suggestions {
+			resp.Suggestions = append(resp.Suggestions, allSuggestion{suggestion: s, Source: source, Origin: s.Source})
+		}
+		for _, nm := range one.NearMisses {
+			resp.NearMisses = append(resp.NearMisses, allNearMiss{NearMiss: nm, Source: source})
+		}
+		for _, m := range one.MissingOne {
+			resp.MissingOne = append(resp.MissingOne, allMissingOne{MissingOne: m, Source: source})
+		}
+	})
+	// Names from the user's own recipes are known, as suggest takes them.
+	names := customNames(ov.mine(r, ""))
+	rest := parts[:0:0]
+	for _, p := range parts {
+		if _, ok := names[norm.Key(p)]; !ok {
+			rest = append(rest, p)
+		}
+	}
+	_, resp.Unrecognized = g.mapIngredients(rest)
+	resp.DidYouMean = g.didYouMean(resp.Unrecognized)
+	if len(resp.Suggestions) > 0 {
+		resp.NearMisses = nil
+	}
+	if o.sortBy != "" {
+		slices.SortStableFunc(resp.Suggestions, func(a, b allSuggestion) int {
+			return compareSuggestions(o.sortBy, a.suggestion, b.suggestion)
+		})
+	}
+	resp.Total = len(resp.Suggestions)
+	resp.Suggestions = pageOf(resp.Suggestions, o)
+	return resp
+}
+
+// batchRequest is the body of POST /api/suggest: one ingredient list,
+// named sets of them, or both, answered with the same options.
+type batchRequest struct {
+	Ingredients []string            `json:"ingredients,omitempty"` // answered under "default"
+	Sets        map[string][]string `json:"sets,omitempty"`
+	Options     struct {
+		Mode            string `json:"mode,omitempty"` // food (default), refiner or all
+		MaxDifficulty   *int   `json:"max_difficulty,omitempty"`
+		EarlyGame       bool   `json:"early_game,omitempty"`
+		DistinctOutputs bool   `json:"distinct_outputs,omitempty"`
+		Sort            string `json:"sort,omitempty"`   // name, qty, inputs or value
+		Limit           int    `json:"limit,omitempty"`  // suggestions per set, 0 for all
+		Offset          int    `json:"offset,omitempty"` // suggestions per set skipped first
+	} `json:"options"`
+}
+
+// batchResp answers POST /api/suggest: what GET /api/suggest (or
+// /api/refiner/suggest, /api/suggest/all, by mode) answers for each set.
+type batchResp struct {
+	Results map[string]any `json:"results"`
+}
+
+// Caps on a batch.
+const (
+	maxBatchSets        = 20
+	maxBatchIngredients = 100 // per set
+)
+
+// batchSuggestHandler serves POST /api/suggest. The names are taken as
+// given, so they may hold commas.
+func batchSuggestHandler(rec *liveRecipes, ov overlay, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body batchRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		sets := map[string][]string{}
+		for name, list := range body.Sets {
+			sets[name] = list
+		}
+		if body.Ingredients != nil {
+			if _, dup := sets["default"]; dup {
+				http.Error(w, "ingredients and a set named default both given", http.StatusBadRequest)
+				return
+			}
+			sets["default"] = body.Ingredients
+		}
+		if len(sets) == 0 {
+			http.Error(w, "no ingredients or sets given", http.StatusBadRequest)
+			return
+		}
+		if len(sets) > maxBatchSets {
+			http.Error(w, "at most "+strconv.Itoa(maxBatchSets)+" sets", http.StatusBadRequest)
+			return
+		}
+		opt := body.Options
+		o := suggestOpts{maxDiff: -1, early: opt.EarlyGame, distinct: opt.DistinctOutputs, sortBy: opt.Sort, limit: opt.Limit, offset: opt.Offset}
+		switch {
+		case opt.MaxDifficulty != nil && *opt.MaxDifficulty < 0:
+			http.Error(w, "max_difficulty must be a non-negative integer", http.StatusBadRequest)
+			return
+		case o.sortBy != "" && !slices.Contains(suggestSorts, o.sortBy):
+			http.Error(w, errSuggestSort.Error(), http.StatusBadRequest)
+			return
+		case o.limit < 0:
+			http.Error(w, "limit must not be negative", http.StatusBadRequest)
+			return
+		case o.offset < 0:
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		case opt.Mode != "" && opt.Mode != "food" && opt.Mode != "refiner" && opt.Mode != "all":
+			http.Error(w, "mode must be food, refiner or all", http.StatusBadRequest)
+			return
+		}
+		if opt.MaxDifficulty != nil {
+			o.maxDiff = *opt.MaxDifficulty
+		}
+		g := rec.graph()
+		resp := batchResp{Results: map[string]any{}}
+		for name, list := range sets {
+			if len(list) > maxBatchIngredients {
+				http.Error(w, fmt.Sprintf("set %q: at most %d ingredients", name, maxBatchIngredients), http.StatusBadRequest)
+				return
+			}
+			var parts []string
+			for _, p := range list {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+			switch opt.Mode {
+			case "all":
+				one := suggestAll(g, ov, r, parts, o)
+				one.Items = g.items(one.names(), icons)
+				resp.Results[name] = one
+			case "refiner":
+				one := suggest(g.refiner, ov.mine(r, "refiner"), parts, o)
+				one.Items = g.refiner.items(one.names(), icons)
+				resp.Results[name] = one
+			default:
+				one := suggest(g.food, ov.mine(r, "food"), parts, o)
+				one.Items = g.food.items(one.names(), icons)
+				resp.Results[name] = one
+			}
+		}
+		writeList(w, r, resp)
+	}
+}
+
+// allIngredientsHandler serves GET /api/ingredients/all: the ingredients
+// of both datasets, with the user's custom ones, in one sorted list.
+func allIngredientsHandler(rec *liveRecipes, ov overlay, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		seen := map[string]bool{}
+		list := []string{}
+		g := rec.graph()
+		g.each(func(source string, db *recipeSet) {
+			for _, n := range ov.ingredients(r, source, db.Ingredients()) {
+				if !seen[n] {
+					seen[n] = true
+					list = append(list, n)
+				}
+			}
+		})
+		sort.Strings(list)
+		if r.URL.Query().Get("info") == "1" {
+			writeJSONCached(w, r, ingredientInfos(list, g.items(list, icons)))
+			return
+		}
+		writeJSONCached(w, r, list)
+	}
+}
+
+// ingredientInfo is an ingredient with its item info, as ?info=1 lists
+// them.
+type ingredientInfo struct {
+	Name string `json:"name"`
+	recipes.ItemInfo
+}
+
+func ingredientInfos(names []string, items map[string]recipes.ItemInfo) []ingredientInfo {
+	out := make([]ingredientInfo, 0, len(names))
+	for _, n := range names {
+		out = append(out, ingredientInfo{Name: n, ItemInfo: items[n]})
+	}
+	return out
+}
+
+// aliasesHandler serves GET /api/admin/aliases.
+func aliasesHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, rec.opts.aliases.List())
+	}
+}
+
+// putAliasHandler serves PUT /api/admin/aliases/{alias} {"name": "Oxygen"},
+// adding the alias or pointing it at another item. The item must be an
+// ingredient of either dataset, and the alias must not be one itself.
+func putAliasHandler(rec *liveRecipes, lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := strings.TrimSpace(r.PathValue("alias"))
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if norm.Key(alias) == "" || utf8.RuneCountInString(alias) > 64 {
+			http.Error(w, "aliases are 1-64 characters", http.StatusBadRequest)
+			return
+		}
+		var name string
+		shadows := false
+		rec.graph().each(func(_ string, db *recipeSet) {
+			for _, ing := range db.Ingredients() {
+				if name == "" && norm.Key(ing) == norm.Key(req.Name) {
+					name = ing
+				}
+				shadows = shadows || norm.Key(ing) == norm.Key(alias)
+			}
+		})
+		switch {
+		case name == "":
+			http.Error(w, "name must be an ingredient of either dataset", http.StatusBadRequest)
+			return
+		case shadows:
+			http.Error(w, alias+" is an ingredient already", http.StatusBadRequest)
+			return
+		}
+		if err := rec.opts.aliases.Set(alias, name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("alias %s -> %s set by %s", alias, name, requestActor(lc, r).User)
+		writeJSON(w, recipes.Alias{Alias: alias, Name: name})
+	}
+}
+
+// deleteAliasHandler serves DELETE /api/admin/aliases/{alias}.
+func deleteAliasHandler(rec *liveRecipes, lc *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := r.PathValue("alias")
+		ok, err := rec.opts.aliases.Delete(alias)
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case !ok:
+			http.Error(w, "no such alias", http.StatusNotFound)
+			return
+		}
+		log.Printf("alias %s removed by %s", alias, requestActor(lc, r).User)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// compareValues orders recipe values: one with value added above one
+// without, then by value added, then by output value; nil lowest.
+func compareValues(a, b *recipes.RecipeValue) int {
+	switch {
+	case a == nil || b == nil:
+		return cmp.Compare(btoi(a != nil), btoi(b != nil))
+	case (a.Added == nil) != (b.Added == nil):
+		return cmp.Compare(btoi(a.Added != nil), btoi(b.Added != nil))
+	case a.Added != nil && *a.Added != *b.Added:
+		return cmp.Compare(*a.Added, *b.Added)
+	}
+	return cmp.Compare(a.Output, b.Output)
+}
+
+// compareOutputValues orders recipe values by output value; nil lowest.
+func compareOutputValues(a, b *recipes.RecipeValue) int {
+	if a == nil || b == nil {
+		return cmp.Compare(btoi(a != nil), btoi(b != nil))
+	}
+	return cmp.Compare(a.Output, b.Output)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// distinctSuggestions keeps the suggestions recipes.DistinctOutputs would
+// keep of their recipes, labels and all.
+func distinctSuggestions(sugs []suggestion) []suggestion {
+	recs := make([]recipes.Recipe, len(sugs))
+	for i, s := range sugs {
+		recs[i] = s.Recipe
+	}
+	keep := map[string]bool{}
+	for _, r := range recipes.DistinctOutputs(recs) {
+		keep[r.Key()] = true
+	}
+	var out []suggestion
+	for _, s := range sugs {
+		if k := s.Key(); keep[k] {
+			out = append(out, s)
+			delete(keep, k)
+		}
+	}
+	return out
+}
+
+// ingredientsDelta answers /api/ingredients?since=VERSION.
+type ingredientsDelta struct {
+	Version     string   `json:"version"`
+	Full        bool     `json:"full,omitempty"` // since was unknown: ingredients is the whole list
+	Ingredients []string `json:"ingredients,omitempty"`
+	Added       []string `json:"added"`
+	Removed     []string `json:"removed"`
+}
+
+// ingredientHistory keeps the last few ingredient lists served, by version,
+// so a client can ask what changed since the one it has cached. A version
+// is a hash of the list, so it outlives restarts and holds for every user
+// whose custom recipes give them the same list.
+type ingredientHistory struct {
+	mu    sync.Mutex
+	lists map[string][]string
+	order []string
+}
+
+const ingredientHistoryLen = 64
+
+func (h *ingredientHistory) remember(list []string) string {
+	v := sha256Hex([]byte(strings.Join(list, "\n")))[:16]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lists == nil {
+		h.lists = map[string][]string{}
+	}
+	if _, ok := h.lists[v]; !ok {
+		h.lists[v] = list
+		h.order = append(h.order, v)
+		if len(h.order) > ingredientHistoryLen {
+			delete(h.lists, h.order[0])
+			h.order = h.order[1:]
+		}
+	}
+	return v
+}
+
+func (h *ingredientHistory) get(v string) ([]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list, ok := h.lists[v]
+	return list, ok
+}
+
+// ingredientsHandler serves the ingredient list. With ?since=VERSION it
+// returns what was added and removed since that version instead (the whole
+// list, marked full, when the version is unknown: use since=0 to start).
+// ?wait=N holds the answer up to N seconds (max 60) until the list changes.
+func ingredientsHandler(dbFn func() *recipeSet, dataset string, ov overlay, icons itemIcons, changes func() <-chan struct{}) http.HandlerFunc {
+	var hist ingredientHistory
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		list := func() []string { return ov.ingredients(r, dataset, dbFn().Ingredients()) }
+		if !q.Has("since") {
+			if q.Get("info") == "1" {
+				names := list()
+				writeJSONCached(w, r, ingredientInfos(names, dbFn().items(names, icons)))
+				return
+			}
+			writeJSONCached(w, r, list())
+			return
+		}
+		wait := 0
+		if v := q.Get("wait"); v != "" {
+			var err error
+			if wait, err = strconv.Atoi(v); err != nil || wait < 0 || wait > 60 {
+				http.Error(w, "wait must be 0-60 seconds", http.StatusBadRequest)
+				return
+			}
+		}
+		since := q.Get("since")
+		// Subscribe before reading the list so a reload in between is seen.
+		changed := changes()
+		cur := list()
+		v := hist.remember(cur)
+		if v == since && wait > 0 {
+			t := time.NewTimer(time.Duration(wait) * time.Second)
+			defer t.Stop()
+			select {
+			case <-changed:
+				cur = list()
+				v = hist.remember(cur)
+			case <-t.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		resp := ingredientsDelta{Version: v, Added: []string{}, Removed: []string{}}
+		old, ok := hist.get(since)
+		if !ok {
+			resp.Full, resp.Ingredients = true, cur
+			writeJSON(w, resp)
+			return
+		}
+		had := map[string]bool{}
+		for _, n := range old {
+			had[n] = true
+		}
+		for _, n := range cur {
+			if had[n] {
+				delete(had, n)
+			} else {
+				resp.Added = append(resp.Added, n)
+			}
+		}
+		for n := range had {
+			resp.Removed = append(resp.Removed, n)
+		}
+		sort.Strings(resp.Added)
+		sort.Strings(resp.Removed)
+		writeJSON(w, resp)
+	}
+}
+
+type itemsResp struct {
+	Items      map[string]recipes.ItemInfo `json:"items"`
+	Categories map[string]string           `json:"categories"` // category -> colour legend
+}
+
+// itemsHandler serves every known item (inputs and outputs) with its
+// category, colour, difficulty, early-game flag and uploaded icon.
+func itemsHandler(dbFn func() *recipeSet, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db := dbFn()
+		writeJSON(w, itemsResp{Items: db.items(db.ItemNames(), icons), Categories: recipes.CategoryColors})
+	}
+}
+
+// itemInfo is the dataset's info on an item with its difficulty and
+// early-game flag.
+func (db *recipeSet) itemInfo(name string) recipes.ItemInfo {
+	info := db.Info(name)
+	if d, ok := db.difficulty.Item(name); ok {
+		info.Difficulty = &d
+	}
+	info.EarlyGame = db.early.Item(name)
+	return info
+}
+
+// didYouMean offers the names nearest each unrecognized input, nil when
+// there are none to offer.
+func (db *recipeSet) didYouMean(unknown []string) map[string][]recipes.Candidate {
+	var out map[string][]recipes.Candidate
+	for _, u := range unknown {
+		if cs := recipes.Closest(db.match, u, db.Ingredients(), didYouMeanLimit); len(cs) > 0 {
+			if out == nil {
+				out = map[string][]recipes.Candidate{}
+			}
+			out[u] = cs
+		}
+	}
+	return out
+}
+
+// items is the info on the named items, with their uploaded icons.
+func (db *recipeSet) items(names []string, icons itemIcons) map[string]recipes.ItemInfo {
+	out := make(map[string]recipes.ItemInfo, len(names))
+	for _, name := range names {
+		out[name] = db.itemInfo(name)
+	}
+	for name, u := range icons.urls(names) {
+		info := out[name]
+		info.Icon = u
+		out[name] = info
+	}
+	return out
+}
+
+// outputHit is a searched output name with the recipes that make it.
+type outputHit struct {
+	recipes.OutputHit
+	Recipes []recipes.Recipe `json:"recipes"`
+}
+
+// recipeSearchHandler serves GET /api/recipes/search?output=stew: the
+// outputs best matching a partial name (see recipes.SearchOutputs), each
+// with its recipes. ?dataset=refiner searches the refiner table, ?limit=
+// caps the hits (default 10).
+func recipeSearchHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var db *recipeSet
+		switch q.Get("dataset") {
+		case "", "food":
+			db = rec.Food()
+		case "refiner":
+			db = rec.Refiner()
+		default:
+			http.Error(w, "dataset must be food or refiner", http.StatusBadRequest)
+			return
+		}
+		output := strings.TrimSpace(q.Get("output"))
+		if output == "" {
+			http.Error(w, "missing 'output' query param", http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if v := q.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 50 {
+				http.Error(w, "limit must be 1-50", http.StatusBadRequest)
+				return
+			}
+		}
+		hits := []outputHit{}
+		for _, h := range recipes.SearchOutputs(db.Outputs(), output, limit) {
+			hits = append(hits, outputHit{OutputHit: h, Recipes: db.ByOutput(h.Output)})
+		}
+		writeList(w, r, hits)
+	}
+}
+
+// recipeEntry is a recipe of the datasets with its ID and the dataset it
+// is in (food or refiner).
+type recipeEntry struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	recipes.Recipe
+}
+
+// sources are the datasets a ?source= value picks: food, refiner, or both
+// when it is empty.
+func (rec *liveRecipes) sources(s string) (map[string]*recipeSet, error) {
+	switch s {
+	case "":
+		return map[string]*recipeSet{"food": rec.Food(), "refiner": rec.Refiner()}, nil
+	case "food":
+		return map[string]*recipeSet{"food": rec.Food()}, nil
+	case "refiner":
+		return map[string]*recipeSet{"refiner": rec.Refiner()}, nil
+	}
+	return nil, errors.New("source must be food or refiner")
+}
+
+// recipeListHandler serves GET /api/recipes: every recipe, food first,
+// in file order, a page at a time (?limit=, default 50, and ?offset=).
+// ?source=food|refiner keeps one dataset, ?output= the recipes whose
+// output contains the text and ?ingredient= those with an input that does.
+func recipeListHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		sets, err := rec.sources(q.Get("source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := listPage{Limit: 50}
+		if err := page.read(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		output, ingredient := norm.Key(q.Get("output")), norm.Key(q.Get("ingredient"))
+		var all []recipeEntry
+		for _, source := range []string{"food", "refiner"} {
+			db, ok := sets[source]
+			if !ok {
+				continue
+			}
+			for _, rc := range db.All() {
+				if output != "" && !strings.Contains(norm.Key(rc.Output), output) {
+					continue
+				}
+				if ingredient != "" && !slices.ContainsFunc(rc.Inputs, func(in string) bool {
+					return strings.Contains(norm.Key(in), ingredient)
+				}) {
+					continue
+				}
+				all = append(all, recipeEntry{ID: rc.ID(), Source: source, Recipe: rc})
+			}
+		}
+		page.Total = len(all)
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		lo, hi := page.bounds()
+		page.Items = []any{}
+		for _, e := range all[lo:hi] {
+			page.Items = append(page.Items, e)
+		}
+		writeList(w, r, page)
+	}
+}
+
+// byOutputResp answers /api/recipes/by-output.
+type byOutputResp struct {
+	Output  string        `json:"output"` // the name matched; "" when none was
+	Recipes []recipeEntry `json:"recipes"`
+}
+
+// recipesByOutputHandler serves GET /api/recipes/by-output?name=: the
+// output name is matched as ingredients are (exact, then the closest
+// within a few typos) across both datasets, or the one of ?source=, and
+// every recipe making it is returned, food first.
+func recipesByOutputHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		sets, err := rec.sources(q.Get("source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(q.Get("name"))
+		if name == "" {
+			http.Error(w, "missing 'name' query param", http.StatusBadRequest)
+			return
+		}
+		var outputs []string
+		for _, source := range []string{"food", "refiner"} {
+			if db, ok := sets[source]; ok {
+				outputs = append(outputs, db.Outputs()...)
+			}
+		}
+		resp := byOutputResp{Recipes: []recipeEntry{}}
+		if out, ok := recipes.MapName(name, outputs); ok {
+			resp.Output = out
+			for _, source := range []string{"food", "refiner"} {
+				if db, ok := sets[source]; ok {
+					for _, rc := range db.ByOutput(out) {
+						resp.Recipes = append(resp.Recipes, recipeEntry{ID: rc.ID(), Source: source, Recipe: rc})
+					}
+				}
+			}
+		}
+		writeList(w, r, resp)
+	}
+}
+
+// usesResp answers /api/ingredients/{name}/uses.
+type usesResp struct {
+	Ingredient string   `json:"ingredient"` // the name matched; "" when none was
+	Uses       []usedBy `json:"uses"`
+}
+
+// usedBy is a recipe taking the ingredient, with how hard its output is
+// to make and what it is worth.
+type usedBy struct {
+	recipeEntry
+	Difficulty *recipes.Difficulty  `json:"difficulty,omitempty"`
+	Value      *recipes.RecipeValue `json:"value,omitempty"`
+}
+
+// ingredientUsesHandler serves GET /api/ingredients/{name}/uses: every
+// recipe of both datasets (or the one of ?source=) taking the ingredient,
+// its name matched as for /api/suggest. The ones whose output is worth
+// most (-values) come first; those with no value follow, the ones making
+// the most processed outputs (deepest crafting chain) first, then those
+// making the most of them, food first.
+func ingredientUsesHandler(rec *liveRecipes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sets, err := rec.sources(r.URL.Query().Get("source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var names []string
+		for _, source := range []string{"food", "refiner"} {
+			if db, ok := sets[source]; ok {
+				names = append(names, db.Ingredients()...)
+			}
+		}
+		resp := usesResp{Uses: []usedBy{}}
+		name, ok := recipes.MapName(r.PathValue("name"), names)
+		if !ok {
+			writeList(w, r, resp)
+			return
+		}
+		resp.Ingredient = name
+		for _, source := range []string{"food", "refiner"} {
+			db, ok := sets[source]
+			if !ok {
+				continue
+			}
+			for _, rc := range db.Suggest([]string{name}) {
+				u := usedBy{recipeEntry: recipeEntry{ID: rc.ID(), Source: source, Recipe: rc}}
+				if d, ok := db.difficulty.Item(rc.Output); ok {
+					u.Difficulty = &d
+				}
+				u.Value = db.value(rc)
+				resp.Uses = append(resp.Uses, u)
+			}
+		}
+		depth := func(u usedBy) int {
+			if u.Difficulty == nil {
+				return -1
+			}
+			return u.Difficulty.Depth
+		}
+		slices.SortStableFunc(resp.Uses, func(a, b usedBy) int {
+			if a.Value != nil || b.Value != nil {
+				if c := compareOutputValues(b.Value, a.Value); c != 0 {
+					return c
+				}
+			}
+			if x, y := depth(a), depth(b); x != y {
+				return y - x
+			}
+			return b.Qty - a.Qty
+		})
+		writeList(w, r, resp)
+	}
+}
+
+// recipeDetail is a recipe with how hard it is to make, what it is worth
+// and the info on its output and inputs.
+type recipeDetail struct {
+	recipeEntry
+	Difficulty *recipes.Difficulty         `json:"difficulty,omitempty"`
+	Value      *recipes.RecipeValue        `json:"value,omitempty"`
+	Items      map[string]recipes.ItemInfo `json:"items"`
+}
+
+// recipeHandler serves GET /api/recipes/{id}, the recipe with that ID in
+// the food dataset or else the refiner one.
+func recipeHandler(rec *liveRecipes, icons itemIcons) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		for _, source := range []string{"food", "refiner"} {
+			db := rec.Food()
+			if source == "refiner" {
+				db = rec.Refiner()
+			}
+			rc, ok := db.ByID(id)
+			if !ok {
+				continue
+			}
+			v := recipeDetail{recipeEntry: recipeEntry{ID: id, Source: source, Recipe: rc}}
+			if d, ok := db.difficulty.Recipe(rc); ok {
+				v.Difficulty = &d
+			}
+			v.Value = db.value(rc)
+			v.Items = db.items(append([]string{rc.Output}, rc.Inputs...), icons)
+			writeJSON(w, v)
+			return
+		}
+		http.Error(w, "recipe not found", http.StatusNotFound)
+	}
+}
+
+// techListHandler serves GET /api/technologies (?q=, ?category=, ?class=).
+func techListHandler(db *recipes.TechDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		words := strings.Fields(norm.Key(q.Get("q")))
+		cat, class := q.Get("category"), q.Get("class")
+		out := []recipes.Technology{}
+		for _, t := range db.Techs {
+			if cat != "" && !strings.EqualFold(t.Category, cat) {
+				continue
+			}
+			if class != "" && !strings.EqualFold(t.Class, class) {
+				continue
+			}
+			name := norm.Key(t.Name)
+			ok := true
+			for _, w := range words {
+				if !strings.Contains(name, w) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				out = append(out, t)
+			}
+		}
+		writeList(w, r, out)
+	}
+}
+
+func newBaseView(b Base, gs *glyphs.Store, ss *SystemStore) baseView {
+	v := baseView{Base: b}
+	if b.GlyphID != "" {
+		if g, ok := gs.Get(b.GlyphID); ok {
+			v.Glyph = &g
+			if s, ok := systemForGlyph(ss, g); ok {
+				v.System = &s
+			}
+		}
+	}
+	return v
+}
+
+// checkGlyphLink rejects references to glyphs that do not exist.
+func checkGlyphLink(gs *glyphs.Store, id string) error {
+	if id == "" {
+		return nil
+	}
+	if _, ok := gs.Get(id); !ok {
+		return fmt.Errorf("unknown glyph id %q", id)
+	}
+	return nil
+}
+
+type randomPortalResp struct {
+	Address string               `json:"address"`
+	Decoded glyphs.PortalAddress `json:"decoded"`
+	Near    *glyphs.Glyph        `json:"near,omitempty"`
+	Radius  int                  `json:"radius,omitempty"`
+	Known   bool                 `json:"known"` // already recorded as a roll
+}
+
+func randomPortalHandler(gs *glyphs.Store, ps *PortalStore) http.HandlerFunc {
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), store.Hash(gs.Path)))
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var resp randomPortalResp
+		var near *glyphs.PortalAddress
+		if id := q.Get("near"); id != "" {
+			g, ok := gs.Get(id)
+			if !ok {
+				http.Error(w, "unknown glyph id", http.StatusBadRequest)
+				return
+			}
+			a, err := glyphs.ParsePortal(g.Symbols)
+			if err != nil {
+				http.Error(w, "glyph symbols are not a portal address: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			near, resp.Near = &a, &g
+			resp.Radius = 16
+			if v := q.Get("radius"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 || n > 256 {
+					http.Error(w, "radius must be 0-256 regions", http.StatusBadRequest)
+					return
+				}
+				resp.Radius = n
+			}
+		}
+		known := map[string]bool{}
+		for _, p := range ps.List() {
+			known[p.Address] = true
+		}
+		skipKnown := q.Get("unvisited") == "1"
+
+		mu.Lock()
+		defer mu.Unlock()
+		for try := 0; try < 32; try++ {
+			a := glyphs.RandomPortal(rng, near, resp.Radius)
+			resp.Address, resp.Decoded, resp.Known = a.String(), a, known[a.String()]
+			if !skipKnown || !resp.Known {
+				break
+			}
+		}
+		writeJSON(w, resp)
+	}
+}
+
+// nearestSystemHandler answers "closest system matching filters" from a saved
+// glyph (?from=<glyph id>) or a raw portal address (?address=).
+func nearestSystemHandler(gs *glyphs.Store, ss *SystemStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		raw := q.Get("address")
+		if id := q.Get("from"); id != "" {
+			g, ok := gs.Get(id)
+			if !ok {
+				http.Error(w, "unknown glyph id", http.StatusBadRequest)
+				return
+			}
+			raw = g.Symbols
+		}
+		if raw == "" {
+			http.Error(w, "missing 'from' or 'address' query param", http.StatusBadRequest)
+			return
+		}
+		origin, err := glyphs.ParsePortal(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := parseSystemFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 5
+		if v := q.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 100 {
+				http.Error(w, "limit must be 1-100", http.StatusBadRequest)
+				return
+			}
+		}
+		hits := nearestSystems(ss, origin, f, limit)
+		if hits == nil {
+			hits = []systemHit{}
+		}
+		writeList(w, r, hits)
+	}
+}
+
+// glyphCoordsHandler serves GET /api/glyphs/{id}/coords: a saved glyph's
+// portal code decoded into galactic coordinates.
+func glyphCoordsHandler(gs *glyphs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := gs.Get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "glyph not found", http.StatusNotFound)
+			return
+		}
+		a, err := glyphs.ParsePortal(g.Symbols)
+		if err != nil {
+			http.Error(w, "glyph symbols are not a portal address: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(w, a.Coordinates())
+	}
+}
+
+// convertHandler serves POST /api/convert: {"input": "...", "planet": N}
+// where input is a portal code or signal-booster coordinates. Booster
+// coordinates name no planet, so planet (default 1) fills it in.
+func convertHandler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Input  string `json:"input"`
+		Planet *int   `json:"planet"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	planet := 1
+	if req.Planet != nil {
+		planet = *req.Planet
+	}
+	c, err := glyphs.Convert(req.Input, planet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, c)
+}
+
+// catalogueAPIs are the collection APIs of every store, with the
+// cross-reference checks that keep glyph links valid.
+type catalogueAPIs struct {
+	glyphs    *collectionAPI[glyphs.Glyph, *glyphs.Glyph]
+	bases     *collectionAPI[Base, *Base]
+	creatures *collectionAPI[Creature, *Creature]
+	portals   *collectionAPI[Portal, *Portal]
+	systems   *collectionAPI[System, *System]
+	loadouts  *collectionAPI[Loadout, *Loadout]
+}
+
+func (c *catalogues) apis(techDB *recipes.TechDB) catalogueAPIs {
+	gs, bs, ss := c.Glyphs, c.Bases, c.Systems
+	return catalogueAPIs{
+		glyphs: &collectionAPI[glyphs.Glyph, *glyphs.Glyph]{
+			Store:   gs,
+			History: c.GlyphHistory,
+			Search:  func(q string, items []glyphs.Glyph) []glyphs.Glyph { return glyphs.Search(items, q) },
+			Same: func(g *glyphs.Glyph, skipID string) (glyphs.Glyph, bool) {
+				return glyphs.SameAddress(gs.List(), g, skipID)
+			},
+			Merge: glyphs.Merge,
+			Sorts: map[string]func(a, b *glyphs.Glyph) int{
+				"name":   func(a, b *glyphs.Glyph) int { return strings.Compare(norm.Key(a.Name), norm.Key(b.Name)) },
+				"galaxy": func(a, b *glyphs.Glyph) int { return strings.Compare(norm.Key(a.Galaxy), norm.Key(b.Galaxy)) },
+			},
+		},
+		bases: &collectionAPI[Base, *Base]{
+			Store: bs,
+			Check: func(b *Base) error { return checkGlyphLink(gs, b.GlyphID) },
+			View:  func(b Base) any { return newBaseView(b, gs, ss) },
+		},
+		creatures: &collectionAPI[Creature, *Creature]{
+			Store: c.Creatures,
+			Check: func(c *Creature) error { return checkGlyphLink(gs, c.HomeGlyphID) },
+		},
+		portals: &collectionAPI[Portal, *Portal]{
+			Store: c.Portals,
+			Check: func(p *Portal) error { return checkGlyphLink(gs, p.NearGlyphID) },
+		},
+		systems: &collectionAPI[System, *System]{
+			Store: ss,
+			Check: func(s *System) error { return checkGlyphLink(gs, s.GlyphID) },
+			View:  func(s System) any { return newSystemView(s, gs, bs) },
+		},
+		loadouts: &collectionAPI[Loadout, *Loadout]{
+			Store: c.Loadouts,
+			View:  func(l Loadout) any { return loadoutView{Loadout: l, Plan: planUpgrades(techDB, l.Modules)} },
+		},
+	}
+}
+
+// routes registers the APIs and pages of the catalogues, which belong to
+// one profile.
+func (c *catalogues) routes(mux *http.ServeMux, techDB *recipes.TechDB, cfg *liveConfig) error {
+	gs, bs, ps, ss, ls := c.Glyphs, c.Bases, c.Portals, c.Systems, c.Loadouts
+	apis := c.apis(techDB)
+	apis.glyphs.Actor = func(r *http.Request) actor { return requestActor(cfg, r) }
+	apis.glyphs.Duplicates = func() string { return cfg.Load().GlyphDuplicates }
+	apis.glyphs.MayChange = cfg.mayChange
+	mux.HandleFunc("GET /api/glyphs/random", randomPortalHandler(gs, ps))
+	mux.HandleFunc("GET /api/systems/nearest", nearestSystemHandler(gs, ss))
+	mux.HandleFunc("GET /api/glyphs/duplicates", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, glyphs.Duplicates(gs.List()))
+	})
+	mux.HandleFunc("GET /api/glyphs/{id}/coords", glyphCoordsHandler(gs))
+	mux.HandleFunc("GET /api/glyphs/{id}/image.png", glyphImageHandler(gs))
+	mux.HandleFunc("GET /g/{id}", shareHandler(gs))
+	mux.HandleFunc("GET /api/events", eventsHandler(gs))
+	mux.HandleFunc("POST /api/glyphs/recognize", recognizeHandler)
+	mux.HandleFunc("GET /glyphs/book", addressBookHandler(gs))
+	mux.HandleFunc("POST /api/convert", convertHandler)
+	mux.HandleFunc("POST /api/systems/import/community", communityImportHandler(gs, ss))
+	mux.HandleFunc("GET /api/loadouts/{id}/plan", loadoutPlanHandler(techDB, ls))
+	for _, api := range []interface{ routes(*http.ServeMux) error }{apis.glyphs, apis.bases, apis.creatures, apis.portals, apis.systems, apis.loadouts} {
+		if err := api.routes(mux); err != nil {
+			return err
+		}
+	}
+
+	mux.HandleFunc("GET /bases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		b, ok := bs.Get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{
+			Title:   b.Name,
+			Heading: b.Name,
+			Active:  "bases",
+			BgDark2: "#0e312b",
+			Big:     bigMode(w, r),
+			Item:    newBaseView(b, gs, ss),
+		}
+		if err := baseDetailTmpl.ExecuteTemplate(&buf, "basedetail", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+	return nil
+}
+
+func serve(rec *liveRecipes, techDB *recipes.TechDB, c *catalogues, icons itemIcons, cfg *liveConfig, maint *maintenance, updates *updateChecker, lo listenOptions) error {
+	mux := http.NewServeMux()
+
+	// Recipes API
+	ov := overlay{store: c.CustomRecipes, cfg: cfg}
+	mux.HandleFunc("/api/suggest", suggestHandler(rec.Food, "food", ov, icons))
+	mux.HandleFunc("/api/ingredients", ingredientsHandler(rec.Food, "food", ov, icons, rec.changes))
+	mux.HandleFunc("GET /api/items", itemsHandler(rec.Food, icons))
+	mux.HandleFunc("GET /api/ingredients/{name}/uses", ingredientUsesHandler(rec))
+	mux.HandleFunc("GET /api/suggest/all", suggestAllHandler(rec, ov, icons))
+	mux.HandleFunc("POST /api/suggest", batchSuggestHandler(rec, ov, icons))
+	mux.HandleFunc("GET /api/ingredients/all", allIngredientsHandler(rec, ov, icons))
+
+	// Refiner API
+	mux.HandleFunc("/api/refiner/suggest", suggestHandler(rec.Refiner, "refiner", ov, icons))
+	mux.HandleFunc("/api/refiner/ingredients", ingredientsHandler(rec.Refiner, "refiner", ov, icons, rec.changes))
+	mux.HandleFunc("GET /api/refiner/items", itemsHandler(rec.Refiner, icons))
+	mux.HandleFunc("GET /api/refiner/chain", refinerChainHandler(rec))
+
+	mux.HandleFunc("POST /api/recipes/upload", rec.uploadHandler)
+	mux.HandleFunc("GET /api/recipes", recipeListHandler(rec))
+	mux.HandleFunc("GET /api/recipes/{id}", recipeHandler(rec, icons))
+	mux.HandleFunc("GET /api/recipes/by-output", recipesByOutputHandler(rec))
+	mux.HandleFunc("GET /api/recipes/{id}/tree", recipeTreeHandler(rec))
+	mux.HandleFunc("POST /api/plan", craftPlanHandler(rec))
+	mux.HandleFunc("GET /api/reachable", reachableHandler(rec))
+	mux.HandleFunc("GET /api/recipes/search", recipeSearchHandler(rec))
+	mux.HandleFunc("GET /api/export/recipes.json", recipeExportHandler(rec))
+	ov.routes(mux)
+
+	// Item icons
+	known := func(name string) bool { return rec.Food().Has(name) || rec.Refiner().Has(name) }
+	if err := icons.routes(mux, known); err != nil {
+		return err
+	}
+
+	// Technologies API
+	mux.HandleFunc("GET /api/technologies", techListHandler(techDB))
+	mux.HandleFunc("POST /api/technologies/plan", planHandler(techDB))
+
+	// Admin API
+	mux.HandleFunc("POST /api/admin/config/reload", cfg.reloadHandler)
+	mux.HandleFunc("GET /api/admin/maintenance", maint.handler)
+	mux.HandleFunc("POST /api/admin/maintenance", maint.handler)
+	mux.HandleFunc("GET /api/admin/users", usersHandler(cfg))
+	mux.HandleFunc("PUT /api/admin/users/{name}", putUserHandler(cfg))
+	mux.HandleFunc("DELETE /api/admin/users/{name}", deleteUserHandler(cfg))
+	mux.HandleFunc("GET /api/admin/backups", backupsHandler(rec.in, c))
+	mux.HandleFunc("POST /api/admin/backup", backupHandler(rec.in))
+	mux.HandleFunc("GET /api/admin/aliases", aliasesHandler(rec))
+	mux.HandleFunc("PUT /api/admin/aliases/{alias}", putAliasHandler(rec, cfg))
+	mux.HandleFunc("DELETE /api/admin/aliases/{alias}", deleteAliasHandler(rec, cfg))
+	mux.HandleFunc("POST /api/admin/restore", restoreHandler(rec.in, c))
+	mux.HandleFunc("GET /api/version", versionHandler(updates))
+
+	// Catalogue APIs, one set per profile
+	profiles, err := newProfiles(rec.in, c, func(c *catalogues, mux *http.ServeMux) error {
+		return c.routes(mux, techDB, cfg)
+	})
+	if err != nil {
+		return err
+	}
+	mux.HandleFunc("GET /api/profiles", profiles.listHandler)
+	mux.HandleFunc("POST /api/profiles", profiles.createHandler)
+
+	// Bases UI
+	mux.HandleFunc("/bases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Bases", Heading: "Bases", Active: "bases", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := basesTmpl.ExecuteTemplate(&buf, "bases", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+	// Systems UI
+	mux.HandleFunc("/systems", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Star Systems", Heading: "Star Systems", Active: "systems", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := systemsTmpl.ExecuteTemplate(&buf, "systems", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Upgrade planner UI
+	mux.HandleFunc("/planner", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Upgrade Planner", Heading: "Upgrade Planner", Active: "planner", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := plannerTmpl.ExecuteTemplate(&buf, "planner", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Portal explorer UI
+	mux.HandleFunc("/explore", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Portal Roulette", Heading: "Portal Roulette", Active: "explore", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := exploreTmpl.ExecuteTemplate(&buf, "explore", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Creatures UI
+	mux.HandleFunc("/creatures", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Creatures", Heading: "Creatures & Eggs", Active: "creatures", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := creaturesTmpl.ExecuteTemplate(&buf, "creatures", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Glyphs UI
+	mux.HandleFunc("/glyphs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Glyphs", Heading: "Glyphs", Active: "glyphs", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := glyphsTmpl.ExecuteTemplate(&buf, "glyphs", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Accounts
+	mux.HandleFunc("GET /login", loginHandler(cfg))
+	mux.HandleFunc("POST /login", loginHandler(cfg))
+	mux.HandleFunc("GET /logout", logoutHandler(cfg))
+	mux.HandleFunc("POST /logout", logoutHandler(cfg))
+	mux.HandleFunc("GET /api/me", meHandler(cfg))
+	mux.HandleFunc("/api/", apiNotFound)
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler(append(apiOps(), c.apis(techDB).ops()...)))
+	mux.HandleFunc("GET /api/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := apiDocsTmpl.ExecuteTemplate(w, "apidocs", nil); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+		}
+	})
+	if cfg.oidc != nil {
+		mux.HandleFunc("GET /login/oidc", cfg.oidc.startHandler)
+		mux.HandleFunc("GET /login/oidc/callback", cfg.oidc.callbackHandler(cfg))
+	}
+
+	// Admin UI
+
+	mux.HandleFunc("GET /admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{Title: "Admin", Heading: "Admin", BgDark2: "#0e312b", Big: bigMode(w, r)}
+		if err := adminTmpl.ExecuteTemplate(&buf, "admin", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Refiner UI
+	mux.HandleFunc("/refiner", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{
+			Title:   "Refiner Recipes",
+			Heading: "Refiner Recipes",
+			Active:  "refiner",
+			APIBase: "/api/refiner",
+			BgDark2: "#0e312b",
+			Big:     bigMode(w, r),
+		}
+		if err := recipesTmpl.ExecuteTemplate(&buf, "recipes", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	// Recipe UI
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var buf bytes.Buffer
+		data := pageData{
+			Title:   "Recipe Finder",
+			Heading: "Recipe Finder",
+			Active:  "home",
+			APIBase: "/api",
+			BgDark2: "#18534a",
+			Big:     bigMode(w, r),
+		}
+		if err := recipesTmpl.ExecuteTemplate(&buf, "recipes", data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+			return
+		}
+	})
+
+	cfg.watchSIGHUP()
+	var h http.Handler = withMaintenance(withRoles(profiles.route(mux), cfg), maint)
+	if rec.in.Demo {
+		h = withDemo(h)
+	}
+	return listenAndServe(withRequestLog(withCompression(withAPIVersions(withCommonHeaders(h, cfg))), lo.AccessLog), lo)
+}
+
+// bigMode reports whether the page should render with oversized tap targets
+// (Steam Deck, TV browsers). An explicit ?big=1 / ?big=0 wins and is
+// remembered in a cookie; otherwise the cookie set by the dock toggle is used.
+func bigMode(w http.ResponseWriter, r *http.Request) bool {
+	if v := r.URL.Query().Get("big"); v != "" {
+		on := v == "1" || strings.EqualFold(v, "true")
+		val := "0"
+		if on {
+			val = "1"
+		}
+		http.SetCookie(w, &http.Cookie{Name: "big", Value: val, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+		return on
+	}
+	c, err := r.Cookie("big")
+	return err == nil && c.Value == "1"
+}
+
+// notModified sets the ETag of the response and reports whether the
+// request's If-None-Match holds it already, in which case it has been
+// answered with 304. Cache-Control: no-cache has the client ask each time,
+// which costs no body while nothing changed.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if m := r.Header.Get("If-None-Match"); m == "*" || strings.Contains(m, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// writeJSONCached is writeJSON with an ETag hashed from the body, for the
+// lists clients fetch again and again.
+func writeJSONCached(w http.ResponseWriter, r *http.Request, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, `"`+sha256Hex(b)[:32]+`"`) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(append(b, '\n'))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+	}
+}
+
+// writeList is writeJSONCached for list endpoints. With ?fields=output,qty
+// every object inside an array of the response keeps only the named fields,
+// so a client fetches no more than it shows; names no object has are
+// ignored.
+func writeList(w http.ResponseWriter, r *http.Request, v any) {
+	fields := splitCSVLike(r.URL.Query().Get("fields"))
+	if len(fields) == 0 {
+		writeJSONCached(w, r, v)
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	keep := map[string]bool{}
+	for _, f := range fields {
+		keep[f] = true
+	}
+	writeJSONCached(w, r, pickFields(doc, keep, false))
+}
+
+// pickFields trims the objects found in arrays of v to the keys in keep.
+// The fields kept are left whole.
+func pickFields(v any, keep map[string]bool, inList bool) any {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			v[i] = pickFields(v[i], keep, true)
+		}
+	case map[string]any:
+		for k, x := range v {
+			switch {
+			case !inList:
+				v[k] = pickFields(x, keep, false)
+			case !keep[k]:
+				delete(v, k)
+			}
+		}
+	}
+	return v
+}
+
+func withCommonHeaders(h http.Handler, cfg *liveConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := cfg.Load().allowOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, X-Profile")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+var csvSplitter = regexp.MustCompile(`[,\n;]+`)
+
+func splitCSVLike(s string) []string {
+	raw := csvSplitter.Split(s, -1)
+	out := make([]string, 0, len(raw))
+	for _, p := range raw {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+)
+
+// ---------- Shared glyph page ----------
+
+// sharePage is one glyph on a page of its own for people without the app:
+// read-only, self-contained (the glyph font is inlined) and with OpenGraph
+// tags so chat apps show a preview. Portal addresses preview as their
+// glyph card, other glyphs as their photo.
+type sharePage struct {
+	glyphs.Glyph
+	Coords  *glyphs.Coordinates
+	URL     string // of this page
+	Image   string // preview image, absolute
+	Photo   string // absolute
+	Summary string // for link previews
+	Font    template.URL
+}
+
+// newSharePage builds the page for g; query selects the glyph's profile in
+// the links it holds (see profileQuery).
+func newSharePage(g glyphs.Glyph, base, query string) sharePage {
+	p := sharePage{
+		Glyph: g,
+		URL:   base + "/g/" + g.ID + query,
+		Font:  template.URL("data:font/ttf;base64," + base64.StdEncoding.EncodeToString(glyphFontTTF)),
+	}
+	if g.Photo != "" {
+		p.Photo = g.Photo
+		if strings.HasPrefix(p.Photo, "/") {
+			p.Photo = base + p.Photo + query
+		}
+	}
+	desc := []string{"Portal address in " + g.Galaxy}
+	if a, err := glyphs.ParsePortal(g.Symbols); err == nil {
+		c := a.Coordinates()
+		p.Coords = &c
+		p.Image = base + "/api/glyphs/" + g.ID + "/image.png" + query
+		desc = []string{c.Portal + " in " + g.Galaxy, "coordinates " + c.Galactic}
+	} else {
+		p.Image = p.Photo
+	}
+	if g.Description != "" {
+		desc = append(desc, g.Description)
+	}
+	p.Summary = strings.Join(desc, " • ")
+	return p
+}
+
+// shareHandler serves GET /g/{id}.
+func shareHandler(gs *glyphs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := gs.Get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if err := shareTmpl.ExecuteTemplate(&buf, "share", newSharePage(g, requestBase(r), profileQuery(r))); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/glyphs"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Star systems ----------
+
+// System records what a player learned about a visited star system.
+type System struct {
+	store.Meta
+	Name        string `json:"name"`
+	Galaxy      string `json:"galaxy"`
+	Address     string `json:"address"`      // portal glyphs of any planet in the system
+	EconomyTier int    `json:"economy_tier"` // 0 unknown, 1 low, 2 medium, 3 high wealth
+	Economy     string `json:"economy"`      // e.g. "Trading", "Mining"
+	Race        string `json:"race"`         // gek, korvax, vykeen, outlaw, none
+	Conflict    int    `json:"conflict"`     // 0 unknown, 1 low, 2 medium, 3 high
+	GlyphID     string `json:"glyph_id,omitempty"`
+	Notes       string `json:"notes"`
+}
+
+type SystemStore = store.Collection[System, *System]
+
+var systemRaces = map[string]string{
+	"gek": "gek", "korvax": "korvax", "vykeen": "vykeen", "vy'keen": "vykeen",
+	"outlaw": "outlaw", "pirate": "outlaw", "none": "none", "abandoned": "none", "": "",
+}
+
+var systemSpec = store.Spec[System]{
+	Kind: "system",
+	Validate: func(s *System) error {
+		s.Name = strings.TrimSpace(s.Name)
+		s.Galaxy = strings.TrimSpace(s.Galaxy)
+		s.Economy = strings.TrimSpace(s.Economy)
+		s.GlyphID = strings.TrimSpace(s.GlyphID)
+		s.Notes = strings.TrimSpace(s.Notes)
+		if s.Galaxy == "" {
+			s.Galaxy = glyphs.DefaultGalaxy
+		}
+		race, ok := systemRaces[strings.ToLower(strings.TrimSpace(s.Race))]
+		if !ok {
+			return store.Invalid("race", "race must be gek, korvax, vykeen, outlaw or none")
+		}
+		s.Race = race
+		a, err := glyphs.ParsePortal(s.Address)
+		if err != nil {
+			return err
+		}
+		s.Address = a.String()
+
+		if s.Name == "" {
+			return store.Invalid("name", "name required")
+		}
+		if utf8.RuneCountInString(s.Name) > 64 || utf8.RuneCountInString(s.Galaxy) > 64 || utf8.RuneCountInString(s.Economy) > 64 {
+			return errors.New("name, galaxy and economy are limited to 64 chars")
+		}
+		if utf8.RuneCountInString(s.Notes) > 2048 {
+			return store.Invalid("notes", "notes too long (max 2048 chars)")
+		}
+		if s.EconomyTier < 0 || s.EconomyTier > 3 {
+			return store.Invalid("economy_tier", "economy_tier must be 0-3")
+		}
+		if s.Conflict < 0 || s.Conflict > 3 {
+			return store.Invalid("conflict", "conflict must be 0-3")
+		}
+		return nil
+	},
+	// one record per system: planet index is ignored
+	Key: func(s *System) string {
+		a, err := glyphs.ParsePortal(s.Address)
+		if err != nil {
+			return ""
+		}
+		a.Planet = 0
+		return strings.ToLower(s.Galaxy) + "\x00" + a.String()
+	},
+	Text: func(s *System) string {
+		return s.Name + " " + s.Galaxy + " " + s.Economy + " " + s.Race + " " + s.Address + " " + s.Notes
+	},
+	FromForm: func(v url.Values) System {
+		tier, _ := strconv.Atoi(v.Get("economy_tier"))
+		conflict, _ := strconv.Atoi(v.Get("conflict"))
+		return System{
+			Name:        v.Get("name"),
+			Galaxy:      v.Get("galaxy"),
+			Address:     v.Get("address"),
+			EconomyTier: tier,
+			Economy:     v.Get("economy"),
+			Race:        v.Get("race"),
+			Conflict:    conflict,
+			GlyphID:     v.Get("glyph_id"),
+			Notes:       v.Get("notes"),
+		}
+	},
+}
+
+// systemView is a system with its region coordinates and the glyphs and
+// bases that point into it.
+type systemView struct {
+	System
+	Region [3]int         `json:"region"` // signed x, y, z region coordinates
+	Glyph  *glyphs.Glyph  `json:"glyph,omitempty"`
+	Glyphs []glyphs.Glyph `json:"glyphs"` // every saved glyph inside this system
+	Bases  []Base         `json:"bases"`
+}
+
+func newSystemView(s System, gs *glyphs.Store, bs *BaseStore) systemView {
+	v := systemView{System: s, Glyphs: []glyphs.Glyph{}, Bases: []Base{}}
+	addr, err := glyphs.ParsePortal(s.Address)
+	if err != nil {
+		return v
+	}
+	v.Region[0], v.Region[1], v.Region[2] = addr.Region()
+	if s.GlyphID != "" {
+		if g, ok := gs.Get(s.GlyphID); ok {
+			v.Glyph = &g
+		}
+	}
+	inSystem := map[string]bool{}
+	for _, g := range gs.List() {
+		if a, err := glyphs.ParsePortal(g.Symbols); err == nil && glyphs.SameSystem(a, addr) {
+			v.Glyphs = append(v.Glyphs, g)
+			inSystem[g.ID] = true
+		}
+	}
+	if s.GlyphID != "" {
+		inSystem[s.GlyphID] = true
+	}
+	v.Bases = append(v.Bases, bs.Filter(func(b *Base) bool { return inSystem[b.GlyphID] })...)
+	return v
+}
+
+// systemForGlyph returns the recorded system a glyph's address points into.
+func systemForGlyph(ss *SystemStore, g glyphs.Glyph) (System, bool) {
+	a, err := glyphs.ParsePortal(g.Symbols)
+	for _, s := range ss.List() {
+		if s.GlyphID == g.ID {
+			return s, true
+		}
+		if err != nil {
+			continue
+		}
+		if b, err := glyphs.ParsePortal(s.Address); err == nil && glyphs.SameSystem(a, b) {
+			return s, true
+		}
+	}
+	return System{}, false
+}
+
+// ---------- Nearest-system finder ----------
+
+// systemFilter selects systems for the nearest-X query. Zero values match
+// anything.
+type systemFilter struct {
+	Galaxy         string
+	MinEconomyTier int
+	Economy        string
+	Race           string
+	MinConflict    int
+	MaxConflict    int
+}
+
+func (f systemFilter) match(s *System) bool {
+	if f.Galaxy != "" && !strings.EqualFold(f.Galaxy, s.Galaxy) {
+		return false
+	}
+	if f.MinEconomyTier > 0 && s.EconomyTier < f.MinEconomyTier {
+		return false
+	}
+	if f.Economy != "" && !strings.EqualFold(f.Economy, s.Economy) {
+		return false
+	}
+	if f.Race != "" && f.Race != s.Race {
+		return false
+	}
+	if f.MinConflict > 0 && s.Conflict < f.MinConflict {
+		return false
+	}
+	if f.MaxConflict > 0 && (s.Conflict == 0 || s.Conflict > f.MaxConflict) {
+		return false
+	}
+	return true
+}
+
+type systemHit struct {
+	System     System  `json:"system"`
+	Regions    float64 `json:"regions"`
+	LightYears int     `json:"light_years"`
+}
+
+// nearestSystems ranks matching systems by distance from origin.
+func nearestSystems(ss *SystemStore, origin glyphs.PortalAddress, f systemFilter, limit int) []systemHit {
+	var hits []systemHit
+	for _, s := range ss.Filter(f.match) {
+		a, err := glyphs.ParsePortal(s.Address)
+		if err != nil {
+			continue
+		}
+		d := glyphs.RegionDistance(origin, a)
+		hits = append(hits, systemHit{System: s, Regions: d, LightYears: int(d * glyphs.LyPerRegion)})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Regions < hits[j].Regions })
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// parseSystemFilter reads filter query params: galaxy, economy, economy_tier
+// (minimum), race, conflict (minimum), max_conflict. black_market=1 is a
+// shorthand for race=outlaw.
+func parseSystemFilter(q url.Values) (systemFilter, error) {
+	f := systemFilter{Galaxy: q.Get("galaxy"), Economy: q.Get("economy")}
+	if v := q.Get("race"); v != "" {
+		race, ok := systemRaces[strings.ToLower(v)]
+		if !ok {
+			return f, fmt.Errorf("unknown race %q", v)
+		}
+		f.Race = race
+	}
+	if q.Get("black_market") == "1" {
+		f.Race = "outlaw"
+	}
+	for name, dst := range map[string]*int{"economy_tier": &f.MinEconomyTier, "conflict": &f.MinConflict, "max_conflict": &f.MaxConflict} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 3 {
+				return f, fmt.Errorf("%s must be 0-3", name)
+			}
+			*dst = n
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed templates/*.html
+var tmplFS embed.FS
+
+// glyphFontTTF is inlined into pages meant to be saved or printed.
+//
+//go:embed templates/fonts/NMS-Glyphs-Mono.ttf
+var glyphFontTTF []byte
+
+var (
+	recipesTmpl     = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/recipes.html"))
+	glyphsTmpl      = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/glyphs.html"))
+	basesTmpl       = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/bases.html"))
+	baseDetailTmpl  = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/basedetail.html"))
+	creaturesTmpl   = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/creatures.html"))
+	exploreTmpl     = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/explore.html"))
+	systemsTmpl     = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/systems.html"))
+	plannerTmpl     = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/planner.html"))
+	maintenanceTmpl = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/maintenance.html"))
+	adminTmpl       = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/admin.html"))
+	loginTmpl       = template.Must(template.ParseFS(tmplFS, "templates/base.html", "templates/login.html"))
+	addressBookTmpl = template.Must(template.ParseFS(tmplFS, "templates/addressbook.html"))
+	shareTmpl       = template.Must(template.ParseFS(tmplFS, "templates/share.html"))
+	apiDocsTmpl     = template.Must(template.ParseFS(tmplFS, "templates/apidocs.html"))
+)
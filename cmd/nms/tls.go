@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ---------- TLS ----------
+
+// tlsOptions say how serve speaks HTTPS, if it does: with a certificate
+// from files (-tls-cert, -tls-key), or with certificates it gets from
+// Let's Encrypt for -autocert-domain, answering the HTTP-01 challenge on
+// -http-addr. Either way the server can face the internet without a
+// reverse proxy in front.
+type tlsOptions struct {
+	Cert, Key string
+	Domains   string // comma-separated
+	Cache     string
+	Email     string
+	HTTPAddr  string
+}
+
+func (o *tlsOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Cert, "tls-cert", "", "Serve HTTPS with this certificate (PEM, chain included); re-read when it changes on disk")
+	fs.StringVar(&o.Key, "tls-key", "", "Private key for -tls-cert (PEM)")
+	fs.StringVar(&o.Domains, "autocert-domain", "", "Serve HTTPS with certificates from Let's Encrypt for these comma-separated domains (needs port 80 reachable for the challenge)")
+	fs.StringVar(&o.Cache, "autocert-cache", "", "Directory keeping the Let's Encrypt account and certificates (default autocert, under -data-dir when given)")
+	fs.StringVar(&o.Email, "autocert-email", "", "Contact address given to Let's Encrypt for expiry notices")
+	fs.StringVar(&o.HTTPAddr, "http-addr", "", "Also listen for plain HTTP here, sending browsers to HTTPS (default :80 with -autocert-domain)")
+}
+
+func (o *tlsOptions) enabled() bool { return o.Cert != "" || o.Domains != "" }
+
+// check validates the options and fills in the defaults; dataDir is
+// -data-dir.
+func (o *tlsOptions) check(dataDir string) error {
+	switch {
+	case (o.Cert == "") != (o.Key == ""):
+		return errors.New("-tls-cert and -tls-key go together")
+	case o.Cert != "" && o.Domains != "":
+		return errors.New("-tls-cert and -autocert-domain are either-or")
+	case o.Domains == "" && (o.Cache != "" || o.Email != ""):
+		return errors.New("-autocert-cache and -autocert-email need -autocert-domain")
+	}
+	if o.Domains != "" {
+		if len(splitCSVLike(o.Domains)) == 0 {
+			return errors.New("-autocert-domain: no domain given")
+		}
+		if o.Cache == "" {
+			o.Cache = filepath.Join(dataDir, "autocert")
+		}
+		if o.HTTPAddr == "" {
+			o.HTTPAddr = ":80"
+		}
+	}
+	if o.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(o.Cert, o.Key); err != nil {
+			return fmt.Errorf("-tls-cert: %w", err)
+		}
+	}
+	return nil
+}
+
+// listenAndServe serves h as the options say, over TLS when they ask for it.
+func listenAndServe(h http.Handler, lo listenOptions) error {
+	o := lo.TLS
+	ln, err := listen(lo.Addr, 0, lo.SocketMode)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 30 * time.Second}
+	if !o.enabled() {
+		return srv.Serve(ln)
+	}
+	redirect := http.HandlerFunc(redirectHTTPS)
+	if o.Domains != "" {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(splitCSVLike(o.Domains)...),
+			Cache:      autocert.DirCache(o.Cache),
+			Email:      o.Email,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect).ServeHTTP
+		log.Printf("tls: certificates from Let's Encrypt for %s, kept in %s", o.Domains, o.Cache)
+	} else {
+		kp := &keyPair{cert: o.Cert, key: o.Key}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: kp.get}
+		log.Printf("tls: certificate %s", o.Cert)
+	}
+	if o.HTTPAddr != "" {
+		plain, err := listen(o.HTTPAddr, 1, lo.SocketMode)
+		if err != nil {
+			return fmt.Errorf("-http-addr: %w", err)
+		}
+		go func() {
+			err := (&http.Server{Handler: redirect, ReadHeaderTimeout: 30 * time.Second}).Serve(plain)
+			log.Printf("-http-addr: %v", err)
+		}()
+	}
+	return srv.ServeTLS(ln, "", "")
+}
+
+// redirectHTTPS sends a plain HTTP request to the same URL over HTTPS, on
+// the default port.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// keyPair is the certificate of -tls-cert, loaded again when its files
+// change, so a renewal by certbot or the like needs no restart.
+type keyPair struct {
+	cert, key string
+
+	mu      sync.Mutex
+	loaded  *tls.Certificate
+	mod     time.Time
+	checked time.Time
+}
+
+func (kp *keyPair) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.loaded != nil && time.Since(kp.checked) < time.Minute {
+		return kp.loaded, nil
+	}
+	kp.checked = time.Now()
+	var mod time.Time
+	for _, p := range []string{kp.cert, kp.key} {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	if kp.loaded != nil && !mod.After(kp.mod) {
+		return kp.loaded, nil
+	}
+	c, err := tls.LoadX509KeyPair(kp.cert, kp.key)
+	if err != nil {
+		if kp.loaded != nil {
+			log.Printf("tls: keeping the certificate loaded before: %v", err)
+			return kp.loaded, nil
+		}
+		return nil, err
+	}
+	if kp.loaded != nil {
+		log.Printf("tls: certificate %s reloaded", kp.cert)
+	}
+	kp.loaded, kp.mod = &c, mod
+	return kp.loaded, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/poku-e/NMScripts/internal/scrape"
+)
+
+// ---------- Update checks ----------
+
+// releasesURL is the GitHub API endpoint of the latest release.
+const releasesURL = "https://api.github.com/repos/poku-e/NMScripts/releases/latest"
+
+// updateKey is the base64 ed25519 public key release binaries are signed
+// with, set at build time with -ldflags "-X main.updateKey=...". A build
+// without one can check for updates but not install them.
+var updateKey = ""
+
+// release is the part of a GitHub release the checker reads.
+type release struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of a named asset.
+func (r *release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// binaryAsset is the release asset built for this platform.
+func binaryAsset() string {
+	name := "nms_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+var updateRetry = scrape.RetryPolicy{Retries: 2, Backoff: 2 * time.Second, Timeout: 5 * time.Minute}
+
+// download fetches a URL with the scraper's client.
+func download(ctx context.Context, url, accept string) ([]byte, error) {
+	body, _, _, err := scrape.Fetch(ctx, url, scrape.FetchOptions{
+		Retry:  updateRetry,
+		Header: http.Header{"Accept": {accept}},
+	})
+	return []byte(body), err
+}
+
+func latestRelease(ctx context.Context) (*release, error) {
+	b, err := download(ctx, releasesURL, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	var rel release
+	if err := json.Unmarshal(b, &rel); err != nil {
+		return nil, fmt.Errorf("decode release: %w", err)
+	}
+	if rel.TagName == "" {
+		return nil, errors.New("release has no tag")
+	}
+	return &rel, nil
+}
+
+// parseSemver splits "v1.2.3" (a pre-release or build suffix is ignored)
+// into its numbers.
+func parseSemver(v string) ([3]int, bool) {
+	var n [3]int
+	v, ok := strings.CutPrefix(v, "v")
+	if !ok {
+		return n, false
+	}
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return n, false
+	}
+	for i, p := range parts {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return n, false
+		}
+		n[i] = x
+	}
+	return n, true
+}
+
+// newerVersion reports whether latest is a later release than cur. A
+// development build is never out of date.
+func newerVersion(cur, latest string) bool {
+	c, ok1 := parseSemver(cur)
+	l, ok2 := parseSemver(latest)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for i := range c {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// updateStatus is the result of the last check, shown by /api/version and
+// the admin page.
+type updateStatus struct {
+	Latest    string    `json:"latest,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Available bool      `json:"available"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// updateChecker checks for a new release once a day when serve runs with
+// -check-updates; nothing is sent to GitHub otherwise.
+type updateChecker struct {
+	cur atomic.Pointer[updateStatus]
+}
+
+// updateInterval is how often serve checks for a new release.
+const updateInterval = 24 * time.Hour
+
+func (u *updateChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	st := &updateStatus{CheckedAt: time.Now().UTC()}
+	rel, err := latestRelease(ctx)
+	if err != nil {
+		st.Error = err.Error()
+		log.Printf("update check: %v", err)
+	} else {
+		st.Latest, st.URL = rel.TagName, rel.HTMLURL
+		st.Available = newerVersion(version, rel.TagName)
+		if st.Available {
+			log.Printf("nms %s is available (running %s): %s", rel.TagName, version, rel.HTMLURL)
+		}
+	}
+	u.cur.Store(st)
+}
+
+func (u *updateChecker) start() {
+	go func() {
+		for {
+			u.check()
+			time.Sleep(updateInterval)
+		}
+	}()
+}
+
+// Status returns the last check, or nil when checks are off or none has
+// finished yet.
+func (u *updateChecker) Status() *updateStatus {
+	if u == nil {
+		return nil
+	}
+	return u.cur.Load()
+}
+
+// versionHandler serves GET /api/version.
+func versionHandler(u *updateChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, rev := buildVersion()
+		writeJSON(w, struct {
+			Version  string        `json:"version"`
+			Revision string        `json:"revision,omitempty"`
+			Update   *updateStatus `json:"update,omitempty"`
+		}{v, rev, u.Status()})
+	}
+}
+
+// ---------- Command line: update ----------
+
+const updateUsage = `usage: nms update [-install]
+
+Checks GitHub for a newer release. With -install, downloads this
+platform's binary (%s) and its manifest, checks the manifest's ed25519
+signature against the key built into nms, and replaces the running
+executable. A release not newer than the running one is never installed.
+`
+
+func updateCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprintf(stderr, updateUsage, binaryAsset()); fs.PrintDefaults() }
+	install := fs.Bool("install", false, "Download and install the new release")
+	force := fs.Bool("force", false, "With -install, install over a development build, which has no version to compare")
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	rel, err := latestRelease(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "update: %v\n", err)
+		return 1
+	}
+	cur, _ := buildVersion()
+	newer := newerVersion(version, rel.TagName)
+	if !newer {
+		fmt.Fprintf(stdout, "nms %s is the latest release (running %s)\n", rel.TagName, cur)
+	} else {
+		fmt.Fprintf(stdout, "nms %s is available (running %s): %s\n", rel.TagName, cur, rel.HTMLURL)
+	}
+	if !*install || (!newer && !*force) {
+		return 0
+	}
+	path, err := installRelease(ctx, rel, *force)
+	if err != nil {
+		fmt.Fprintf(stderr, "update: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "installed %s to %s; restart nms to use it\n", rel.TagName, path)
+	return 0
+}
+
+// releaseManifest is what a release's signature covers: the version, the
+// asset and its SHA-256. Signing the version as well as the binary keeps an
+// older signed release from being passed off as a newer one.
+type releaseManifest struct {
+	Version string `json:"version"`
+	Asset   string `json:"asset"`
+	SHA256  string `json:"sha256"` // hex
+}
+
+// manifestAsset is the release asset holding the manifest of a binary; it
+// is signed by the asset of the same name plus .sig, a base64 ed25519
+// signature of the manifest's bytes.
+func manifestAsset(bin string) string { return bin + ".manifest" }
+
+// verifyRelease checks a downloaded binary against its signed manifest:
+// the signature, that the manifest is of this asset of the release tagged
+// tag with these contents, and that it is newer than the running version
+// cur. A development build, which has no version, takes any release with
+// force.
+func verifyRelease(key ed25519.PublicKey, tag, cur string, force bool, manifest, sigText, bin []byte) (releaseManifest, error) {
+	var m releaseManifest
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil || !ed25519.Verify(key, manifest, sig) {
+		return m, fmt.Errorf("signature of %s does not verify; not installing", manifestAsset(binaryAsset()))
+	}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return m, fmt.Errorf("decode %s: %w", manifestAsset(binaryAsset()), err)
+	}
+	sum := sha256.Sum256(bin)
+	switch {
+	case m.Asset != binaryAsset():
+		return m, fmt.Errorf("the signed manifest is of %s, not %s; not installing", m.Asset, binaryAsset())
+	case m.Version != tag:
+		return m, fmt.Errorf("release %s comes with the signed manifest of %s; not installing", tag, m.Version)
+	case !strings.EqualFold(m.SHA256, hex.EncodeToString(sum[:])):
+		return m, fmt.Errorf("%s does not match its signed manifest; not installing", binaryAsset())
+	}
+	if _, release := parseSemver(cur); (release || !force) && !newerVersion(cur, m.Version) {
+		return m, fmt.Errorf("%s is not newer than the running %s; not installing", m.Version, cur)
+	}
+	return m, nil
+}
+
+// installRelease downloads this platform's binary and its signed manifest
+// (see manifestAsset) and, once they verify, renames the binary over the
+// running executable.
+func installRelease(ctx context.Context, rel *release, force bool) (string, error) {
+	if updateKey == "" {
+		return "", fmt.Errorf("this build has no update key to verify downloads with; get %s from %s", binaryAsset(), rel.HTMLURL)
+	}
+	key, err := base64.StdEncoding.DecodeString(updateKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", errors.New("the update key built into nms is not a base64 ed25519 public key")
+	}
+	binURL, ok := rel.asset(binaryAsset())
+	manURL, okMan := rel.asset(manifestAsset(binaryAsset()))
+	sigURL, okSig := rel.asset(manifestAsset(binaryAsset()) + ".sig")
+	if !ok || !okMan || !okSig {
+		return "", fmt.Errorf("release %s has no signed %s", rel.TagName, binaryAsset())
+	}
+	manifest, err := download(ctx, manURL, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	sigText, err := download(ctx, sigURL, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	bin, err := download(ctx, binURL, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	cur, _ := buildVersion()
+	if _, err := verifyRelease(ed25519.PublicKey(key), rel.TagName, cur, force, manifest, sigText, bin); err != nil {
+		return "", err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".nms-update-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(bin)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o755)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), exe)
+	}
+	return exe, err
+}
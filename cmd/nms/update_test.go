@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRelease(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := []byte("the new nms")
+	sum := sha256.Sum256(bin)
+	manifest := func(version, asset, sha string) []byte {
+		b, err := json.Marshal(releaseManifest{Version: version, Asset: asset, SHA256: sha})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	sign := func(key ed25519.PrivateKey, b []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, b)) + "\n")
+	}
+	good := manifest("v1.3.0", binaryAsset(), hex.EncodeToString(sum[:]))
+	old := manifest("v1.1.0", binaryAsset(), hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name     string
+		tag, cur string
+		force    bool
+		manifest []byte
+		sig      []byte
+		bin      []byte
+		err      string // in the error; "" for none
+	}{
+		{"newer", "v1.3.0", "v1.2.0", false, good, sign(priv, good), bin, ""},
+		{"newer, forced", "v1.3.0", "v1.2.0", true, good, sign(priv, good), bin, ""},
+		{"over a development build, forced", "v1.3.0", "devel", true, good, sign(priv, good), bin, ""},
+		{"over a development build", "v1.3.0", "devel", false, good, sign(priv, good), bin, "not newer"},
+		{"same version", "v1.3.0", "v1.3.0", false, good, sign(priv, good), bin, "not newer"},
+		{"same version, forced", "v1.3.0", "v1.3.0", true, good, sign(priv, good), bin, "not newer"},
+		{"older release replayed", "v1.1.0", "v1.2.0", false, old, sign(priv, old), bin, "not newer"},
+		{"older release replayed, forced", "v1.1.0", "v1.2.0", true, old, sign(priv, old), bin, "not newer"},
+		{"older manifest under a newer tag", "v1.3.0", "v1.2.0", false, old, sign(priv, old), bin, "signed manifest of v1.1.0"},
+		{"binary swapped", "v1.3.0", "v1.2.0", false, good, sign(priv, good), []byte("something else"), "does not match"},
+		{"manifest edited", "v1.3.0", "v1.2.0", false, manifest("v1.3.0", binaryAsset(), strings.Repeat("0", 64)), sign(priv, good), bin, "does not verify"},
+		{"signed by another key", "v1.3.0", "v1.2.0", false, good, sign(otherKey, good), bin, "does not verify"},
+		{"signature not base64", "v1.3.0", "v1.2.0", false, good, []byte("!!"), bin, "does not verify"},
+		{"other platform", "v1.3.0", "v1.2.0", false, manifest("v1.3.0", "nms_plan9_mips", hex.EncodeToString(sum[:])),
+			sign(priv, manifest("v1.3.0", "nms_plan9_mips", hex.EncodeToString(sum[:]))), bin, "not " + binaryAsset()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := verifyRelease(pub, tt.tag, tt.cur, tt.force, tt.manifest, tt.sig, tt.bin)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("verifyRelease: %v", err)
+			case tt.err == "" && m.Version != tt.tag:
+				t.Errorf("manifest version = %q, want %q", m.Version, tt.tag)
+			case tt.err != "" && err == nil:
+				t.Fatalf("verifyRelease succeeded, want an error about %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("error = %q, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		cur, latest string
+		want        bool
+	}{
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.2.3", "v1.10.0", true},
+		{"v1.2.3", "v2.0.0", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v1.2.2", false},
+		{"v1.2.3-rc1", "v1.2.3", false},
+		{"devel", "v9.9.9", false},
+		{"v1.2.3", "latest", false},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.cur, tt.latest); got != tt.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.cur, tt.latest, got, tt.want)
+		}
+	}
+}
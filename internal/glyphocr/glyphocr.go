@@ -0,0 +1,461 @@
+// Package glyphocr reads portal addresses from screenshots. It looks for a
+// row of twelve evenly spaced shapes and matches each against the sixteen
+// portal glyphs, drawn from the glyph font the site already embeds.
+//
+// It is template matching, not a trained model: it does well on crisp
+// captures of the portal or signal-booster screens and on the site's own
+// glyph cards, and says so through the confidence when it does not.
+package glyphocr
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Symbols are the portal glyphs, in the order the glyph font maps them.
+const Symbols = "0123456789ABCDEF"
+
+const (
+	grid    = 32   // side of the normalized bitmaps
+	maxSide = 2000 // larger screenshots are scaled down first
+)
+
+// ErrNotFound means no row of twelve glyphs was found.
+var ErrNotFound = errors.New("glyphocr: no row of 12 glyphs found")
+
+// Match is one recognized glyph.
+type Match struct {
+	Symbol string  `json:"symbol"`
+	Score  float64 `json:"score"` // 0..1, how closely it matched
+}
+
+// Result is a recognized portal address.
+type Result struct {
+	Symbols    string  `json:"symbols"`
+	Confidence float64 `json:"confidence"` // the mean of the glyph scores
+	Glyphs     []Match `json:"glyphs"`
+}
+
+// Recognizer holds the glyph templates.
+type Recognizer struct {
+	templates [len(Symbols)][]float64
+}
+
+// New draws the templates from a font mapping "0"-"9" and "A"-"F" to the
+// portal glyphs.
+func New(ttf []byte) (*Recognizer, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 128, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	rec := &Recognizer{}
+	for i, r := range Symbols {
+		img := image.NewGray(image.Rect(0, 0, 220, 220))
+		d := font.Drawer{Dst: img, Src: image.White, Face: face, Dot: fixed.P(40, 160)}
+		d.DrawString(string(r))
+		m := newMask(img.Bounds().Dx(), img.Bounds().Dy())
+		for j, v := range img.Pix {
+			m.on[j] = v >= 128
+		}
+		box, ok := m.ink(m.bounds())
+		if !ok {
+			return nil, errors.New("glyphocr: font has no glyph for " + string(r))
+		}
+		rec.templates[i] = m.normalize(box)
+	}
+	return rec, nil
+}
+
+// Read finds the glyph row in img and recognizes it. Rows are looked for
+// in every binarization (a row of glyphs on panels is found by the panels)
+// and each cell is read from whichever shows its glyph best.
+func (rec *Recognizer) Read(img image.Image) (*Result, error) {
+	masks := binarizations(grayscale(img))
+	var best *Result
+	seen := map[image.Rectangle]bool{}
+	for _, m := range masks {
+		for _, row := range m.rows() {
+			if seen[row[0]] {
+				continue
+			}
+			seen[row[0]] = true
+			res := rec.match(masks, row)
+			if best == nil || res.Confidence > best.Confidence {
+				best = res
+			}
+		}
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+	return best, nil
+}
+
+// match recognizes the twelve cells of row.
+func (rec *Recognizer) match(masks []*mask, row []image.Rectangle) *Result {
+	res := &Result{Glyphs: make([]Match, len(row))}
+	var sum float64
+	for i, cell := range row {
+		bi, bs := -1, 0.0
+		for _, m := range masks {
+			box, ok := m.ink(cell)
+			if !ok {
+				continue
+			}
+			v := m.normalize(box)
+			for j, t := range rec.templates {
+				if s := correlate(v, t); s > bs {
+					bi, bs = j, s
+				}
+			}
+		}
+		if bi < 0 {
+			res.Glyphs[i] = Match{Symbol: "?"}
+			res.Symbols += "?"
+			continue
+		}
+		res.Glyphs[i] = Match{Symbol: Symbols[bi : bi+1], Score: math.Round(bs*1000) / 1000}
+		res.Symbols += Symbols[bi : bi+1]
+		sum += bs
+	}
+	res.Confidence = math.Round(sum/float64(len(row))*1000) / 1000
+	return res
+}
+
+// correlate is the normalized cross-correlation of two bitmaps, -1..1.
+func correlate(a, b []float64) float64 {
+	var ma, mb float64
+	for i := range a {
+		ma += a[i]
+		mb += b[i]
+	}
+	ma /= float64(len(a))
+	mb /= float64(len(b))
+	var ab, aa, bb float64
+	for i := range a {
+		da, db := a[i]-ma, b[i]-mb
+		ab += da * db
+		aa += da * da
+		bb += db * db
+	}
+	if aa == 0 || bb == 0 {
+		return 0
+	}
+	return ab / math.Sqrt(aa*bb)
+}
+
+// ---------- Images ----------
+
+// grayscale converts img to luminance, scaled down by a whole factor when
+// it is larger than maxSide.
+func grayscale(img image.Image) *image.Gray {
+	b := img.Bounds()
+	k := (max(b.Dx(), b.Dy()) + maxSide - 1) / maxSide
+	k = max(k, 1)
+	out := image.NewGray(image.Rect(0, 0, b.Dx()/k, b.Dy()/k))
+	if k == 1 {
+		draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+		return out
+	}
+	for y := range out.Rect.Dy() {
+		for x := range out.Rect.Dx() {
+			var sum int
+			for dy := range k {
+				for dx := range k {
+					sum += int(color.GrayModel.Convert(img.At(b.Min.X+x*k+dx, b.Min.Y+y*k+dy)).(color.Gray).Y)
+				}
+			}
+			out.Pix[y*out.Stride+x] = uint8(sum / (k * k))
+		}
+	}
+	return out
+}
+
+// otsu is the threshold best separating the values of hist[lo:hi] in two.
+func otsu(hist *[256]int, lo, hi int) int {
+	var n, sum float64
+	for v := lo; v < hi; v++ {
+		n += float64(hist[v])
+		sum += float64(v * hist[v])
+	}
+	best, bestVar := (lo+hi)/2, -1.0
+	var w0, sum0 float64
+	for t := lo; t < hi; t++ {
+		w0 += float64(hist[t])
+		sum0 += float64(t * hist[t])
+		w1 := n - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		m0, m1 := sum0/w0, (sum-sum0)/w1
+		if v := w0 * w1 * (m0 - m1) * (m0 - m1); v > bestVar {
+			best, bestVar = t+1, v
+		}
+	}
+	return best
+}
+
+// binarizations are the masks worth searching: light glyphs on a dark
+// background and the reverse, at the overall threshold and at the
+// thresholds within the light and the dark part, for glyphs drawn on
+// panels of their own.
+func binarizations(g *image.Gray) []*mask {
+	var hist [256]int
+	for _, v := range g.Pix {
+		hist[v]++
+	}
+	t := otsu(&hist, 0, 256)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	build := func(t int, light bool) *mask {
+		m := newMask(w, h)
+		for y := range h {
+			for x := range w {
+				v := int(g.Pix[y*g.Stride+x])
+				m.on[y*w+x] = (v >= t) == light
+			}
+		}
+		return m
+	}
+	return []*mask{
+		build(t, true),
+		build(t, false),
+		build(otsu(&hist, t, 256), true),
+		build(otsu(&hist, 0, t), false),
+	}
+}
+
+// ---------- Masks ----------
+
+type mask struct {
+	w, h int
+	on   []bool
+}
+
+func newMask(w, h int) *mask { return &mask{w: w, h: h, on: make([]bool, w*h)} }
+
+func (m *mask) bounds() image.Rectangle { return image.Rect(0, 0, m.w, m.h) }
+
+func (m *mask) at(x, y int) bool {
+	return x >= 0 && y >= 0 && x < m.w && y < m.h && m.on[y*m.w+x]
+}
+
+// ink is the bounding box of the set pixels within r.
+func (m *mask) ink(r image.Rectangle) (image.Rectangle, bool) {
+	r = r.Intersect(m.bounds())
+	box, found := image.Rectangle{}, false
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !m.on[y*m.w+x] {
+				continue
+			}
+			p := image.Rect(x, y, x+1, y+1)
+			if !found {
+				box, found = p, true
+			} else {
+				box = box.Union(p)
+			}
+		}
+	}
+	return box, found
+}
+
+// normalize scales the square around box down to grid×grid, keeping the
+// aspect ratio, as the share of set pixels in each cell.
+func (m *mask) normalize(box image.Rectangle) []float64 {
+	side := max(box.Dx(), box.Dy())
+	x0 := box.Min.X - (side-box.Dx())/2
+	y0 := box.Min.Y - (side-box.Dy())/2
+	sum := make([]float64, grid*grid)
+	cnt := make([]float64, grid*grid)
+	for y := range side {
+		for x := range side {
+			i := (y*grid/side)*grid + x*grid/side
+			cnt[i]++
+			if m.at(x0+x, y0+y) {
+				sum[i]++
+			}
+		}
+	}
+	for i := range sum {
+		if cnt[i] > 0 {
+			sum[i] /= cnt[i]
+		}
+	}
+	// Blurred, so strokes a cell or two off still overlap.
+	return blur(blur(sum))
+}
+
+// blur averages each cell of a grid×grid bitmap with its neighbours.
+func blur(v []float64) []float64 {
+	out := make([]float64, len(v))
+	for y := range grid {
+		for x := range grid {
+			var s, n float64
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if xx, yy := x+dx, y+dy; xx >= 0 && yy >= 0 && xx < grid && yy < grid {
+						s += v[yy*grid+xx]
+						n++
+					}
+				}
+			}
+			out[y*grid+x] = s / n
+		}
+	}
+	return out
+}
+
+// components are the bounding boxes of the 8-connected groups of set
+// pixels, leaving out specks.
+func (m *mask) components() []image.Rectangle {
+	seen := make([]bool, len(m.on))
+	var out []image.Rectangle
+	var stack []int
+	for start, on := range m.on {
+		if !on || seen[start] {
+			continue
+		}
+		seen[start] = true
+		stack = append(stack[:0], start)
+		box := image.Rect(start%m.w, start/m.w, start%m.w+1, start/m.w+1)
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			px, py := p%m.w, p/m.w
+			box = box.Union(image.Rect(px, py, px+1, py+1))
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					x, y := px+dx, py+dy
+					if q := y*m.w + x; m.at(x, y) && !seen[q] {
+						seen[q] = true
+						stack = append(stack, q)
+					}
+				}
+			}
+		}
+		if max(box.Dx(), box.Dy()) >= 6 {
+			out = append(out, box)
+		}
+	}
+	return out
+}
+
+// ---------- Rows ----------
+
+const (
+	rowLen     = 12
+	maxShapes  = 1500 // a screenshot busier than this is searched by its largest shapes
+	pitchSlack = 0.2  // how far a glyph may sit from its place in the row, in pitches
+)
+
+func center(r image.Rectangle) (float64, float64) {
+	return float64(r.Min.X+r.Max.X) / 2, float64(r.Min.Y+r.Max.Y) / 2
+}
+
+// rows are the cells of every run of twelve evenly spaced shapes on a
+// line. A run is seeded by a shape and its right-hand neighbour, which set
+// the pitch; each further glyph must turn up within pitchSlack of where
+// the pitch puts it. Glyphs drawn in several pieces are found by their
+// nearest piece and read whole from their cell.
+func (m *mask) rows() [][]image.Rectangle {
+	shapes := m.components()
+	if len(shapes) > maxShapes {
+		slices.SortFunc(shapes, func(a, b image.Rectangle) int {
+			return max(b.Dx(), b.Dy()) - max(a.Dx(), a.Dy())
+		})
+		shapes = shapes[:maxShapes]
+	}
+	sort.Slice(shapes, func(i, j int) bool { return shapes[i].Min.X+shapes[i].Max.X < shapes[j].Min.X+shapes[j].Max.X })
+	xs := make([]float64, len(shapes))
+	for i, s := range shapes {
+		xs[i], _ = center(s)
+	}
+	// near is the shape closest to (x, y), within slack.
+	near := func(x, y, slack float64) (int, bool) {
+		lo := sort.SearchFloat64s(xs, x-slack)
+		best, bestD := -1, slack
+		for i := lo; i < len(xs) && xs[i] <= x+slack; i++ {
+			_, sy := center(shapes[i])
+			if d := math.Hypot(xs[i]-x, sy-y); d <= bestD {
+				best, bestD = i, d
+			}
+		}
+		return best, best >= 0
+	}
+
+	var out [][]image.Rectangle
+	seen := map[[2]int]bool{}
+	for i, s := range shapes {
+		size := float64(max(s.Dx(), s.Dy()))
+		x0, y0 := center(s)
+		for j := i + 1; j < len(shapes) && xs[j]-x0 <= 3*size; j++ {
+			x1, y1 := center(shapes[j])
+			pitch := x1 - x0
+			if pitch < 0.5*size || math.Abs(y1-y0) > pitchSlack*pitch {
+				continue
+			}
+			cx := []float64{x0, x1}
+			cy := []float64{y0, y1}
+			for k := 2; k < rowLen; k++ {
+				n, ok := near(x0+float64(k)*pitch, y0, pitchSlack*pitch)
+				if !ok {
+					break
+				}
+				x, y := center(shapes[n])
+				cx, cy = append(cx, x), append(cy, y)
+			}
+			if len(cx) < rowLen {
+				continue
+			}
+			// Fit the row to the centres found and cut it into cells.
+			p, first := fitPitch(cx)
+			key := [2]int{int(first / 4), int(p / 4)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			var yMean float64
+			for _, y := range cy {
+				yMean += y
+			}
+			yMean /= rowLen
+			row := make([]image.Rectangle, rowLen)
+			for k := range row {
+				x := first + float64(k)*p
+				row[k] = image.Rect(int(x-p/2), int(yMean-0.6*p), int(x+p/2), int(yMean+0.6*p))
+			}
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// fitPitch fits x = first + k·pitch to the centres by least squares.
+func fitPitch(xs []float64) (pitch, first float64) {
+	n := float64(len(xs))
+	var sk, sx, skk, skx float64
+	for k, x := range xs {
+		fk := float64(k)
+		sk += fk
+		sx += x
+		skk += fk * fk
+		skx += fk * x
+	}
+	pitch = (n*skx - sk*sx) / (n*skk - sk*sk)
+	first = (sx - pitch*sk) / n
+	return pitch, first
+}
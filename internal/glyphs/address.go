@@ -0,0 +1,160 @@
+package glyphs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// ---------- Portal addresses ----------
+
+// PortalAddress is a decoded 12-glyph portal code laid out as
+// P SSS YY ZZZ XXX (hex): planet index, star system index and the region's
+// Y, Z and X voxel coordinates, each stored with an offset so they are
+// unsigned.
+type PortalAddress struct {
+	Planet int `json:"planet"` // 0x0-0xF, real planets use 1-6
+	System int `json:"system"` // 0x000-0xFFF
+	Y      int `json:"y"`      // 0x00-0xFF
+	Z      int `json:"z"`      // 0x000-0xFFF
+	X      int `json:"x"`      // 0x000-0xFFF
+}
+
+const (
+	maxPlanetIndex = 6
+	maxSystemIndex = 0x2FF // higher indices do not generate stars
+)
+
+var errPortalFormat = errors.New("portal address must be 12 hex glyphs (0-9, A-F)")
+
+// ParsePortal accepts 12 hex digits, ignoring spaces, dashes and colons.
+func ParsePortal(s string) (PortalAddress, error) {
+	var digits []int
+	for _, r := range strings.ToUpper(s) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, int(r-'0'))
+		case r >= 'A' && r <= 'F':
+			digits = append(digits, int(r-'A'+10))
+		case r == ' ' || r == '-' || r == ':':
+		default:
+			return PortalAddress{}, errPortalFormat
+		}
+	}
+	if len(digits) != 12 {
+		return PortalAddress{}, errPortalFormat
+	}
+	num := func(ds []int) int {
+		n := 0
+		for _, d := range ds {
+			n = n<<4 | d
+		}
+		return n
+	}
+	return PortalAddress{
+		Planet: digits[0],
+		System: num(digits[1:4]),
+		Y:      num(digits[4:6]),
+		Z:      num(digits[6:9]),
+		X:      num(digits[9:12]),
+	}, nil
+}
+
+// ParseGalacticCoords converts signal-booster coordinates XXXX:YYYY:ZZZZ:SSSS
+// into a portal address for the given planet. The booster format offsets
+// the region from the galaxy's corner rather than its centre.
+func ParseGalacticCoords(s string, planet int) (PortalAddress, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 4 {
+		return PortalAddress{}, errors.New("coordinates must look like XXXX:YYYY:ZZZZ:SSSS")
+	}
+	var n [4]int
+	for i, p := range parts {
+		v, err := strconv.ParseUint(strings.TrimSpace(p), 16, 16)
+		if err != nil {
+			return PortalAddress{}, errors.New("coordinates must be four hex groups")
+		}
+		n[i] = int(v)
+	}
+	return PortalAddress{
+		Planet: planet,
+		System: n[3] & 0xFFF,
+		X:      (n[0] - 0x7FF) & 0xFFF,
+		Y:      (n[1] - 0x7F) & 0xFF,
+		Z:      (n[2] - 0x7FF) & 0xFFF,
+	}, nil
+}
+
+// CanonicalSymbols rewrites a portal code as 12 uppercase hex glyphs without
+// separators. Anything that is not a portal code is only trimmed.
+func CanonicalSymbols(s string) string {
+	if a, err := ParsePortal(s); err == nil {
+		return a.String()
+	}
+	return strings.TrimSpace(s)
+}
+
+// String renders the address as 12 uppercase hex glyphs.
+func (a PortalAddress) String() string {
+	return fmt.Sprintf("%X%03X%02X%03X%03X", a.Planet&0xF, a.System&0xFFF, a.Y&0xFF, a.Z&0xFFF, a.X&0xFFF)
+}
+
+// Valid reports whether the address points at a real planet.
+func (a PortalAddress) Valid() bool {
+	return a.Planet >= 1 && a.Planet <= maxPlanetIndex && a.System >= 1 && a.System <= maxSystemIndex
+}
+
+// SameSystem reports whether two addresses point into the same star system
+// (planet index ignored).
+func SameSystem(a, b PortalAddress) bool {
+	a.Planet, b.Planet = 0, 0
+	return a == b
+}
+
+// DefaultGalaxy is assumed for records that do not name a galaxy.
+const DefaultGalaxy = "Euclid"
+
+// LyPerRegion is the approximate width of one region voxel in light years.
+const LyPerRegion = 400
+
+// Region returns the signed region coordinates, centred on the galactic core.
+func (a PortalAddress) Region() (x, y, z int) {
+	sx := func(v, half, span int) int {
+		if v >= half {
+			return v - span
+		}
+		return v
+	}
+	return sx(a.X, 0x800, 0x1000), sx(a.Y, 0x80, 0x100), sx(a.Z, 0x800, 0x1000)
+}
+
+// RegionDistance is the straight-line distance between two addresses'
+// regions, in regions. Systems in the same region are 0 apart.
+func RegionDistance(a, b PortalAddress) float64 {
+	ax, ay, az := a.Region()
+	bx, by, bz := b.Region()
+	dx, dy, dz := float64(ax-bx), float64(ay-by), float64(az-bz)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// RandomPortal returns a random valid address. With near set, the region is
+// chosen within radius regions of near on every axis (wrapping at the edges).
+func RandomPortal(rng *rand.Rand, near *PortalAddress, radius int) PortalAddress {
+	a := PortalAddress{
+		Planet: 1 + rng.IntN(maxPlanetIndex),
+		System: 1 + rng.IntN(maxSystemIndex),
+		Y:      rng.IntN(0x100),
+		Z:      rng.IntN(0x1000),
+		X:      rng.IntN(0x1000),
+	}
+	if near != nil {
+		off := func() int { return rng.IntN(2*radius+1) - radius }
+		a.X = (near.X + off()) & 0xFFF
+		a.Y = (near.Y + off()) & 0xFF
+		a.Z = (near.Z + off()) & 0xFFF
+	}
+	return a
+}
@@ -0,0 +1,78 @@
+package glyphs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ---------- Coordinate conversion ----------
+
+// Coordinates is a location in every form players pass around: the portal
+// code, the signal-booster (galactic) coordinates and the decoded parts.
+type Coordinates struct {
+	Portal   string        `json:"portal"`   // 12 hex glyphs
+	Galactic string        `json:"galactic"` // XXXX:YYYY:ZZZZ:SSSS
+	Address  PortalAddress `json:"address"`  // raw glyph fields
+	Planet   int           `json:"planet"`   // planet index, 1-6 on real planets
+	System   int           `json:"system"`   // star system index within the region
+	Region   [3]int        `json:"region"`   // signed x, y, z from the galactic core
+	CoreLy   int           `json:"core_ly"`  // rough distance to the core in light years
+	Valid    bool          `json:"valid"`    // planet and system indices exist in game
+	Issues   []string      `json:"issues,omitempty"`
+}
+
+// GalacticCoords renders the address as signal-booster coordinates. They
+// carry no planet index.
+func (a PortalAddress) GalacticCoords() string {
+	return fmt.Sprintf("%04X:%04X:%04X:%04X",
+		(a.X+0x7FF)&0xFFF, (a.Y+0x7F)&0xFF, (a.Z+0x7FF)&0xFFF, a.System&0xFFF)
+}
+
+// Coordinates decodes the address.
+func (a PortalAddress) Coordinates() Coordinates {
+	c := Coordinates{
+		Portal:   a.String(),
+		Galactic: a.GalacticCoords(),
+		Address:  a,
+		Planet:   a.Planet,
+		System:   a.System,
+		Valid:    a.Valid(),
+	}
+	c.Region[0], c.Region[1], c.Region[2] = a.Region()
+	x, y, z := float64(c.Region[0]), float64(c.Region[1]), float64(c.Region[2])
+	c.CoreLy = int(math.Round(math.Sqrt(x*x+y*y+z*z) * LyPerRegion))
+	if a.Planet < 1 || a.Planet > maxPlanetIndex {
+		c.Issues = append(c.Issues, fmt.Sprintf("planet index %d is not 1-%d", a.Planet, maxPlanetIndex))
+	}
+	if a.System < 1 || a.System > maxSystemIndex {
+		c.Issues = append(c.Issues, fmt.Sprintf("system index %03X is not 001-%03X", a.System, maxSystemIndex))
+	}
+	return c
+}
+
+// Convert decodes either a portal code or signal-booster coordinates, which
+// need the planet index to become a portal code. It tells them apart by
+// the colon-separated groups of the booster format.
+func Convert(s string, planet int) (Coordinates, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Coordinates{}, errors.New("nothing to convert")
+	}
+	if strings.Count(s, ":") == 3 {
+		if planet < 0 || planet > 0xF {
+			return Coordinates{}, errors.New("planet index must be 0-15")
+		}
+		a, err := ParseGalacticCoords(s, planet)
+		if err != nil {
+			return Coordinates{}, err
+		}
+		return a.Coordinates(), nil
+	}
+	a, err := ParsePortal(s)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	return a.Coordinates(), nil
+}
@@ -0,0 +1,91 @@
+package glyphs
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Duplicate addresses ----------
+
+// The store's Key only catches a glyph saved twice under the same name.
+// AddressKey is looser: two glyphs with the same portal address in the same
+// galaxy lead to the same place, whatever they are called.
+
+// AddressKey identifies where g leads: its galaxy and portal address, or
+// its normalized symbols when they are not a portal address.
+func AddressKey(g *Glyph) string {
+	galaxy := strings.TrimSpace(g.Galaxy)
+	if galaxy == "" {
+		galaxy = DefaultGalaxy
+	}
+	sym := norm.Key(g.Symbols)
+	if a, err := ParsePortal(g.Symbols); err == nil {
+		sym = a.String()
+	}
+	return strings.ToLower(galaxy) + "\x00" + sym
+}
+
+// SameAddress returns the oldest glyph in items with g's address, leaving
+// out the one with ID skipID.
+func SameAddress(items []Glyph, g *Glyph, skipID string) (Glyph, bool) {
+	key := AddressKey(g)
+	var found *Glyph
+	for i := range items {
+		it := &items[i]
+		if it.ID == skipID || AddressKey(it) != key {
+			continue
+		}
+		if found == nil || it.CreatedAt.Before(found.CreatedAt) {
+			found = it
+		}
+	}
+	if found == nil {
+		return Glyph{}, false
+	}
+	return *found, true
+}
+
+// Merge folds a second save of the same address into the stored glyph: its
+// name stays and its blank description and photo are filled in.
+func Merge(into *Glyph, g Glyph) {
+	if into.Description == "" {
+		into.Description = g.Description
+	}
+	if into.Photo == "" {
+		into.Photo = g.Photo
+	}
+}
+
+// DuplicateGroup is glyphs that share an address, oldest first.
+type DuplicateGroup struct {
+	Galaxy  string  `json:"galaxy"`
+	Symbols string  `json:"symbols"`
+	Glyphs  []Glyph `json:"glyphs"`
+}
+
+// Duplicates groups the glyphs in items that share an address, the groups
+// ordered by galaxy and symbols.
+func Duplicates(items []Glyph) []DuplicateGroup {
+	byKey := map[string][]Glyph{}
+	for _, g := range items {
+		k := AddressKey(&g)
+		byKey[k] = append(byKey[k], g)
+	}
+	out := []DuplicateGroup{}
+	for _, gs := range byKey {
+		if len(gs) < 2 {
+			continue
+		}
+		slices.SortFunc(gs, func(a, b Glyph) int { return a.CreatedAt.Compare(b.CreatedAt) })
+		out = append(out, DuplicateGroup{Galaxy: gs[0].Galaxy, Symbols: gs[0].Symbols, Glyphs: gs})
+	}
+	slices.SortFunc(out, func(a, b DuplicateGroup) int {
+		if c := strings.Compare(norm.Key(a.Galaxy), norm.Key(b.Galaxy)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Symbols, b.Symbols)
+	})
+	return out
+}
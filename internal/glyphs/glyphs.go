@@ -0,0 +1,98 @@
+// Package glyphs stores saved portal glyph addresses and decodes them into
+// galactic coordinates.
+package glyphs
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+	"github.com/poku-e/NMScripts/internal/store"
+)
+
+// ---------- Data model: Glyphs ----------
+
+type Glyph struct {
+	store.Meta
+	Name        string `json:"name"`
+	Symbols     string `json:"symbols"`     // raw glyph string
+	Description string `json:"description"` // free text
+	Galaxy      string `json:"galaxy"`
+	Photo       string `json:"photo,omitempty"`
+	Thumb       string `json:"thumb,omitempty"` // follows Photo
+}
+
+// Store is the glyph collection shared by the server and the glyphs CLI.
+type Store = store.Collection[Glyph, *Glyph]
+
+// Spec is the glyph record spec to pass to Store.
+var Spec = store.Spec[Glyph]{
+	Kind: "glyph",
+	Validate: func(g *Glyph) error {
+		g.Name = strings.TrimSpace(g.Name)
+		g.Symbols = CanonicalSymbols(g.Symbols)
+		g.Description = strings.TrimSpace(g.Description)
+		g.Galaxy = strings.TrimSpace(g.Galaxy)
+		if g.Galaxy == "" {
+			g.Galaxy = DefaultGalaxy
+		}
+		g.Thumb = store.ThumbURL(g.Photo)
+
+		if g.Name == "" {
+			return store.Invalid("name", "name required")
+		}
+		if g.Symbols == "" {
+			return store.Invalid("symbols", "symbols required")
+		}
+		if utf8.RuneCountInString(g.Name) > 64 {
+			return store.Invalid("name", "name too long (max 64 chars)")
+		}
+		if utf8.RuneCountInString(g.Symbols) > 128 {
+			return store.Invalid("symbols", "symbols too long (max 128 chars)")
+		}
+		if utf8.RuneCountInString(g.Description) > 512 {
+			return store.Invalid("description", "description too long (max 512 chars)")
+		}
+		if utf8.RuneCountInString(g.Galaxy) > 64 {
+			return store.Invalid("galaxy", "galaxy too long (max 64 chars)")
+		}
+		return nil
+	},
+	// same name (case-insensitive), symbols and galaxy
+	Key: func(g *Glyph) string {
+		return strings.ToLower(g.Name) + "\x00" + norm.Key(g.Symbols) + "\x00" + strings.ToLower(g.Galaxy)
+	},
+	Text: func(g *Glyph) string {
+		return g.Name + " " + g.Symbols + " " + g.Description + " " + g.Galaxy
+	},
+	FromForm: func(v url.Values) Glyph {
+		return Glyph{Name: v.Get("name"), Symbols: v.Get("symbols"), Description: v.Get("description"), Galaxy: v.Get("galaxy")}
+	},
+	SetPhotos: func(g *Glyph, urls []string) {
+		g.Photo = urls[0]
+	},
+	Migrations: []store.Migration{
+		// 1: portal codes are stored as 12 uppercase hex glyphs.
+		func(rec map[string]any) error {
+			if s, ok := rec["symbols"].(string); ok {
+				rec["symbols"] = CanonicalSymbols(s)
+			}
+			return nil
+		},
+		// 2: glyphs record their galaxy; older ones predate it.
+		func(rec map[string]any) error {
+			if g, _ := rec["galaxy"].(string); strings.TrimSpace(g) == "" {
+				rec["galaxy"] = DefaultGalaxy
+			}
+			return nil
+		},
+		// 3: glyphs with a photo link its thumbnail.
+		func(rec map[string]any) error {
+			if p, _ := rec["photo"].(string); store.ThumbURL(p) != "" {
+				rec["thumb"] = store.ThumbURL(p)
+			}
+			return nil
+		},
+	},
+}
@@ -0,0 +1,91 @@
+package glyphs
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Search ----------
+
+// Search ranks glyphs against a query, matching each query word the way
+// typed ingredients are matched to known ones: both sides normalized with
+// norm.Key, then scored by edit distance (halved when one word contains the
+// other) and dropped above norm.MatchCutoff. Whole words, prefixes and
+// substrings score below any typo; words shorter than four letters must
+// match without typos. Every query word must match somewhere in the name,
+// description, symbols, galaxy or tags; a match in the name or symbols
+// ranks above one elsewhere. Ties keep the order of items.
+func Search(items []Glyph, q string) []Glyph {
+	words := strings.Fields(norm.Key(q))
+	if len(words) == 0 {
+		return items
+	}
+	type hit struct {
+		g     Glyph
+		score float64
+	}
+	var hits []hit
+	for _, g := range items {
+		fields := []struct {
+			words  []string
+			weight float64
+		}{
+			{strings.Fields(norm.Key(g.Name)), 0},
+			{[]string{strings.ToLower(g.Symbols)}, 0},
+			{strings.Fields(norm.Key(g.Description)), 0.25},
+			{strings.Fields(norm.Key(g.Galaxy + " " + strings.Join(g.Tags, " "))), 0.25},
+		}
+		total, ok := 0.0, true
+		for _, w := range words {
+			best := -1.0
+			for _, f := range fields {
+				for _, t := range f.words {
+					if d, ok := wordScore(w, t); ok && (best < 0 || d+f.weight < best) {
+						best = d + f.weight
+					}
+				}
+			}
+			if best < 0 {
+				ok = false
+				break
+			}
+			total += best
+		}
+		if ok {
+			hits = append(hits, hit{g, total})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score < hits[j].score })
+	out := make([]Glyph, len(hits))
+	for i, h := range hits {
+		out[i] = h.g
+	}
+	return out
+}
+
+// wordScore scores query word w against a word of a glyph; lower is
+// better. Portal codes compare without their separators.
+func wordScore(w, t string) (float64, bool) {
+	if hex := strings.NewReplacer("-", "", ":", "").Replace(w); hex != w && strings.Contains(t, hex) {
+		w = hex
+	}
+	switch {
+	case t == w:
+		return 0, true
+	case strings.HasPrefix(t, w):
+		return 0.5, true
+	case strings.Contains(t, w):
+		return 1, true
+	}
+	if utf8.RuneCountInString(w) < 4 {
+		return 0, false
+	}
+	d := float64(norm.Distance(w, t))
+	if strings.Contains(w, t) {
+		d *= 0.5
+	}
+	return d, d <= norm.MatchCutoff
+}
@@ -0,0 +1,240 @@
+// Package norm canonicalizes names for lookups and fuzzy matching.
+package norm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Key lowercases s, drops diacritics and symbols and collapses whitespace,
+// so "Fungal  Mould" and "fungal mould" compare equal.
+func Key(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// MatchCutoff is the largest score a fuzzy name match may have: the edit
+// distance, halved when one name contains the other.
+const MatchCutoff = 2.5
+
+// Distance is the Levenshtein edit distance between a and b, in runes.
+func Distance(a, b string) int {
+	if a == b {
+		return 0
+	}
+	la := utf8.RuneCountInString(a)
+	lb := utf8.RuneCountInString(b)
+	if la == 0 {
+		return lb
+	}
+	if lb == 0 {
+		return la
+	}
+	ar := []rune(a)
+	br := []rune(b)
+
+	prev := make([]int, lb+1)
+	cur := make([]int, lb+1)
+	for j := 0; j <= lb; j++ {
+		prev[j] = j
+	}
+	for i := 1; i <= la; i++ {
+		cur[0] = i
+		for j := 1; j <= lb; j++ {
+			cost := 0
+			if ar[i-1] != br[j-1] {
+				cost = 1
+			}
+			a := prev[j] + 1
+			b := cur[j-1] + 1
+			c := prev[j-1] + cost
+			cur[j] = min(a, b, c)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[lb]
+}
+
+// Strategy is how a Matcher compares a typed name with a known one.
+type Strategy string
+
+const (
+	Exact       Strategy = "exact"        // equal once keyed
+	Prefix      Strategy = "prefix"       // the known name starts with the typed one
+	Substring   Strategy = "substring"    // one contains the other
+	Levenshtein Strategy = "levenshtein"  // edit distance, less when one contains the other
+	JaroWinkler Strategy = "jaro-winkler" // Jaro-Winkler similarity
+)
+
+// Strategies are the strategies a Matcher can use.
+var Strategies = []Strategy{Exact, Prefix, Substring, Levenshtein, JaroWinkler}
+
+// Matcher scores typed names against known ones. Threshold is where it
+// stops accepting: for levenshtein the largest score (edit distance,
+// times Bonus when one name contains the other), for jaro-winkler the
+// smallest similarity; the other strategies take none. Zero values mean
+// the defaults (see DefaultMatcher and DefaultJaroWinkler).
+type Matcher struct {
+	Strategy  Strategy
+	Threshold float64
+	Bonus     float64
+}
+
+// DefaultMatcher is how names are matched unless configured otherwise.
+var DefaultMatcher = Matcher{Strategy: Levenshtein, Threshold: MatchCutoff, Bonus: 0.5}
+
+// DefaultJaroWinkler is the smallest similarity jaro-winkler accepts by
+// default.
+const DefaultJaroWinkler = 0.85
+
+// NewMatcher checks a strategy and threshold as given on the command line;
+// threshold and bonus 0 take the strategy's defaults.
+func NewMatcher(strategy string, threshold, bonus float64) (Matcher, error) {
+	m := Matcher{Strategy: Strategy(strategy), Threshold: threshold, Bonus: bonus}
+	switch m.Strategy {
+	case Levenshtein:
+		if m.Threshold == 0 {
+			m.Threshold = MatchCutoff
+		}
+		if m.Bonus == 0 {
+			m.Bonus = DefaultMatcher.Bonus
+		}
+		if m.Threshold < 0 || m.Bonus < 0 || m.Bonus > 1 {
+			return m, errors.New("levenshtein takes a threshold above 0 and a bonus from 0 to 1")
+		}
+	case JaroWinkler:
+		if m.Threshold == 0 {
+			m.Threshold = DefaultJaroWinkler
+		}
+		if m.Threshold < 0 || m.Threshold > 1 {
+			return m, errors.New("jaro-winkler takes a threshold from 0 to 1")
+		}
+	case Exact, Prefix, Substring:
+	default:
+		return m, fmt.Errorf("match strategy must be exact, prefix, substring, levenshtein or jaro-winkler, not %q", strategy)
+	}
+	return m, nil
+}
+
+// Score compares a typed name q with a known name k, both keyed (see Key):
+// a confidence from 0 to 1, higher for closer names, and whether m accepts
+// the match. Under levenshtein the confidence is 1/(1+score), so names
+// rank as they do by edit distance.
+func (m Matcher) Score(q, k string) (float64, bool) {
+	if q == k {
+		return 1, q != ""
+	}
+	if q == "" || k == "" {
+		return 0, false
+	}
+	lq, lk := float64(utf8.RuneCountInString(q)), float64(utf8.RuneCountInString(k))
+	switch m.Strategy {
+	case Prefix:
+		if strings.HasPrefix(k, q) {
+			return lq / lk, true
+		}
+	case Substring:
+		if strings.Contains(k, q) || strings.Contains(q, k) {
+			return min(lq, lk) / max(lq, lk), true
+		}
+	case Levenshtein:
+		d := float64(Distance(q, k))
+		if strings.Contains(k, q) || strings.Contains(q, k) {
+			d *= m.Bonus
+		}
+		return 1 / (1 + d), d <= m.Threshold
+	case JaroWinkler:
+		s := JaroWinklerSimilarity(q, k)
+		return s, s >= m.Threshold
+	}
+	return 0, false
+}
+
+// JaroWinklerSimilarity is the Jaro-Winkler similarity of a and b, from 0
+// (nothing in common) to 1 (equal), favouring a shared start of up to four
+// runes.
+func JaroWinklerSimilarity(a, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	if len(ar) == 0 && len(br) == 0 {
+		return 1
+	}
+	if len(ar) == 0 || len(br) == 0 {
+		return 0
+	}
+	window := max(len(ar), len(br))/2 - 1
+	window = max(window, 0)
+	am, bm := make([]bool, len(ar)), make([]bool, len(br))
+	matches := 0
+	for i := range ar {
+		for j := max(0, i-window); j < min(len(br), i+window+1); j++ {
+			if !bm[j] && ar[i] == br[j] {
+				am[i], bm[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range ar {
+		if !am[i] {
+			continue
+		}
+		for !bm[j] {
+			j++
+		}
+		if ar[i] != br[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(ar)) + m/float64(len(br)) + (m-float64(transpositions/2))/m) / 3
+	prefix := 0
+	for prefix < min(4, len(ar), len(br)) && ar[prefix] == br[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// Closeness is how near a known name k is to a typed name q under m,
+// whether or not m accepts it, for offering names the input may have
+// meant: m's confidence under jaro-winkler, else the levenshtein one,
+// since the other strategies only accept or reject. Names too far off to
+// be worth offering score 0: a similarity under 0.7, or more edits than
+// half the runes of q.
+func (m Matcher) Closeness(q, k string) float64 {
+	if m.Strategy == JaroWinkler {
+		if s := JaroWinklerSimilarity(q, k); s >= 0.7 {
+			return s
+		}
+		return 0
+	}
+	d := float64(Distance(q, k))
+	if d > float64(utf8.RuneCountInString(q))/2 {
+		return 0
+	}
+	if strings.Contains(k, q) || strings.Contains(q, k) {
+		bonus := m.Bonus
+		if m.Strategy != Levenshtein || bonus == 0 {
+			bonus = DefaultMatcher.Bonus
+		}
+		d *= bonus
+	}
+	return 1 / (1 + d)
+}
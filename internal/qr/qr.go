@@ -0,0 +1,461 @@
+// Package qr encodes short texts as QR codes (ISO/IEC 18004) and renders
+// them as SVG. It covers what the address book prints, links and portal
+// codes: byte mode, error correction level M and versions 1 to 10, which
+// hold up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code is an encoded QR symbol.
+type Code struct {
+	Size    int // modules per side, without the quiet zone
+	Version int
+	modules [][]bool
+}
+
+// Dark reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x, y int) bool { return c.modules[y][x] }
+
+// ---------- Encoding ----------
+
+// block layout of one version at level M: EC codewords per block and the
+// data codewords of each block.
+type layout struct {
+	ec     int
+	blocks []int
+}
+
+func rep(n, size int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = size
+	}
+	return s
+}
+
+var layouts = [...]layout{
+	1:  {10, rep(1, 16)},
+	2:  {16, rep(1, 28)},
+	3:  {26, rep(1, 44)},
+	4:  {18, rep(2, 32)},
+	5:  {24, rep(2, 43)},
+	6:  {16, rep(4, 27)},
+	7:  {18, rep(4, 31)},
+	8:  {22, append(rep(2, 38), rep(2, 39)...)},
+	9:  {22, append(rep(3, 36), rep(2, 37)...)},
+	10: {26, append(rep(4, 43), rep(1, 44)...)},
+}
+
+var alignments = [...][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// ErrTooLong means the text does not fit in the largest supported version.
+var ErrTooLong = errors.New("qr: text too long")
+
+// Encode makes the smallest QR code holding data.
+func Encode(data []byte) (*Code, error) {
+	ver := 0
+	for v := 1; v < len(layouts); v++ {
+		if 4+countBits(v)+8*len(data) <= 8*dataCapacity(v) {
+			ver = v
+			break
+		}
+	}
+	if ver == 0 {
+		top := len(layouts) - 1
+		return nil, fmt.Errorf("%w (%d bytes, max %d)", ErrTooLong, len(data), (8*dataCapacity(top)-4-countBits(top))/8)
+	}
+
+	var bb bitBuffer
+	bb.put(0b0100, 4) // byte mode
+	bb.put(len(data), countBits(ver))
+	for _, b := range data {
+		bb.put(int(b), 8)
+	}
+	capBits := 8 * dataCapacity(ver)
+	bb.put(0, min(4, capBits-len(bb))) // terminator
+	bb.put(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capBits; pad ^= 0xEC ^ 0x11 {
+		bb.put(pad, 8)
+	}
+
+	c := newCode(ver)
+	c.drawCodewords(interleave(bb.bytes(), layouts[ver]))
+	best, bestPenalty := 0, -1
+	for m := range 8 {
+		c.applyMask(m)
+		c.drawFormat(m)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = m, p
+		}
+		c.applyMask(m) // masks are their own inverse
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return &Code{Size: c.size, Version: ver, modules: c.modules}, nil
+}
+
+func countBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+func dataCapacity(ver int) int {
+	n := 0
+	for _, b := range layouts[ver].blocks {
+		n += b
+	}
+	return n
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) put(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, v>>i&1 == 1)
+	}
+}
+
+func (bb bitBuffer) bytes() []byte {
+	out := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// interleave splits data into blocks, adds each block's error correction
+// and interleaves the codewords in the order they are placed.
+func interleave(data []byte, l layout) []byte {
+	div := rsDivisor(l.ec)
+	var blocks, ecs [][]byte
+	longest := 0
+	for _, n := range l.blocks {
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, rsRemainder(data[:n], div))
+		data = data[n:]
+		longest = max(longest, n)
+	}
+	var out []byte
+	for i := range longest {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := range l.ec {
+		for _, e := range ecs {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+// ---------- Reed-Solomon over GF(256) ----------
+
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func rsDivisor(degree int) []byte {
+	d := make([]byte, degree)
+	d[degree-1] = 1
+	root := byte(1)
+	for range degree {
+		for j := range d {
+			d[j] = gfMul(d[j], root)
+			if j+1 < len(d) {
+				d[j] ^= d[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return d
+}
+
+func rsRemainder(data, div []byte) []byte {
+	r := make([]byte, len(div))
+	for _, b := range data {
+		f := b ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i, c := range div {
+			r[i] ^= gfMul(c, f)
+		}
+	}
+	return r
+}
+
+// ---------- Module placement ----------
+
+type grid struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // finder, timing, alignment, format and version modules
+}
+
+func newCode(ver int) *grid {
+	g := &grid{size: 17 + 4*ver}
+	g.modules = make([][]bool, g.size)
+	g.function = make([][]bool, g.size)
+	for i := range g.size {
+		g.modules[i] = make([]bool, g.size)
+		g.function[i] = make([]bool, g.size)
+	}
+	for i := range g.size {
+		g.set(6, i, i%2 == 0)
+		g.set(i, 6, i%2 == 0)
+	}
+	g.finder(3, 3)
+	g.finder(g.size-4, 3)
+	g.finder(3, g.size-4)
+	pos := alignments[ver]
+	for i, x := range pos {
+		for j, y := range pos {
+			// skip the three corners with finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					g.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	g.drawFormat(0) // reserve the format modules until the mask is chosen
+	if ver >= 7 {
+		rem := ver
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := ver<<12 | rem
+		for i := range 18 {
+			a, b := g.size-11+i%3, i/3
+			g.set(a, b, bits>>i&1 == 1)
+			g.set(b, a, bits>>i&1 == 1)
+		}
+	}
+	return g
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func (g *grid) set(x, y int, dark bool) {
+	g.modules[y][x] = dark
+	g.function[y][x] = true
+}
+
+// finder draws a finder pattern centred on x, y with its separator.
+func (g *grid) finder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= g.size || yy >= g.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			g.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawFormat writes both copies of the format information for level M
+// and a mask, and the dark module.
+func (g *grid) drawFormat(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := range 6 {
+		g.set(8, i, bit(i))
+	}
+	g.set(8, 7, bit(6))
+	g.set(8, 8, bit(7))
+	g.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		g.set(14-i, 8, bit(i))
+	}
+	for i := range 8 {
+		g.set(g.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		g.set(8, g.size-15+i, bit(i))
+	}
+	g.set(8, g.size-8, true)
+}
+
+// drawCodewords fills the data area in the zigzag order, right to left in
+// column pairs, skipping the vertical timing pattern.
+func (g *grid) drawCodewords(data []byte) {
+	i := 0
+	for right := g.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range g.size {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = g.size - 1 - vert
+				}
+				if g.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				g.modules[y][x] = data[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (g *grid) applyMask(mask int) {
+	for y := range g.size {
+		for x := range g.size {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !g.function[y][x] {
+				g.modules[y][x] = !g.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; the mask
+// with the lowest score is used.
+func (g *grid) penalty() int {
+	p := 0
+	at := func(x, y int, col bool) bool {
+		if col {
+			return g.modules[x][y]
+		}
+		return g.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, col := range []bool{false, true} {
+		for y := range g.size {
+			run := 0
+			for x := range g.size {
+				if x > 0 && at(x, y, col) == at(x-1, y, col) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					p += 3
+				} else if run > 5 {
+					p++
+				}
+			}
+			for x := 0; x+7 <= g.size; x++ {
+				match := true
+				for k, d := range finderLike {
+					if at(x+k, y, col) != d {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				light := func(from, to int) bool {
+					for k := from; k < to; k++ {
+						if k >= 0 && k < g.size && at(k, y, col) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(x-4, x) || light(x+7, x+11) {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := range g.size {
+		for x := range g.size {
+			if g.modules[y][x] {
+				dark++
+			}
+			if x+1 < g.size && y+1 < g.size {
+				c := g.modules[y][x]
+				if c == g.modules[y][x+1] && c == g.modules[y+1][x] && c == g.modules[y+1][x+1] {
+					p += 3
+				}
+			}
+		}
+	}
+	total := g.size * g.size
+	p += abs(dark*20-total*10) / total * 10
+	return p
+}
+
+// ---------- Rendering ----------
+
+// SVG renders the code with its 4-module quiet zone as a scalable SVG
+// element, one module per user unit; size it with CSS.
+func (c *Code) SVG() string {
+	const quiet = 4
+	n := c.Size + 2*quiet
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range c.Size {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			w := 1
+			for x+w < c.Size && c.modules[y][x+w] {
+				w++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x+quiet, y+quiet, w, w)
+			x += w - 1
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
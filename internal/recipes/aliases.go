@@ -0,0 +1,213 @@
+package recipes
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Ingredient aliases ----------
+
+// Aliases map the shorthand players type ("o2", "carbon+", "salt water")
+// to item names, and are looked up before any fuzzy matching. They are
+// safe for concurrent use; Set and Delete save them back to their file.
+// A nil *Aliases has none.
+type Aliases struct {
+	mu   sync.RWMutex
+	path string
+	m    map[string]Alias // by normalized alias
+}
+
+// Alias is one shorthand and the item it stands for.
+type Alias struct {
+	Alias string `json:"alias"`
+	Name  string `json:"name"`
+}
+
+// LoadAliasesCSV loads aliases from a CSV with alias and name columns. A
+// missing file is not an error: it is created by the first Set.
+func LoadAliasesCSV(path string) (*Aliases, error) {
+	a := &Aliases{path: path, m: map[string]Alias{}}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open csv: %w", err)
+	}
+	defer f.Close()
+	list, err := ReadAliasesCSV(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, al := range list {
+		a.m[norm.Key(al.Alias)] = al
+	}
+	return a, nil
+}
+
+// ReadAliasesCSV reads aliases from r, as LoadAliasesCSV does from a
+// file. Rows missing either cell are skipped.
+func ReadAliasesCSV(r io.Reader) ([]Alias, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	headers := map[string]int{}
+	for i, h := range records[0] {
+		headers[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+	ac, ok := headers["alias"]
+	if !ok {
+		return nil, errors.New("missing required column: alias")
+	}
+	nc, ok := headers["name"]
+	if !ok {
+		return nil, errors.New("missing required column: name")
+	}
+	var out []Alias
+	for _, row := range records[1:] {
+		if ac >= len(row) || nc >= len(row) {
+			continue
+		}
+		al := Alias{Alias: strings.TrimSpace(row[ac]), Name: strings.TrimSpace(row[nc])}
+		if norm.Key(al.Alias) == "" || al.Name == "" {
+			continue
+		}
+		out = append(out, al)
+	}
+	return out, nil
+}
+
+// Len is how many aliases there are.
+func (a *Aliases) Len() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.m)
+}
+
+// Resolve returns the item an alias stands for.
+func (a *Aliases) Resolve(q string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	al, ok := a.m[norm.Key(q)]
+	return al.Name, ok
+}
+
+// Apply replaces the aliases in a list of user input with the items they
+// stand for, leaving the rest as typed.
+func (a *Aliases) Apply(parts []string) []string {
+	if a.Len() == 0 {
+		return parts
+	}
+	out := make([]string, len(parts))
+	for i, p := range parts {
+		if name, ok := a.Resolve(p); ok {
+			p = name
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// List returns the aliases sorted by alias.
+func (a *Aliases) List() []Alias {
+	out := []Alias{}
+	if a == nil {
+		return out
+	}
+	a.mu.RLock()
+	for _, al := range a.m {
+		out = append(out, al)
+	}
+	a.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return norm.Key(out[i].Alias) < norm.Key(out[j].Alias) })
+	return out
+}
+
+// Set adds or changes an alias and saves the file.
+func (a *Aliases) Set(alias, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := norm.Key(alias)
+	old, had := a.m[k]
+	a.m[k] = Alias{Alias: strings.TrimSpace(alias), Name: name}
+	if err := a.save(); err != nil {
+		if had {
+			a.m[k] = old
+		} else {
+			delete(a.m, k)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes an alias and saves the file; false if there was none.
+func (a *Aliases) Delete(alias string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := norm.Key(alias)
+	old, ok := a.m[k]
+	if !ok {
+		return false, nil
+	}
+	delete(a.m, k)
+	if err := a.save(); err != nil {
+		a.m[k] = old
+		return true, err
+	}
+	return true, nil
+}
+
+// save writes the aliases to a temporary file and renames it over the
+// file, so a crash leaves the old or the new list. The caller holds a.mu.
+func (a *Aliases) save() error {
+	if a.path == "" {
+		return errors.New("no aliases file")
+	}
+	keys := make([]string, 0, len(a.m))
+	for k := range a.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), ".aliases-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	cw := csv.NewWriter(tmp)
+	cw.Write([]string{"alias", "name"})
+	for _, k := range keys {
+		cw.Write([]string{a.m[k].Alias, a.m[k].Name})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.path)
+}
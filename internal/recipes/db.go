@@ -0,0 +1,565 @@
+// Package recipes loads the cooking, refiner and technology datasets and
+// answers ingredient and crafting-cost queries over them.
+package recipes
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Data model: Recipes ----------
+
+type Recipe struct {
+	Inputs   []string `json:"inputs"`
+	InputQty []int    `json:"input_qty,omitempty"` // per input, as Inputs; see InQty
+	Output   string   `json:"output"`
+	Qty      int      `json:"qty"`
+}
+
+// InQty is how many of input i one craft takes: 1 unless InputQty says
+// otherwise.
+func (r Recipe) InQty(i int) int {
+	if i < len(r.InputQty) && r.InputQty[i] > 0 {
+		return r.InputQty[i]
+	}
+	return 1
+}
+
+type DB struct {
+	Recipes         []Recipe
+	AllIngredients  []string
+	ingIndex        map[string][]int // ingredient -> indices into Recipes
+	byID            map[string]int   // Recipe.ID -> index into Recipes
+	normIngToActual map[string]string
+	Items           map[string]ItemInfo // item name -> category/colour, when the CSV has them
+	Issues          []Issue             // row problems found while loading, in file order
+}
+
+// Issue is a problem with one CSV row. Rows with an error are skipped; rows
+// with only warnings are loaded (a bad qty falls back to 1).
+type Issue struct {
+	Line  int
+	Error bool
+	Msg   string
+}
+
+func (i Issue) String() string {
+	level := "warning"
+	if i.Error {
+		level = "error"
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, level, i.Msg)
+}
+
+// Errors counts the issues that made LoadCSV skip a row.
+func (db *DB) Errors() int {
+	n := 0
+	for _, is := range db.Issues {
+		if is.Error {
+			n++
+		}
+	}
+	return n
+}
+
+// ---------- CSV load ----------
+
+func LoadCSV(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open csv: %w", err)
+	}
+	defer func(f *os.File) {
+		if cerr := f.Close(); cerr != nil {
+			fmt.Printf("error closing file: %v", cerr)
+		}
+	}(f)
+	return ReadCSV(f)
+}
+
+// ReadCSV loads a recipe table from r, as LoadCSV does from a file.
+func ReadCSV(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	// Read row by row to keep each record's line number for Issues.
+	var records [][]string
+	var lines []int
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("csv has no rows")
+	}
+
+	headers := map[string]int{}
+	for i, h := range records[0] {
+		headers[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+
+	col := func(name string) (int, bool) {
+		i, ok := headers[strings.ToLower(name)]
+		return i, ok
+	}
+
+	req := []string{
+		"input1_name", "input2_name", "input3_name",
+		"output_name", "output_qty",
+	}
+	for _, r := range req {
+		if _, ok := col(r); !ok {
+			return nil, fmt.Errorf("missing required column: %s", r)
+		}
+	}
+
+	var db DB
+	db.Items = make(map[string]ItemInfo)
+	seen := make(map[string]int) // recipe key -> line of its first row
+	issue := func(line int, isErr bool, format string, args ...any) {
+		db.Issues = append(db.Issues, Issue{Line: line, Error: isErr, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	for r := 1; r < len(records); r++ {
+		row, line := records[r], lines[r]
+		if len(row) == 0 || strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		var inputs []string
+		var inQty []int
+		var bad []string // input_qty cells that are not a positive number
+		for _, p := range []string{"input1", "input2", "input3"} {
+			if idx, ok := col(p + "_name"); ok && idx < len(row) {
+				if v := strings.TrimSpace(row[idx]); v != "" {
+					inputs = append(inputs, v)
+					q := 1
+					if idx, ok := col(p + "_qty"); ok && idx < len(row) {
+						v := strings.TrimSpace(row[idx])
+						if n, err := strconv.Atoi(v); err == nil && n > 0 {
+							q = n
+						} else if v != "" {
+							bad = append(bad, p+"_qty "+strconv.Quote(v))
+						}
+					}
+					inQty = append(inQty, q)
+				}
+			}
+		}
+		var output string
+		if idx, ok := col("output_name"); ok && idx < len(row) {
+			output = strings.TrimSpace(row[idx])
+		}
+		if output == "" {
+			issue(line, true, "missing output_name")
+			continue
+		}
+		if len(inputs) == 0 {
+			issue(line, true, "%s has no inputs", output)
+			continue
+		}
+		qty := 1
+		if idx, ok := col("output_qty"); ok && idx < len(row) {
+			v := strings.TrimSpace(row[idx])
+			if q, err := strconv.Atoi(v); err == nil && q > 0 {
+				qty = q
+			} else if v != "" {
+				issue(line, false, "%s: output_qty %q is not a positive number, using 1", output, v)
+			}
+		}
+		for _, b := range bad {
+			issue(line, false, "%s: %s is not a positive number, using 1", output, b)
+		}
+		key := recipeKey(inputs, output)
+		if first, dup := seen[key]; dup {
+			issue(line, false, "%s: duplicate of the recipe on line %d", output, first)
+		} else {
+			seen[key] = line
+		}
+		// Optional *_category/*_bg/*_img/*_href columns from the scraper
+		// describe items.
+		for _, p := range []string{"input1", "input2", "input3", "output"} {
+			cell := func(suffix string) string {
+				if idx, ok := col(p + suffix); ok && idx < len(row) {
+					return strings.TrimSpace(row[idx])
+				}
+				return ""
+			}
+			if name := cell("_name"); name != "" {
+				db.Items[name] = db.Items[name].merge(newItemInfo(cell("_category"), cell("_bg"), cell("_img"), cell("_href")))
+			}
+		}
+		rec := Recipe{Inputs: inputs, InputQty: inQty, Output: output, Qty: qty}
+		db.Recipes = append(db.Recipes, rec)
+	}
+
+	db.index()
+	return &db, nil
+}
+
+// index builds the ingredient lookups from db.Recipes.
+func (db *DB) index() {
+	db.ingIndex = make(map[string][]int)
+	db.byID = make(map[string]int, len(db.Recipes))
+	db.normIngToActual = make(map[string]string)
+	db.AllIngredients = nil
+	ingSet := make(map[string]struct{})
+	for i, rec := range db.Recipes {
+		if _, dup := db.byID[rec.ID()]; !dup {
+			db.byID[rec.ID()] = i
+		}
+		for _, ing := range rec.Inputs {
+			ing = strings.TrimSpace(ing)
+			if ing == "" {
+				continue
+			}
+			ingSet[ing] = struct{}{}
+			db.ingIndex[ing] = append(db.ingIndex[ing], i)
+			db.normIngToActual[norm.Key(ing)] = ing
+		}
+	}
+
+	for ing := range ingSet {
+		db.AllIngredients = append(db.AllIngredients, ing)
+	}
+	sort.Strings(db.AllIngredients)
+}
+
+// Key identifies the recipe by its output and its inputs in any order, as
+// the duplicate check when loading does.
+func (r Recipe) Key() string { return recipeKey(r.Inputs, r.Output) }
+
+// ID is a short name for the recipe made from its Key, so it stays the
+// same across reloads, storage backends and instances serving the dataset.
+// Stores index their recipes by it as they load (see Store.ByID).
+func (r Recipe) ID() string {
+	sum := sha256.Sum256([]byte(r.Key()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// recipeKey identifies a recipe by its output and its inputs in any order.
+func recipeKey(inputs []string, output string) string {
+	keys := make([]string, len(inputs))
+	for i, in := range inputs {
+		keys[i] = norm.Key(in)
+	}
+	sort.Strings(keys)
+	return norm.Key(output) + "\x00" + strings.Join(keys, "\x00")
+}
+
+// ---------- Fuzzy matching helpers ----------
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Match is how one piece of user input was mapped: the ingredient it was
+// taken for and how sure the match is, from 0 to 1 (1 for exact; see
+// norm.Matcher.Score).
+type Match struct {
+	Input      string  `json:"input"`
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Names lists the ingredients of matches, in order.
+func Names(matches []Match) []string {
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.Name
+	}
+	return out
+}
+
+func (db *DB) MapIngredients(inputs []string) ([]string, []string) {
+	return mapIngredients(inputs, db.AllIngredients, db.normIngToActual)
+}
+
+func (db *DB) MatchIngredients(m norm.Matcher, inputs []string) ([]Match, []string) {
+	return matchIngredients(m, inputs, db.AllIngredients, db.normIngToActual)
+}
+
+// mapIngredients maps user input as matchIngredients does with
+// norm.DefaultMatcher.
+func mapIngredients(inputs, all []string, exact map[string]string) ([]string, []string) {
+	matches, unknown := matchIngredients(norm.DefaultMatcher, inputs, all, exact)
+	if matches == nil {
+		return nil, unknown
+	}
+	return Names(matches), unknown
+}
+
+// matchIngredients maps user input onto the known ingredient names: exact
+// (normalized) matches first, then the name m scores highest, if m
+// accepts it. An ingredient two inputs map to is listed once.
+func matchIngredients(m norm.Matcher, inputs, all []string, exact map[string]string) ([]Match, []string) {
+	var matches []Match
+	var unknown []string
+
+	type cand struct{ norm, actual string }
+	candidates := make([]cand, 0, len(all))
+	for _, ing := range all {
+		candidates = append(candidates, cand{norm: norm.Key(ing), actual: ing})
+	}
+
+	for _, raw := range inputs {
+		q := norm.Key(raw)
+		if q == "" {
+			continue
+		}
+		if act, ok := exact[q]; ok {
+			matches = append(matches, Match{Input: raw, Name: act, Confidence: 1})
+			continue
+		}
+		best := Match{Input: raw}
+		for _, c := range candidates {
+			if conf, ok := m.Score(q, c.norm); ok && conf > best.Confidence {
+				best.Name, best.Confidence = c.actual, conf
+			}
+		}
+		if best.Name != "" {
+			matches = append(matches, best)
+		} else {
+			unknown = append(unknown, raw)
+		}
+	}
+	seen := map[string]struct{}{}
+	uniq := matches[:0]
+	for _, mt := range matches {
+		if _, ok := seen[mt.Name]; ok {
+			continue
+		}
+		seen[mt.Name] = struct{}{}
+		uniq = append(uniq, mt)
+	}
+	return uniq, unknown
+}
+
+// Candidate is a name some input may have meant, with how close it is
+// (see norm.Matcher.Closeness).
+type Candidate struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Closest returns up to n of names nearest to q under m, closest first.
+func Closest(m norm.Matcher, q string, names []string, n int) []Candidate {
+	k := norm.Key(q)
+	var out []Candidate
+	for _, name := range names {
+		if s := m.Closeness(k, norm.Key(name)); s > 0 {
+			out = append(out, Candidate{Name: name, Score: s})
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Candidate) int { return cmp.Compare(b.Score, a.Score) })
+	return out[:min(n, len(out))]
+}
+
+// MapName maps user input onto one of names the way MapIngredients maps
+// ingredients, for lists such as Store.Outputs.
+func MapName(q string, names []string) (string, bool) {
+	exact := make(map[string]string, len(names))
+	for _, n := range names {
+		exact[norm.Key(n)] = n
+	}
+	mapped, _ := mapIngredients([]string{q}, names, exact)
+	if len(mapped) == 0 {
+		return "", false
+	}
+	return mapped[0], true
+}
+
+func (db *DB) Suggest(all []string) []Recipe {
+	if len(all) == 0 {
+		return nil
+	}
+	var idxs []int
+	for i, ing := range all {
+		list := db.ingIndex[ing]
+		if i == 0 {
+			idxs = append([]int(nil), list...)
+			continue
+		}
+		idxs = intersectSortedOrUnsorted(idxs, list)
+		if len(idxs) == 0 {
+			break
+		}
+	}
+	seen := map[int]struct{}{}
+	out := make([]Recipe, 0, len(idxs))
+	for _, ix := range idxs {
+		if _, ok := seen[ix]; ok {
+			continue
+		}
+		seen[ix] = struct{}{}
+		out = append(out, db.Recipes[ix])
+	}
+	return out
+}
+
+// NearMiss is a recipe that takes some, but not all, of the ingredients
+// asked for.
+type NearMiss struct {
+	Recipe
+	Uses    []string `json:"uses"`     // asked-for ingredients the recipe takes
+	NotUsed []string `json:"not_used"` // asked-for ingredients it does not
+}
+
+// NearMisses explains an empty Suggest: the recipes that take the most of
+// have, best first (then fewest inputs, then file order), at most limit.
+func (db *DB) NearMisses(have []string, limit int) []NearMiss {
+	hits := map[int]int{} // recipe index -> how many of have it takes
+	for _, ing := range have {
+		for _, ix := range db.ingIndex[ing] {
+			hits[ix]++
+		}
+	}
+	idxs := make([]int, 0, len(hits))
+	for ix := range hits {
+		idxs = append(idxs, ix)
+	}
+	sort.Slice(idxs, func(i, j int) bool {
+		a, b := idxs[i], idxs[j]
+		if hits[a] != hits[b] {
+			return hits[a] > hits[b]
+		}
+		if la, lb := len(db.Recipes[a].Inputs), len(db.Recipes[b].Inputs); la != lb {
+			return la < lb
+		}
+		return a < b
+	})
+	if len(idxs) > limit {
+		idxs = idxs[:limit]
+	}
+	out := make([]NearMiss, 0, len(idxs))
+	for _, ix := range idxs {
+		out = append(out, newNearMiss(db.Recipes[ix], have))
+	}
+	return out
+}
+
+func newNearMiss(r Recipe, have []string) NearMiss {
+	nm := NearMiss{Recipe: r}
+	for _, ing := range have {
+		if slices.Contains(nm.Inputs, ing) {
+			nm.Uses = append(nm.Uses, ing)
+		} else {
+			nm.NotUsed = append(nm.NotUsed, ing)
+		}
+	}
+	return nm
+}
+
+// MissingOne is a recipe taking every input but one from the ingredients
+// asked for.
+type MissingOne struct {
+	Recipe
+	Missing string `json:"missing"`
+}
+
+// MissingOnes returns the recipes of s that have is one ingredient short
+// of, those missing the ingredient that completes the most recipes first.
+// At most limit are returned.
+func MissingOnes(s Store, have []string, limit int) []MissingOne {
+	got := map[string]bool{}
+	for _, h := range have {
+		got[h] = true
+	}
+	seen := map[string]bool{}
+	var out []MissingOne
+	unlocks := map[string]int{}
+	for _, h := range have {
+		for _, r := range s.Suggest([]string{h}) {
+			if seen[r.Key()] {
+				continue
+			}
+			seen[r.Key()] = true
+			var missing []string
+			for _, in := range r.Inputs {
+				if !got[in] && !slices.Contains(missing, in) {
+					missing = append(missing, in)
+				}
+			}
+			if len(missing) == 1 {
+				out = append(out, MissingOne{Recipe: r, Missing: missing[0]})
+				unlocks[missing[0]]++
+			}
+		}
+	}
+	slices.SortStableFunc(out, func(a, b MissingOne) int {
+		if ua, ub := unlocks[a.Missing], unlocks[b.Missing]; ua != ub {
+			return ub - ua
+		}
+		return strings.Compare(a.Missing, b.Missing)
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// DistinctOutputs keeps one recipe per output, in the order outputs first
+// appear. The one kept makes the most of the output and, on a tie, needs the
+// fewest inputs.
+func DistinctOutputs(recs []Recipe) []Recipe {
+	at := map[string]int{} // output -> index into out
+	out := make([]Recipe, 0, len(recs))
+	for _, r := range recs {
+		i, ok := at[r.Output]
+		if !ok {
+			at[r.Output] = len(out)
+			out = append(out, r)
+			continue
+		}
+		if b := out[i]; r.Qty > b.Qty || r.Qty == b.Qty && len(r.Inputs) < len(b.Inputs) {
+			out[i] = r
+		}
+	}
+	return out
+}
+
+func intersectSortedOrUnsorted(a, b []int) []int {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	ac := append([]int(nil), a...)
+	bc := append([]int(nil), b...)
+	sort.Ints(ac)
+	sort.Ints(bc)
+	var out []int
+	i, j := 0, 0
+	for i < len(ac) && j < len(bc) {
+		switch {
+		case ac[i] == bc[j]:
+			out = append(out, ac[i])
+			i++
+			j++
+		case ac[i] < bc[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
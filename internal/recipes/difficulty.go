@@ -0,0 +1,123 @@
+package recipes
+
+import (
+	"math"
+	"sort"
+)
+
+// ---------- Difficulty ----------
+
+// Difficulty is how far something is from raw materials: the number of
+// crafting steps on its shallowest route, and how many distinct raw
+// ingredients that route takes. A raw item (made by no recipe) has depth 0
+// and is its own one raw ingredient.
+type Difficulty struct {
+	Depth int `json:"depth"`
+	Raw   int `json:"raw"`
+}
+
+// Difficulties rates every item of a dataset, following only its own
+// recipes: an item no recipe makes counts as raw.
+type Difficulties struct {
+	depth map[string]int
+	raw   map[string][]string // sorted raw ingredients of the shallowest route
+}
+
+// NewDifficulties works out each item's shallowest route. Recipes that
+// only lead back to their own output (cycles) never win, since the depth of
+// a recipe is one more than its deepest input.
+func NewDifficulties(recs []Recipe) *Difficulties {
+	d := &Difficulties{depth: map[string]int{}, raw: map[string][]string{}}
+	made := map[string]bool{}
+	for _, r := range recs {
+		made[r.Output] = true
+	}
+	for _, r := range recs {
+		for _, in := range r.Inputs {
+			if !made[in] {
+				d.depth[in] = 0
+				d.raw[in] = []string{in}
+			}
+		}
+	}
+	best := map[string]int{} // output -> index of its shallowest recipe
+	for out := range made {
+		d.depth[out] = math.MaxInt
+	}
+	// Relax until nothing gets shallower; depths only ever shrink, so this
+	// ends.
+	for changed := true; changed; {
+		changed = false
+		for i, r := range recs {
+			dep := d.recipeDepth(r)
+			if dep < d.depth[r.Output] {
+				d.depth[r.Output] = dep
+				best[r.Output] = i
+				changed = true
+			}
+		}
+	}
+	var rawOf func(item string) []string
+	rawOf = func(item string) []string {
+		if r, ok := d.raw[item]; ok {
+			return r
+		}
+		i, ok := best[item]
+		if !ok {
+			return nil // only reachable through a cycle
+		}
+		d.raw[item] = nil // guards against cycles while recursing
+		d.raw[item] = d.union(recs[i].Inputs, rawOf)
+		return d.raw[item]
+	}
+	for out := range made {
+		rawOf(out)
+	}
+	return d
+}
+
+func (d *Difficulties) recipeDepth(r Recipe) int {
+	dep := 0
+	for _, in := range r.Inputs {
+		di, ok := d.depth[in]
+		if !ok || di == math.MaxInt {
+			return math.MaxInt
+		}
+		dep = max(dep, di)
+	}
+	return dep + 1
+}
+
+func (d *Difficulties) union(inputs []string, rawOf func(string) []string) []string {
+	set := map[string]bool{}
+	for _, in := range inputs {
+		for _, r := range rawOf(in) {
+			set[r] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for r := range set {
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Item rates one item; ok is false for an item only cycles make.
+func (d *Difficulties) Item(name string) (Difficulty, bool) {
+	dep, ok := d.depth[name]
+	if !ok || dep == math.MaxInt {
+		return Difficulty{}, false
+	}
+	return Difficulty{Depth: dep, Raw: len(d.raw[name])}, true
+}
+
+// Recipe rates making r's output with r itself, which can be deeper than
+// the output's shallowest route; ok is false if an input only cycles make.
+func (d *Difficulties) Recipe(r Recipe) (Difficulty, bool) {
+	dep := d.recipeDepth(r)
+	if dep == math.MaxInt {
+		return Difficulty{}, false
+	}
+	return Difficulty{Depth: dep, Raw: len(d.union(r.Inputs, func(in string) []string { return d.raw[in] }))}, true
+}
@@ -0,0 +1,77 @@
+package recipes
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Early game ----------
+
+// lateGameList is the curated list of raw ingredients that need advanced
+// tech (base farms, the Fishing Rig) to get.
+//
+//go:embed data/late_game.txt
+var lateGameList []byte
+
+// lateGame holds the normalized names in lateGameList.
+var lateGame = func() map[string]bool {
+	m := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(lateGameList))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m[norm.Key(line)] = true
+	}
+	return m
+}()
+
+// EarlyGame tells which items of a dataset a new player can make: raw
+// ingredients not on the late-game list, and anything some recipe makes
+// from early items alone.
+type EarlyGame struct {
+	early map[string]bool
+}
+
+func NewEarlyGame(recs []Recipe) *EarlyGame {
+	e := &EarlyGame{early: map[string]bool{}}
+	made := map[string]bool{}
+	for _, r := range recs {
+		made[r.Output] = true
+	}
+	for _, r := range recs {
+		for _, in := range r.Inputs {
+			if !made[in] && !lateGame[norm.Key(in)] {
+				e.early[in] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range recs {
+			if !e.early[r.Output] && e.Recipe(r) {
+				e.early[r.Output] = true
+				changed = true
+			}
+		}
+	}
+	return e
+}
+
+// Item reports whether name can be had early on.
+func (e *EarlyGame) Item(name string) bool { return e.early[name] }
+
+// Recipe reports whether every input of r can be had early on.
+func (e *EarlyGame) Recipe(r Recipe) bool {
+	for _, in := range r.Inputs {
+		if !e.early[in] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package recipes
+
+import (
+	"embed"
+	"fmt"
+)
+
+// ---------- Built-in datasets ----------
+
+// The food and refiner tables as of the last scrape, so the server can start
+// without any files next to it. Refresh them with
+//
+//	nms scrape --url https://app.nmsassistant.com/cooking --out internal/recipes/data/food.csv
+//	nms scrape --url https://app.nmsassistant.com/refiner --out internal/recipes/data/refiner.csv
+//
+//go:embed data/food.csv data/refiner.csv
+var builtin embed.FS
+
+// LoadBuiltin loads a dataset built into the binary: "food.csv" or
+// "refiner.csv".
+func LoadBuiltin(name string) (*DB, error) {
+	f, err := builtin.Open("data/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("no built-in dataset %q", name)
+	}
+	defer f.Close()
+	return ReadCSV(f)
+}
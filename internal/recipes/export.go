@@ -0,0 +1,66 @@
+package recipes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ---------- JSON export ----------
+
+// Dataset is a recipe dataset as JSON: the form one instance serves its
+// recipes in for others to mount.
+type Dataset struct {
+	Recipes []Recipe            `json:"recipes"`
+	Items   map[string]ItemInfo `json:"items,omitempty"`
+}
+
+// Export returns a store's recipes and the category, colour, icon and page
+// of every item it mentions.
+func Export(s Store) Dataset {
+	d := Dataset{Recipes: s.All(), Items: map[string]ItemInfo{}}
+	for _, name := range s.ItemNames() {
+		info := s.Info(name)
+		info = ItemInfo{Category: info.Category, Color: info.Color, Img: info.Img, Href: info.Href}
+		if info != (ItemInfo{}) {
+			d.Items[name] = info
+		}
+	}
+	return d
+}
+
+// FromDataset builds a DB from an exported dataset, with the checks LoadCSV
+// applies to rows. A recipe LoadCSV would skip makes the whole dataset
+// invalid, since an export never has one.
+func FromDataset(d Dataset) (*DB, error) {
+	if len(d.Recipes) == 0 {
+		return nil, errors.New("no recipes")
+	}
+	db := &DB{Recipes: make([]Recipe, 0, len(d.Recipes)), Items: map[string]ItemInfo{}}
+	for i, r := range d.Recipes {
+		r.Output = strings.TrimSpace(r.Output)
+		var ins []string
+		var qs []int
+		for j, in := range r.Inputs {
+			if in = strings.TrimSpace(in); in != "" {
+				ins = append(ins, in)
+				qs = append(qs, r.InQty(j))
+			}
+		}
+		r.Inputs, r.InputQty = ins, qs
+		switch {
+		case r.Output == "":
+			return nil, fmt.Errorf("recipe %d: missing output", i)
+		case len(r.Inputs) == 0 || len(r.Inputs) > 3:
+			return nil, fmt.Errorf("recipe %d (%s): takes %d inputs, want 1 to 3", i, r.Output, len(r.Inputs))
+		case r.Qty < 1:
+			r.Qty = 1
+		}
+		db.Recipes = append(db.Recipes, r)
+	}
+	for name, info := range d.Items {
+		db.Items[name] = newItemInfo(info.Category, info.Color, info.Img, info.Href)
+	}
+	db.index()
+	return db, nil
+}
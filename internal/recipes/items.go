@@ -0,0 +1,145 @@
+package recipes
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ---------- Item categories and colours ----------
+
+// ItemInfo is what the UI needs to show an item: its category, the
+// background the game shows behind its icon, and the icon and page the
+// scraper found for it.
+type ItemInfo struct {
+	Category string `json:"category,omitempty"` // raw, product, cooked, curiosity...
+	Color    string `json:"color,omitempty"`    // #rrggbb
+	Img      string `json:"img,omitempty"`      // URL of the scraped icon
+	Href     string `json:"href,omitempty"`     // URL of the item's page
+	Icon     string `json:"icon,omitempty"`     // URL of an uploaded icon
+
+	Difficulty *Difficulty `json:"difficulty,omitempty"`
+	EarlyGame  bool        `json:"early_game,omitempty"` // makeable without advanced tech
+}
+
+// CategoryColors is the fallback colour per category, close to the in-game
+// icon backgrounds, for items the dataset has no colour for.
+var CategoryColors = map[string]string{
+	"raw":        "#8a7f72",
+	"product":    "#f3a923",
+	"cooked":     "#e8853b",
+	"curiosity":  "#4d2957",
+	"technology": "#1a2733",
+	"currency":   "#1e354e",
+	"trade":      "#2f7d6d",
+	"building":   "#6b7f99",
+	"other":      "#5f6b73",
+}
+
+// imgCategories maps nmsassistant icon folders to categories, for datasets
+// scraped before the *_category columns existed.
+var imgCategories = map[string]string{
+	"rawmaterials":          "raw",
+	"products":              "product",
+	"curiosities":           "curiosity",
+	"cooking":               "cooked",
+	"technology":            "technology",
+	"constructedtechnology": "technology",
+	"tradeitems":            "trade",
+	"buildings":             "building",
+	"others":                "other",
+}
+
+var itemColorRe = regexp.MustCompile(`(?i)^#([0-9a-f]{6}|[0-9a-f]{3})$`)
+
+func categoryFromImg(img string) string {
+	u, err := url.Parse(img)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Path, "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if c, ok := imgCategories[strings.ToLower(parts[i])]; ok {
+			return c
+		}
+	}
+	return ""
+}
+
+// newItemInfo builds an item's info from the CSV cells; only plain hex
+// colours are kept so the value is safe to drop into a style attribute,
+// and only http(s) URLs for the same reason with src and href.
+func newItemInfo(category, bg, img, href string) ItemInfo {
+	info := ItemInfo{Category: strings.ToLower(strings.TrimSpace(category)), Img: webURL(img), Href: webURL(href)}
+	if info.Category == "" {
+		info.Category = categoryFromImg(img)
+	}
+	if bg = strings.TrimSpace(bg); itemColorRe.MatchString(bg) {
+		info.Color = strings.ToLower(bg)
+	}
+	return info
+}
+
+// webURL returns s if it is an absolute http(s) URL, else "".
+func webURL(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return s
+}
+
+// merge fills fields of a that are still empty from b.
+func (a ItemInfo) merge(b ItemInfo) ItemInfo {
+	if a.Category == "" {
+		a.Category = b.Category
+	}
+	if a.Color == "" {
+		a.Color = b.Color
+	}
+	if a.Img == "" {
+		a.Img = b.Img
+	}
+	if a.Href == "" {
+		a.Href = b.Href
+	}
+	return a
+}
+
+// FillOutputCategory gives recipe outputs without a known category the
+// given one, e.g. everything the cooking dataset produces is cooked food.
+func (db *DB) FillOutputCategory(category string) {
+	for _, r := range db.Recipes {
+		db.Items[r.Output] = db.Items[r.Output].merge(ItemInfo{Category: category})
+	}
+}
+
+// BorrowItems copies info for items db knows nothing about from another
+// dataset, so cooking ingredients pick up refiner colours for shared items.
+func (db *DB) BorrowItems(from *DB) {
+	for name, info := range from.Items {
+		if _, ok := db.ingIndex[name]; ok {
+			db.Items[name] = db.Items[name].merge(info)
+		}
+	}
+}
+
+// Has reports whether the dataset mentions the item, as input or output.
+func (db *DB) Has(name string) bool {
+	if _, ok := db.Items[name]; ok {
+		return true
+	}
+	_, ok := db.ingIndex[name]
+	return ok
+}
+
+// Info returns an item's category, colour, icon and page, defaulting the
+// colour from its category.
+func (db *DB) Info(name string) ItemInfo {
+	info := db.Items[name]
+	if info.Color == "" {
+		info.Color = CategoryColors[info.Category]
+	}
+	return info
+}
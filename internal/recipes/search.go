@@ -0,0 +1,89 @@
+package recipes
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Output search ----------
+
+// How an output name matched a search, best first.
+const (
+	MatchExact = iota
+	MatchPrefix
+	MatchWordPrefix
+	MatchSubstring
+	MatchFuzzy
+)
+
+var matchNames = [...]string{"exact", "prefix", "word", "substring", "fuzzy"}
+
+// OutputHit is one output name found by SearchOutputs.
+type OutputHit struct {
+	Output string `json:"output"`
+	Match  string `json:"match"` // exact, prefix, word, substring or fuzzy
+
+	kind, pos int // match kind, then position or edit distance
+}
+
+// SearchOutputs ranks output names against a partial name: exact matches,
+// then names starting with q, names with a word starting with q, names
+// containing q, and finally names within a few typos of q (whole name or
+// one of its words). Ties go to the shorter name. At most limit hits are
+// returned.
+func SearchOutputs(outputs []string, q string, limit int) []OutputHit {
+	q = norm.Key(q)
+	if q == "" || limit <= 0 {
+		return nil
+	}
+	// Allowed typos grow with the query: 1 up to 3 letters, 2 up to 6,
+	// then 3 (a swapped pair of letters counts 2).
+	maxDist := min(3, 1+(utf8.RuneCountInString(q)-1)/3)
+	var hits []OutputHit
+	for _, out := range outputs {
+		k := norm.Key(out)
+		h := OutputHit{Output: out, kind: -1}
+		switch i := strings.Index(k, q); {
+		case k == q:
+			h.kind = MatchExact
+		case i == 0:
+			h.kind = MatchPrefix
+		case i > 0 && strings.Contains(" "+k, " "+q):
+			h.kind, h.pos = MatchWordPrefix, strings.Index(" "+k, " "+q)
+		case i > 0:
+			h.kind, h.pos = MatchSubstring, i
+		default:
+			d := norm.Distance(q, k)
+			for _, w := range strings.Fields(k) {
+				d = min(d, norm.Distance(q, w))
+			}
+			if d <= maxDist {
+				h.kind, h.pos = MatchFuzzy, d
+			}
+		}
+		if h.kind >= 0 {
+			h.Match = matchNames[h.kind]
+			hits = append(hits, h)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.pos != b.pos {
+			return a.pos < b.pos
+		}
+		if la, lb := len(a.Output), len(b.Output); la != lb {
+			return la < lb
+		}
+		return a.Output < b.Output
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
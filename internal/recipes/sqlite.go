@@ -0,0 +1,404 @@
+package recipes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- SQLite storage ----------
+
+// SQLiteStore is one dataset ("food", "refiner") in a SQLite database that
+// may hold several. Queries run in SQL, so the recipes are not held in
+// memory, and other processes can write the same file.
+type SQLiteStore struct {
+	db      *sql.DB
+	dataset string
+
+	// Ingredient names for MapIngredients' fuzzy matching, and the row of
+	// each Recipe.ID, read at open and after Replace.
+	mu          sync.RWMutex
+	ingredients []string
+	exact       map[string]string
+	rows        map[string]int64
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS recipes (
+	id         INTEGER PRIMARY KEY,
+	dataset    TEXT NOT NULL,
+	seq        INTEGER NOT NULL,
+	output     TEXT NOT NULL,
+	output_key TEXT NOT NULL,
+	qty        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS recipes_output ON recipes (dataset, output_key);
+CREATE TABLE IF NOT EXISTS recipe_inputs (
+	recipe_id  INTEGER NOT NULL REFERENCES recipes (id) ON DELETE CASCADE,
+	pos        INTEGER NOT NULL,
+	ingredient TEXT NOT NULL,
+	qty        INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS recipe_inputs_ingredient ON recipe_inputs (ingredient, recipe_id);
+CREATE INDEX IF NOT EXISTS recipe_inputs_recipe ON recipe_inputs (recipe_id);
+CREATE TABLE IF NOT EXISTS items (
+	dataset  TEXT NOT NULL,
+	name     TEXT NOT NULL,
+	category TEXT NOT NULL,
+	color    TEXT NOT NULL,
+	img      TEXT NOT NULL DEFAULT '',
+	href     TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (dataset, name)
+);
+`
+
+// sqliteColumns are columns added to tables after their first release,
+// added to databases made before them.
+var sqliteColumns = []struct{ table, column, def string }{
+	{"items", "img", `TEXT NOT NULL DEFAULT ''`},
+	{"items", "href", `TEXT NOT NULL DEFAULT ''`},
+	{"recipe_inputs", "qty", `INTEGER NOT NULL DEFAULT 1`},
+}
+
+// migrateSQLite adds the sqliteColumns a database lacks.
+func migrateSQLite(db *sql.DB) error {
+	for _, c := range sqliteColumns {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenSQLite opens (creating if needed) the database at path and returns
+// the named dataset in it; Empty reports whether it has been filled yet.
+func OpenSQLite(path, dataset string) (*SQLiteStore, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
+	s := &SQLiteStore{db: db, dataset: dataset}
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) Close() error { return s.db.Close() }
+
+// load reads what the store keeps in memory: the ingredient names and
+// the recipe IDs.
+func (s *SQLiteStore) load() error {
+	if err := s.loadIngredients(); err != nil {
+		return err
+	}
+	ids, recs := s.query(`1 = 1`)
+	rows := make(map[string]int64, len(ids))
+	for i, r := range recs {
+		if _, dup := rows[r.ID()]; !dup {
+			rows[r.ID()] = ids[i]
+		}
+	}
+	s.mu.Lock()
+	s.rows = rows
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *SQLiteStore) loadIngredients() error {
+	rows, err := s.db.Query(`SELECT DISTINCT i.ingredient FROM recipe_inputs i
+		JOIN recipes r ON r.id = i.recipe_id WHERE r.dataset = ? ORDER BY i.ingredient`, s.dataset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ings []string
+	exact := map[string]string{}
+	for rows.Next() {
+		var ing string
+		if err := rows.Scan(&ing); err != nil {
+			return err
+		}
+		ings = append(ings, ing)
+		exact[norm.Key(ing)] = ing
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ingredients, s.exact = ings, exact
+	s.mu.Unlock()
+	return nil
+}
+
+// Empty reports whether the dataset has no recipes.
+func (s *SQLiteStore) Empty() bool { return len(s.Ingredients()) == 0 }
+
+// Replace swaps the dataset's recipes and items for db's in one
+// transaction, so readers see either the old or the new dataset. Other open
+// stores of the same dataset keep their ingredient list until reopened.
+func (s *SQLiteStore) Replace(db *DB) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err = tx.Exec(`DELETE FROM recipes WHERE dataset = ?`, s.dataset); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM items WHERE dataset = ?`, s.dataset); err != nil {
+		return err
+	}
+	insRecipe, err := tx.Prepare(`INSERT INTO recipes (dataset, seq, output, output_key, qty) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insRecipe.Close()
+	insInput, err := tx.Prepare(`INSERT INTO recipe_inputs (recipe_id, pos, ingredient, qty) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insInput.Close()
+	for seq, r := range db.Recipes {
+		res, err := insRecipe.Exec(s.dataset, seq, r.Output, norm.Key(r.Output), r.Qty)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for pos, ing := range r.Inputs {
+			if _, err := insInput.Exec(id, pos, ing, r.InQty(pos)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range db.ItemNames() {
+		info := db.Items[name]
+		if _, err = tx.Exec(`INSERT INTO items (dataset, name, category, color, img, href) VALUES (?, ?, ?, ?, ?, ?)`,
+			s.dataset, name, info.Category, info.Color, info.Img, info.Href); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return s.load()
+}
+
+// query returns the recipes the condition selects, in file order, with
+// their ids.
+func (s *SQLiteStore) query(cond string, args ...any) ([]int64, []Recipe) {
+	rows, err := s.db.Query(`SELECT r.id, r.output, r.qty, i.ingredient, i.qty
+		FROM recipes r JOIN recipe_inputs i ON i.recipe_id = r.id
+		WHERE r.dataset = ? AND `+cond+`
+		ORDER BY r.seq, i.pos`, append([]any{s.dataset}, args...)...)
+	if err != nil {
+		return nil, nil
+	}
+	defer rows.Close()
+	var ids []int64
+	var out []Recipe
+	for rows.Next() {
+		var id int64
+		var r Recipe
+		var ing string
+		var q int
+		if err := rows.Scan(&id, &r.Output, &r.Qty, &ing, &q); err != nil {
+			return nil, nil
+		}
+		if len(ids) == 0 || ids[len(ids)-1] != id {
+			ids = append(ids, id)
+			out = append(out, r)
+		}
+		last := &out[len(out)-1]
+		last.Inputs = append(last.Inputs, ing)
+		last.InputQty = append(last.InputQty, q)
+	}
+	return ids, out
+}
+
+func (s *SQLiteStore) recipes(cond string, args ...any) []Recipe {
+	_, out := s.query(cond, args...)
+	return out
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func (s *SQLiteStore) MapIngredients(inputs []string) ([]string, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return mapIngredients(inputs, s.ingredients, s.exact)
+}
+
+func (s *SQLiteStore) MatchIngredients(m norm.Matcher, inputs []string) ([]Match, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return matchIngredients(m, inputs, s.ingredients, s.exact)
+}
+
+func (s *SQLiteStore) Suggest(have []string) []Recipe {
+	if len(have) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(have)+1)
+	for _, h := range have {
+		args = append(args, h)
+	}
+	args = append(args, len(have))
+	return s.recipes(`r.id IN (SELECT recipe_id FROM recipe_inputs WHERE ingredient IN (`+placeholders(len(have))+`)
+		GROUP BY recipe_id HAVING COUNT(DISTINCT ingredient) = ?)`, args...)
+}
+
+func (s *SQLiteStore) NearMisses(have []string, limit int) []NearMiss {
+	if len(have) == 0 || limit <= 0 {
+		return nil
+	}
+	args := []any{s.dataset}
+	for _, h := range have {
+		args = append(args, h)
+	}
+	args = append(args, limit)
+	rows, err := s.db.Query(`SELECT r.id FROM recipes r JOIN recipe_inputs i ON i.recipe_id = r.id
+		WHERE r.dataset = ? AND i.ingredient IN (`+placeholders(len(have))+`)
+		GROUP BY r.id
+		ORDER BY COUNT(DISTINCT i.ingredient) DESC,
+			(SELECT COUNT(*) FROM recipe_inputs n WHERE n.recipe_id = r.id), r.seq
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil
+	}
+	var ranked []any
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ranked = append(ranked, id)
+		}
+	}
+	rows.Close()
+	if len(ranked) == 0 {
+		return nil
+	}
+	ids, recs := s.query(`r.id IN (`+placeholders(len(ranked))+`)`, ranked...)
+	byID := make(map[int64]Recipe, len(ids))
+	for i, id := range ids {
+		byID[id] = recs[i]
+	}
+	out := make([]NearMiss, 0, len(ranked))
+	for _, id := range ranked {
+		if r, ok := byID[id.(int64)]; ok {
+			out = append(out, newNearMiss(r, have))
+		}
+	}
+	return out
+}
+
+func (s *SQLiteStore) All() []Recipe { return s.recipes(`1 = 1`) }
+
+func (s *SQLiteStore) ByOutput(name string) []Recipe {
+	return s.recipes(`r.output_key = ?`, norm.Key(name))
+}
+
+func (s *SQLiteStore) ByID(id string) (Recipe, bool) {
+	s.mu.RLock()
+	row, ok := s.rows[id]
+	s.mu.RUnlock()
+	if !ok {
+		return Recipe{}, false
+	}
+	recs := s.recipes(`r.id = ?`, row)
+	if len(recs) == 0 {
+		return Recipe{}, false
+	}
+	return recs[0], true
+}
+
+func (s *SQLiteStore) Outputs() []string {
+	rows, err := s.db.Query(`SELECT DISTINCT output FROM recipes WHERE dataset = ? ORDER BY output`, s.dataset)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (s *SQLiteStore) Ingredients() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ingredients
+}
+
+func (s *SQLiteStore) ItemNames() []string {
+	rows, err := s.db.Query(`SELECT name FROM items WHERE dataset = ? ORDER BY name`, s.dataset)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (s *SQLiteStore) Has(name string) bool {
+	var one int
+	err := s.db.QueryRow(`SELECT 1 FROM items WHERE dataset = ? AND name = ?`, s.dataset, name).Scan(&one)
+	return err == nil
+}
+
+func (s *SQLiteStore) Info(name string) ItemInfo {
+	var info ItemInfo
+	err := s.db.QueryRow(`SELECT category, color, img, href FROM items WHERE dataset = ? AND name = ?`, s.dataset, name).
+		Scan(&info.Category, &info.Color, &info.Img, &info.Href)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ItemInfo{}
+	}
+	if info.Color == "" {
+		info.Color = CategoryColors[info.Category]
+	}
+	return info
+}
@@ -0,0 +1,92 @@
+package recipes
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Storage ----------
+
+// Store is a recipe dataset as the server queries it. *DB holds a CSV in
+// memory; *SQLiteStore keeps the recipes in a SQLite database.
+type Store interface {
+	// MapIngredients maps user input onto known ingredient names and
+	// returns the input it could not place.
+	MapIngredients(inputs []string) (mapped, unknown []string)
+	// MatchIngredients maps user input as MapIngredients does, with m
+	// in place of norm.DefaultMatcher, saying how sure each match is.
+	MatchIngredients(m norm.Matcher, inputs []string) (matches []Match, unknown []string)
+	// Suggest returns the recipes that take every one of have.
+	Suggest(have []string) []Recipe
+	NearMisses(have []string, limit int) []NearMiss
+	All() []Recipe
+	// ByOutput returns the recipes making the named item.
+	ByOutput(name string) []Recipe
+	// ByID returns the recipe with the given Recipe.ID; of duplicate rows,
+	// the first.
+	ByID(id string) (Recipe, bool)
+	// Outputs lists every item some recipe makes, sorted.
+	Outputs() []string
+	Ingredients() []string
+	// ItemNames lists every item the dataset mentions, sorted.
+	ItemNames() []string
+	Has(name string) bool
+	Info(name string) ItemInfo
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+func (db *DB) All() []Recipe { return slices.Clone(db.Recipes) }
+
+func (db *DB) ByOutput(name string) []Recipe {
+	k := norm.Key(name)
+	var out []Recipe
+	for _, r := range db.Recipes {
+		if norm.Key(r.Output) == k {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (db *DB) ByID(id string) (Recipe, bool) {
+	i, ok := db.byID[id]
+	if !ok {
+		return Recipe{}, false
+	}
+	return db.Recipes[i], true
+}
+
+func (db *DB) Outputs() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, r := range db.Recipes {
+		if !seen[r.Output] {
+			seen[r.Output] = true
+			out = append(out, r.Output)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (db *DB) Ingredients() []string { return db.AllIngredients }
+
+func (db *DB) ItemNames() []string {
+	names := make([]string, 0, len(db.Items))
+	for name := range db.Items {
+		names = append(names, name)
+	}
+	for _, name := range db.AllIngredients {
+		if _, ok := db.Items[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,162 @@
+package recipes
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Data model: Technologies ----------
+
+// Technology is an upgrade module or base technology as scraped with
+// `nms scrape --profile technology`.
+type Technology struct {
+	Name      string     `json:"name"`
+	Category  string     `json:"category"` // Starship, Multi-Tool, Exosuit...
+	Class     string     `json:"class"`    // S, A, B, C, X or empty
+	Stats     []string   `json:"stats"`
+	Resources []TechCost `json:"resources"`
+	Href      string     `json:"href,omitempty"`
+	Img       string     `json:"img,omitempty"`
+}
+
+// TechCost is one resource in a technology's crafting cost.
+type TechCost struct {
+	Name string `json:"name"`
+	Qty  int    `json:"qty"`
+}
+
+type TechDB struct {
+	Techs  []Technology
+	byName map[string][]int // norm.Key(name) -> indices (one per class)
+}
+
+var techQtyRe = regexp.MustCompile(`(?i)\s*x\s*(\d+)\s*$`)
+
+// parseTechCosts reads "Name x50; Name x10" as written by the scraper.
+func parseTechCosts(s string) []TechCost {
+	var out []TechCost
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		c := TechCost{Name: part, Qty: 1}
+		if m := techQtyRe.FindStringSubmatchIndex(part); m != nil {
+			if q, err := strconv.Atoi(part[m[2]:m[3]]); err == nil && q > 0 {
+				c.Name, c.Qty = strings.TrimSpace(part[:m[0]]), q
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// LoadTechCSV loads the technologies dataset. A missing file is not an error:
+// the dataset is optional and the server starts with no technologies.
+func LoadTechCSV(path string) (*TechDB, error) {
+	db := &TechDB{byName: map[string][]int{}}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open csv: %w", err)
+	}
+	defer f.Close()
+	return ReadTechCSV(f)
+}
+
+// ReadTechCSV loads the technologies dataset from r.
+func ReadTechCSV(r io.Reader) (*TechDB, error) {
+	db := &TechDB{byName: map[string][]int{}}
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv: %w", err)
+	}
+	if len(records) == 0 {
+		return db, nil
+	}
+	headers := map[string]int{}
+	for i, h := range records[0] {
+		headers[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+	for _, r := range []string{"name", "resources"} {
+		if _, ok := headers[r]; !ok {
+			return nil, fmt.Errorf("missing required column: %s", r)
+		}
+	}
+
+	for _, row := range records[1:] {
+		get := func(name string) string {
+			if i, ok := headers[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		t := Technology{
+			Name:      get("name"),
+			Category:  get("category"),
+			Class:     get("class"),
+			Resources: parseTechCosts(get("resources")),
+			Href:      get("href"),
+			Img:       get("img"),
+		}
+		if t.Name == "" {
+			continue
+		}
+		for _, s := range strings.Split(get("stats"), ";") {
+			if s = strings.TrimSpace(s); s != "" {
+				t.Stats = append(t.Stats, s)
+			}
+		}
+		k := norm.Key(t.Name)
+		db.byName[k] = append(db.byName[k], len(db.Techs))
+		db.Techs = append(db.Techs, t)
+	}
+	return db, nil
+}
+
+// Lookup finds a technology by name and, when class is set, by class.
+func (db *TechDB) Lookup(name, class string) (Technology, bool) {
+	for _, i := range db.byName[norm.Key(name)] {
+		if class == "" || strings.EqualFold(db.Techs[i].Class, class) {
+			return db.Techs[i], true
+		}
+	}
+	return Technology{}, false
+}
+
+// Classes returns every class of the named technology.
+func (db *TechDB) Classes(name string) []Technology {
+	var out []Technology
+	for _, i := range db.byName[norm.Key(name)] {
+		out = append(out, db.Techs[i])
+	}
+	return out
+}
+
+// Categories lists the distinct categories, sorted.
+func (db *TechDB) Categories() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range db.Techs {
+		if t.Category != "" && !seen[t.Category] {
+			seen[t.Category] = true
+			out = append(out, t.Category)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,121 @@
+package recipes
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Item values ----------
+
+// Values are the base values of items (what one sells for), by normalized
+// name.
+type Values map[string]float64
+
+// valueColumns are the headers taken as an item's value, in the order
+// tried: a values file has "value", a scraped item-details table one of
+// the others.
+var valueColumns = []string{"value", "base_value", "base value", "price", "units"}
+
+// LoadValuesCSV loads item values. A missing file is not an error: values
+// are optional and the server starts with none.
+func LoadValuesCSV(path string) (Values, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Values{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open csv: %w", err)
+	}
+	defer f.Close()
+	return ReadValuesCSV(f)
+}
+
+// ReadValuesCSV loads item values from a CSV with a name column and a
+// value column (see valueColumns). Rows without a number are skipped;
+// "1,250" and "1250 units" read as 1250.
+func ReadValuesCSV(r io.Reader) (Values, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv: %w", err)
+	}
+	v := Values{}
+	if len(records) == 0 {
+		return v, nil
+	}
+	headers := map[string]int{}
+	for i, h := range records[0] {
+		headers[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+	name, ok := headers["name"]
+	if !ok {
+		return nil, errors.New("missing required column: name")
+	}
+	col := -1
+	for _, c := range valueColumns {
+		if i, ok := headers[c]; ok {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return nil, errors.New("missing required column: value")
+	}
+	for _, row := range records[1:] {
+		if name >= len(row) || col >= len(row) {
+			continue
+		}
+		k := norm.Key(row[name])
+		s := strings.NewReplacer(",", "", "units", "").Replace(strings.ToLower(row[col]))
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if k == "" || err != nil || n < 0 {
+			continue
+		}
+		v[k] = n
+	}
+	return v, nil
+}
+
+// Of returns an item's value.
+func (v Values) Of(name string) (float64, bool) {
+	n, ok := v[norm.Key(name)]
+	return n, ok
+}
+
+// RecipeValue is what a recipe's output is worth and, when every input
+// has a value, what crafting it adds to them.
+type RecipeValue struct {
+	Output float64  `json:"output"`           // of everything one craft makes
+	Inputs *float64 `json:"inputs,omitempty"` // of what one craft takes
+	Added  *float64 `json:"added,omitempty"`  // Output - Inputs
+}
+
+// Recipe values a recipe, counting each input as many times as one craft
+// takes it; false when its output has no value.
+func (v Values) Recipe(r Recipe) (RecipeValue, bool) {
+	out, ok := v.Of(r.Output)
+	if !ok {
+		return RecipeValue{}, false
+	}
+	rv := RecipeValue{Output: out * float64(r.Qty)}
+	var in float64
+	for i, name := range r.Inputs {
+		n, ok := v.Of(name)
+		if !ok {
+			return rv, true
+		}
+		in += n * float64(r.InQty(i))
+	}
+	added := rv.Output - in
+	rv.Inputs, rv.Added = &in, &added
+	return rv, true
+}
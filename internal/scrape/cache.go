@@ -0,0 +1,97 @@
+package scrape
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ---------- Conditional fetch cache ----------
+
+// ErrNotModified is returned by Fetch when the server answered 304 to a
+// conditional request built from a cache entry.
+var ErrNotModified = errors.New("not modified since last fetch")
+
+type CacheEntry struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+func (e CacheEntry) Empty() bool {
+	return e.ETag == "" && e.LastModified == ""
+}
+
+// applyTo adds If-None-Match / If-Modified-Since validators to req.
+func (e CacheEntry) applyTo(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// HTTPCache stores response validators on disk, one small JSON file per
+// cache key. Bodies are not kept: a 304 means the previous output is current.
+type HTTPCache struct {
+	Dir string
+}
+
+func DefaultCacheDir() string {
+	if d, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(d, "nmscripts", "scrape")
+	}
+	return ".nms-cache"
+}
+
+// CacheKey ties validators to everything that shapes the output file, so a
+// new selector, profile or destination never reuses another run's validators.
+func CacheKey(rawURL, outPath, selector, profile string) string {
+	sum := sha256.Sum256([]byte(rawURL + "\x00" + outPath + "\x00" + selector + "\x00" + profile))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *HTTPCache) file(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *HTTPCache) Get(key string) (CacheEntry, bool) {
+	if c == nil || c.Dir == "" {
+		return CacheEntry{}, false
+	}
+	b, err := os.ReadFile(c.file(key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	var e CacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || e.Empty() {
+		return CacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *HTTPCache) Put(e CacheEntry) error {
+	if c == nil || c.Dir == "" || e.Empty() {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.file(e.Key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file(e.Key))
+}
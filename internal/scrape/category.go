@@ -0,0 +1,101 @@
+package scrape
+
+import (
+	. "fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ---------- Item categories ----------
+
+// bgCategories maps the cell backgrounds nmsassistant paints behind item
+// icons to the item's category. Only colours used by a single kind of item
+// are listed; shared ones (#1a2733, #cccccc, #4d2957) are left to the icon
+// folder in categoryFromImg.
+var bgCategories = map[string]string{
+	// crafted products
+	"#f3a923": "product", // alloys and compounds: Aronium, Ion Battery
+	"#c01746": "product", // consumables: Warp Cell, Life Support Gel
+	// currencies
+	"#1e354e": "currency", // Nanite Cluster
+	// raw elements, one colour per substance
+	"#005c83": "raw", "#00a64d": "raw", "#01387d": "raw", "#1e4fd0": "raw",
+	"#1e8a42": "raw", "#208dab": "raw", "#239626": "raw", "#260000": "raw",
+	"#265e3a": "raw", "#355a7d": "raw", "#36611c": "raw", "#4c3780": "raw",
+	"#4e404f": "raw", "#507575": "raw", "#512741": "raw", "#5b6f35": "raw",
+	"#79502e": "raw", "#7b0000": "raw", "#8a7f72": "raw", "#aa6e06": "raw",
+	"#b94318": "raw", "#bb3830": "raw", "#c5871d": "raw", "#db2400": "raw",
+	"#de921f": "raw", "#dedcd1": "raw", "#e57002": "raw", "#e59001": "raw",
+	"#f26d15": "raw", "#ffad00": "raw",
+}
+
+// imgCategories maps nmsassistant icon folders to categories.
+var imgCategories = map[string]string{
+	"rawmaterials":          "raw",
+	"products":              "product",
+	"curiosities":           "curiosity",
+	"cooking":               "cooked",
+	"technology":            "technology",
+	"constructedtechnology": "technology",
+	"tradeitems":            "trade",
+	"buildings":             "building",
+	"others":                "other",
+}
+
+var (
+	hexColorRe = regexp.MustCompile(`(?i)#([0-9a-f]{6}|[0-9a-f]{3})\b`)
+	rgbColorRe = regexp.MustCompile(`(?i)rgba?\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)`)
+)
+
+// normColor reduces a CSS background value to a lower-case #rrggbb, or ""
+// when it holds no colour.
+func normColor(bg string) string {
+	if m := hexColorRe.FindStringSubmatch(bg); m != nil {
+		h := strings.ToLower(m[1])
+		if len(h) == 3 {
+			h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+		}
+		return "#" + h
+	}
+	if m := rgbColorRe.FindStringSubmatch(bg); m != nil {
+		var c [3]int
+		for i := range c {
+			c[i], _ = strconv.Atoi(m[i+1])
+			c[i] = min(c[i], 255)
+		}
+		return Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+	}
+	return ""
+}
+
+// categoryFromImg reads the icon folder, e.g. .../images/rawMaterials/56.png.
+func categoryFromImg(img string) string {
+	u, err := url.Parse(img)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Path, "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if c, ok := imgCategories[strings.ToLower(parts[i])]; ok {
+			return c
+		}
+	}
+	return ""
+}
+
+// cellCategory names a cell's category from its background, then from its
+// icon folder. extra (from a --map file) takes precedence over the built-in
+// colours.
+func cellCategory(c Cell, extra map[string]string) string {
+	if bg := normColor(c.Bg); bg != "" {
+		if cat, ok := extra[bg]; ok {
+			return cat
+		}
+		if cat, ok := bgCategories[bg]; ok {
+			return cat
+		}
+	}
+	return categoryFromImg(c.Img)
+}
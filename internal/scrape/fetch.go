@@ -0,0 +1,149 @@
+// Package scrape fetches table pages politely (retries, robots.txt, conditional
+// requests), parses them into datasets and writes CSV, XLSX and manifests.
+package scrape
+
+import (
+	"context"
+	. "fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ---------- HTTP with retry ----------
+// HTTPClient builds the scraper's client. proxy, when non-nil, replaces the
+// HTTP(S)_PROXY environment settings.
+func HTTPClient(timeout time.Duration, proxy *url.URL) *http.Client {
+	proxyFn := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFn = http.ProxyURL(proxy)
+	}
+	transport := &http.Transport{
+		Proxy: proxyFn,
+		// Reasonable defaults; keepalives enabled
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 60 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+type FetchOptions struct {
+	// Cached holds validators from a previous run; when set, the request is
+	// conditional and a 304 answer yields ErrNotModified.
+	Cached *CacheEntry
+	Retry  RetryPolicy
+	Proxy  *url.URL    // explicit proxy; nil falls back to the environment
+	Header http.Header // extra request headers, applied over the defaults
+	Cookie string      // raw Cookie header value, e.g. "session=abc; theme=dark"
+	Pacer  *Pacer      // politeness delay between requests to one host
+}
+
+// Fetch returns the page body, the final URL after redirects, and the
+// validators the server sent so the caller can cache them once the output
+// has been written successfully.
+func Fetch(ctx context.Context, rawURL string, opts FetchOptions) (html string, finalBase *url.URL, validators CacheEntry, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, CacheEntry{}, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	for k, vs := range opts.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if opts.Cookie != "" {
+		req.Header.Set("Cookie", opts.Cookie)
+	}
+	if opts.Cached != nil {
+		opts.Cached.applyTo(req)
+	}
+
+	client := HTTPClient(opts.Retry.Timeout, opts.Proxy)
+	log := slog.With("url", rawURL)
+	start := time.Now()
+
+	var resp *http.Response
+	// Bounded retry on network errors and configured status codes.
+	for attempt := 0; attempt <= opts.Retry.Retries; attempt++ {
+		if d := opts.Retry.delay(attempt); d > 0 {
+			log.Debug("retry backoff", "attempt", attempt+1, "delay", d)
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return "", nil, CacheEntry{}, ctx.Err()
+			}
+		}
+		last := attempt == opts.Retry.Retries
+		if err := opts.Pacer.Wait(ctx, req.URL.Host); err != nil {
+			return "", nil, CacheEntry{}, err
+		}
+		log.Debug("request", "attempt", attempt+1, "conditional", opts.Cached != nil)
+		t0 := time.Now()
+		resp, err = client.Do(req)
+		if err != nil {
+			// retry on network errors
+			if !last && ctx.Err() == nil {
+				log.Warn("request failed, retrying", "attempt", attempt+1, "err", err, "elapsed", time.Since(t0))
+				continue
+			}
+			return "", nil, CacheEntry{}, err
+		}
+		if opts.Retry.shouldRetry(resp.StatusCode) {
+			_ = resp.Body.Close()
+			if !last {
+				log.Warn("retryable status, retrying", "attempt", attempt+1, "status", resp.StatusCode, "elapsed", time.Since(t0))
+				continue
+			}
+			return "", nil, CacheEntry{}, Errorf("giving up after %d attempts: %s", attempt+1, resp.Status)
+		}
+		break
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Debug("close body", "err", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusNotModified && opts.Cached != nil {
+		log.Info("not modified", "duration", time.Since(start))
+		return "", nil, *opts.Cached, ErrNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", nil, CacheEntry{}, Errorf("bad status %d: %s", resp.StatusCode, string(b))
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, CacheEntry{}, err
+	}
+
+	u, err := url.Parse(resp.Request.URL.String())
+	if err != nil {
+		return "", nil, CacheEntry{}, err
+	}
+	log.Info("fetched", "status", resp.StatusCode, "bytes", len(b), "duration", time.Since(start), "final_url", u.String())
+	validators = CacheEntry{
+		URL:          rawURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now().UTC(),
+	}
+	return string(b), u, validators, nil
+}
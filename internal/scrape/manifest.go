@@ -0,0 +1,133 @@
+package scrape
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- Run manifest ----------
+
+// Manifest records what a run fetched and wrote so downstream pipelines can
+// verify outputs and spot partial runs: a run that failed before the end
+// leaves no manifest, or one whose output checksum no longer matches.
+type Manifest struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Args        []string         `json:"args"`
+	Outputs     []ManifestFile   `json:"outputs"`
+	Sources     []ManifestSource `json:"sources"`
+	Assets      []ManifestAsset  `json:"assets,omitempty"`
+
+	mu sync.Mutex
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+	Rows   int    `json:"rows"`
+}
+
+// ManifestSource is one scraped page.
+type ManifestSource struct {
+	URL       string    `json:"url"`
+	FinalURL  string    `json:"final_url"`
+	Sheet     string    `json:"sheet"`
+	FetchedAt time.Time `json:"fetched_at"`
+	SHA256    string    `json:"sha256"` // of the page body
+	Bytes     int       `json:"bytes"`
+	Rows      int       `json:"rows"`
+}
+
+// ManifestAsset is one downloaded icon; Error is set when it was not embedded.
+type ManifestAsset struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Bytes     int       `json:"bytes"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RedactArgs hides values that may carry credentials (cookies, headers,
+// proxy passwords) before the command line is written to the manifest.
+func RedactArgs(args []string) []string {
+	out := make([]string, len(args))
+	hideNext := false
+	for i, a := range args {
+		if hideNext {
+			out[i], hideNext = "REDACTED", false
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		switch {
+		case !strings.HasPrefix(a, "-"):
+			out[i] = a
+		case name == "cookie" || name == "header" || name == "proxy":
+			if hasValue {
+				out[i] = a[:strings.Index(a, "=")+1] + "REDACTED"
+			} else {
+				out[i], hideNext = a, true
+			}
+		default:
+			out[i] = a
+		}
+	}
+	return out
+}
+
+func SHA256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manifest) AddAsset(u string, b []byte, err error) {
+	if m == nil {
+		return
+	}
+	a := ManifestAsset{URL: u, FetchedAt: time.Now().UTC(), Bytes: len(b)}
+	if err != nil {
+		a.Error = err.Error()
+	} else {
+		a.SHA256 = SHA256Hex(b)
+	}
+	m.mu.Lock()
+	m.Assets = append(m.Assets, a)
+	m.mu.Unlock()
+}
+
+// AddOutput checksums a written file.
+func (m *Manifest) AddOutput(path string, rows int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(path)
+	m.Outputs = append(m.Outputs, ManifestFile{Path: abs, SHA256: hex.EncodeToString(h.Sum(nil)), Bytes: n, Rows: rows})
+	return nil
+}
+
+// Write saves the manifest atomically, like the cache entries.
+func (m *Manifest) Write(path string) error {
+	m.GeneratedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,196 @@
+package scrape
+
+import (
+	"encoding/json"
+	"errors"
+	. "fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------- Column mapping file ----------
+
+// ColumnMap configures one output column group (name, qty, href, img, bg,
+// category).
+// Every selector is relative to the cell; empty fields keep the built-in
+// extraction, so a mapping only needs to name what changed on the page.
+type ColumnMap struct {
+	Column    string `json:"column" yaml:"column"`         // output prefix, e.g. "input1"
+	Cell      string `json:"cell" yaml:"cell"`             // selector within the row; default the Nth <td>
+	Name      string `json:"name" yaml:"name"`             // element whose text is the item name
+	Qty       string `json:"qty" yaml:"qty"`               // element whose text holds "xN" or a number
+	Href      string `json:"href" yaml:"href"`             // link element
+	HrefAttr  string `json:"href_attr" yaml:"href_attr"`   // default "href"
+	Image     string `json:"image" yaml:"image"`           // image element
+	ImageAttr string `json:"image_attr" yaml:"image_attr"` // default "src"; e.g. "data-src" for lazy images
+	Bg        string `json:"bg" yaml:"bg"`                 // element whose style carries the background
+}
+
+// TableMap is the --map file: where the table and rows are and how each
+// column group is read.
+type TableMap struct {
+	Table   string      `json:"table" yaml:"table"` // used unless --selector is given
+	Row     string      `json:"row" yaml:"row"`     // default "tbody > tr"
+	Columns []ColumnMap `json:"columns" yaml:"columns"`
+	// Categories adds or overrides background colour -> category entries,
+	// e.g. "#1a2733": special.
+	Categories map[string]string `json:"categories" yaml:"categories"`
+}
+
+// LoadTableMap reads a mapping file; .json files are JSON, anything else
+// is parsed as YAML.
+func LoadTableMap(path string) (*TableMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m TableMap
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, &m)
+	} else {
+		err = yaml.Unmarshal(b, &m)
+	}
+	if err != nil {
+		return nil, Errorf("parse %s: %w", path, err)
+	}
+	if len(m.Columns) == 0 {
+		return nil, errors.New("mapping has no columns")
+	}
+	seen := map[string]bool{}
+	for i := range m.Columns {
+		c := &m.Columns[i]
+		c.Column = strings.TrimSpace(c.Column)
+		if c.Column == "" {
+			c.Column = Sprintf("col%d", i+1)
+		}
+		if seen[c.Column] {
+			return nil, Errorf("duplicate column %q", c.Column)
+		}
+		seen[c.Column] = true
+		for _, sel := range []string{c.Cell, c.Name, c.Qty, c.Href, c.Image, c.Bg} {
+			if err := checkSelector(sel); err != nil {
+				return nil, Errorf("column %q: %w", c.Column, err)
+			}
+		}
+	}
+	if m.Row == "" {
+		m.Row = "tbody > tr"
+	}
+	cats := map[string]string{}
+	for bg, cat := range m.Categories {
+		c := normColor(bg)
+		if c == "" {
+			return nil, Errorf("categories: %q is not a colour", bg)
+		}
+		cats[c] = strings.TrimSpace(cat)
+	}
+	m.Categories = cats
+	for _, sel := range []string{m.Table, m.Row} {
+		if err := checkSelector(sel); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+// checkSelector rejects CSS selectors that do not compile; goquery would
+// otherwise treat them as matching nothing and the run would just yield
+// empty columns.
+func checkSelector(sel string) error {
+	if sel == "" {
+		return nil
+	}
+	if _, err := cascadia.ParseGroup(sel); err != nil {
+		return Errorf("invalid selector %q: %w", sel, err)
+	}
+	return nil
+}
+
+func (m *TableMap) header() []string {
+	var h []string
+	for _, c := range m.Columns {
+		h = append(h, c.Column+"_name", c.Column+"_qty", c.Column+"_href", c.Column+"_img", c.Column+"_bg", c.Column+"_category")
+	}
+	return h
+}
+
+// extractMapped starts from the built-in extraction and overrides each field
+// the mapping names a selector for.
+func extractMapped(td *goquery.Selection, base *url.URL, c ColumnMap) Cell {
+	cell := extractCell(td, base)
+	if td == nil || td.Length() == 0 {
+		return cell
+	}
+	if c.Name != "" {
+		cell.Name = first(td.Find(c.Name))
+	}
+	if c.Qty != "" {
+		txt := first(td.Find(c.Qty))
+		cell.Qty = parseQtyFromText(txt)
+		if cell.Qty == nil && txt != "" {
+			n := atoiSafe(txt)
+			cell.Qty = &n
+		}
+	}
+	if c.Href != "" || c.HrefAttr != "" {
+		sel := td.Find(or(c.Href, "a")).First()
+		cell.Href = ""
+		if v, ok := sel.Attr(or(c.HrefAttr, "href")); ok {
+			cell.Href = resolve(base, v)
+		}
+	}
+	if c.Image != "" || c.ImageAttr != "" {
+		sel := td.Find(or(c.Image, "img")).First()
+		cell.Img = ""
+		if v, ok := sel.Attr(or(c.ImageAttr, "src")); ok {
+			cell.Img = resolve(base, v)
+		}
+	}
+	if c.Bg != "" {
+		style, _ := td.Find(c.Bg).First().Attr("style")
+		cell.Bg = parseBG(style)
+	}
+	return cell
+}
+
+func or(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
+
+// Parse reads a table with the mapping instead of a fixed profile.
+func (m *TableMap) Parse(html string, base *url.URL, selector string) (Dataset, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return Dataset{}, err
+	}
+	table := doc.Find(selector).First()
+	if table.Length() == 0 {
+		return Dataset{}, Errorf("table not found with selector %q", selector)
+	}
+	d := Dataset{Header: m.header()}
+	table.Find(m.Row).Each(func(_ int, tr *goquery.Selection) {
+		tds := tr.Find("td")
+		var rec []string
+		for i, c := range m.Columns {
+			var td *goquery.Selection
+			if c.Cell != "" {
+				td = tr.Find(c.Cell).First()
+			} else if i < tds.Length() {
+				td = tds.Eq(i)
+			}
+			cell := extractMapped(td, base, c)
+			rec = append(rec, cell.Name, qtyStr(cell.Qty), cell.Href, cell.Img, cell.Bg, cellCategory(cell, m.Categories))
+		}
+		d.Records = append(d.Records, rec)
+	})
+	return d, nil
+}
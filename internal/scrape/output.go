@@ -0,0 +1,177 @@
+package scrape
+
+import (
+	"encoding/csv"
+	. "fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ---------- Output writers ----------
+// Dataset is a parsed table ready for output: a header and one string
+// record per row. Columns named *_href become hyperlinks and *_img icons in
+// .xlsx output.
+type Dataset struct {
+	Header  []string
+	Records [][]string
+}
+
+var recipeHeader = []string{
+	"input1_name", "input1_qty", "input1_href", "input1_img", "input1_bg", "input1_category",
+	"input2_name", "input2_qty", "input2_href", "input2_img", "input2_bg", "input2_category",
+	"input3_name", "input3_qty", "input3_href", "input3_img", "input3_bg", "input3_category",
+	"output_name", "output_qty", "output_href", "output_img", "output_bg", "output_category",
+}
+
+func recipeDataset(rows []Row) Dataset {
+	d := Dataset{Header: recipeHeader}
+	for _, r := range rows {
+		var rec []string
+		for _, c := range []Cell{r.Input1, r.Input2, r.Input3, r.Output} {
+			rec = append(rec, c.Name, qtyStr(c.Qty), c.Href, c.Img, c.Bg, cellCategory(c, nil))
+		}
+		d.Records = append(d.Records, rec)
+	}
+	return d
+}
+
+// Dedupe canonicalizes name columns (name, *_name) by trimming, collapsing
+// whitespace and stripping a trailing "xN", then drops records that are
+// exact duplicates of an earlier one. It returns the number dropped.
+func (d *Dataset) Dedupe() int {
+	var nameCols []int
+	for i, h := range d.Header {
+		if h == "name" || strings.HasSuffix(h, "_name") {
+			nameCols = append(nameCols, i)
+		}
+	}
+	seen := map[string]bool{}
+	out := d.Records[:0]
+	for _, rec := range d.Records {
+		for _, i := range nameCols {
+			if i < len(rec) {
+				rec[i] = textCondense(amountRe.ReplaceAllString(textCondense(rec[i]), ""))
+			}
+		}
+		k := strings.Join(rec, "\x00")
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, rec)
+	}
+	dropped := len(d.Records) - len(out)
+	d.Records = out
+	return dropped
+}
+
+// CSVOptions tunes delimited output for spreadsheet imports that expect
+// something other than plain comma-separated UTF-8.
+type CSVOptions struct {
+	Comma rune // field delimiter; 0 means ','
+	BOM   bool // prefix a UTF-8 byte order mark
+}
+
+// ParseDelimiter accepts a delimiter name or the character itself.
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "", ",", "comma":
+		return ',', nil
+	case "\\t", "\t", "tab":
+		return '\t', nil
+	case ";", "semicolon":
+		return ';', nil
+	case "|", "pipe":
+		return '|', nil
+	}
+	return 0, Errorf("unsupported --delimiter %q (want comma, tab, semicolon or pipe)", s)
+}
+
+func WriteCSV(path string, d Dataset, opt CSVOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			slog.Error("close output", "path", path, "err", err)
+		}
+	}(f)
+
+	if opt.BOM {
+		if _, err := f.WriteString("\ufeff"); err != nil {
+			return err
+		}
+	}
+	w := csv.NewWriter(f)
+	if opt.Comma != 0 {
+		w.Comma = opt.Comma
+	}
+	defer w.Flush()
+
+	if err := w.Write(d.Header); err != nil {
+		return err
+	}
+	for _, rec := range d.Records {
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	return w.Error()
+}
+
+func qtyStr(q *int) string {
+	if q == nil {
+		return ""
+	}
+	return Sprintf("%d", *q)
+}
+
+// previewCellWidth caps each column in --preview so long URLs do not push
+// the table off screen.
+const previewCellWidth = 40
+
+// PrintPreview writes the first n records of d as an aligned text table,
+// leaving out columns that are empty in every shown row.
+func PrintPreview(w io.Writer, name string, d Dataset, n int) error {
+	recs := d.Records[:min(n, len(d.Records))]
+	var cols []int
+	for c := range d.Header {
+		for _, r := range recs {
+			if c < len(r) && r[c] != "" {
+				cols = append(cols, c)
+				break
+			}
+		}
+	}
+	clip := func(s string) string {
+		if r := []rune(s); len(r) > previewCellWidth {
+			return string(r[:previewCellWidth-1]) + "…"
+		}
+		return s
+	}
+
+	Fprintf(w, "== %s: %d of %d rows ==\n", name, len(recs), len(d.Records))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(get func(c int) string) {
+		cells := make([]string, len(cols))
+		for i, c := range cols {
+			cells[i] = clip(get(c))
+		}
+		Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	line(func(c int) string { return d.Header[c] })
+	for _, r := range recs {
+		line(func(c int) string {
+			if c < len(r) {
+				return r[c]
+			}
+			return ""
+		})
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,205 @@
+package scrape
+
+import (
+	. "fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Parsing ----------
+type Cell struct {
+	Name string
+	Qty  *int
+	Href string
+	Img  string
+	Bg   string
+}
+
+type Row struct {
+	Input1 Cell
+	Input2 Cell
+	Input3 Cell
+	Output Cell
+}
+
+var (
+	amountRe = regexp.MustCompile(`(?i)\bx\s*(\d+)\b`)
+	bgRe     = regexp.MustCompile(`(?i)background:\s*([^;]+)`)
+	spaceRe  = regexp.MustCompile(`\s+`)
+)
+
+func parseQtyFromText(s string) *int {
+	if s == "" {
+		return nil
+	}
+	m := amountRe.FindStringSubmatch(s)
+	if len(m) == 2 {
+		val := atoiSafe(m[1])
+		return &val
+	}
+	return nil
+}
+
+func atoiSafe(s string) int {
+	n := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			continue
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
+
+func parseBG(style string) string {
+	if style == "" {
+		return ""
+	}
+	m := bgRe.FindStringSubmatch(style)
+	if len(m) == 2 {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+func textCondense(s string) string {
+	return strings.TrimSpace(spaceRe.ReplaceAllString(s, " "))
+}
+
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	ru, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(ru).String()
+}
+
+func first(sel *goquery.Selection) string {
+	if sel.Length() == 0 {
+		return ""
+	}
+	return textCondense(sel.First().Text())
+}
+
+func extractCell(td *goquery.Selection, base *url.URL) Cell {
+	if td == nil || td.Length() == 0 {
+		return Cell{}
+	}
+
+	// 1) Preferred name: hidden <span class="... sort ...">
+	name := first(td.Find("span.sort"))
+	if name == "" {
+		// 2) Visible .cell-text minus any trailing "xN"
+		vis := first(td.Find(".cell-text"))
+		if vis != "" {
+			name = strings.TrimSpace(amountRe.ReplaceAllString(vis, ""))
+			if name == "" {
+				name = vis // fallback if replace made empty
+			}
+		}
+	}
+	if name == "" {
+		// 3) Fallback to <img alt=...>
+		if img := td.Find("img"); img.Length() != 0 {
+			if alt, ok := img.Attr("alt"); ok {
+				name = strings.TrimSpace(alt)
+			}
+		}
+	}
+
+	// qty from <span class="amount"> or any xN fragment
+	var qty *int
+	if amt := first(td.Find("span.amount")); amt != "" {
+		qty = parseQtyFromText(amt)
+	}
+	if qty == nil {
+		// Sometimes amount is only in the visible text
+		vis := first(td.Find(".cell-text"))
+		qty = parseQtyFromText(vis)
+	}
+	if qty == nil && name != "" {
+		// default to 1 when a name exists but no explicit qty
+		one := 1
+		qty = &one
+	}
+
+	// href absolute
+	var href string
+	if a := td.Find("a").First(); a.Length() != 0 {
+		if h, ok := a.Attr("href"); ok {
+			href = resolve(base, h)
+		}
+	}
+
+	// img absolute
+	var imgURL string
+	if img := td.Find("img").First(); img.Length() != 0 {
+		if s, ok := img.Attr("src"); ok {
+			imgURL = resolve(base, s)
+		}
+	}
+
+	// background from .cell-content style
+	var bg string
+	if div := td.Find("div.cell-content").First(); div.Length() != 0 {
+		if style, ok := div.Attr("style"); ok {
+			bg = parseBG(style)
+		}
+	}
+
+	return Cell{
+		Name: name,
+		Qty:  qty,
+		Href: href,
+		Img:  imgURL,
+		Bg:   bg,
+	}
+}
+
+func parseTable(html string, base *url.URL, selector string) ([]Row, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	table := doc.Find(selector).First()
+	if table.Length() == 0 {
+		return nil, Errorf("table not found with selector %q", selector)
+	}
+
+	var out []Row
+	table.Find("tbody > tr").Each(func(_ int, tr *goquery.Selection) {
+		tds := tr.Find("td")
+		getTD := func(i int) *goquery.Selection {
+			if i < 0 || i >= tds.Length() {
+				return nil
+			}
+			return tds.Eq(i)
+		}
+		row := Row{
+			Input1: extractCell(getTD(0), base),
+			Input2: extractCell(getTD(1), base),
+			Input3: extractCell(getTD(2), base),
+			Output: extractCell(getTD(3), base),
+		}
+		out = append(out, row)
+	})
+	return out, nil
+}
+
+// Profiles maps --profile names to the parser producing that table schema.
+var Profiles = map[string]func(html string, base *url.URL, selector string) (Dataset, error){
+	"recipe": func(html string, base *url.URL, selector string) (Dataset, error) {
+		rows, err := parseTable(html, base, selector)
+		return recipeDataset(rows), err
+	},
+	"technology": func(html string, base *url.URL, selector string) (Dataset, error) {
+		techs, err := parseTechTable(html, base, selector)
+		return techDataset(techs), err
+	},
+}
@@ -0,0 +1,85 @@
+package scrape
+
+import (
+	. "fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Retry policy ----------
+
+type RetryPolicy struct {
+	Retries int           // extra attempts after the first
+	Backoff time.Duration // base delay, doubled per attempt
+	Timeout time.Duration // per-request client timeout
+	RetryOn []StatusMatch // status codes that trigger a retry
+}
+
+// StatusMatch is either an exact code (429) or a class (5xx → class 5).
+type StatusMatch struct {
+	Code  int
+	Class int
+}
+
+func (p RetryPolicy) shouldRetry(status int) bool {
+	for _, m := range p.RetryOn {
+		if m.Code == status || (m.Class != 0 && status/100 == m.Class) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the jittered exponential wait before attempt n (n >= 1):
+// half of Backoff*2^(n-1) is fixed, the other half random, so concurrent
+// jobs hitting the same host spread out instead of retrying in lockstep.
+func (p RetryPolicy) delay(n int) time.Duration {
+	if p.Backoff <= 0 || n < 1 {
+		return 0
+	}
+	d := p.Backoff << min(n-1, 16)
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// Budget is a generous upper bound for one fetch including all retries.
+func (p RetryPolicy) Budget() time.Duration {
+	total := time.Duration(p.Retries+1) * p.Timeout
+	for n := 1; n <= p.Retries; n++ {
+		total += p.Backoff << min(n-1, 16) * 3 / 2
+	}
+	return total
+}
+
+// ParseRetryOn parses a list such as "429,500-504,5xx".
+func ParseRetryOn(s string) ([]StatusMatch, error) {
+	var out []StatusMatch
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case part == "":
+			continue
+		case len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5':
+			out = append(out, StatusMatch{Class: int(part[0] - '0')})
+		case strings.Contains(part, "-"):
+			lo, hi, _ := strings.Cut(part, "-")
+			a, err1 := strconv.Atoi(lo)
+			b, err2 := strconv.Atoi(hi)
+			if err1 != nil || err2 != nil || a > b || a < 100 || b > 599 {
+				return nil, Errorf("invalid status range %q", part)
+			}
+			for c := a; c <= b; c++ {
+				out = append(out, StatusMatch{Code: c})
+			}
+		default:
+			c, err := strconv.Atoi(part)
+			if err != nil || c < 100 || c > 599 {
+				return nil, Errorf("invalid status code %q", part)
+			}
+			out = append(out, StatusMatch{Code: c})
+		}
+	}
+	return out, nil
+}
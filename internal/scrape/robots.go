@@ -0,0 +1,234 @@
+package scrape
+
+import (
+	"bufio"
+	"context"
+	. "fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- robots.txt and politeness ----------
+
+// robotsAgent is the product token matched against User-agent lines.
+const robotsAgent = "nmscripts"
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsPolicy is the rule group that applies to this scraper on one host.
+type robotsPolicy struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	disallowed bool // robots.txt unreachable: RFC 9309 says assume full disallow
+}
+
+// Allowed applies the longest matching rule; on a tie Allow wins.
+func (p *robotsPolicy) Allowed(u *url.URL) bool {
+	if p == nil {
+		return true
+	}
+	if p.disallowed {
+		return false
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	best, allow := -1, true
+	for _, r := range p.rules {
+		if !r.re.MatchString(path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			best, allow = n, r.allow
+		}
+	}
+	return allow
+}
+
+func robotsPattern(p string) *regexp.Regexp {
+	anchored := strings.HasSuffix(p, "$")
+	p = strings.TrimSuffix(p, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// parseRobots extracts the group for robotsAgent, falling back to "*".
+func parseRobots(r io.Reader) *robotsPolicy {
+	type group struct {
+		agents []string
+		policy robotsPolicy
+	}
+	var groups []*group
+	var cur *group
+	lastWasAgent := false
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		switch key {
+		case "user-agent":
+			if !lastWasAgent || cur == nil {
+				cur = &group{}
+				groups = append(groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			lastWasAgent = true
+			continue
+		case "allow", "disallow":
+			if cur != nil && val != "" {
+				cur.policy.rules = append(cur.policy.rules, robotsRule{allow: key == "allow", pattern: val, re: robotsPattern(val)})
+			}
+		case "crawl-delay":
+			if cur != nil {
+				if secs, err := strconv.ParseFloat(val, 64); err == nil && secs > 0 {
+					cur.policy.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+		lastWasAgent = false
+	}
+
+	var star *group
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if strings.Contains(robotsAgent, a) && a != "*" && a != "" {
+				return &g.policy
+			}
+			if a == "*" && star == nil {
+				star = g
+			}
+		}
+	}
+	if star != nil {
+		return &star.policy
+	}
+	return &robotsPolicy{}
+}
+
+// fetchRobots downloads and parses robots.txt for u's host. Per RFC 9309 a
+// 4xx means no restrictions, while 5xx or a network failure means the whole
+// site is treated as disallowed.
+func fetchRobots(ctx context.Context, u *url.URL, opts FetchOptions) (*robotsPolicy, error) {
+	ru := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ru.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; "+robotsAgent+")")
+	for k, vs := range opts.Header {
+		req.Header[k] = vs
+	}
+	if err := opts.Pacer.Wait(ctx, u.Host); err != nil {
+		return nil, err
+	}
+	resp, err := HTTPClient(opts.Retry.Timeout, opts.Proxy).Do(req)
+	if err != nil {
+		return &robotsPolicy{disallowed: true}, Errorf("robots.txt unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return &robotsPolicy{disallowed: true}, Errorf("robots.txt: %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return &robotsPolicy{}, nil
+	}
+	return parseRobots(io.LimitReader(resp.Body, 512<<10)), nil
+}
+
+// RobotsCache fetches robots.txt once per host. A nil cache allows every URL
+// (--ignore-robots). Each host's Crawl-delay raises the shared pacer.
+type RobotsCache struct {
+	Opts     FetchOptions
+	policies map[string]*robotsPolicy
+}
+
+func (c *RobotsCache) Allowed(ctx context.Context, u *url.URL) bool {
+	if c == nil {
+		return true
+	}
+	p, ok := c.policies[u.Host]
+	if !ok {
+		var err error
+		p, err = fetchRobots(ctx, u, c.Opts)
+		if err != nil {
+			slog.Warn("robots.txt check failed", "host", u.Host, "err", err)
+		}
+		if p != nil {
+			c.Opts.Pacer.Raise(p.crawlDelay)
+		}
+		if c.policies == nil {
+			c.policies = map[string]*robotsPolicy{}
+		}
+		c.policies[u.Host] = p
+	}
+	return p.Allowed(u)
+}
+
+// Pacer enforces a minimum delay between requests to the same host.
+type Pacer struct {
+	mu    sync.Mutex
+	Delay time.Duration
+	last  map[string]time.Time
+}
+
+// Raise bumps the delay to d if it is longer (e.g. a robots Crawl-delay).
+func (p *Pacer) Raise(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d > p.Delay {
+		p.Delay = d
+	}
+}
+
+func (p *Pacer) Wait(ctx context.Context, host string) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if p.last == nil {
+		p.last = map[string]time.Time{}
+	}
+	wait := time.Until(p.last[host].Add(p.Delay))
+	if wait < 0 {
+		wait = 0
+	}
+	p.last[host] = time.Now().Add(wait)
+	p.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
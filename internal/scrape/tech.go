@@ -0,0 +1,187 @@
+package scrape
+
+import (
+	. "fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Technology profile ----------
+
+// Technology is one row of an nmsassistant technology/upgrade module table.
+type Technology struct {
+	Name      string
+	Category  string   // Starship, Multi-Tool, Exosuit, Exocraft, Freighter...
+	Class     string   // S, A, B, C, X; empty for base technology
+	Stats     []string // e.g. "Damage +12%"
+	Resources []Cell   // crafting cost, Qty per resource
+	Href      string
+	Img       string
+}
+
+var techHeader = []string{"name", "category", "class", "stats", "resources", "href", "img"}
+
+// techColumns maps header words to Technology fields; the first matching
+// word wins, so "Module" lands on name and "Module Type" on category.
+var techColumns = []struct {
+	field string
+	words []string
+}{
+	{"category", []string{"type", "category", "platform", "slot"}},
+	{"class", []string{"class", "tier", "grade"}},
+	{"stats", []string{"stat", "bonus", "effect"}},
+	{"resources", []string{"cost", "resource", "craft", "require", "ingredient", "blueprint"}},
+	{"name", []string{"name", "module", "technology", "upgrade", "item"}},
+}
+
+var classRe = regexp.MustCompile(`(?i)^(?:class\s*)?([SABCX])(?:[\s-]*class)?$`)
+
+// techColumnIndex reads the table header; without one the columns are taken
+// as name, class, stats, resources.
+func techColumnIndex(table *goquery.Selection) map[string]int {
+	cols := map[string]int{}
+	table.Find("thead th, thead td").Each(func(i int, th *goquery.Selection) {
+		h := strings.ToLower(textCondense(th.Text()))
+		for _, c := range techColumns {
+			if _, seen := cols[c.field]; seen {
+				continue
+			}
+			for _, w := range c.words {
+				if strings.Contains(h, w) {
+					cols[c.field] = i
+					return
+				}
+			}
+		}
+	})
+	if len(cols) == 0 {
+		cols = map[string]int{"name": 0, "class": 1, "stats": 2, "resources": 3}
+	}
+	return cols
+}
+
+// cellItems splits a cell holding a list into its entries: <li> items, else
+// .cell-content blocks, else direct child elements. Nil means plain text.
+func cellItems(td *goquery.Selection) []*goquery.Selection {
+	var items []*goquery.Selection
+	sel := td.Find("li")
+	if sel.Length() == 0 {
+		sel = td.Find(".cell-content")
+	}
+	if sel.Length() == 0 {
+		sel = td.Children()
+	}
+	sel.Each(func(_ int, s *goquery.Selection) { items = append(items, s) })
+	return items
+}
+
+// splitText splits plain cell text on line breaks, semicolons and commas.
+func splitText(s string) []string {
+	var out []string
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ';' || r == ',' }) {
+		if p = textCondense(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parseTechTable(html string, base *url.URL, selector string) ([]Technology, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	table := doc.Find(selector).First()
+	if table.Length() == 0 {
+		return nil, Errorf("table not found with selector %q", selector)
+	}
+	cols := techColumnIndex(table)
+	table.Find("br").ReplaceWithHtml("\n") // keep <br>-separated stats apart
+
+	var out []Technology
+	table.Find("tbody > tr").Each(func(_ int, tr *goquery.Selection) {
+		tds := tr.Find("td")
+		td := func(field string) *goquery.Selection {
+			i, ok := cols[field]
+			if !ok || i >= tds.Length() {
+				return nil
+			}
+			return tds.Eq(i)
+		}
+		text := func(field string) string {
+			if s := td(field); s != nil {
+				return textCondense(s.Text())
+			}
+			return ""
+		}
+
+		name := extractCell(td("name"), base)
+		if name.Name == "" {
+			return
+		}
+		t := Technology{Name: name.Name, Href: name.Href, Img: name.Img, Category: text("category")}
+		t.Class = text("class")
+		if m := classRe.FindStringSubmatch(t.Class); m != nil {
+			t.Class = strings.ToUpper(m[1])
+		}
+
+		if s := td("stats"); s != nil {
+			for _, it := range cellItems(s) {
+				if v := textCondense(it.Text()); v != "" {
+					t.Stats = append(t.Stats, v)
+				}
+			}
+			if len(t.Stats) == 0 {
+				t.Stats = splitText(s.Text())
+			}
+		}
+
+		if s := td("resources"); s != nil {
+			items := cellItems(s)
+			structured := len(items) > 0
+			if !structured {
+				items = []*goquery.Selection{s}
+			}
+			for _, it := range items {
+				if structured {
+					if c := extractCell(it, base); c.Name != "" {
+						t.Resources = append(t.Resources, c)
+						continue
+					}
+				}
+				// plain text such as "Chromatic Metal x50, Ferrite Dust x100"
+				for _, p := range splitText(it.Text()) {
+					c := Cell{Name: strings.TrimSpace(amountRe.ReplaceAllString(p, "")), Qty: parseQtyFromText(p)}
+					if c.Name != "" {
+						t.Resources = append(t.Resources, c)
+					}
+				}
+			}
+		}
+		out = append(out, t)
+	})
+	return out, nil
+}
+
+// techDataset flattens technologies for output: stats are joined with "; "
+// and resources written as "Name xQty; Name xQty".
+func techDataset(techs []Technology) Dataset {
+	d := Dataset{Header: techHeader}
+	for _, t := range techs {
+		var res []string
+		for _, c := range t.Resources {
+			if q := qtyStr(c.Qty); q != "" {
+				res = append(res, c.Name+" x"+q)
+			} else {
+				res = append(res, c.Name)
+			}
+		}
+		d.Records = append(d.Records, []string{
+			t.Name, t.Category, t.Class, strings.Join(t.Stats, "; "), strings.Join(res, "; "), t.Href, t.Img,
+		})
+	}
+	return d
+}
@@ -0,0 +1,223 @@
+package scrape
+
+import (
+	. "fmt"
+	_ "image/gif" // decoders excelize needs to size embedded icons
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ---------- XLSX output ----------
+
+// XLSXSheet is one scraped table written as its own worksheet.
+type XLSXSheet struct {
+	Name string
+	Data Dataset
+}
+
+type XLSXOptions struct {
+	// Image downloads an icon URL for embedding. Nil leaves the img columns
+	// as plain URLs.
+	Image func(url string) ([]byte, error)
+}
+
+// xlsxImageExt maps sniffed content types to the extensions excelize embeds.
+var xlsxImageExt = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// sheetName makes s usable as a worksheet name: no []:*?/\ characters, at
+// most 31 runes, and unique within taken.
+func sheetName(s string, taken map[string]bool) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" {
+		s = "Sheet"
+	}
+	if r := []rune(s); len(r) > 31 {
+		s = string(r[:31])
+	}
+	name := s
+	for i := 2; taken[strings.ToLower(name)]; i++ {
+		suffix := Sprintf(" (%d)", i)
+		r := []rune(s)
+		if len(r)+len(suffix) > 31 {
+			r = r[:31-len(suffix)]
+		}
+		name = string(r) + suffix
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
+
+// URLSheetName names a sheet after the last path segment of a page URL,
+// e.g. ".../cooking" or ".../cooking.html" becomes "cooking".
+func URLSheetName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "Sheet1"
+	}
+	seg := path.Base(strings.TrimSuffix(u.Path, "/"))
+	seg = strings.TrimSuffix(seg, path.Ext(seg))
+	if seg == "." || seg == "/" || seg == "" {
+		return u.Hostname()
+	}
+	return seg
+}
+
+// WriteXLSX writes each table to its own sheet with a frozen header row and
+// clickable *_href columns. With opt.Image set, icons are embedded over their
+// *_img cells; icons that fail to download keep their URL and log a warning.
+func WriteXLSX(path string, sheets []XLSXSheet, opt XLSXOptions) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	linkStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "1265BE", Underline: "single"}})
+	if err != nil {
+		return err
+	}
+	headStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+
+	icons := map[string][]byte{} // by URL; nil marks a failed download
+	taken := map[string]bool{}
+	for i, s := range sheets {
+		name := sheetName(s.Name, taken)
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(name); err != nil {
+			return err
+		}
+		if err := writeXLSXSheet(f, name, s.Data, opt, icons, linkStyle, headStyle); err != nil {
+			return Errorf("sheet %q: %w", name, err)
+		}
+	}
+	return f.SaveAs(path)
+}
+
+func writeXLSXSheet(f *excelize.File, sheet string, d Dataset, opt XLSXOptions, icons map[string][]byte, linkStyle, headStyle int) error {
+	if err := f.SetSheetRow(sheet, "A1", &d.Header); err != nil {
+		return err
+	}
+	last, _ := excelize.CoordinatesToCellName(len(d.Header), 1)
+	if err := f.SetCellStyle(sheet, "A1", last, headStyle); err != nil {
+		return err
+	}
+	if err := f.SetPanes(sheet, &excelize.Panes{
+		Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft",
+	}); err != nil {
+		return err
+	}
+
+	// href and img columns, plus the name column used as each icon's alt text
+	var hrefCols, imgCols []int
+	altCol := map[int]int{}
+	for i, h := range d.Header {
+		switch {
+		case strings.HasSuffix(h, "href"):
+			hrefCols = append(hrefCols, i)
+		case strings.HasSuffix(h, "img"):
+			imgCols = append(imgCols, i)
+			altCol[i] = slices.Index(d.Header, strings.TrimSuffix(h, "img")+"name")
+		}
+	}
+
+	for i, rec := range d.Records {
+		rowNum := i + 2
+		addr, _ := excelize.CoordinatesToCellName(1, rowNum)
+		if err := f.SetSheetRow(sheet, addr, &rec); err != nil {
+			return err
+		}
+		for _, c := range hrefCols {
+			if c >= len(rec) || rec[c] == "" {
+				continue
+			}
+			ref, _ := excelize.CoordinatesToCellName(c+1, rowNum)
+			if err := f.SetCellHyperLink(sheet, ref, rec[c], "External"); err != nil {
+				return err
+			}
+			if err := f.SetCellStyle(sheet, ref, ref, linkStyle); err != nil {
+				return err
+			}
+		}
+		if opt.Image == nil {
+			continue
+		}
+		embedded := false
+		for _, c := range imgCols {
+			if c >= len(rec) || rec[c] == "" {
+				continue
+			}
+			u := rec[c]
+			img, ok := icons[u]
+			if !ok {
+				img = fetchIcon(opt, u)
+				icons[u] = img
+			}
+			if img == nil {
+				continue
+			}
+			alt := ""
+			if a := altCol[c]; a >= 0 && a < len(rec) {
+				alt = rec[a]
+			}
+			ref, _ := excelize.CoordinatesToCellName(c+1, rowNum)
+			if err := f.AddPictureFromBytes(sheet, ref, &excelize.Picture{
+				Extension: xlsxImageExt[http.DetectContentType(img)],
+				File:      img,
+				Format:    &excelize.GraphicOptions{AltText: alt, AutoFit: true, Hyperlink: u, HyperlinkType: "External"},
+			}); err != nil {
+				return err
+			}
+			embedded = true
+		}
+		if embedded {
+			if err := f.SetRowHeight(sheet, rowNum, 36); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opt.Image != nil {
+		for _, c := range imgCols {
+			col, _ := excelize.ColumnNumberToName(c + 1)
+			if err := f.SetColWidth(sheet, col, col, 8); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchIcon downloads one icon, returning nil (after a warning) when it
+// cannot be fetched or is not a format excelize can embed.
+func fetchIcon(opt XLSXOptions, u string) []byte {
+	b, err := opt.Image(u)
+	if err != nil {
+		slog.Warn("icon fetch failed", "url", u, "err", err)
+		return nil
+	}
+	if _, ok := xlsxImageExt[http.DetectContentType(b)]; !ok {
+		slog.Warn("icon has unsupported image type", "url", u, "type", http.DetectContentType(b))
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,835 @@
+// Package store keeps typed records in JSON files shared safely between
+// processes, with tags, photos, search and import/export.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/poku-e/NMScripts/internal/norm"
+)
+
+// ---------- Generic collection store ----------
+
+// Meta holds the fields every stored record shares. Record types embed it so
+// their JSON stays flat.
+type Meta struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Tags      []string  `json:"tags,omitempty"`
+	Pinned    bool      `json:"pinned,omitempty"`     // listed first; see SetPinned
+	CreatedBy string    `json:"created_by,omitempty"` // signed-in user who added it, if any
+}
+
+// Fields gives generic code access to the embedded Meta.
+func (m *Meta) Fields() *Meta { return m }
+
+// Record is satisfied by *T when T embeds Meta.
+type Record[T any] interface {
+	*T
+	Fields() *Meta
+}
+
+// Spec declares what is specific to one kind of record. A new catalogue is a
+// record type embedding Meta plus one Spec value; storage, tags, photos,
+// search, import/export and the HTTP API come from Collection.
+type Spec[T any] struct {
+	Kind     string          // singular noun; also names the API path and image dir
+	Validate func(*T) error  // normalizes in place; rejects invalid records
+	Key      func(*T) string // duplicate key; nil or "" disables the check
+	Text     func(*T) string // text matched by Search
+
+	// FromForm maps multipart fields onto a record. nil means the API only
+	// accepts JSON bodies. Tags and photo files are handled generically.
+	FromForm func(url.Values) T
+	// SetPhotos receives the URLs of photos uploaded with a form. nil means
+	// the kind has no photos and uploads are rejected.
+	SetPhotos func(*T, []string)
+	// Migrations upgrade files written by older versions on Load; their
+	// count is the schema version this program writes.
+	Migrations []Migration
+}
+
+const (
+	maxTags   = 16
+	maxTagLen = 32
+)
+
+// normalizeTags lowercases, trims and de-duplicates tags, keeping order.
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := map[string]struct{}{}
+	for _, t := range tags {
+		t = strings.Join(strings.Fields(strings.ToLower(t)), " ")
+		if t == "" {
+			continue
+		}
+		if utf8.RuneCountInString(t) > maxTagLen {
+			return nil, fmt.Errorf("tag too long (max %d chars)", maxTagLen)
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("too many tags (max %d)", maxTags)
+	}
+	return out, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Collection is a JSON-file backed list of records, the same persistence the
+// glyph store always used: the whole array is rewritten atomically on every
+// change. A Path ending in .db (or .sqlite) keeps the records in a SQLite
+// database instead, where a change writes only the records it touches, and
+// one ending in .jsonl an append-only log, where a change appends them (see
+// logLine).
+//
+// Several processes (the server, the glyphs CLI, a second server) may share
+// one file. Every read and write of it happens under an advisory file lock,
+// and a change first reloads the file if another process rewrote it, so
+// concurrent writers never drop each other's records. With SQLite the lock
+// is a database transaction.
+type Collection[T any, P Record[T]] struct {
+	mu    sync.RWMutex
+	Path  string
+	Spec  Spec[T]
+	Items []T
+
+	// ImageDir, when set, holds the photo directories of every kind;
+	// otherwise photos live next to Path.
+	ImageDir string
+	// KeepBackups is how many copies of a JSON store file each save keeps,
+	// as Path.bak.1 (the newest) to Path.bak.N; see BackupPath.
+	KeepBackups int
+
+	stamp fileStamp   // of the file as last read or written
+	db    *sqliteFile // when Path is a SQLite database; opened by lock
+	log   logState    // when Path is a log
+
+	subMu sync.Mutex
+	subs  map[chan Event[T]]struct{} // see Subscribe
+}
+
+// InMemory is the Path of a collection kept in memory only: Load starts it
+// empty and changes are never written anywhere. Photos still go to
+// PhotoDir, so set ImageDir.
+const InMemory = ":memory:"
+
+// fileStamp identifies one version of the store file.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+	rev  int64 // of a SQLite store, which has no useful mtime
+}
+
+// currentStamp is the stamp of the store as it is now.
+func (c *Collection[T, P]) currentStamp() fileStamp {
+	if c.Path == InMemory {
+		return c.stamp
+	}
+	if c.db != nil {
+		return c.db.stamp()
+	}
+	return statStamp(c.Path)
+}
+
+// lock takes the file lock, or starts the database transaction; callers
+// hold c.mu exclusively.
+func (c *Collection[T, P]) lock(exclusive bool) (unlock func(), err error) {
+	if c.Path == InMemory {
+		return func() {}, nil
+	}
+	if !IsSQLite(c.Path) {
+		return lockFile(c.Path, exclusive)
+	}
+	if c.db == nil {
+		if c.db, err = openSQLiteFile(c.Path); err != nil {
+			return nil, err
+		}
+	}
+	return c.db.lock(exclusive)
+}
+
+func statStamp(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{mod: fi.ModTime(), size: fi.Size()}
+}
+
+var (
+	ErrNotFound    = errors.New("not found")
+	errLockTimeout = errors.New("timed out waiting for lock")
+)
+
+// FieldError is a Spec.Validate error about one field of the record, named
+// as in its JSON, so an API client can show it next to that field.
+type FieldError struct {
+	Field   string
+	Message string // the whole message, field name included
+}
+
+func (e *FieldError) Error() string { return e.Message }
+
+// Invalid returns a FieldError about field.
+func Invalid(field, message string) error {
+	return &FieldError{Field: field, Message: message}
+}
+
+const (
+	lockTimeout = 10 * time.Second
+	lockPoll    = 25 * time.Millisecond
+)
+
+// Load reads the file. A file written with an older schema is migrated,
+// kept as path.v<N>.bak and rewritten, so Load takes the exclusive lock.
+func (c *Collection[T, P]) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Path == "" {
+		return fmt.Errorf("%s store path empty", c.Spec.Kind)
+	}
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if IsLog(c.Path) {
+		if err := c.adoptJSON(); err != nil {
+			return err
+		}
+	}
+	from, err := c.read()
+	if err != nil || from == c.Spec.schemaVersion() {
+		return err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", c.Path, from)
+	if c.db != nil {
+		// A live database can't be copied file by file; keep a JSON copy.
+		var b []byte
+		if b, err = c.db.export(); err == nil {
+			err = os.WriteFile(backup, b, 0o644)
+		}
+	} else {
+		err = copyFile(c.Path, backup)
+	}
+	if err != nil {
+		return fmt.Errorf("back up %s: %w", c.Path, err)
+	}
+	if err := c.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "migrated %s from schema %d to %d (backup: %s)\n", c.Path, from, c.Spec.schemaVersion(), backup)
+	return nil
+}
+
+// read replaces Items with the file's contents, migrated to the current
+// schema, and returns the schema version the file had; callers hold c.mu
+// and the file lock.
+func (c *Collection[T, P]) read() (version int, err error) {
+	stamp := c.currentStamp()
+	f, ok, err := c.readStore()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		c.Items, c.stamp = nil, stamp
+		return c.Spec.schemaVersion(), nil
+	}
+	items, err := c.decodeItems(f)
+	if err != nil {
+		return 0, err
+	}
+	c.Items, c.stamp = items, stamp
+	return f.SchemaVersion, nil
+}
+
+// readStore reads the file or database as stored; ok is false when there
+// is nothing yet.
+func (c *Collection[T, P]) readStore() (f storeFile, ok bool, err error) {
+	if c.Path == InMemory {
+		return storeFile{}, false, nil
+	}
+	if c.db != nil {
+		return c.db.read()
+	}
+	b, err := os.ReadFile(c.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.log = logState{}
+			return storeFile{}, false, nil
+		}
+		return storeFile{}, false, err
+	}
+	if IsLog(c.Path) {
+		f, c.log, err = decodeLog(b)
+		return f, err == nil, err
+	}
+	f, err = decodeStoreFile(b)
+	return f, err == nil, err
+}
+
+// refresh reloads the file if another process changed it since this one
+// last read or wrote it. A failed reload keeps the records in memory.
+func (c *Collection[T, P]) refresh() {
+	c.mu.RLock()
+	same := c.currentStamp() == c.stamp
+	c.mu.RUnlock()
+	if same {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unlock, err := c.lock(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reload %s: %v\n", c.Spec.Kind+"s", err)
+		return
+	}
+	defer unlock()
+	if c.currentStamp() == c.stamp {
+		return
+	}
+	if _, err := c.read(); err != nil {
+		fmt.Fprintf(os.Stderr, "reload %s: %v\n", c.Spec.Kind+"s", err)
+	}
+}
+
+// lockForWrite takes the exclusive file lock for a change and brings Items
+// up to date with the file; callers hold c.mu and must call unlock.
+func (c *Collection[T, P]) lockForWrite() (unlock func(), err error) {
+	unlock, err = c.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	if c.currentStamp() != c.stamp {
+		if _, err := c.read(); err != nil {
+			unlock()
+			return nil, fmt.Errorf("reload %s: %w", c.Spec.Kind+"s", err)
+		}
+	}
+	return unlock, nil
+}
+
+// save writes the collection, or in a database just the records with the
+// given IDs (every record when none are given); callers hold c.mu and the
+// exclusive lock.
+func (c *Collection[T, P]) save(ids ...string) error {
+	if c.Path == InMemory {
+		return nil
+	}
+	if c.db != nil {
+		return c.saveSQL(ids)
+	}
+	if IsLog(c.Path) {
+		return c.saveLog(ids)
+	}
+	items := c.Items
+	if items == nil {
+		items = []T{}
+	}
+	data, err := json.MarshalIndent(struct {
+		SchemaVersion int `json:"schema_version"`
+		Items         []T `json:"items"`
+	}{c.Spec.schemaVersion(), items}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := rotate(c.Path, c.KeepBackups); err != nil {
+		return fmt.Errorf("rotate %s: %w", c.Path, err)
+	}
+	if err := writeFileSync(c.Path, data); err != nil {
+		return err
+	}
+	c.stamp = statStamp(c.Path)
+	return nil
+}
+
+// ReadFile returns a store file's raw contents under the shared lock, for
+// copies taken while a server may be writing. A missing file is nil, nil. A
+// database or log is returned as the JSON file it would be.
+func ReadFile(path string) ([]byte, error) {
+	if IsSQLite(path) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+		var b []byte
+		err := withSQLite(path, false, func(f *sqliteFile) (err error) {
+			b, err = f.export()
+			return err
+		})
+		return b, err
+	}
+	unlock, err := lockFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil || !IsLog(path) {
+		return b, err
+	}
+	f, _, err := decodeLog(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Items == nil {
+		f.Items = []json.RawMessage{}
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// WriteFile replaces a store file under the exclusive lock, keeping keep
+// rotated copies as a save does. Running collections on the file reload it
+// on their next access. A database or log takes the records of a JSON store
+// file.
+func WriteFile(path string, data []byte, keep int) error {
+	if IsSQLite(path) {
+		return withSQLite(path, true, func(f *sqliteFile) error { return f.replace(data) })
+	}
+	unlock, err := lockFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if IsLog(path) {
+		f, err := decodeStoreFile(data)
+		if err != nil {
+			return err
+		}
+		if data, err = encodeLog(f); err != nil {
+			return err
+		}
+	}
+	if err := rotate(path, keep); err != nil {
+		return fmt.Errorf("rotate %s: %w", path, err)
+	}
+	return writeFileSync(path, data)
+}
+
+func (c *Collection[T, P]) Len() int {
+	c.refresh()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.Items)
+}
+
+// List returns all records, pinned ones first, each newest first.
+func (c *Collection[T, P]) List() []T {
+	c.refresh()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]T, len(c.Items))
+	copy(out, c.Items)
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := P(&out[i]).Fields(), P(&out[j]).Fields()
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return out
+}
+
+func (c *Collection[T, P]) Get(id string) (T, bool) {
+	c.refresh()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if i := c.indexOf(id); i >= 0 {
+		return c.Items[i], true
+	}
+	var zero T
+	return zero, false
+}
+
+func (c *Collection[T, P]) indexOf(id string) int {
+	for i := range c.Items {
+		if P(&c.Items[i]).Fields().ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Collection[T, P]) validate(it *T) error {
+	tags, err := normalizeTags(P(it).Fields().Tags)
+	if err != nil {
+		return err
+	}
+	P(it).Fields().Tags = tags
+	if c.Spec.Validate != nil {
+		return c.Spec.Validate(it)
+	}
+	return nil
+}
+
+// PhotoDir is where uploaded photos for this kind are stored; it is served
+// at PhotoPrefix.
+func (c *Collection[T, P]) PhotoDir() string {
+	if c.ImageDir != "" {
+		return filepath.Join(c.ImageDir, c.Spec.Kind)
+	}
+	return filepath.Join(filepath.Dir(c.Path), c.Spec.Kind+"-images")
+}
+
+func (c *Collection[T, P]) PhotoPrefix() string {
+	return "/" + c.Spec.Kind + "-images/"
+}
+
+// StorePhoto re-encodes an uploaded image into PhotoDir and returns its URL.
+func (c *Collection[T, P]) StorePhoto(photo []byte) (string, error) {
+	name := fmt.Sprintf("%d_%x", time.Now().UnixNano(), Hash(string(photo)))
+	if err := storePhoto(c.PhotoDir(), name, photo); err != nil {
+		return "", err
+	}
+	return c.PhotoPrefix() + name + ".jpg", nil
+}
+
+// duplicate reports whether another record (not skipID) shares its key.
+func (c *Collection[T, P]) duplicate(it *T, skipID string) bool {
+	if c.Spec.Key == nil {
+		return false
+	}
+	k := c.Spec.Key(it)
+	if k == "" {
+		return false
+	}
+	for i := range c.Items {
+		if P(&c.Items[i]).Fields().ID != skipID && c.Spec.Key(&c.Items[i]) == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collection[T, P]) assignMeta(it *T) {
+	now := time.Now()
+	m := P(it).Fields()
+	seed := ""
+	if c.Spec.Key != nil {
+		seed = c.Spec.Key(it)
+	}
+	m.ID = fmt.Sprintf("%d_%x", now.UnixNano(), Hash(c.Spec.Kind+seed))
+	m.CreatedAt = now.UTC()
+}
+
+// Add validates it, assigns ID and CreatedAt, and persists it.
+func (c *Collection[T, P]) Add(it T) (T, error) {
+	var zero T
+	if err := c.validate(&it); err != nil {
+		return zero, err
+	}
+	c.assignMeta(&it)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return zero, err
+	}
+	defer unlock()
+
+	if c.duplicate(&it, "") {
+		return zero, fmt.Errorf("duplicate %s", c.Spec.Kind)
+	}
+	c.Items = append(c.Items, it)
+	if err := c.save(P(&it).Fields().ID); err != nil {
+		c.Items = c.Items[:len(c.Items)-1]
+		return zero, err
+	}
+	c.publish("create", it)
+	return it, nil
+}
+
+// Update replaces the record with the given ID. Its ID, CreatedAt,
+// CreatedBy and Pinned are kept; its tags are the ones sent.
+func (c *Collection[T, P]) Update(id string, it T) (T, error) {
+	var zero T
+	if err := c.validate(&it); err != nil {
+		return zero, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return zero, err
+	}
+	defer unlock()
+
+	i := c.indexOf(id)
+	if i < 0 {
+		return zero, ErrNotFound
+	}
+	m, old := P(&it).Fields(), P(&c.Items[i]).Fields()
+	m.ID, m.CreatedAt, m.CreatedBy, m.Pinned = old.ID, old.CreatedAt, old.CreatedBy, old.Pinned
+	if c.duplicate(&it, id) {
+		return zero, fmt.Errorf("duplicate %s", c.Spec.Kind)
+	}
+	prev := c.Items[i]
+	c.Items[i] = it
+	if err := c.save(id); err != nil {
+		c.Items[i] = prev
+		return zero, err
+	}
+	c.publish("update", it)
+	return it, nil
+}
+
+// SetPinned pins or unpins the record with the given ID. Pinning is part
+// of Meta, so Update leaves it alone.
+func (c *Collection[T, P]) SetPinned(id string, pinned bool) (T, error) {
+	var zero T
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return zero, err
+	}
+	defer unlock()
+
+	i := c.indexOf(id)
+	if i < 0 {
+		return zero, ErrNotFound
+	}
+	m := P(&c.Items[i]).Fields()
+	was := m.Pinned
+	m.Pinned = pinned
+	if err := c.save(id); err != nil {
+		m.Pinned = was
+		return zero, err
+	}
+	c.publish("update", c.Items[i])
+	return c.Items[i], nil
+}
+
+func (c *Collection[T, P]) Delete(id string) (T, error) {
+	var zero T
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return zero, err
+	}
+	defer unlock()
+
+	i := c.indexOf(id)
+	if i < 0 {
+		return zero, ErrNotFound
+	}
+	removed := c.Items[i]
+	prev := c.Items
+	c.Items = append(append([]T(nil), c.Items[:i]...), c.Items[i+1:]...)
+	if err := c.save(id); err != nil {
+		c.Items = prev
+		return zero, err
+	}
+	c.publish("delete", removed)
+	return removed, nil
+}
+
+// ImportResult reports the outcome for one record of an Import call.
+type ImportResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Import validates and adds each record, skipping invalid ones and
+// duplicates, and saves once. Records that carry an ID and CreatedAt (from an
+// export) keep them so re-importing a backup is stable.
+func (c *Collection[T, P]) Import(items []T) ([]ImportResult, error) {
+	return c.importItems(items, false, false)
+}
+
+// Reset replaces every record with items, checked as Import checks them.
+func (c *Collection[T, P]) Reset(items []T) ([]ImportResult, error) {
+	return c.importItems(items, false, true)
+}
+
+// Preview runs the same checks as Import, duplicates within the batch
+// included, without changing the store. Accepted records get no ID.
+func (c *Collection[T, P]) Preview(items []T) []ImportResult {
+	results, _ := c.importItems(items, true, false)
+	return results
+}
+
+func (c *Collection[T, P]) importItems(items []T, dryRun, reset bool) ([]ImportResult, error) {
+	results := make([]ImportResult, len(items))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	old := c.Items
+	if reset {
+		c.Items = nil
+	}
+	n := len(c.Items)
+	for i := range items {
+		it := items[i]
+		results[i].Index = i
+		if err := c.validate(&it); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		m := P(&it).Fields()
+		if m.ID == "" || m.CreatedAt.IsZero() {
+			c.assignMeta(&it)
+		} else if c.indexOf(m.ID) >= 0 {
+			results[i].Error = fmt.Sprintf("%s %s already exists", c.Spec.Kind, m.ID)
+			continue
+		}
+		if c.duplicate(&it, "") {
+			results[i].Error = fmt.Sprintf("duplicate %s", c.Spec.Kind)
+			continue
+		}
+		c.Items = append(c.Items, it)
+		if !dryRun {
+			results[i].ID = m.ID
+		}
+	}
+	if dryRun {
+		c.Items = c.Items[:n]
+		return results, nil
+	}
+	if reset {
+		if err := c.save(); err != nil {
+			c.Items = old
+			return nil, err
+		}
+		c.publish("reset")
+		return results, nil
+	}
+	if len(c.Items) == n {
+		return results, nil
+	}
+	added := make([]string, 0, len(c.Items)-n)
+	for i := n; i < len(c.Items); i++ {
+		added = append(added, P(&c.Items[i]).Fields().ID)
+	}
+	if err := c.save(added...); err != nil {
+		c.Items = c.Items[:n]
+		return nil, err
+	}
+	c.publish("create", c.Items[n:]...)
+	return results, nil
+}
+
+// Filter returns the records (newest first) for which keep reports true.
+func (c *Collection[T, P]) Filter(keep func(*T) bool) []T {
+	var out []T
+	for _, it := range c.List() {
+		if keep(&it) {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// Tagged returns the records carrying tag (normalized like stored tags).
+func (c *Collection[T, P]) Tagged(tag string) []T {
+	norm, _ := normalizeTags([]string{tag})
+	if len(norm) == 0 {
+		return c.List()
+	}
+	return c.Filter(func(it *T) bool { return hasTag(P(it).Fields().Tags, norm[0]) })
+}
+
+// TagCount is one tag in use and how many records carry it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// TagCounts lists the tags in use, most used first (ties by name).
+func (c *Collection[T, P]) TagCounts() []TagCount {
+	counts := map[string]int{}
+	for _, it := range c.List() {
+		for _, t := range P(&it).Fields().Tags {
+			counts[t]++
+		}
+	}
+	out := make([]TagCount, 0, len(counts))
+	for t, n := range counts {
+		out = append(out, TagCount{t, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	return out
+}
+
+// Search returns records whose Spec.Text or tags contain every query word,
+// ranked by how early the first word appears. An empty query returns List().
+func (c *Collection[T, P]) Search(q string) []T {
+	return c.SearchIn(q, c.List())
+}
+
+// SearchIn is Search over an already filtered list.
+func (c *Collection[T, P]) SearchIn(q string, all []T) []T {
+	words := strings.Fields(norm.Key(q))
+	if len(words) == 0 || c.Spec.Text == nil {
+		return all
+	}
+	type hit struct {
+		it  T
+		pos int
+	}
+	var hits []hit
+	for i := range all {
+		text := norm.Key(c.Spec.Text(&all[i]) + " " + strings.Join(P(&all[i]).Fields().Tags, " "))
+		ok := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			hits = append(hits, hit{all[i], strings.Index(text, words[0])})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].pos < hits[j].pos })
+	out := make([]T, len(hits))
+	for i, h := range hits {
+		out[i] = h.it
+	}
+	return out
+}
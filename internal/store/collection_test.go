@@ -0,0 +1,98 @@
+package store
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type testRecord struct {
+	Meta
+	Name string `json:"name"`
+}
+
+func newTestCollection(t *testing.T, path string) *Collection[testRecord, *testRecord] {
+	t.Helper()
+	c := &Collection[testRecord, *testRecord]{
+		Path: path,
+		Spec: Spec[testRecord]{
+			Kind: "thing",
+			Key:  func(r *testRecord) string { return r.Name },
+		},
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUpdateKeepsTags(t *testing.T) {
+	for _, path := range []string{InMemory, "things.json", "things.jsonl", "things.db"} {
+		t.Run(path, func(t *testing.T) {
+			if path != InMemory {
+				path = filepath.Join(t.TempDir(), path)
+			}
+			c := newTestCollection(t, path)
+			added, err := c.Add(testRecord{Meta: Meta{Tags: []string{"old"}, CreatedBy: "ana"}, Name: "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.SetPinned(added.ID, true); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name string
+				tags []string
+				want []string
+			}{
+				{"replaced", []string{"New", " two  words "}, []string{"new", "two words"}},
+				{"deduplicated", []string{"x", "X"}, []string{"x"}},
+				{"cleared", nil, nil},
+			}
+			for _, tt := range tests {
+				in := testRecord{Meta: Meta{ID: "forged", CreatedBy: "mallory", Tags: tt.tags}, Name: "a"}
+				got, err := c.Update(added.ID, in)
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if !slices.Equal(got.Tags, tt.want) {
+					t.Errorf("%s: tags = %q, want %q", tt.name, got.Tags, tt.want)
+				}
+				if got.ID != added.ID || !got.CreatedAt.Equal(added.CreatedAt) || got.CreatedBy != "ana" || !got.Pinned {
+					t.Errorf("%s: meta = %+v, want the stored ID, CreatedAt, CreatedBy and Pinned", tt.name, got.Meta)
+				}
+				stored, _ := c.Get(added.ID)
+				if !slices.Equal(stored.Tags, tt.want) {
+					t.Errorf("%s: stored tags = %q, want %q", tt.name, stored.Tags, tt.want)
+				}
+			}
+			if path != InMemory {
+				fresh := newTestCollection(t, path)
+				if got, _ := fresh.Get(added.ID); got.Tags != nil {
+					t.Errorf("reloaded tags = %q, want none", got.Tags)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	c := newTestCollection(t, InMemory)
+	a, _ := c.Add(testRecord{Name: "a"})
+	c.Add(testRecord{Name: "b"})
+	tests := []struct {
+		name string
+		id   string
+		in   testRecord
+	}{
+		{"missing", "nope", testRecord{Name: "a"}},
+		{"duplicate", a.ID, testRecord{Name: "b"}},
+		{"tag too long", a.ID, testRecord{Meta: Meta{Tags: []string{"abcdefghijklmnopqrstuvwxyz0123456789"}}, Name: "a"}},
+	}
+	for _, tt := range tests {
+		if _, err := c.Update(tt.id, tt.in); err == nil {
+			t.Errorf("%s: Update succeeded", tt.name)
+		}
+	}
+}
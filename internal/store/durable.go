@@ -0,0 +1,152 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ---------- Durable writes ----------
+
+// writeFileSync replaces path with data through path.tmp. The temporary
+// file is synced before it is renamed over path and the directory after,
+// so a crash or power cut leaves either the old file or the new one, whole.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// BackupPath is the n-th rotated copy of a JSON store file, 1 the newest.
+func BackupPath(path string, n int) string { return fmt.Sprintf("%s.bak.%d", path, n) }
+
+// rotate keeps the file at path as its first rotated copy before it is
+// replaced, moving older copies up to the keep-th and dropping the oldest.
+func rotate(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(BackupPath(path, n), BackupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	first := BackupPath(path, 1)
+	if err := os.Remove(first); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// A hard link costs nothing; the next save renames a new file over
+	// path and leaves the link holding the old one.
+	if os.Link(path, first) == nil {
+		return nil
+	}
+	return copyFile(path, first)
+}
+
+// Backup is one rotated copy of a store file.
+type Backup struct {
+	N     int       `json:"n"`
+	Path  string    `json:"path"`
+	Bytes int64     `json:"bytes"`
+	Saved time.Time `json:"saved"`
+}
+
+// Backups lists the rotated copies of the store file, newest first.
+func (c *Collection[T, P]) Backups() []Backup {
+	out := []Backup{}
+	if c.Path == InMemory || IsSQLite(c.Path) {
+		return out
+	}
+	for n := 1; n <= c.KeepBackups; n++ {
+		fi, err := os.Stat(BackupPath(c.Path, n))
+		if err != nil {
+			continue
+		}
+		out = append(out, Backup{N: n, Path: BackupPath(c.Path, n), Bytes: fi.Size(), Saved: fi.ModTime().UTC()})
+	}
+	return out
+}
+
+// RestoreBackup puts the n-th rotated copy back as the store's records.
+// The records it replaces are rotated like on any other save, so a restore
+// can itself be undone.
+func (c *Collection[T, P]) RestoreBackup(n int) error {
+	if c.Path == InMemory || IsSQLite(c.Path) {
+		return fmt.Errorf("%s store keeps no rotated copies", c.Spec.Kind)
+	}
+	b, err := os.ReadFile(BackupPath(c.Path, n))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s backup %d: %w", c.Spec.Kind, n, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := decodeFile(c.Path, b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", BackupPath(c.Path, n), err)
+	}
+	items, err := c.decodeItems(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", BackupPath(c.Path, n), err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockForWrite()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	prev := c.Items
+	c.Items = items
+	if err := c.save(); err != nil {
+		c.Items = prev
+		return err
+	}
+	c.publish("reset")
+	return nil
+}
+
+// decodeItems migrates the records of a store file and decodes them.
+func (c *Collection[T, P]) decodeItems(f storeFile) ([]T, error) {
+	raw, err := c.Spec.migrate(f)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &items[i]); err != nil {
+			return nil, fmt.Errorf("%s %d: %w", c.Spec.Kind, i, err)
+		}
+	}
+	return items, nil
+}
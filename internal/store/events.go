@@ -0,0 +1,62 @@
+package store
+
+// ---------- Change events ----------
+
+// Event is one change made to a collection in this process. Item is the
+// record as saved (as it was, for a delete); a reset replaced every record
+// and carries none.
+type Event[T any] struct {
+	Type string `json:"type"` // create, update, delete or reset
+	ID   string `json:"id,omitempty"`
+	Item *T     `json:"item,omitempty"`
+}
+
+// eventBuffer is how many events a subscriber may fall behind by before
+// it misses some.
+const eventBuffer = 64
+
+// Subscribe returns a channel receiving every change from now on and a
+// function that stops it. A subscriber that does not keep up loses events
+// rather than holding up writers; changes other processes make to the file
+// are not seen.
+func (c *Collection[T, P]) Subscribe() (<-chan Event[T], func()) {
+	ch := make(chan Event[T], eventBuffer)
+	c.subMu.Lock()
+	if c.subs == nil {
+		c.subs = map[chan Event[T]]struct{}{}
+	}
+	c.subs[ch] = struct{}{}
+	c.subMu.Unlock()
+	return ch, func() {
+		c.subMu.Lock()
+		if _, ok := c.subs[ch]; ok {
+			delete(c.subs, ch)
+			close(ch)
+		}
+		c.subMu.Unlock()
+	}
+}
+
+func (c *Collection[T, P]) publish(typ string, items ...T) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if len(c.subs) == 0 {
+		return
+	}
+	events := []Event[T]{{Type: typ}}
+	if len(items) > 0 {
+		events = events[:0]
+		for i := range items {
+			it := items[i]
+			events = append(events, Event[T]{Type: typ, ID: P(&it).Fields().ID, Item: &it})
+		}
+	}
+	for ch := range c.subs {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
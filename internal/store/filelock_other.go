@@ -0,0 +1,89 @@
+//go:build !unix
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ---------- Cross-process file locking (lock file) ----------
+
+// staleLock is how old a lock file may get before a waiter takes it to be
+// left by a process that died holding it. Nothing holds the lock for longer
+// than one read or write of the store.
+const staleLock = 3 * lockTimeout
+
+// lockFile falls back to a lock file protocol where flock is unavailable:
+// the holder creates path+".lock" exclusively and removes it on unlock.
+// Readers and writers both take it, so access is serialized. The file names
+// its holder, so a lock left by a crash can be broken once it is stale and
+// unlock never removes a lock that was broken and taken by someone else.
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	name := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			owner := []byte(fmt.Sprintf("pid %d at %s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339Nano)))
+			_, err = f.Write(owner)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(name)
+				return nil, fmt.Errorf("lock %s: %w", name, err)
+			}
+			return func() {
+				if b, err := os.ReadFile(name); err == nil && bytes.Equal(b, owner) {
+					_ = os.Remove(name)
+				}
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("lock %s: %w", name, err)
+		}
+		if breakStale(name) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			if b, err := os.ReadFile(name); err == nil {
+				return nil, fmt.Errorf("lock %s (held by %s): %w", name, strings.TrimSpace(string(b)), errLockTimeout)
+			}
+			return nil, fmt.Errorf("lock %s: %w", name, errLockTimeout)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// breakStale removes the lock file if it is older than staleLock and
+// reports whether it did. The file is first renamed aside and only removed
+// if it is still the one found stale: when two waiters break the same lock,
+// the second puts back the fresh lock the first has taken in the meantime.
+func breakStale(name string) bool {
+	fi, err := os.Stat(name)
+	if err != nil || time.Since(fi.ModTime()) < staleLock {
+		return false
+	}
+	owner, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	aside := fmt.Sprintf("%s.stale-%d", name, os.Getpid())
+	if os.Rename(name, aside) != nil {
+		return false
+	}
+	if b, err := os.ReadFile(aside); err != nil || !bytes.Equal(b, owner) {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) || os.Rename(aside, name) != nil {
+			_ = os.Remove(aside)
+		}
+		return false
+	}
+	_ = os.Remove(aside)
+	fmt.Fprintf(os.Stderr, "removed stale lock %s (held by %s)\n", name, strings.TrimSpace(string(owner)))
+	return true
+}
@@ -0,0 +1,65 @@
+//go:build !unix
+
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBreakStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		broken bool
+	}{
+		{"fresh", 0, false},
+		{"held a while", staleLock / 2, false},
+		{"stale", staleLock + time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "things.json.lock")
+			if err := os.WriteFile(name, []byte("pid 1 at then\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			at := time.Now().Add(-tt.age)
+			if err := os.Chtimes(name, at, at); err != nil {
+				t.Fatal(err)
+			}
+			if got := breakStale(name); got != tt.broken {
+				t.Errorf("breakStale = %v, want %v", got, tt.broken)
+			}
+			_, err := os.Stat(name)
+			if gone := errors.Is(err, os.ErrNotExist); gone != tt.broken {
+				t.Errorf("lock file removed = %v, want %v", gone, tt.broken)
+			}
+		})
+	}
+}
+
+func TestLockFileBreaksStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "things.json")
+	if err := os.WriteFile(path+".lock", []byte("pid 1 at then\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-staleLock - time.Minute)
+	os.Chtimes(path+".lock", old, old)
+
+	unlock, err := lockFile(path, true)
+	if err != nil {
+		t.Fatalf("lock over a stale lock: %v", err)
+	}
+	// Someone breaking this lock in turn must not have it removed by our
+	// unlock.
+	if err := os.WriteFile(path+".lock", []byte("pid 2 at now\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("unlock removed a lock taken by someone else: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---------- Append-only log storage ----------
+
+// IsLog reports whether a store path names an append-only log (.jsonl)
+// rather than a JSON file.
+func IsLog(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".jsonl")
+}
+
+// A store log holds one JSON object per line. The first line is a header,
+// {"schema_version":N}; every other line puts a record, {"put":{...}}, or
+// deletes one, {"del":"ID"}. A save appends and syncs one line per changed
+// record, so a write costs the size of the change rather than of the store.
+//
+// Reading replays the lines in order. A record keeps the place of its first
+// put, so Items come out in the order a JSON file would hold them. A last
+// line cut short by a crash is ignored, and the next write overwrites it.
+//
+// Once stale lines outnumber the records (and there are logCompactMin
+// lines), the next save compacts the log: it is rewritten as the header and
+// one put per record, through a synced temporary file like a JSON store,
+// rotating KeepBackups copies.
+type logLine struct {
+	SchemaVersion *int            `json:"schema_version,omitempty"`
+	Put           json.RawMessage `json:"put,omitempty"`
+	Del           string          `json:"del,omitempty"`
+}
+
+const logCompactMin = 256
+
+// logState is what a Collection knows of its log as last read or written.
+type logState struct {
+	lines int   // header included
+	end   int64 // just past the last complete line
+}
+
+// decodeLog replays a log into the records it leaves.
+func decodeLog(b []byte) (storeFile, logState, error) {
+	var (
+		f     storeFile
+		st    logState
+		items []json.RawMessage // nil where a record was deleted
+		index = map[string]int{}
+	)
+	for off := 0; off < len(b); {
+		n := bytes.IndexByte(b[off:], '\n')
+		if n < 0 {
+			break // cut short by a crash
+		}
+		line, next := bytes.TrimSpace(b[off:off+n]), off+n+1
+		off = next
+		if len(line) == 0 {
+			st.end = int64(next)
+			continue
+		}
+		var l logLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return f, st, fmt.Errorf("log line %d: %w", st.lines+1, err)
+		}
+		st.lines++
+		st.end = int64(next)
+		switch {
+		case l.SchemaVersion != nil:
+			if st.lines != 1 {
+				return f, st, fmt.Errorf("log line %d: header after records", st.lines)
+			}
+			f.SchemaVersion = *l.SchemaVersion
+		case l.Put != nil:
+			var m Meta
+			if err := json.Unmarshal(l.Put, &m); err != nil {
+				return f, st, fmt.Errorf("log line %d: %w", st.lines, err)
+			}
+			if i, ok := index[m.ID]; ok {
+				items[i] = l.Put
+				continue
+			}
+			index[m.ID] = len(items)
+			items = append(items, l.Put)
+		case l.Del != "":
+			if i, ok := index[l.Del]; ok {
+				items[i] = nil
+				delete(index, l.Del)
+			}
+		}
+	}
+	for _, raw := range items {
+		if raw != nil {
+			f.Items = append(f.Items, raw)
+		}
+	}
+	return f, st, nil
+}
+
+// encodeLog writes f as a compacted log.
+func encodeLog(f storeFile) ([]byte, error) {
+	v := f.SchemaVersion
+	lines := []logLine{{SchemaVersion: &v}}
+	for _, raw := range f.Items {
+		lines = append(lines, logLine{Put: raw})
+	}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeFile reads the contents of a store file in the format its path
+// names.
+func decodeFile(path string, b []byte) (storeFile, error) {
+	if IsLog(path) {
+		f, _, err := decodeLog(b)
+		return f, err
+	}
+	return decodeStoreFile(b)
+}
+
+// saveLog appends the records with the given IDs to the log, or a delete
+// for those no longer there, compacting it instead when it is due; callers
+// hold c.mu and the exclusive lock.
+func (c *Collection[T, P]) saveLog(ids []string) error {
+	if len(ids) == 0 || c.log.end == 0 || c.log.lines >= logCompactMin && c.log.lines > 2*len(c.Items) {
+		return c.compactLog()
+	}
+	var buf bytes.Buffer
+	for _, id := range ids {
+		l := logLine{Del: id}
+		if i := c.indexOf(id); i >= 0 {
+			b, err := json.Marshal(c.Items[i])
+			if err != nil {
+				return err
+			}
+			l = logLine{Put: b}
+		}
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(c.Path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Drop a line cut short by a crash before appending after it.
+	err = f.Truncate(c.log.end)
+	if err == nil {
+		_, err = f.WriteAt(buf.Bytes(), c.log.end)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	c.log.lines += len(ids)
+	c.log.end += int64(buf.Len())
+	c.stamp = statStamp(c.Path)
+	return nil
+}
+
+// compactLog rewrites the log as the records it holds.
+func (c *Collection[T, P]) compactLog() error {
+	f := storeFile{SchemaVersion: c.Spec.schemaVersion(), Items: make([]json.RawMessage, len(c.Items))}
+	for i := range c.Items {
+		b, err := json.Marshal(c.Items[i])
+		if err != nil {
+			return err
+		}
+		f.Items[i] = b
+	}
+	data, err := encodeLog(f)
+	if err != nil {
+		return err
+	}
+	if err := rotate(c.Path, c.KeepBackups); err != nil {
+		return fmt.Errorf("rotate %s: %w", c.Path, err)
+	}
+	if err := writeFileSync(c.Path, data); err != nil {
+		return err
+	}
+	c.log = logState{lines: 1 + len(f.Items), end: int64(len(data))}
+	c.stamp = statStamp(c.Path)
+	return nil
+}
+
+// adoptJSON starts a log that does not exist yet with the records of the
+// JSON store file beside it (glyphs.json for glyphs.jsonl), which is left
+// as it is; callers hold c.mu and the exclusive lock.
+func (c *Collection[T, P]) adoptJSON() error {
+	if _, err := os.Stat(c.Path); !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	src := strings.TrimSuffix(c.Path, filepath.Ext(c.Path)) + ".json"
+	b, err := os.ReadFile(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, err := decodeStoreFile(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	data, err := encodeLog(f)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(c.Path, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "started %s from the %d records of %s\n", c.Path, len(f.Items), src)
+	return nil
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func logLines(t *testing.T, path string) int {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(b, []byte("\n"))
+}
+
+func TestLogAppendsAndCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "things.jsonl")
+	c := newTestCollection(t, path)
+	a, err := c.Add(testRecord{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Add(testRecord{Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first save writes the header and the record; each change after
+	// it appends one line.
+	if n := logLines(t, path); n != 3 {
+		t.Fatalf("%d lines after two adds, want 3", n)
+	}
+	if _, err := c.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := logLines(t, path); n != 4 {
+		t.Fatalf("%d lines after a delete, want 4", n)
+	}
+
+	for i := 0; logLines(t, path) < logCompactMin; i++ {
+		if _, err := c.Update(a.ID, testRecord{Name: fmt.Sprintf("a%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Update(a.ID, testRecord{Name: "last"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := logLines(t, path); n != 2 {
+		t.Errorf("%d lines after compaction, want the header and one record", n)
+	}
+
+	fresh := newTestCollection(t, path)
+	items := fresh.List()
+	if len(items) != 1 || items[0].ID != a.ID || items[0].Name != "last" {
+		t.Errorf("replayed %+v, want only %s named last", items, a.ID)
+	}
+}
+
+func TestLogReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		log   string
+		names []string
+		err   bool
+	}{
+		{"empty", `{"schema_version":1}` + "\n", nil, false},
+		{"puts keep their first place", `{"schema_version":1}
+{"put":{"id":"1","name":"a"}}
+{"put":{"id":"2","name":"b"}}
+{"put":{"id":"1","name":"a2"}}
+`, []string{"a2", "b"}, false},
+		{"delete", `{"schema_version":1}
+{"put":{"id":"1","name":"a"}}
+{"put":{"id":"2","name":"b"}}
+{"del":"1"}
+`, []string{"b"}, false},
+		{"put after delete", `{"schema_version":1}
+{"put":{"id":"1","name":"a"}}
+{"del":"1"}
+{"put":{"id":"1","name":"again"}}
+`, []string{"again"}, false},
+		{"last line cut short", `{"schema_version":1}
+{"put":{"id":"1","name":"a"}}
+{"put":{"id":"2","na`, []string{"a"}, false},
+		{"header after records", `{"put":{"id":"1","name":"a"}}
+{"schema_version":1}
+`, nil, true},
+		{"bad line", `{"schema_version":1}
+not json
+{"put":{"id":"1","name":"a"}}
+`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, err := decodeLog([]byte(tt.log))
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want one: %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			var names []string
+			for _, raw := range f.Items {
+				var r testRecord
+				if err := json.Unmarshal(raw, &r); err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, r.Name)
+			}
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("records = %q, want %q", names, tt.names)
+			}
+		})
+	}
+}
+
+// A line cut short by a crash is dropped by the next write rather than
+// left in the middle of the log.
+func TestLogOverwritesCutLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "things.jsonl")
+	c := newTestCollection(t, path)
+	if _, err := c.Add(testRecord{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"put":{"id":"x","na`)
+	f.Close()
+
+	c = newTestCollection(t, path)
+	if _, err := c.Add(testRecord{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	fresh := newTestCollection(t, path)
+	if n := fresh.Len(); n != 2 {
+		t.Errorf("%d records after the cut line, want 2", n)
+	}
+	if n := logLines(t, path); n != 3 {
+		t.Errorf("%d lines, want 3", n)
+	}
+}
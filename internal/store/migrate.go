@@ -0,0 +1,73 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ---------- Schema migrations ----------
+
+// Migration upgrades one stored record, decoded as a JSON object, from the
+// schema version before it to the next: Spec.Migrations[i] turns version i
+// into version i+1. Keep them idempotent: an older program may rewrite the
+// file as version 0 with records that were already migrated.
+type Migration func(rec map[string]any) error
+
+// storeFile is the on-disk layout. Files written before schema versions
+// existed are a bare JSON array and count as version 0.
+type storeFile struct {
+	SchemaVersion int               `json:"schema_version"`
+	Items         []json.RawMessage `json:"items"`
+}
+
+// schemaVersion is the version this program writes for the kind.
+func (s Spec[T]) schemaVersion() int { return len(s.Migrations) }
+
+func decodeStoreFile(b []byte) (storeFile, error) {
+	var f storeFile
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		err := json.Unmarshal(b, &f.Items)
+		return f, err
+	}
+	err := json.Unmarshal(b, &f)
+	return f, err
+}
+
+// migrate brings every record of f up to the spec's schema version.
+func (s Spec[T]) migrate(f storeFile) ([]json.RawMessage, error) {
+	want := s.schemaVersion()
+	if f.SchemaVersion > want {
+		return nil, fmt.Errorf("%s store has schema version %d, newer than this program supports (%d)", s.Kind, f.SchemaVersion, want)
+	}
+	if f.SchemaVersion == want {
+		return f.Items, nil
+	}
+	out := make([]json.RawMessage, len(f.Items))
+	for i, raw := range f.Items {
+		var rec map[string]any
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, fmt.Errorf("%s %d: %w", s.Kind, i, err)
+		}
+		for v := f.SchemaVersion; v < want; v++ {
+			if err := s.Migrations[v](rec); err != nil {
+				return nil, fmt.Errorf("migrate %s %d to schema %d: %w", s.Kind, i, v+1, err)
+			}
+		}
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0o644)
+}
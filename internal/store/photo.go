@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// ---------- Photos ----------
+
+// Uploaded photos are turned upright, scaled down to MaxPhotoSide and
+// re-encoded as JPEG, which leaves their metadata (EXIF location and all)
+// behind. A thumbnail of at most ThumbSide goes in thumb/ beside them.
+const (
+	MaxPhotoSide = 2048
+	ThumbSide    = 320
+	thumbDir     = "thumb"
+)
+
+// MaxPhotoPixels caps the size of an uploaded image, checked before it is
+// decoded: a small file may declare a huge image, and turning it upright
+// takes full-size copies.
+const MaxPhotoPixels = 40_000_000
+
+// storePhoto decodes an uploaded image and re-encodes it as dir/name.jpg,
+// with its thumbnail.
+func storePhoto(dir, name string, photo []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(photo))
+	if err != nil {
+		return fmt.Errorf("invalid photo: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxPhotoPixels {
+		return fmt.Errorf("photo too large (max %d megapixels)", MaxPhotoPixels/1_000_000)
+	}
+	img, _, err := image.Decode(bytes.NewReader(photo))
+	if err != nil {
+		return fmt.Errorf("invalid photo: %w", err)
+	}
+	img = fitWithin(orient(img, exifOrientation(photo)), MaxPhotoSide)
+	if err := writeJPEG(filepath.Join(dir, name+".jpg"), img, 80); err != nil {
+		return err
+	}
+	return writeJPEG(filepath.Join(dir, thumbDir, name+".jpg"), fitWithin(img, ThumbSide), 75)
+}
+
+// ThumbURL is the thumbnail URL of a stored photo's URL, or "" when photo
+// is not one.
+func ThumbURL(photo string) string {
+	dir, file := path.Split(photo)
+	if file == "" || !strings.HasPrefix(dir, "/") || !strings.HasSuffix(dir, "-images/") || strings.Count(dir, "/") != 2 {
+		return ""
+	}
+	return dir + thumbDir + "/" + file
+}
+
+// Thumbnail returns the path of the thumbnail of the photo named file,
+// making it from the photo when it is missing (photos stored before
+// thumbnails were, or restored from a backup, which leaves them out).
+func (c *Collection[T, P]) Thumbnail(file string) (string, error) {
+	if file != filepath.Base(file) || !strings.HasSuffix(file, ".jpg") {
+		return "", os.ErrNotExist
+	}
+	dir := c.PhotoDir()
+	thumb := filepath.Join(dir, thumbDir, file)
+	if _, err := os.Stat(thumb); err == nil {
+		return thumb, nil
+	}
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	if err := writeJPEG(thumb, fitWithin(img, ThumbSide), 75); err != nil {
+		return "", err
+	}
+	return thumb, nil
+}
+
+// writeJPEG writes img to file through a temporary file, so a reader never
+// sees half of it.
+func writeJPEG(file string, img image.Image, quality int) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// fitWithin scales img down so neither side is longer than side.
+func fitWithin(img image.Image, side int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= side && h <= side {
+		return img
+	}
+	if w >= h {
+		w, h = side, max(1, h*side/w)
+	} else {
+		w, h = max(1, w*side/h), side
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient turns img upright according to an EXIF orientation (1-8).
+func orient(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch o {
+			case 2: // flip left-right
+				dx, dy = w-1-x, y
+			case 3: // rotate 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip top-bottom
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90° anticlockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// exifOrientation reads the orientation tag from a JPEG's EXIF block, or
+// returns 1 (upright) when there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for p := 2; p+4 <= len(data) && data[p] == 0xFF; {
+		marker := data[p+1]
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if marker == 0xDA || n < 2 || p+2+n > len(data) { // image data starts
+			break
+		}
+		seg := data[p+4 : p+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			if o, err := tiffOrientation(seg[6:]); err == nil {
+				return o
+			}
+			return 1
+		}
+		p += 2 + n
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in the first IFD of a TIFF header.
+func tiffOrientation(t []byte) (int, error) {
+	if len(t) < 8 {
+		return 0, errors.New("short exif")
+	}
+	var bo binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0, errors.New("bad exif byte order")
+	}
+	ifd := int(bo.Uint32(t[4:]))
+	if ifd+2 > len(t) {
+		return 0, errors.New("bad exif offset")
+	}
+	count := int(bo.Uint16(t[ifd:]))
+	for i := range count {
+		e := ifd + 2 + 12*i
+		if e+12 > len(t) {
+			break
+		}
+		if bo.Uint16(t[e:]) == 0x0112 {
+			return int(bo.Uint16(t[e+8:])), nil
+		}
+	}
+	return 0, errors.New("no orientation")
+}
+
+// Hash is a tiny non-crypto hash for IDs (FNV-1a 64).
+func Hash(s string) uint64 {
+	var h uint64 = 1469598103934665603
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
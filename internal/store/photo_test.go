@@ -0,0 +1,76 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngHeader is the start of a PNG declaring a w×h image, enough for
+// image.DecodeConfig but not for image.Decode.
+func pngHeader(w, h int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestStorePhoto(t *testing.T) {
+	var small bytes.Buffer
+	png.Encode(&small, image.NewRGBA(image.Rect(0, 0, 3000, 100)))
+	tests := []struct {
+		name  string
+		photo []byte
+		err   string // in the error; "" for none
+	}{
+		{"scaled down", small.Bytes(), ""},
+		{"declares 30000x30000", pngHeader(30000, 30000), "too large"},
+		{"declares just over the cap", pngHeader(8000, 5001), "too large"},
+		{"not an image", []byte("hello"), "invalid photo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := storePhoto(dir, "p", tt.photo)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one about %q", err, tt.err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "p.jpg")); err == nil {
+					t.Error("photo stored anyway")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, side := range map[string]int{"p.jpg": MaxPhotoSide, "thumb/p.jpg": ThumbSide} {
+				f, err := os.Open(filepath.Join(dir, path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg, _, err := image.DecodeConfig(f)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cfg.Width != side {
+					t.Errorf("%s is %dx%d, want %d wide", path, cfg.Width, cfg.Height, side)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// ---------- SQLite storage ----------
+
+// IsSQLite reports whether a store path names a SQLite database rather than
+// a JSON file.
+func IsSQLite(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// A store database holds one record per row, as the JSON the file would
+// hold, so the schema migrations and backups work the same on both. meta
+// keeps the schema version and a revision bumped by every write, which is
+// how a Collection notices another process's changes.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+`
+
+// sqliteFile is an open store database. Writes happen in the transaction
+// of the lock being held, so readers never see half a change.
+type sqliteFile struct {
+	db *sql.DB
+
+	// Of the lock being held; the caller's mutex guards them.
+	conn   *sql.Conn
+	failed bool // a write failed: roll back on unlock
+}
+
+// queryer is a *sql.DB or *sql.Conn.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func openSQLiteFile(path string) (*sqliteFile, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		fmt.Sprintf("?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", lockTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema in %s: %w", path, err)
+	}
+	return &sqliteFile{db: db}, nil
+}
+
+func (f *sqliteFile) q() queryer {
+	if f.conn != nil {
+		return f.conn
+	}
+	return f.db
+}
+
+// lock starts a transaction on one connection. An exclusive lock is a
+// write transaction, which SQLite serializes across processes.
+func (f *sqliteFile) lock(exclusive bool) (unlock func(), err error) {
+	ctx := context.Background()
+	conn, err := f.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	begin := "BEGIN"
+	if exclusive {
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		conn.Close()
+		if strings.Contains(err.Error(), "SQLITE_BUSY") {
+			return nil, errLockTimeout
+		}
+		return nil, err
+	}
+	f.conn, f.failed = conn, false
+	return func() {
+		end := "COMMIT"
+		if f.failed {
+			end = "ROLLBACK"
+		}
+		if _, err := conn.ExecContext(ctx, end); err != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+		conn.Close()
+		f.conn = nil
+	}, nil
+}
+
+func (f *sqliteFile) meta(key string) (int64, bool, error) {
+	var v int64
+	err := f.q().QueryRowContext(context.Background(), `SELECT value FROM meta WHERE key = ?`, key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return v, err == nil, err
+}
+
+func (f *sqliteFile) stamp() fileStamp {
+	rev, _, _ := f.meta("rev")
+	return fileStamp{rev: rev}
+}
+
+// read returns the stored records; ok is false for a database no
+// Collection has written to yet.
+func (f *sqliteFile) read() (sf storeFile, ok bool, err error) {
+	v, ok, err := f.meta("schema_version")
+	if err != nil || !ok {
+		return storeFile{}, false, err
+	}
+	sf.SchemaVersion = int(v)
+	rows, err := f.q().QueryContext(context.Background(), `SELECT data FROM records ORDER BY rowid`)
+	if err != nil {
+		return storeFile{}, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return storeFile{}, false, err
+		}
+		sf.Items = append(sf.Items, json.RawMessage(data))
+	}
+	return sf, true, rows.Err()
+}
+
+// write applies one change inside the held lock: the records in put are
+// inserted or replaced, those in del removed, and with all set every other
+// record is dropped first. It returns the new stamp.
+func (f *sqliteFile) write(version int, all bool, put []sqliteRecord, del []string) (fileStamp, error) {
+	st, err := f.apply(version, all, put, del)
+	if err != nil {
+		f.failed = true
+	}
+	return st, err
+}
+
+type sqliteRecord struct {
+	id   string
+	data []byte
+}
+
+func (f *sqliteFile) apply(version int, all bool, put []sqliteRecord, del []string) (fileStamp, error) {
+	if f.conn == nil {
+		return fileStamp{}, errors.New("store database written without its lock")
+	}
+	ctx := context.Background()
+	if all {
+		if _, err := f.conn.ExecContext(ctx, `DELETE FROM records`); err != nil {
+			return fileStamp{}, err
+		}
+	}
+	for _, id := range del {
+		if _, err := f.conn.ExecContext(ctx, `DELETE FROM records WHERE id = ?`, id); err != nil {
+			return fileStamp{}, err
+		}
+	}
+	for _, r := range put {
+		if _, err := f.conn.ExecContext(ctx, `INSERT INTO records (id, data) VALUES (?, ?)
+			ON CONFLICT (id) DO UPDATE SET data = excluded.data`, r.id, string(r.data)); err != nil {
+			return fileStamp{}, err
+		}
+	}
+	if _, err := f.conn.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES ('schema_version', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, version); err != nil {
+		return fileStamp{}, err
+	}
+	var rev int64
+	if err := f.conn.QueryRowContext(ctx, `INSERT INTO meta (key, value) VALUES ('rev', 1)
+		ON CONFLICT (key) DO UPDATE SET value = value + 1 RETURNING value`).Scan(&rev); err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{rev: rev}, nil
+}
+
+// export renders the database as a JSON store file.
+func (f *sqliteFile) export() ([]byte, error) {
+	sf, ok, err := f.read()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if sf.Items == nil {
+		sf.Items = []json.RawMessage{}
+	}
+	return json.MarshalIndent(sf, "", "  ")
+}
+
+// replace makes the database hold a JSON store file's records, as they are;
+// a Collection migrates them on its next Load.
+func (f *sqliteFile) replace(data []byte) error {
+	sf, err := decodeStoreFile(data)
+	if err != nil {
+		return err
+	}
+	put := make([]sqliteRecord, len(sf.Items))
+	for i, raw := range sf.Items {
+		var m Meta
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		if m.ID == "" {
+			return fmt.Errorf("record %d has no id", i)
+		}
+		put[i] = sqliteRecord{m.ID, raw}
+	}
+	_, err = f.write(sf.SchemaVersion, true, put, nil)
+	return err
+}
+
+// saveSQL writes the records with the given IDs (all of them if none are
+// given); callers hold c.mu and the exclusive lock.
+func (c *Collection[T, P]) saveSQL(ids []string) error {
+	var put []sqliteRecord
+	var del []string
+	add := func(i int) error {
+		b, err := json.Marshal(c.Items[i])
+		if err != nil {
+			return err
+		}
+		put = append(put, sqliteRecord{P(&c.Items[i]).Fields().ID, b})
+		return nil
+	}
+	if len(ids) == 0 {
+		for i := range c.Items {
+			if err := add(i); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range ids {
+		if i := c.indexOf(id); i >= 0 {
+			if err := add(i); err != nil {
+				return err
+			}
+		} else {
+			del = append(del, id)
+		}
+	}
+	st, err := c.db.write(c.Spec.schemaVersion(), len(ids) == 0, put, del)
+	if err != nil {
+		return err
+	}
+	c.stamp = st
+	return nil
+}
+
+// withSQLite opens a store database for ReadFile and WriteFile.
+func withSQLite(path string, exclusive bool, fn func(f *sqliteFile) error) error {
+	f, err := openSQLiteFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.db.Close()
+	unlock, err := f.lock(exclusive)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn(f)
+}
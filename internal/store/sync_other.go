@@ -0,0 +1,6 @@
+//go:build !unix
+
+package store
+
+// syncDir is a no-op where a directory cannot be opened to be synced.
+func syncDir(dir string) error { return nil }